@@ -48,6 +48,12 @@ func isBlacklisted(candidate string) bool {
 		return true
 	}
 
+	// Skip golden files, which hold the verbatim outputs expected by the integration tests and
+	// thus cannot carry license headers.
+	if ext == ".golden" {
+		return true
+	}
+
 	// Skip plist files, which even though are manually written, they have quite a few
 	// exceptions.
 	if ext == ".plist" {
@@ -0,0 +1,240 @@
+// Copyright 2020 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not
+// use this file except in compliance with the License.  You may obtain a copy
+// of the License at:
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.  See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"math/rand"
+	"strconv"
+	"time"
+)
+
+// compareSettings holds the settings that control how two binaries are compared.
+type compareSettings struct {
+	// rounds is the number of times each workload is run against each binary.
+	rounds int
+
+	// confidence is the confidence level of the reported intervals, and one minus the significance
+	// level required by the Mann-Whitney test to flag a regression.
+	confidence float64
+
+	// maxRegression is the largest increase in median latency, in percent, that is tolerated.
+	maxRegression float64
+
+	// bootstrapIterations is the number of resamples used to compute confidence intervals.
+	bootstrapIterations int
+}
+
+// comparison holds the statistical comparison of one configuration between two binaries.
+type comparison struct {
+	// Workload is the name of the workload that produced the measurements.
+	Workload string `json:"workload"`
+
+	// Params describes the configuration of the measurement.
+	Params map[string]string `json:"params"`
+
+	// Baseline is the latency distribution of the baseline binary across all rounds.
+	Baseline summary `json:"baseline"`
+
+	// Candidate is the latency distribution of the candidate binary across all rounds.
+	Candidate summary `json:"candidate"`
+
+	// MedianDelta is the relative change of the median latency of the candidate over the
+	// baseline, in percent.  Positive values mean the candidate is slower.
+	MedianDelta float64 `json:"median_delta_percent"`
+
+	// CILow and CIHigh delimit the bootstrap confidence interval of MedianDelta.
+	CILow  float64 `json:"ci_low_percent"`
+	CIHigh float64 `json:"ci_high_percent"`
+
+	// PValue is the two-sided p-value of the Mann-Whitney U test on both sets of samples.
+	PValue float64 `json:"p_value"`
+
+	// Regression is true if the candidate is significantly slower than the baseline by more than
+	// the tolerated threshold.
+	Regression bool `json:"regression"`
+}
+
+// comparisonReport is the complete output of a comparison between two binaries.
+type comparisonReport struct {
+	Baseline      environment  `json:"baseline"`
+	Candidate     environment  `json:"candidate"`
+	Rounds        int          `json:"rounds"`
+	Confidence    float64      `json:"confidence"`
+	MaxRegression float64      `json:"max_regression_percent"`
+	Comparisons   []comparison `json:"comparisons"`
+}
+
+// regressions returns the number of configurations in which the candidate regressed.
+func (r *comparisonReport) regressions() int {
+	count := 0
+	for _, c := range r.Comparisons {
+		if c.Regression {
+			count++
+		}
+	}
+	return count
+}
+
+// accumulator merges the results of multiple rounds of the same workloads.
+type accumulator struct {
+	// order contains the keys of the results in the order in which they were first seen.
+	order []string
+
+	// results maps the keys of the results to their merged values.
+	results map[string]*result
+}
+
+// newAccumulator instantiates a new empty accumulator.
+func newAccumulator() *accumulator {
+	return &accumulator{results: make(map[string]*result)}
+}
+
+// add merges the samples of a round into the accumulated results.
+func (a *accumulator) add(results []result) {
+	for _, res := range results {
+		key := res.key()
+		if merged, ok := a.results[key]; ok {
+			merged.samples = append(merged.samples, res.samples...)
+			continue
+		}
+		merged := res
+		merged.samples = append([]time.Duration(nil), res.samples...)
+		a.results[key] = &merged
+		a.order = append(a.order, key)
+	}
+}
+
+// compare computes the statistical comparison of the samples of a baseline and a candidate.
+func compare(baseline *result, candidate *result, settings *compareSettings, rng *rand.Rand) comparison {
+	baselineValues := toMicroseconds(baseline.samples)
+	candidateValues := toMicroseconds(candidate.samples)
+
+	c := comparison{
+		Workload:  baseline.Workload,
+		Params:    baseline.Params,
+		Baseline:  summarize(baseline.samples),
+		Candidate: summarize(candidate.samples),
+		PValue:    mannWhitney(baselineValues, candidateValues),
+	}
+	if base := median(baselineValues); base > 0 {
+		c.MedianDelta = (median(candidateValues)/base - 1) * 100
+	}
+	c.CILow, c.CIHigh = bootstrapMedianDelta(baselineValues, candidateValues, settings.confidence, settings.bootstrapIterations, rng)
+	c.Regression = c.MedianDelta > settings.maxRegression && c.PValue < 1-settings.confidence
+	return c
+}
+
+// runComparison runs the selected workloads against the baseline and candidate binaries and
+// compares their results.  Runs are interleaved, alternating which binary goes first in each round,
+// so that slow drifts in the machine's performance affect both binaries equally.
+func runComparison(cfg *config, baseline string, candidate string, selected []string, settings *compareSettings) (*comparisonReport, error) {
+	accumulators := map[string]*accumulator{baseline: newAccumulator(), candidate: newAccumulator()}
+	for _, name := range selected {
+		for round := 0; round < settings.rounds; round++ {
+			binaries := []string{baseline, candidate}
+			if round%2 == 1 {
+				binaries[0], binaries[1] = binaries[1], binaries[0]
+			}
+			for _, binary := range binaries {
+				log.Printf("round %d of %d: running %s against %s", round+1, settings.rounds, name, binary)
+				roundCfg := *cfg
+				roundCfg.binary = binary
+				results, err := workloads[name](&roundCfg)
+				if err != nil {
+					return nil, fmt.Errorf("workload %s failed against %s: %v", name, binary, err)
+				}
+				accumulators[binary].add(results)
+			}
+		}
+	}
+
+	r := &comparisonReport{
+		Baseline:      collectEnvironment(baseline),
+		Candidate:     collectEnvironment(candidate),
+		Rounds:        settings.rounds,
+		Confidence:    settings.confidence,
+		MaxRegression: settings.maxRegression,
+	}
+	rng := rand.New(rand.NewSource(1)) // Fixed seed so that reports are reproducible.
+	base, cand := accumulators[baseline], accumulators[candidate]
+	for _, key := range base.order {
+		candidateResult, ok := cand.results[key]
+		if !ok {
+			return nil, fmt.Errorf("no results for %s from %s", key, candidate)
+		}
+		c := compare(base.results[key], candidateResult, settings, rng)
+		if c.Regression {
+			log.Printf("regression in %s: median %+.1f%% (CI %+.1f%% to %+.1f%%, p=%.3g)", key, c.MedianDelta, c.CILow, c.CIHigh, c.PValue)
+		}
+		r.Comparisons = append(r.Comparisons, c)
+	}
+	return r, nil
+}
+
+// writeJSON writes the report to w as indented JSON.
+func (r *comparisonReport) writeJSON(w io.Writer) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(r); err != nil {
+		return fmt.Errorf("cannot encode comparison: %v", err)
+	}
+	return nil
+}
+
+// writeCSV writes the comparisons to w as CSV, with one column per parameter used by any of them.
+// The environments are written as comment lines before the header.
+func (r *comparisonReport) writeCSV(w io.Writer) error {
+	for _, env := range []struct {
+		name  string
+		value environment
+	}{{"baseline", r.Baseline}, {"candidate", r.Candidate}} {
+		encoded, err := json.Marshal(env.value)
+		if err != nil {
+			return fmt.Errorf("cannot encode environment: %v", err)
+		}
+		if _, err := fmt.Fprintf(w, "# %s: %s\n", env.name, encoded); err != nil {
+			return err
+		}
+	}
+
+	var results []result
+	for _, c := range r.Comparisons {
+		results = append(results, result{Params: c.Params})
+	}
+	params := paramNames(results)
+
+	cw := csv.NewWriter(w)
+	header := append([]string{"workload"}, params...)
+	header = append(header, "baseline_p50_us", "candidate_p50_us", "median_delta_percent", "ci_low_percent", "ci_high_percent", "p_value", "regression")
+	cw.Write(header)
+	for _, c := range r.Comparisons {
+		row := []string{c.Workload}
+		for _, param := range params {
+			row = append(row, c.Params[param])
+		}
+		for _, value := range []float64{c.Baseline.P50, c.Candidate.P50, c.MedianDelta, c.CILow, c.CIHigh} {
+			row = append(row, fmt.Sprintf("%.3f", value))
+		}
+		row = append(row, fmt.Sprintf("%.4g", c.PValue), strconv.FormatBool(c.Regression))
+		cw.Write(row)
+	}
+	cw.Flush()
+	return cw.Error()
+}
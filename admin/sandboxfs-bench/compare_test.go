@@ -0,0 +1,90 @@
+// Copyright 2020 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not
+// use this file except in compliance with the License.  You may obtain a copy
+// of the License at:
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.  See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package main
+
+import (
+	"math/rand"
+	"testing"
+	"time"
+)
+
+// makeResult creates a result for the stat operation with the given latencies in microseconds.
+func makeResult(target string, latencies ...int) result {
+	var samples []time.Duration
+	for _, latency := range latencies {
+		samples = append(samples, time.Duration(latency)*time.Microsecond)
+	}
+	return result{
+		Workload: "fileops",
+		Params:   map[string]string{"operation": "stat", "target": target},
+		Latency:  summarize(samples),
+		samples:  samples,
+	}
+}
+
+func TestAccumulator(t *testing.T) {
+	a := newAccumulator()
+	a.add([]result{makeResult("mount", 1, 2), makeResult("direct", 3)})
+	a.add([]result{makeResult("direct", 4, 5), makeResult("mount", 6)})
+
+	if len(a.order) != 2 {
+		t.Fatalf("Got %d merged results; want 2", len(a.order))
+	}
+	for i, want := range []struct {
+		target  string
+		samples int
+	}{{"mount", 3}, {"direct", 3}} {
+		res := a.results[a.order[i]]
+		if res.Params["target"] != want.target || len(res.samples) != want.samples {
+			t.Errorf("Got result %d with target %s and %d samples; want %s and %d", i, res.Params["target"], len(res.samples), want.target, want.samples)
+		}
+	}
+}
+
+func TestCompare(t *testing.T) {
+	settings := &compareSettings{confidence: 0.95, maxRegression: 5, bootstrapIterations: 200}
+
+	var fast, similar, slow []int
+	for i := 0; i < 100; i++ {
+		fast = append(fast, 100+i%20)
+		similar = append(similar, 101+i%20)
+		slow = append(slow, 150+i%20)
+	}
+
+	testData := []struct {
+		name           string
+		baseline       []int
+		candidate      []int
+		wantRegression bool
+	}{
+		{"same", fast, fast, false},
+		{"within threshold", fast, similar, false},
+		{"faster", slow, fast, false},
+		{"slower", fast, slow, true},
+	}
+	for _, d := range testData {
+		t.Run(d.name, func(t *testing.T) {
+			baseline := makeResult("mount", d.baseline...)
+			candidate := makeResult("mount", d.candidate...)
+			c := compare(&baseline, &candidate, settings, rand.New(rand.NewSource(1)))
+			if c.Regression != d.wantRegression {
+				t.Errorf("Got regression %v (delta %v%%, p=%v); want %v", c.Regression, c.MedianDelta, c.PValue, d.wantRegression)
+			}
+			if c.CILow > c.MedianDelta || c.CIHigh < c.MedianDelta {
+				t.Errorf("Got interval [%v, %v] that does not contain median delta %v", c.CILow, c.CIHigh, c.MedianDelta)
+			}
+		})
+	}
+}
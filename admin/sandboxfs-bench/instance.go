@@ -0,0 +1,139 @@
+// Copyright 2020 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not
+// use this file except in compliance with the License.  You may obtain a copy
+// of the License at:
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.  See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"time"
+
+	"github.com/bazelbuild/sandboxfs/integration/mounts"
+	"github.com/bazelbuild/sandboxfs/integration/protocol"
+)
+
+const (
+	// startupDeadline is the maximum amount of time to wait for sandboxfs to start serving.
+	startupDeadline = 10 * time.Second
+
+	// shutdownDeadline is the maximum amount of time to wait for sandboxfs to exit.
+	shutdownDeadline = 5 * time.Second
+)
+
+// instance represents a running sandboxfs process under benchmark.
+type instance struct {
+	// cmd is the handle of the sandboxfs process.
+	cmd *exec.Cmd
+
+	// stdin is the pipe through which reconfiguration requests are sent.
+	stdin io.WriteCloser
+
+	// client sends reconfiguration requests to the instance.
+	client *protocol.Client
+
+	// mountPoint is the path where the instance is mounted.
+	mountPoint string
+
+	// stderr collects the error output of the instance to report it on failures.
+	stderr *os.File
+}
+
+// waitFor polls the given condition until it succeeds or the deadline expires.
+func waitFor(condition func() error, deadline time.Duration) error {
+	var err error
+	for start := time.Now(); time.Since(start) < deadline; time.Sleep(10 * time.Millisecond) {
+		if err = condition(); err == nil {
+			return nil
+		}
+	}
+	return err
+}
+
+// startInstance starts sandboxfs from binary with the given extra arguments, mounting it at
+// mountPoint with root mapped read-only at its top.  Returns once the file system is serving.
+func startInstance(binary string, mountPoint string, root string, args ...string) (*instance, error) {
+	stderr, err := ioutil.TempFile("", "sandboxfs-bench-stderr")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create stderr file: %v", err)
+	}
+	os.Remove(stderr.Name())
+
+	cookie := filepath.Join(root, ".cookie")
+	if err := ioutil.WriteFile(cookie, nil, 0644); err != nil {
+		stderr.Close()
+		return nil, fmt.Errorf("failed to create startup cookie: %v", err)
+	}
+	defer os.Remove(cookie)
+
+	allArgs := append([]string{"--mapping=ro:/:" + root}, args...)
+	allArgs = append(allArgs, mountPoint)
+	cmd := exec.Command(binary, allArgs...)
+	cmd.Stderr = stderr
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		stderr.Close()
+		return nil, fmt.Errorf("failed to create stdin pipe: %v", err)
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		stderr.Close()
+		return nil, fmt.Errorf("failed to create stdout pipe: %v", err)
+	}
+	if err := cmd.Start(); err != nil {
+		stderr.Close()
+		return nil, fmt.Errorf("failed to start %s with arguments %v: %v", binary, allArgs, err)
+	}
+
+	inst := &instance{
+		cmd:        cmd,
+		stdin:      stdin,
+		client:     protocol.NewClient(stdin, stdout),
+		mountPoint: mountPoint,
+		stderr:     stderr,
+	}
+	waitForCookie := func() error {
+		_, err := os.Lstat(filepath.Join(mountPoint, ".cookie"))
+		return err
+	}
+	if err := waitFor(waitForCookie, startupDeadline); err != nil {
+		inst.stop()
+		return nil, fmt.Errorf("file system failed to come up: %v", err)
+	}
+	return inst, nil
+}
+
+// stop unmounts the instance and waits for the process to exit.
+func (inst *instance) stop() error {
+	defer inst.stderr.Close()
+
+	inst.stdin.Close()
+	unmount := func() error { return mounts.Unmount(inst.mountPoint) }
+	unmountErr := waitFor(unmount, shutdownDeadline)
+
+	timer := time.AfterFunc(shutdownDeadline, func() { inst.cmd.Process.Kill() })
+	defer timer.Stop()
+	if err := inst.cmd.Wait(); err != nil {
+		inst.stderr.Seek(0, io.SeekStart)
+		output, _ := ioutil.ReadAll(inst.stderr)
+		return fmt.Errorf("sandboxfs did not exit cleanly: %v; stderr:\n%s", err, output)
+	}
+	if unmountErr != nil {
+		return fmt.Errorf("failed to unmount %s: %v", inst.mountPoint, unmountErr)
+	}
+	return nil
+}
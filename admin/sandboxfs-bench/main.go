@@ -0,0 +1,245 @@
+// Copyright 2020 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not
+// use this file except in compliance with the License.  You may obtain a copy
+// of the License at:
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.  See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+// The sandboxfs-bench binary measures the performance of sandboxfs.
+//
+// The following workloads are supported:
+//
+//   - reconfig: sweeps reconfiguration throughput across values of --reconfig_threads and numbers
+//     of mappings per request.
+//   - fileops: compares the latency of stat, open/read, readdir of a large directory, and
+//     create/unlink in a read/write mapping through the mount point against the same operations
+//     performed directly on the underlying tree.
+//   - caching: sweeps the --ttl and --node_cache settings and measures the same file operations
+//     through the mount point.
+//
+// Results are written as JSON or CSV and include metadata about the environment in which they were
+// collected.  Progress messages are written to stderr.
+//
+// When --sandboxfs_binary is given twice, the first binary is taken as the baseline and the second
+// one as the candidate.  The selected workloads are run --rounds times against each binary, in
+// interleaved order, and the report contains the relative change of the median latency of every
+// configuration along with its bootstrap confidence interval and the p-value of a Mann-Whitney U
+// test.  The tool exits with code 3 if the candidate is significantly slower than the baseline by
+// more than --max_regression percent in any configuration.
+//
+// When --cpu_profile_dir is given, every sandboxfs instance is started with --cpu_profile, which
+// requires a binary built with the "profiling" feature.  Each profile is stored in that directory
+// along with a summary of its most expensive functions (.top.txt) and its stack traces in the
+// folded format used by flame graph generators (.folded), and its path is recorded in the results
+// that the instance produced.
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// parseInts parses a comma-separated list of positive integers.
+func parseInts(value string) ([]int, error) {
+	var result []int
+	for _, field := range strings.Split(value, ",") {
+		i, err := strconv.Atoi(field)
+		if err != nil || i < 1 {
+			return nil, fmt.Errorf("invalid positive integer %q", field)
+		}
+		result = append(result, i)
+	}
+	return result, nil
+}
+
+// errRegression indicates that a comparison found a performance regression.
+var errRegression = errors.New("candidate binary regressed")
+
+// binariesFlag is a repeatable flag.Value that collects --sandboxfs_binary values.
+type binariesFlag []string
+
+// String returns the textual representation of the flag's value.
+func (f *binariesFlag) String() string {
+	return strings.Join(*f, ",")
+}
+
+// Set records a new --sandboxfs_binary value.
+func (f *binariesFlag) Set(value string) error {
+	*f = append(*f, value)
+	return nil
+}
+
+// run parses the command line, runs the requested workloads and writes their results.
+func run(args []string) error {
+	fs := flag.NewFlagSet("sandboxfs-bench", flag.ContinueOnError)
+	var binaries binariesFlag
+	fs.Var(&binaries, "sandboxfs_binary", "path to the sandboxfs binary to benchmark; give twice to compare a baseline and a candidate")
+	names := fs.String("workloads", "reconfig,fileops,caching", "comma-separated list of workloads to run")
+	format := fs.String("format", "json", "output format: json or csv")
+	output := fs.String("output", "", "path to the file in which to write the results; stdout if empty")
+	workDir := fs.String("work_dir", "", "directory in which to create the test files; a temporary one if empty")
+	iterations := fs.Int("iterations", 1000, "number of times each cheap file operation is measured")
+	readdirIterations := fs.Int("readdir_iterations", 20, "number of times each readdir of the large directory is measured")
+	reconfigIterations := fs.Int("reconfig_iterations", 10, "number of request batches measured per reconfiguration setting")
+	threads := fs.String("threads", "1,2,4,8", "comma-separated values of --reconfig_threads to sweep")
+	mappings := fs.String("mappings", "1,10,100,1000", "comma-separated numbers of mappings per request to sweep")
+	concurrency := fs.Int("concurrency", 8, "number of reconfiguration requests in flight at once")
+	ttls := fs.String("ttls", "0s,60s", "comma-separated values of --ttl to sweep")
+	files := fs.Int("files", 1000, "number of files in the underlying tree")
+	largeDirEntries := fs.Int("large_dir_entries", 4096, "number of entries in the large directory")
+	rounds := fs.Int("rounds", 5, "number of interleaved runs of each workload per binary when comparing")
+	confidence := fs.Float64("confidence", 0.95, "confidence level of the comparison statistics")
+	maxRegression := fs.Float64("max_regression", 5, "largest tolerated increase of a median latency, in percent, when comparing")
+	bootstrapIterations := fs.Int("bootstrap_iterations", 1000, "number of resamples to compute confidence intervals")
+	cpuProfileDir := fs.String("cpu_profile_dir", "", "directory in which to store CPU profiles of every sandboxfs instance; requires a profiling build")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 0 {
+		return fmt.Errorf("too many arguments")
+	}
+	if *format != "json" && *format != "csv" {
+		return fmt.Errorf("invalid --format %s; must be json or csv", *format)
+	}
+	for _, value := range []int{*iterations, *readdirIterations, *reconfigIterations, *concurrency, *files, *rounds, *bootstrapIterations} {
+		if value < 1 {
+			return fmt.Errorf("iteration counts, --concurrency, --files and --rounds must be positive")
+		}
+	}
+	if *confidence <= 0 || *confidence >= 1 {
+		return fmt.Errorf("invalid --confidence %v; must be between 0 and 1", *confidence)
+	}
+	switch len(binaries) {
+	case 0:
+		binaries = binariesFlag{"sandboxfs"}
+	case 1, 2:
+	default:
+		return fmt.Errorf("--sandboxfs_binary can be given at most twice")
+	}
+
+	cfg := &config{
+		binary:             binaries[0],
+		iterations:         *iterations,
+		readdirIterations:  *readdirIterations,
+		reconfigIterations: *reconfigIterations,
+		concurrency:        *concurrency,
+		ttls:               strings.Split(*ttls, ","),
+		files:              *files,
+		largeDirEntries:    *largeDirEntries,
+	}
+	var err error
+	if cfg.threads, err = parseInts(*threads); err != nil {
+		return fmt.Errorf("invalid --threads: %v", err)
+	}
+	if cfg.mappings, err = parseInts(*mappings); err != nil {
+		return fmt.Errorf("invalid --mappings: %v", err)
+	}
+
+	if *cpuProfileDir != "" {
+		if err := os.MkdirAll(*cpuProfileDir, 0755); err != nil {
+			return fmt.Errorf("failed to create profiles directory: %v", err)
+		}
+		cfg.profiler = &profiler{dir: *cpuProfileDir}
+	}
+
+	var selected []string
+	for _, name := range strings.Split(*names, ",") {
+		if _, ok := workloads[name]; !ok {
+			return fmt.Errorf("unknown workload %s", name)
+		}
+		selected = append(selected, name)
+	}
+
+	if *workDir == "" {
+		dir, err := ioutil.TempDir("", "sandboxfs-bench")
+		if err != nil {
+			return fmt.Errorf("failed to create work directory: %v", err)
+		}
+		defer os.RemoveAll(dir)
+		*workDir = dir
+	}
+	cfg.root = filepath.Join(*workDir, "root")
+	cfg.mountPoint = filepath.Join(*workDir, "mnt")
+	if err := os.MkdirAll(cfg.mountPoint, 0755); err != nil {
+		return fmt.Errorf("failed to create mount point: %v", err)
+	}
+	log.Printf("setting up underlying tree in %s", cfg.root)
+	if err := setUpTree(cfg); err != nil {
+		return fmt.Errorf("failed to set up underlying tree: %v", err)
+	}
+
+	var w io.Writer = os.Stdout
+	if *output != "" {
+		file, err := os.Create(*output)
+		if err != nil {
+			return fmt.Errorf("failed to create output file: %v", err)
+		}
+		defer file.Close()
+		w = file
+	}
+
+	if len(binaries) == 2 {
+		settings := &compareSettings{
+			rounds:              *rounds,
+			confidence:          *confidence,
+			maxRegression:       *maxRegression,
+			bootstrapIterations: *bootstrapIterations,
+		}
+		r, err := runComparison(cfg, binaries[0], binaries[1], selected, settings)
+		if err != nil {
+			return err
+		}
+		if *format == "csv" {
+			err = r.writeCSV(w)
+		} else {
+			err = r.writeJSON(w)
+		}
+		if err != nil {
+			return err
+		}
+		if count := r.regressions(); count > 0 {
+			log.Printf("%d of %d configurations regressed by more than %v%%", count, len(r.Comparisons), *maxRegression)
+			return errRegression
+		}
+		return nil
+	}
+
+	r := &report{Environment: collectEnvironment(cfg.binary)}
+	for _, name := range selected {
+		results, err := workloads[name](cfg)
+		if err != nil {
+			return fmt.Errorf("workload %s failed: %v", name, err)
+		}
+		r.Results = append(r.Results, results...)
+	}
+	if *format == "csv" {
+		return r.writeCSV(w)
+	}
+	return r.writeJSON(w)
+}
+
+func main() {
+	log.SetPrefix("sandboxfs-bench: ")
+	if err := run(os.Args[1:]); err != nil {
+		if err == errRegression {
+			log.Print(err)
+			os.Exit(3)
+		}
+		log.Fatal(err)
+	}
+}
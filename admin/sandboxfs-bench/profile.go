@@ -0,0 +1,93 @@
+// Copyright 2020 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not
+// use this file except in compliance with the License.  You may obtain a copy
+// of the License at:
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.  See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/bazelbuild/sandboxfs/integration/cpuprof"
+)
+
+// profileTopFunctions is the number of functions included in the summary of each profile.
+const profileTopFunctions = 30
+
+// profiler collects CPU profiles from the sandboxfs instances started by the workloads.
+type profiler struct {
+	// dir is the directory in which to store the profiles and their summaries.
+	dir string
+
+	// count is the number of profiles collected so far, used to name the files.
+	count int
+}
+
+// next returns the path to the profile of the next instance started from binary.
+func (p *profiler) next(binary string) string {
+	p.count++
+	name := strings.TrimSuffix(filepath.Base(binary), filepath.Ext(binary))
+	return filepath.Join(p.dir, fmt.Sprintf("%03d-%s.prof", p.count, name))
+}
+
+// writeFile creates the file at path and fills it with write.
+func writeFile(path string, write func(*os.File) error) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := write(file); err != nil {
+		file.Close()
+		return fmt.Errorf("failed to write %s: %v", path, err)
+	}
+	return file.Close()
+}
+
+// analyze symbolizes the profile at path, written by binary, and stores its top functions and its
+// folded stack traces next to it.
+func (p *profiler) analyze(path string, binary string) error {
+	stacks, err := cpuprof.Load(path, binary)
+	if err != nil {
+		return err
+	}
+	base := strings.TrimSuffix(path, ".prof")
+	if err := writeFile(base+".top.txt", func(f *os.File) error { return cpuprof.WriteTop(f, stacks, profileTopFunctions) }); err != nil {
+		return err
+	}
+	return writeFile(base+".folded", func(f *os.File) error { return cpuprof.WriteFolded(f, stacks) })
+}
+
+// withProfile arranges for the instance started with args to write a CPU profile if profiling is
+// enabled.  Returns the arguments to use and a function to call once the instance has exited, which
+// analyzes the profile and records its path in the results.
+func withProfile(cfg *config, args []string) ([]string, func([]result)) {
+	if cfg.profiler == nil {
+		return args, func([]result) {}
+	}
+	path := cfg.profiler.next(cfg.binary)
+	args = append([]string{"--cpu_profile=" + path}, args...)
+	return args, func(results []result) {
+		// Failing to analyze a profile should not discard the measurements, which are the main
+		// purpose of a benchmark run, so only warn about it.
+		if err := cfg.profiler.analyze(path, cfg.binary); err != nil {
+			log.Printf("WARNING: failed to analyze CPU profile %s: %v", path, err)
+			return
+		}
+		for i := range results {
+			results[i].Profile = path
+		}
+	}
+}
@@ -0,0 +1,48 @@
+// Copyright 2020 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not
+// use this file except in compliance with the License.  You may obtain a copy
+// of the License at:
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.  See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestWithProfile(t *testing.T) {
+	t.Run("Disabled", func(t *testing.T) {
+		cfg := &config{binary: "/usr/bin/sandboxfs"}
+		args, _ := withProfile(cfg, []string{"--node_cache"})
+		if want := []string{"--node_cache"}; !reflect.DeepEqual(args, want) {
+			t.Errorf("Got %v; want %v", args, want)
+		}
+	})
+
+	t.Run("Enabled", func(t *testing.T) {
+		cfg := &config{binary: "/usr/bin/sandboxfs", profiler: &profiler{dir: "/profiles"}}
+		testData := []struct {
+			binary string
+			want   []string
+		}{
+			{"/usr/bin/sandboxfs", []string{"--cpu_profile=/profiles/001-sandboxfs.prof", "--node_cache"}},
+			{"/tmp/candidate.bin", []string{"--cpu_profile=/profiles/002-candidate.prof", "--node_cache"}},
+		}
+		for _, d := range testData {
+			cfg.binary = d.binary
+			args, _ := withProfile(cfg, []string{"--node_cache"})
+			if !reflect.DeepEqual(args, d.want) {
+				t.Errorf("Got %v; want %v", args, d.want)
+			}
+		}
+	})
+}
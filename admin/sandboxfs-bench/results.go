@@ -0,0 +1,165 @@
+// Copyright 2020 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not
+// use this file except in compliance with the License.  You may obtain a copy
+// of the License at:
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.  See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"runtime"
+	"sort"
+	"strings"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// result holds the measurements of a single benchmark configuration.
+type result struct {
+	// Workload is the name of the workload that produced the result.
+	Workload string `json:"workload"`
+
+	// Params describes the configuration of the measurement, such as the sandboxfs flags used
+	// or the operation that was measured.
+	Params map[string]string `json:"params"`
+
+	// Latency is the distribution of the latency of each operation.
+	Latency summary `json:"latency"`
+
+	// Throughput is the number of operations per second, if meaningful for the workload.
+	Throughput float64 `json:"ops_per_second,omitempty"`
+
+	// Profile is the path to the CPU profile of the sandboxfs instance that was measured, if any.
+	Profile string `json:"profile,omitempty"`
+
+	// samples contains the raw latencies summarized in Latency, which comparisons need.
+	samples []time.Duration
+}
+
+// key returns a string that identifies the configuration of the result within a report.
+func (r *result) key() string {
+	params := make([]string, 0, len(r.Params))
+	for key, value := range r.Params {
+		params = append(params, key+"="+value)
+	}
+	sort.Strings(params)
+	return r.Workload + " " + strings.Join(params, " ")
+}
+
+// environment describes the machine and the software on which the benchmarks ran.
+type environment struct {
+	Time             time.Time `json:"time"`
+	Hostname         string    `json:"hostname"`
+	OS               string    `json:"os"`
+	Arch             string    `json:"arch"`
+	Kernel           string    `json:"kernel"`
+	CPUs             int       `json:"cpus"`
+	GoVersion        string    `json:"go_version"`
+	SandboxfsBinary  string    `json:"sandboxfs_binary"`
+	SandboxfsVersion string    `json:"sandboxfs_version"`
+	Args             []string  `json:"args"`
+}
+
+// collectEnvironment gathers the metadata of the current machine and the given sandboxfs binary.
+func collectEnvironment(binary string) environment {
+	env := environment{
+		Time:            time.Now(),
+		OS:              runtime.GOOS,
+		Arch:            runtime.GOARCH,
+		CPUs:            runtime.NumCPU(),
+		GoVersion:       runtime.Version(),
+		SandboxfsBinary: binary,
+		Args:            os.Args[1:],
+	}
+	env.Hostname, _ = os.Hostname()
+
+	var uname unix.Utsname
+	if err := unix.Uname(&uname); err == nil {
+		cString := func(b []byte) string { return strings.TrimRight(string(b), "\x00") }
+		env.Kernel = fmt.Sprintf("%s %s", cString(uname.Sysname[:]), cString(uname.Release[:]))
+	}
+
+	if output, err := exec.Command(binary, "--version").Output(); err == nil {
+		env.SandboxfsVersion = strings.TrimSpace(string(output))
+	}
+	return env
+}
+
+// report is the complete output of a benchmark run.
+type report struct {
+	Environment environment `json:"environment"`
+	Results     []result    `json:"results"`
+}
+
+// writeJSON writes the report to w as indented JSON.
+func (r *report) writeJSON(w io.Writer) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(r); err != nil {
+		return fmt.Errorf("cannot encode results: %v", err)
+	}
+	return nil
+}
+
+// paramNames returns the sorted names of all parameters used by any of the given results.
+func paramNames(results []result) []string {
+	keys := make(map[string]bool)
+	for _, res := range results {
+		for key := range res.Params {
+			keys[key] = true
+		}
+	}
+	params := make([]string, 0, len(keys))
+	for key := range keys {
+		params = append(params, key)
+	}
+	sort.Strings(params)
+	return params
+}
+
+// writeCSV writes the results to w as CSV, with one column per parameter used by any result.  The
+// environment is written as comment lines before the header because CSV has no place for it.
+func (r *report) writeCSV(w io.Writer) error {
+	env, err := json.Marshal(r.Environment)
+	if err != nil {
+		return fmt.Errorf("cannot encode environment: %v", err)
+	}
+	if _, err := fmt.Fprintf(w, "# environment: %s\n", env); err != nil {
+		return err
+	}
+
+	params := paramNames(r.Results)
+	cw := csv.NewWriter(w)
+	header := append([]string{"workload"}, params...)
+	header = append(header, "samples", "mean_us", "min_us", "p50_us", "p90_us", "p99_us", "max_us", "ops_per_second")
+	cw.Write(header)
+	for _, res := range r.Results {
+		row := []string{res.Workload}
+		for _, param := range params {
+			row = append(row, res.Params[param])
+		}
+		l := res.Latency
+		row = append(row, fmt.Sprintf("%d", l.Samples))
+		for _, value := range []float64{l.Mean, l.Min, l.P50, l.P90, l.P99, l.Max, res.Throughput} {
+			row = append(row, fmt.Sprintf("%.3f", value))
+		}
+		cw.Write(row)
+	}
+	cw.Flush()
+	return cw.Error()
+}
@@ -0,0 +1,172 @@
+// Copyright 2020 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not
+// use this file except in compliance with the License.  You may obtain a copy
+// of the License at:
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.  See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package main
+
+import (
+	"math"
+	"math/rand"
+	"sort"
+	"time"
+)
+
+// summary describes the distribution of a set of samples, in microseconds.
+type summary struct {
+	Samples int     `json:"samples"`
+	Mean    float64 `json:"mean_us"`
+	Min     float64 `json:"min_us"`
+	P50     float64 `json:"p50_us"`
+	P90     float64 `json:"p90_us"`
+	P99     float64 `json:"p99_us"`
+	Max     float64 `json:"max_us"`
+}
+
+// microseconds converts a duration to fractional microseconds.
+func microseconds(d time.Duration) float64 {
+	return float64(d) / float64(time.Microsecond)
+}
+
+// summarize computes the distribution of a set of durations using nearest-rank percentiles.
+func summarize(samples []time.Duration) summary {
+	if len(samples) == 0 {
+		return summary{}
+	}
+	sorted := make([]time.Duration, len(samples))
+	copy(sorted, samples)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+
+	var total time.Duration
+	for _, sample := range sorted {
+		total += sample
+	}
+	percentile := func(p int) float64 {
+		rank := (p*len(sorted) + 99) / 100
+		if rank < 1 {
+			rank = 1
+		}
+		return microseconds(sorted[rank-1])
+	}
+	return summary{
+		Samples: len(sorted),
+		Mean:    microseconds(total / time.Duration(len(sorted))),
+		Min:     microseconds(sorted[0]),
+		P50:     percentile(50),
+		P90:     percentile(90),
+		P99:     percentile(99),
+		Max:     microseconds(sorted[len(sorted)-1]),
+	}
+}
+
+// median returns the median of a set of values, which must not be empty.  values is sorted in place.
+func median(values []float64) float64 {
+	sort.Float64s(values)
+	n := len(values)
+	if n%2 == 1 {
+		return values[n/2]
+	}
+	return (values[n/2-1] + values[n/2]) / 2
+}
+
+// toMicroseconds converts a set of durations to fractional microseconds.
+func toMicroseconds(samples []time.Duration) []float64 {
+	values := make([]float64, len(samples))
+	for i, sample := range samples {
+		values[i] = microseconds(sample)
+	}
+	return values
+}
+
+// mannWhitney performs a two-sided Mann-Whitney U test on two independent sets of samples and returns
+// the p-value of the hypothesis that both sets come from the same distribution.  Uses the normal
+// approximation with tie and continuity corrections, which is accurate for the sample sizes that the
+// workloads collect.
+func mannWhitney(a []float64, b []float64) float64 {
+	type sample struct {
+		value float64
+		first bool
+	}
+	all := make([]sample, 0, len(a)+len(b))
+	for _, value := range a {
+		all = append(all, sample{value, true})
+	}
+	for _, value := range b {
+		all = append(all, sample{value, false})
+	}
+	sort.Slice(all, func(i, j int) bool { return all[i].value < all[j].value })
+
+	// Compute the rank sum of the first set, assigning the average rank to tied values.
+	var rankSum, tieSum float64
+	for i := 0; i < len(all); {
+		j := i
+		for j < len(all) && all[j].value == all[i].value {
+			j++
+		}
+		rank := float64(i+j+1) / 2
+		for k := i; k < j; k++ {
+			if all[k].first {
+				rankSum += rank
+			}
+		}
+		ties := float64(j - i)
+		tieSum += ties*ties*ties - ties
+		i = j
+	}
+
+	n1, n2 := float64(len(a)), float64(len(b))
+	n := n1 + n2
+	u := rankSum - n1*(n1+1)/2
+	mean := n1 * n2 / 2
+	variance := n1 * n2 / 12 * ((n + 1) - tieSum/(n*(n-1)))
+	if variance <= 0 {
+		return 1
+	}
+	z := math.Max(math.Abs(u-mean)-0.5, 0) / math.Sqrt(variance)
+	return math.Erfc(z / math.Sqrt2)
+}
+
+// bootstrapMedianDelta estimates a confidence interval for the relative difference between the
+// medians of candidate and baseline, in percent, by resampling both sets iterations times.
+func bootstrapMedianDelta(baseline []float64, candidate []float64, confidence float64, iterations int, rng *rand.Rand) (float64, float64) {
+	resample := func(values []float64, buffer []float64) float64 {
+		for i := range buffer {
+			buffer[i] = values[rng.Intn(len(values))]
+		}
+		return median(buffer)
+	}
+
+	baselineBuffer := make([]float64, len(baseline))
+	candidateBuffer := make([]float64, len(candidate))
+	deltas := make([]float64, 0, iterations)
+	for i := 0; i < iterations; i++ {
+		base := resample(baseline, baselineBuffer)
+		if base == 0 {
+			continue
+		}
+		deltas = append(deltas, (resample(candidate, candidateBuffer)/base-1)*100)
+	}
+	if len(deltas) == 0 {
+		return 0, 0
+	}
+	sort.Float64s(deltas)
+
+	tail := (1 - confidence) / 2
+	index := func(q float64) int {
+		i := int(q * float64(len(deltas)-1))
+		if i < 0 {
+			return 0
+		}
+		return i
+	}
+	return deltas[index(tail)], deltas[index(1-tail)]
+}
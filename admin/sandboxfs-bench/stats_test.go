@@ -0,0 +1,127 @@
+// Copyright 2020 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not
+// use this file except in compliance with the License.  You may obtain a copy
+// of the License at:
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.  See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package main
+
+import (
+	"math/rand"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestSummarize(t *testing.T) {
+	micros := func(values ...int) []time.Duration {
+		var samples []time.Duration
+		for _, value := range values {
+			samples = append(samples, time.Duration(value)*time.Microsecond)
+		}
+		return samples
+	}
+
+	var hundred []int
+	for i := 100; i > 0; i-- {
+		hundred = append(hundred, i)
+	}
+
+	testData := []struct {
+		name    string
+		samples []time.Duration
+		want    summary
+	}{
+		{"empty", nil, summary{}},
+		{"single", micros(7), summary{Samples: 1, Mean: 7, Min: 7, P50: 7, P90: 7, P99: 7, Max: 7}},
+		{"unsorted", micros(4, 1, 3, 2), summary{Samples: 4, Mean: 2.5, Min: 1, P50: 2, P90: 4, P99: 4, Max: 4}},
+		{"hundred", micros(hundred...), summary{Samples: 100, Mean: 50.5, Min: 1, P50: 50, P90: 90, P99: 99, Max: 100}},
+	}
+	for _, d := range testData {
+		t.Run(d.name, func(t *testing.T) {
+			got := summarize(d.samples)
+			if !reflect.DeepEqual(got, d.want) {
+				t.Errorf("Got %+v; want %+v", got, d.want)
+			}
+		})
+	}
+}
+
+func TestMedian(t *testing.T) {
+	testData := []struct {
+		name   string
+		values []float64
+		want   float64
+	}{
+		{"single", []float64{3}, 3},
+		{"odd", []float64{5, 1, 3}, 3},
+		{"even", []float64{4, 1, 3, 2}, 2.5},
+	}
+	for _, d := range testData {
+		t.Run(d.name, func(t *testing.T) {
+			if got := median(d.values); got != d.want {
+				t.Errorf("Got %v; want %v", got, d.want)
+			}
+		})
+	}
+}
+
+func TestMannWhitney(t *testing.T) {
+	sequence := func(start float64, n int) []float64 {
+		values := make([]float64, n)
+		for i := range values {
+			values[i] = start + float64(i)
+		}
+		return values
+	}
+
+	testData := []struct {
+		name    string
+		a       []float64
+		b       []float64
+		wantMin float64
+		wantMax float64
+	}{
+		{"identical", sequence(0, 50), sequence(0, 50), 0.99, 1},
+		{"all ties", []float64{1, 1, 1}, []float64{1, 1}, 1, 1},
+		{"overlapping", sequence(0, 50), sequence(5, 50), 0.05, 0.99},
+		{"disjoint", sequence(0, 50), sequence(100, 50), 0, 1e-10},
+		{"disjoint reversed", sequence(100, 50), sequence(0, 50), 0, 1e-10},
+	}
+	for _, d := range testData {
+		t.Run(d.name, func(t *testing.T) {
+			got := mannWhitney(d.a, d.b)
+			if got < d.wantMin || got > d.wantMax {
+				t.Errorf("Got %v; want value in [%v, %v]", got, d.wantMin, d.wantMax)
+			}
+		})
+	}
+}
+
+func TestBootstrapMedianDelta(t *testing.T) {
+	baseline := make([]float64, 200)
+	candidate := make([]float64, 200)
+	for i := range baseline {
+		baseline[i] = 100 + float64(i%10)
+		candidate[i] = 1.2 * baseline[i]
+	}
+	rng := rand.New(rand.NewSource(1))
+
+	low, high := bootstrapMedianDelta(baseline, candidate, 0.95, 500, rng)
+	if low > 20 || high < 20 || high-low > 10 {
+		t.Errorf("Got interval [%v, %v]; want narrow interval containing 20", low, high)
+	}
+
+	low, high = bootstrapMedianDelta(baseline, baseline, 0.95, 500, rng)
+	if low > 0 || high < 0 {
+		t.Errorf("Got interval [%v, %v]; want interval containing 0", low, high)
+	}
+}
@@ -0,0 +1,372 @@
+// Copyright 2020 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not
+// use this file except in compliance with the License.  You may obtain a copy
+// of the License at:
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.  See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/bazelbuild/sandboxfs/integration/protocol"
+)
+
+// config holds the settings of a benchmark run.
+type config struct {
+	// binary is the path to the sandboxfs binary to benchmark.
+	binary string
+
+	// root is the directory holding the underlying files used by the workloads.
+	root string
+
+	// mountPoint is the directory where sandboxfs is mounted.
+	mountPoint string
+
+	// iterations is the number of times each cheap operation is measured.
+	iterations int
+
+	// readdirIterations is the number of times each readdir of the large directory is measured.
+	readdirIterations int
+
+	// reconfigIterations is the number of batches of requests measured per configuration.
+	reconfigIterations int
+
+	// threads contains the values of --reconfig_threads to sweep.
+	threads []int
+
+	// mappings contains the numbers of mappings per request to sweep.
+	mappings []int
+
+	// concurrency is the number of reconfiguration requests in flight at once.
+	concurrency int
+
+	// ttls contains the values of --ttl to sweep.
+	ttls []string
+
+	// files is the number of files in the underlying tree to operate on.
+	files int
+
+	// largeDirEntries is the number of entries in the large directory.
+	largeDirEntries int
+
+	// profiler collects CPU profiles from every sandboxfs instance, or is nil to not profile.
+	profiler *profiler
+}
+
+// fileName returns the relative path to the i-th file in the underlying tree.
+func fileName(i int) string {
+	return filepath.Join("files", fmt.Sprintf("f%06d", i))
+}
+
+// setUpTree creates the underlying files used by all workloads.
+func setUpTree(cfg *config) error {
+	files := cfg.files
+	for _, count := range cfg.mappings {
+		if count > files {
+			files = count
+		}
+	}
+
+	for _, dir := range []string{"files", "large", "rw"} {
+		if err := os.MkdirAll(filepath.Join(cfg.root, dir), 0755); err != nil {
+			return err
+		}
+	}
+	content := make([]byte, 4096)
+	for i := 0; i < files; i++ {
+		if err := ioutil.WriteFile(filepath.Join(cfg.root, fileName(i)), content, 0644); err != nil {
+			return err
+		}
+	}
+	// This mimics the scenario in TestReadOnly_ReadLargeDir.
+	for i := 0; i < cfg.largeDirEntries; i++ {
+		name := fmt.Sprintf("this-is-a-long-file-name-%08d", i)
+		if err := ioutil.WriteFile(filepath.Join(cfg.root, "large", name), nil, 0644); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// measure runs op n times and returns the duration of each run.
+func measure(n int, op func(i int) error) ([]time.Duration, error) {
+	samples := make([]time.Duration, 0, n)
+	for i := 0; i < n; i++ {
+		start := time.Now()
+		if err := op(i); err != nil {
+			return nil, err
+		}
+		samples = append(samples, time.Since(start))
+	}
+	return samples, nil
+}
+
+// fileOp represents a file system operation to measure.
+type fileOp struct {
+	// name is the name of the operation as reported in the results.
+	name string
+
+	// run executes the operation for the i-th time on the tree rooted at base.
+	run func(cfg *config, base string, i int) error
+
+	// readdir is true if the operation is expensive and thus uses the readdir iteration count.
+	readdir bool
+}
+
+// fileOps contains all file system operations measured by the file-based workloads.
+var fileOps = []fileOp{
+	{"stat", func(cfg *config, base string, i int) error {
+		_, err := os.Lstat(filepath.Join(base, fileName(i%cfg.files)))
+		return err
+	}, false},
+	{"open-read", func(cfg *config, base string, i int) error {
+		_, err := ioutil.ReadFile(filepath.Join(base, fileName(i%cfg.files)))
+		return err
+	}, false},
+	{"readdir-large", func(cfg *config, base string, i int) error {
+		entries, err := ioutil.ReadDir(filepath.Join(base, "large"))
+		if err == nil && len(entries) != cfg.largeDirEntries {
+			err = fmt.Errorf("got %d entries in large directory; want %d", len(entries), cfg.largeDirEntries)
+		}
+		return err
+	}, true},
+	{"create-unlink", func(cfg *config, base string, i int) error {
+		path := filepath.Join(base, "rw", fmt.Sprintf("tmp-%d", i))
+		file, err := os.Create(path)
+		if err != nil {
+			return err
+		}
+		if err := file.Close(); err != nil {
+			return err
+		}
+		return os.Remove(path)
+	}, false},
+}
+
+// measureFileOps measures the given operations on the tree rooted at base.  params are the
+// parameters that describe the configuration and are extended with the operation name.
+func measureFileOps(cfg *config, workload string, base string, ops []fileOp, params map[string]string) ([]result, error) {
+	var results []result
+	for _, op := range ops {
+		n := cfg.iterations
+		if op.readdir {
+			n = cfg.readdirIterations
+		}
+		run := func(i int) error { return op.run(cfg, base, i) }
+		if err := run(0); err != nil { // Warm up.
+			return nil, fmt.Errorf("%s failed on %s: %v", op.name, base, err)
+		}
+		samples, err := measure(n, run)
+		if err != nil {
+			return nil, fmt.Errorf("%s failed on %s: %v", op.name, base, err)
+		}
+
+		resultParams := map[string]string{"operation": op.name}
+		for key, value := range params {
+			resultParams[key] = value
+		}
+		latency := summarize(samples)
+		results = append(results, result{
+			Workload:   workload,
+			Params:     resultParams,
+			Latency:    latency,
+			Throughput: 1e6 / latency.Mean,
+			samples:    samples,
+		})
+	}
+	return results, nil
+}
+
+// withInstance runs fn against a new sandboxfs instance started with the given arguments.
+func withInstance(cfg *config, fn func(*instance) ([]result, error), args ...string) ([]result, error) {
+	args, done := withProfile(cfg, args)
+	inst, err := startInstance(cfg.binary, cfg.mountPoint, cfg.root, args...)
+	if err != nil {
+		return nil, err
+	}
+	results, err := fn(inst)
+	if stopErr := inst.stop(); stopErr != nil && err == nil {
+		err = stopErr
+	}
+	if err != nil {
+		return nil, err
+	}
+	done(results)
+	return results, nil
+}
+
+// runBatch sends a batch of requests in parallel and waits for all of them.  Returns the latency
+// of each request and the time it took for the whole batch to complete.
+func runBatch(client *protocol.Client, reqs []protocol.Request) ([]time.Duration, time.Duration, error) {
+	start := time.Now()
+	calls := make([]*protocol.Call, len(reqs))
+	for i, req := range reqs {
+		call, err := client.Start(req)
+		if err != nil {
+			return nil, 0, err
+		}
+		calls[i] = call
+	}
+
+	latencies := make([]time.Duration, len(reqs))
+	for i, call := range calls {
+		resp, err := call.Wait(time.Minute)
+		if err == nil {
+			err = resp.Err()
+		}
+		if err != nil {
+			return nil, 0, fmt.Errorf("request for %s failed: %v", reqs[i].ID(), err)
+		}
+		// Responses are awaited in order so this overestimates the latency of requests that
+		// complete early, but it never misses time actually spent by sandboxfs.
+		latencies[i] = time.Since(start)
+	}
+	return latencies, time.Since(start), nil
+}
+
+// runReconfig implements the workload that measures reconfiguration throughput across different
+// numbers of reconfiguration threads and mappings per request.
+func runReconfig(cfg *config) ([]result, error) {
+	var results []result
+	for _, threads := range cfg.threads {
+		log.Printf("reconfig: measuring with %d threads", threads)
+		measureThreads := func(inst *instance) ([]result, error) {
+			var results []result
+			for _, count := range cfg.mappings {
+				var creates, destroys []time.Duration
+				var createWall, destroyWall time.Duration
+				for iter := 0; iter < cfg.reconfigIterations; iter++ {
+					var createReqs, destroyReqs []protocol.Request
+					for j := 0; j < cfg.concurrency; j++ {
+						id := fmt.Sprintf("bench-%d-%d", iter, j)
+						mappings := make([]protocol.Mapping, count)
+						for k := range mappings {
+							mappings[k] = protocol.Mapping{
+								Path:           "/" + fileName(k),
+								UnderlyingPath: filepath.Join(cfg.root, fileName(k)),
+							}
+						}
+						createReqs = append(createReqs, protocol.MakeCreateSandboxRequest(id, mappings[0], mappings[1:]...))
+						destroyReqs = append(destroyReqs, protocol.MakeDestroySandboxRequest(id))
+					}
+
+					latencies, wall, err := runBatch(inst.client, createReqs)
+					if err != nil {
+						return nil, err
+					}
+					creates = append(creates, latencies...)
+					createWall += wall
+
+					latencies, wall, err = runBatch(inst.client, destroyReqs)
+					if err != nil {
+						return nil, err
+					}
+					destroys = append(destroys, latencies...)
+					destroyWall += wall
+				}
+
+				params := func(operation string) map[string]string {
+					return map[string]string{
+						"operation":        operation,
+						"reconfig_threads": strconv.Itoa(threads),
+						"mappings":         strconv.Itoa(count),
+						"concurrency":      strconv.Itoa(cfg.concurrency),
+					}
+				}
+				results = append(results,
+					result{
+						Workload:   "reconfig",
+						Params:     params("create"),
+						Latency:    summarize(creates),
+						Throughput: float64(len(creates)) / createWall.Seconds(),
+						samples:    creates,
+					},
+					result{
+						Workload:   "reconfig",
+						Params:     params("destroy"),
+						Latency:    summarize(destroys),
+						Throughput: float64(len(destroys)) / destroyWall.Seconds(),
+						samples:    destroys,
+					})
+			}
+			return results, nil
+		}
+		threadResults, err := withInstance(cfg, measureThreads, fmt.Sprintf("--reconfig_threads=%d", threads))
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, threadResults...)
+	}
+	return results, nil
+}
+
+// runFileOps implements the workload that compares the latency of file operations through the
+// mount point with the latency of the same operations on the underlying tree.
+func runFileOps(cfg *config) ([]result, error) {
+	log.Printf("fileops: measuring directly on the underlying tree")
+	results, err := measureFileOps(cfg, "fileops", cfg.root, fileOps, map[string]string{"target": "direct"})
+	if err != nil {
+		return nil, err
+	}
+
+	log.Printf("fileops: measuring through the mount point")
+	measureMount := func(inst *instance) ([]result, error) {
+		return measureFileOps(cfg, "fileops", inst.mountPoint, fileOps, map[string]string{"target": "mount"})
+	}
+	mountResults, err := withInstance(cfg, measureMount, "--mapping=rw:/rw:"+filepath.Join(cfg.root, "rw"))
+	if err != nil {
+		return nil, err
+	}
+	return append(results, mountResults...), nil
+}
+
+// runCaching implements the workload that measures the impact of the --ttl and --node_cache
+// settings on the latency of file operations through the mount point.
+func runCaching(cfg *config) ([]result, error) {
+	var results []result
+	for _, ttl := range cfg.ttls {
+		for _, nodeCache := range []bool{false, true} {
+			log.Printf("caching: measuring with --ttl=%s and node cache %v", ttl, nodeCache)
+			args := []string{"--ttl=" + ttl, "--mapping=rw:/rw:" + filepath.Join(cfg.root, "rw")}
+			if nodeCache {
+				args = append(args, "--node_cache")
+			}
+			params := map[string]string{
+				"target":     "mount",
+				"ttl":        ttl,
+				"node_cache": strconv.FormatBool(nodeCache),
+			}
+			measureMount := func(inst *instance) ([]result, error) {
+				return measureFileOps(cfg, "caching", inst.mountPoint, fileOps, params)
+			}
+			cachingResults, err := withInstance(cfg, measureMount, args...)
+			if err != nil {
+				return nil, err
+			}
+			results = append(results, cachingResults...)
+		}
+	}
+	return results, nil
+}
+
+// workloads contains all known workloads, keyed by name.
+var workloads = map[string]func(cfg *config) ([]result, error){
+	"reconfig": runReconfig,
+	"fileops":  runFileOps,
+	"caching":  runCaching,
+}
@@ -0,0 +1,385 @@
+// Copyright 2020 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not
+// use this file except in compliance with the License.  You may obtain a copy
+// of the License at:
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.  See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+// The sandboxfs-exec binary runs a command rooted in a sandbox of a running sandboxfs instance.
+//
+// The tool creates a sandbox from a manifest and/or --mapping flags, runs the given command with
+// its root directory changed to the sandbox, and destroys the sandbox once the command terminates.
+// The command runs in new user and mount namespaces, and all mounts are made private within the
+// latter so that mount events do not propagate between the command and the rest of the system.
+// The exit status of the command is passed through; failures of the tool itself exit with code 125.
+//
+// When running as root, the user namespace maps the identity given to --user, or root, to itself,
+// so the command acts as that identity when accessing any file.  When running unprivileged, the
+// only identity that can be mapped is the invoking user's: --user then only changes the identity
+// of the command within its user namespace, and sandboxfs must have been mounted with --allow=other
+// for the command to access the sandbox.
+//
+// As with sandboxfsctl, sandboxfs freezes its mappings once its input FIFO is closed, so prefer
+// to talk to it through the socket of a "sandboxfsctl serve" daemon.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/signal"
+	"os/user"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/bazelbuild/sandboxfs/integration/protocol"
+	"golang.org/x/sys/unix"
+)
+
+// toolFailureExitCode is the exit code returned when the tool fails, as opposed to the command.
+const toolFailureExitCode = 125
+
+// Names of the environment variables that turn a re-execution of the tool into the stage that
+// enters the sandbox.  See newCommand and enterSandbox for details.
+const (
+	stageRootEnv = "SANDBOXFS_EXEC_ROOT"
+	stageCwdEnv  = "SANDBOXFS_EXEC_CWD"
+)
+
+// identity represents the credentials of a user.
+type identity struct {
+	uid    int
+	gid    int
+	groups []int
+}
+
+// lookupIdentity looks up the credentials of a user by name.
+func lookupIdentity(name string) (*identity, error) {
+	u, err := user.Lookup(name)
+	if err != nil {
+		return nil, fmt.Errorf("cannot find user %s: %v", name, err)
+	}
+	id := &identity{}
+	if id.uid, err = strconv.Atoi(u.Uid); err != nil {
+		return nil, fmt.Errorf("invalid uid %s for user %s: %v", u.Uid, name, err)
+	}
+	if id.gid, err = strconv.Atoi(u.Gid); err != nil {
+		return nil, fmt.Errorf("invalid gid %s for user %s: %v", u.Gid, name, err)
+	}
+	groups, err := u.GroupIds()
+	if err != nil {
+		return nil, fmt.Errorf("cannot get groups for %s: %v", name, err)
+	}
+	for _, group := range groups {
+		gid, err := strconv.Atoi(group)
+		if err != nil {
+			return nil, fmt.Errorf("invalid secondary gid %s for user %s: %v", group, name, err)
+		}
+		id.groups = append(id.groups, gid)
+	}
+	return id, nil
+}
+
+// sandbox represents a sandbox created by this tool.
+type sandbox struct {
+	// conn is the connection to the sandboxfs instance that owns the sandbox.
+	conn *protocol.Conn
+
+	// id is the identifier of the sandbox.
+	id string
+
+	// timeout is the maximum time to wait for each response from sandboxfs.
+	timeout time.Duration
+}
+
+// do sends a request to sandboxfs and waits for its successful completion.
+func (s *sandbox) do(req protocol.Request) error {
+	resp, err := s.conn.Do(req, s.timeout)
+	if err != nil {
+		return err
+	}
+	return resp.Err()
+}
+
+// createSandbox creates a sandbox from an optional manifest and a set of additional mappings.
+func createSandbox(conn *protocol.Conn, id string, manifest string, mappings []protocol.Mapping, timeout time.Duration) (*sandbox, error) {
+	req := &protocol.CreateSandboxRequest{}
+	if manifest != "" {
+		var err error
+		req, err = protocol.ReadManifest(manifest)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if id != "" {
+		req.ID = id
+	} else if req.ID == "" {
+		req.ID = fmt.Sprintf("exec-%d", os.Getpid())
+	}
+	req.Mappings = append(req.Mappings, mappings...)
+	if len(req.Mappings) == 0 {
+		return nil, fmt.Errorf("sandbox %s has no mappings; need a manifest or --mapping flags", req.ID)
+	}
+
+	// Resolve prefixes locally because they are global to the reconfiguration stream, which may be
+	// shared with other clients.
+	resolved, err := protocol.NewPrefixes().Resolve(req)
+	if err != nil {
+		return nil, fmt.Errorf("invalid request: %v", err)
+	}
+
+	s := &sandbox{conn: conn, id: req.ID, timeout: timeout}
+	create := protocol.Request{CreateSandbox: &protocol.CreateSandboxRequest{ID: req.ID, Mappings: resolved}}
+	if err := s.do(create); err != nil {
+		return nil, fmt.Errorf("failed to create sandbox %s: %v", req.ID, err)
+	}
+	return s, nil
+}
+
+// destroy destroys the sandbox.
+func (s *sandbox) destroy() error {
+	if err := s.do(protocol.MakeDestroySandboxRequest(s.id)); err != nil {
+		return fmt.Errorf("failed to destroy sandbox %s: %v", s.id, err)
+	}
+	return nil
+}
+
+// newCommand prepares a command to run rooted at root as the given user, which may be nil to run
+// as the current user.
+//
+// The mounts inherited by the new mount namespace must be made private before changing the root
+// directory, which exec.Cmd cannot do.  Therefore, the returned command re-executes this tool,
+// which enters the sandbox from within the namespaces and then executes the real command.  The
+// tool keeps just enough capabilities to do so, which it drops before executing the command.
+func newCommand(root string, cwd string, user *identity, args []string) (*exec.Cmd, error) {
+	self, err := os.Executable()
+	if err != nil {
+		return nil, err
+	}
+
+	cmd := exec.Command(self, args[1:]...)
+	cmd.Args[0] = args[0]
+	cmd.Env = append(os.Environ(), stageRootEnv+"="+root, stageCwdEnv+"="+cwd)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.SysProcAttr = namespaceAttrs(identity{uid: os.Getuid(), gid: os.Getgid()}, user)
+	return cmd, nil
+}
+
+// namespaceAttrs computes the attributes to spawn a process in new user and mount namespaces as
+// the given user, or as the caller if user is nil.
+//
+// If the caller is root, the user namespace maps the requested credentials to themselves.
+// Otherwise, the only identity that can be mapped is the caller's, which becomes the requested
+// one within the namespace.
+func namespaceAttrs(caller identity, user *identity) *syscall.SysProcAttr {
+	attrs := &syscall.SysProcAttr{
+		Cloneflags:  syscall.CLONE_NEWUSER | syscall.CLONE_NEWNS,
+		AmbientCaps: []uintptr{unix.CAP_SYS_ADMIN, unix.CAP_SYS_CHROOT},
+	}
+
+	inner := &caller
+	if user != nil {
+		inner = user
+	}
+
+	if caller.uid == 0 {
+		attrs.UidMappings = []syscall.SysProcIDMap{{ContainerID: inner.uid, HostID: inner.uid, Size: 1}}
+		seen := make(map[int]bool)
+		groups := make([]uint32, 0, len(inner.groups))
+		for _, gid := range append([]int{inner.gid}, inner.groups...) {
+			if !seen[gid] {
+				attrs.GidMappings = append(attrs.GidMappings, syscall.SysProcIDMap{ContainerID: gid, HostID: gid, Size: 1})
+				seen[gid] = true
+			}
+		}
+		for _, gid := range inner.groups {
+			groups = append(groups, uint32(gid))
+		}
+		attrs.GidMappingsEnableSetgroups = true
+		attrs.Credential = &syscall.Credential{Uid: uint32(inner.uid), Gid: uint32(inner.gid), Groups: groups}
+		return attrs
+	}
+
+	attrs.UidMappings = []syscall.SysProcIDMap{{ContainerID: inner.uid, HostID: caller.uid, Size: 1}}
+	attrs.GidMappings = []syscall.SysProcIDMap{{ContainerID: inner.gid, HostID: caller.gid, Size: 1}}
+	attrs.GidMappingsEnableSetgroups = false
+	// Secondary groups cannot be mapped into the namespace, so don't carry them over.
+	attrs.Credential = &syscall.Credential{Uid: uint32(inner.uid), Gid: uint32(inner.gid), NoSetGroups: true}
+	return attrs
+}
+
+// enterSandbox runs within the namespaces created by newCommand.  It makes all mounts private,
+// changes the root and working directories, drops the capabilities that the tool kept for this
+// purpose, and executes the real command.  Only returns on failure.
+func enterSandbox(root string, cwd string, args []string) error {
+	// Capabilities are per-thread, so make sure we drop them from the thread that executes the
+	// command.
+	runtime.LockOSThread()
+
+	if err := unix.Mount("none", "/", "", unix.MS_REC|unix.MS_PRIVATE, ""); err != nil {
+		return fmt.Errorf("failed to make mounts private: %v", err)
+	}
+	if err := unix.Chroot(root); err != nil {
+		return fmt.Errorf("failed to change root to %s: %v", root, err)
+	}
+	if err := os.Chdir(cwd); err != nil {
+		return fmt.Errorf("failed to change working directory: %v", err)
+	}
+	if err := unix.Prctl(unix.PR_CAP_AMBIENT, unix.PR_CAP_AMBIENT_CLEAR_ALL, 0, 0, 0); err != nil {
+		return fmt.Errorf("failed to drop capabilities: %v", err)
+	}
+
+	var env []string
+	for _, kv := range os.Environ() {
+		if !strings.HasPrefix(kv, stageRootEnv+"=") && !strings.HasPrefix(kv, stageCwdEnv+"=") {
+			env = append(env, kv)
+		}
+	}
+	path, err := exec.LookPath(args[0])
+	if err != nil {
+		return err
+	}
+	return syscall.Exec(path, args, env)
+}
+
+// exitStatus computes the exit code to return for a command that terminated with err.
+func exitStatus(err error) (int, error) {
+	if err == nil {
+		return 0, nil
+	}
+	exitErr, ok := err.(*exec.ExitError)
+	if !ok {
+		return toolFailureExitCode, err
+	}
+	status := exitErr.Sys().(syscall.WaitStatus)
+	if status.Signaled() {
+		return 128 + int(status.Signal()), nil
+	}
+	return status.ExitStatus(), nil
+}
+
+// run executes the command in a new sandbox and returns the exit code to terminate with.
+func run(args []string) (int, error) {
+	fs := flag.NewFlagSet("sandboxfs-exec", flag.ContinueOnError)
+	input := fs.String("input", "", "path to the FIFO sandboxfs reads requests from")
+	output := fs.String("output", "", "path to the FIFO sandboxfs writes responses to")
+	socket := fs.String("socket", "", "path to the socket of a sandboxfsctl serve daemon")
+	mountPoint := fs.String("mount_point", "", "path to the mount point of sandboxfs")
+	manifest := fs.String("manifest", "", "path to a JSON file with the body of a CreateSandbox request")
+	var mappings protocol.MappingsFlag
+	fs.Var(&mappings, "mapping", "mapping to add to the sandbox as TYPE:PATH:UNDERLYING_PATH; repeatable")
+	id := fs.String("id", "", "identifier of the sandbox; defaults to the manifest's or to a unique value")
+	username := fs.String("user", "", "name of the user to run the command as; defaults to the current user")
+	cwd := fs.String("cwd", "/", "working directory of the command within the sandbox")
+	timeout := fs.Duration("timeout", 10*time.Second, "maximum time to wait for each response from sandboxfs")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: sandboxfs-exec [flags] -- command [args]\n\nFlags:\n")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return toolFailureExitCode, err
+	}
+	if fs.NArg() == 0 {
+		return toolFailureExitCode, fmt.Errorf("no command specified")
+	}
+	if *mountPoint == "" {
+		return toolFailureExitCode, fmt.Errorf("must specify --mount_point")
+	}
+	if (*socket == "") == (*input == "" || *output == "") {
+		return toolFailureExitCode, fmt.Errorf("must specify either --socket or both --input and --output")
+	}
+	if !filepath.IsAbs(*cwd) {
+		return toolFailureExitCode, fmt.Errorf("--cwd must be an absolute path")
+	}
+
+	var user *identity
+	if *username != "" {
+		var err error
+		user, err = lookupIdentity(*username)
+		if err != nil {
+			return toolFailureExitCode, err
+		}
+	}
+
+	conn, err := protocol.Connect(*socket, *input, *output)
+	if err != nil {
+		return toolFailureExitCode, err
+	}
+	defer conn.Close()
+
+	sb, err := createSandbox(conn, *id, *manifest, mappings, *timeout)
+	if err != nil {
+		return toolFailureExitCode, err
+	}
+
+	// Forward termination signals to the command instead of dying so that we get a chance to
+	// destroy the sandbox once the command exits.
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
+	defer signal.Stop(signals)
+
+	code := toolFailureExitCode
+	cmd, err := newCommand(filepath.Join(*mountPoint, sb.id), *cwd, user, fs.Args())
+	if err == nil {
+		err = cmd.Start()
+	}
+	if err == nil {
+		done := make(chan struct{})
+		go func() {
+			for {
+				select {
+				case sig := <-signals:
+					cmd.Process.Signal(sig)
+				case <-done:
+					return
+				}
+			}
+		}()
+		code, err = exitStatus(cmd.Wait())
+		close(done)
+	} else {
+		err = fmt.Errorf("failed to run %s in sandbox %s: %v", fs.Arg(0), sb.id, err)
+	}
+
+	if destroyErr := sb.destroy(); destroyErr != nil {
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "sandboxfs-exec: %v\n", err)
+		}
+		return toolFailureExitCode, destroyErr
+	}
+	return code, err
+}
+
+// enterSandboxIfStaged takes over the process if it is the re-execution of the tool prepared by
+// newCommand, in which case it never returns.
+func enterSandboxIfStaged() {
+	if root := os.Getenv(stageRootEnv); root != "" {
+		err := enterSandbox(root, os.Getenv(stageCwdEnv), os.Args)
+		fmt.Fprintf(os.Stderr, "sandboxfs-exec: failed to run %s in sandbox: %v\n", os.Args[0], err)
+		os.Exit(toolFailureExitCode)
+	}
+}
+
+func main() {
+	enterSandboxIfStaged()
+
+	code, err := run(os.Args[1:])
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "sandboxfs-exec: %v\n", err)
+	}
+	os.Exit(code)
+}
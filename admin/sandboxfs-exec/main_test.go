@@ -0,0 +1,180 @@
+// Copyright 2020 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not
+// use this file except in compliance with the License.  You may obtain a copy
+// of the License at:
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.  See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package main
+
+import (
+	"bytes"
+	"os"
+	"os/exec"
+	"reflect"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+)
+
+func TestMain(m *testing.M) {
+	// Tests that run commands through newCommand re-execute the test binary, which must then
+	// behave as the tool would.
+	enterSandboxIfStaged()
+	os.Exit(m.Run())
+}
+
+func TestNamespaceAttrs(t *testing.T) {
+	root := identity{uid: 0, gid: 0}
+	caller := identity{uid: 1000, gid: 100}
+	other := &identity{uid: 2000, gid: 200, groups: []int{200, 300}}
+
+	testData := []struct {
+		name string
+
+		caller identity
+		user   *identity
+
+		wantUIDMappings []syscall.SysProcIDMap
+		wantGIDMappings []syscall.SysProcIDMap
+		wantSetgroups   bool
+		wantCredential  syscall.Credential
+	}{
+		{
+			"RootAsSelf", root, nil,
+			[]syscall.SysProcIDMap{{ContainerID: 0, HostID: 0, Size: 1}},
+			[]syscall.SysProcIDMap{{ContainerID: 0, HostID: 0, Size: 1}},
+			true,
+			syscall.Credential{Uid: 0, Gid: 0, Groups: []uint32{}},
+		},
+		{
+			"RootAsOther", root, other,
+			[]syscall.SysProcIDMap{{ContainerID: 2000, HostID: 2000, Size: 1}},
+			[]syscall.SysProcIDMap{{ContainerID: 200, HostID: 200, Size: 1}, {ContainerID: 300, HostID: 300, Size: 1}},
+			true,
+			syscall.Credential{Uid: 2000, Gid: 200, Groups: []uint32{200, 300}},
+		},
+		{
+			"UnprivilegedAsSelf", caller, nil,
+			[]syscall.SysProcIDMap{{ContainerID: 1000, HostID: 1000, Size: 1}},
+			[]syscall.SysProcIDMap{{ContainerID: 100, HostID: 100, Size: 1}},
+			false,
+			syscall.Credential{Uid: 1000, Gid: 100, NoSetGroups: true},
+		},
+		{
+			"UnprivilegedAsOther", caller, other,
+			[]syscall.SysProcIDMap{{ContainerID: 2000, HostID: 1000, Size: 1}},
+			[]syscall.SysProcIDMap{{ContainerID: 200, HostID: 100, Size: 1}},
+			false,
+			syscall.Credential{Uid: 2000, Gid: 200, NoSetGroups: true},
+		},
+	}
+	for _, d := range testData {
+		t.Run(d.name, func(t *testing.T) {
+			attrs := namespaceAttrs(d.caller, d.user)
+			if want := uintptr(syscall.CLONE_NEWUSER | syscall.CLONE_NEWNS); attrs.Cloneflags != want {
+				t.Errorf("Got clone flags %#x; want %#x", attrs.Cloneflags, want)
+			}
+			if !reflect.DeepEqual(attrs.UidMappings, d.wantUIDMappings) {
+				t.Errorf("Got UID mappings %v; want %v", attrs.UidMappings, d.wantUIDMappings)
+			}
+			if !reflect.DeepEqual(attrs.GidMappings, d.wantGIDMappings) {
+				t.Errorf("Got GID mappings %v; want %v", attrs.GidMappings, d.wantGIDMappings)
+			}
+			if attrs.GidMappingsEnableSetgroups != d.wantSetgroups {
+				t.Errorf("Got setgroups %v; want %v", attrs.GidMappingsEnableSetgroups, d.wantSetgroups)
+			}
+			if !reflect.DeepEqual(*attrs.Credential, d.wantCredential) {
+				t.Errorf("Got credential %+v; want %+v", *attrs.Credential, d.wantCredential)
+			}
+		})
+	}
+}
+
+func TestNewCommand_EntersNamespaces(t *testing.T) {
+	cmd, err := newCommand("/", "/tmp", nil, []string{"/bin/sh", "-c", "pwd; id -u; grep ' / / ' /proc/self/mountinfo"})
+	if err != nil {
+		t.Fatalf("Failed to prepare command: %v", err)
+	}
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if _, ok := err.(*exec.ExitError); !ok {
+			t.Skipf("Cannot create namespaces in this environment: %v", err)
+		}
+		t.Fatalf("Command failed: %v; stderr: %s", err, stderr.String())
+	}
+
+	lines := strings.Split(strings.TrimSpace(stdout.String()), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("Got output %q; want three lines", stdout.String())
+	}
+	if lines[0] != "/tmp" {
+		t.Errorf("Got working directory %s; want /tmp", lines[0])
+	}
+	if want := strconv.Itoa(os.Getuid()); lines[1] != want {
+		t.Errorf("Got UID %s; want %s", lines[1], want)
+	}
+	if strings.Contains(lines[2], "shared:") {
+		t.Errorf("Got root mount %q; want it to be private", lines[2])
+	}
+}
+
+func TestNewCommand_MissingCommand(t *testing.T) {
+	cmd, err := newCommand("/", "/", nil, []string{"/non-existent"})
+	if err != nil {
+		t.Fatalf("Failed to prepare command: %v", err)
+	}
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	code, err := exitStatus(cmd.Run())
+	if err != nil {
+		t.Skipf("Cannot create namespaces in this environment: %v", err)
+	}
+	if code != toolFailureExitCode {
+		t.Errorf("Got exit code %d; want %d", code, toolFailureExitCode)
+	}
+	if !strings.Contains(stderr.String(), "failed to run /non-existent in sandbox") {
+		t.Errorf("Got stderr %q; want error about the missing command", stderr.String())
+	}
+}
+
+func TestExitStatus(t *testing.T) {
+	testData := []struct {
+		name string
+
+		script   string
+		wantCode int
+	}{
+		{"Success", "exit 0", 0},
+		{"Failure", "exit 3", 3},
+		{"Signal", "kill -TERM $$", 128 + int(syscall.SIGTERM)},
+	}
+	for _, d := range testData {
+		t.Run(d.name, func(t *testing.T) {
+			code, err := exitStatus(exec.Command("/bin/sh", "-c", d.script).Run())
+			if err != nil {
+				t.Fatalf("Got error %v; want exit code %d", err, d.wantCode)
+			}
+			if code != d.wantCode {
+				t.Errorf("Got exit code %d; want %d", code, d.wantCode)
+			}
+		})
+	}
+
+	t.Run("NotStarted", func(t *testing.T) {
+		code, err := exitStatus(exec.Command("/non-existent").Run())
+		if err == nil || code != toolFailureExitCode {
+			t.Errorf("Got exit code %d and error %v; want %d and an error", code, err, toolFailureExitCode)
+		}
+	})
+}
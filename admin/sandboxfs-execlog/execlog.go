@@ -0,0 +1,210 @@
+// Copyright 2020 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not
+// use this file except in compliance with the License.  You may obtain a copy
+// of the License at:
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.  See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"path"
+	"path/filepath"
+	"sort"
+
+	"github.com/bazelbuild/sandboxfs/integration/protocol"
+)
+
+// spawnInput represents an input file of a spawn in the execution log.
+type spawnInput struct {
+	// Path is the exec path of the input, relative to the execroot.
+	Path string `json:"path"`
+}
+
+// spawnExec represents a single spawn in the execution log.  Only the fields needed to compute
+// the layout of the spawn's sandbox are decoded.
+type spawnExec struct {
+	// Mnemonic is the type of the action that issued the spawn.
+	Mnemonic string `json:"mnemonic"`
+
+	// TargetLabel is the label of the target that owns the action.
+	TargetLabel string `json:"targetLabel"`
+
+	// ExecRoot is the execroot of the spawn.  Bazel does not record it, so it is usually empty
+	// and the value given to --execroot applies, but it can be set to override that.
+	ExecRoot string `json:"execRoot"`
+
+	// Inputs contains the files that must be present in the sandbox.
+	Inputs []spawnInput `json:"inputs"`
+}
+
+// readExecLog parses an execution log in JSON form, which contains a sequence of spawns either one
+// per line or pretty-printed one after another.
+func readExecLog(r io.Reader) ([]spawnExec, error) {
+	var spawns []spawnExec
+	decoder := json.NewDecoder(r)
+	for {
+		var spawn spawnExec
+		if err := decoder.Decode(&spawn); err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("invalid spawn %d in execution log: %v", len(spawns)+1, err)
+		}
+		spawns = append(spawns, spawn)
+	}
+	return spawns, nil
+}
+
+// converter computes the sandbox mappings for spawns.
+type converter struct {
+	// execRoot is the execroot in which the exec paths of inputs are resolved.
+	execRoot string
+
+	// innerExecRoot is the directory within the sandbox where the execroot is exposed.
+	innerExecRoot string
+
+	// scratchDir is the directory where the writable roots of the sandboxes live, if any.
+	scratchDir string
+}
+
+// mappings returns the mappings that expose the inputs of a spawn in the sandbox with the given
+// identifier.  Inputs are sorted by path so that directories are mapped before any input beneath
+// them, which sandboxfs requires.
+func (c *converter) mappings(id string, spawn *spawnExec) ([]protocol.Mapping, error) {
+	execRoot := spawn.ExecRoot
+	if execRoot == "" {
+		execRoot = c.execRoot
+	}
+	if !filepath.IsAbs(execRoot) {
+		return nil, fmt.Errorf("execroot %q is not absolute; use --execroot", execRoot)
+	}
+
+	var mappings []protocol.Mapping
+	if c.scratchDir != "" {
+		mappings = append(mappings, protocol.Mapping{
+			Path:           "/",
+			UnderlyingPath: filepath.Join(c.scratchDir, id),
+			Writable:       true,
+		})
+	}
+
+	paths := make([]string, 0, len(spawn.Inputs))
+	for _, input := range spawn.Inputs {
+		if input.Path == "" {
+			return nil, fmt.Errorf("input with empty path")
+		}
+		paths = append(paths, path.Clean(input.Path))
+	}
+	sort.Strings(paths)
+	for i, p := range paths {
+		if i > 0 && p == paths[i-1] {
+			continue
+		}
+		underlyingPath := p
+		if !path.IsAbs(p) {
+			underlyingPath = filepath.Join(execRoot, p)
+		}
+		mappings = append(mappings, protocol.Mapping{
+			Path:           path.Join(c.innerExecRoot, p),
+			UnderlyingPath: underlyingPath,
+		})
+	}
+	return mappings, nil
+}
+
+// actionStats describes the requests generated for a single spawn.
+type actionStats struct {
+	// ID is the identifier of the sandbox created for the spawn.
+	ID string `json:"id"`
+
+	// Mnemonic is the type of the action that issued the spawn.
+	Mnemonic string `json:"mnemonic,omitempty"`
+
+	// TargetLabel is the label of the target that owns the action.
+	TargetLabel string `json:"target_label,omitempty"`
+
+	// Mappings is the number of mappings in the create request.
+	Mappings int `json:"mappings"`
+
+	// PlainBytes is the size of the create request using canonical names and no prefixes.
+	PlainBytes int `json:"plain_bytes"`
+
+	// MinimizedBytes is the size of the create request using minimized names and no prefixes.
+	MinimizedBytes int `json:"minimized_bytes"`
+
+	// CompressedBytes is the size of the create request using minimized names and the prefixes
+	// computed for the whole stream of requests.
+	CompressedBytes int `json:"compressed_bytes"`
+}
+
+// conversion holds the result of converting a single spawn.
+type conversion struct {
+	// request is the prefix-compressed create request to send through a stream shared by all
+	// spawns, in its minimized encoding.
+	request []byte
+
+	// manifest is the body of a standalone prefix-compressed create request, in its minimized
+	// encoding.
+	manifest []byte
+
+	// stats contains the sizes of the different encodings of the request.
+	stats actionStats
+}
+
+// convert computes the create requests for the given spawn.  compressor must be shared by all
+// spawns whose requests are sent through the same stream, in order.
+func (c *converter) convert(id string, spawn *spawnExec, compressor *protocol.PrefixCompressor) (*conversion, error) {
+	mappings, err := c.mappings(id, spawn)
+	if err != nil {
+		return nil, err
+	}
+	create := &protocol.CreateSandboxRequest{ID: id, Mappings: mappings}
+
+	plain, err := json.Marshal(protocol.Request{CreateSandbox: create})
+	if err != nil {
+		return nil, err
+	}
+	minimized, err := protocol.EncodeMinimized(protocol.Request{CreateSandbox: create})
+	if err != nil {
+		return nil, err
+	}
+	compressed, err := protocol.EncodeMinimized(protocol.Request{CreateSandbox: compressor.Compress(create)})
+	if err != nil {
+		return nil, err
+	}
+
+	standalone, err := protocol.EncodeMinimized(protocol.Request{CreateSandbox: protocol.NewPrefixCompressor().Compress(create)})
+	if err != nil {
+		return nil, err
+	}
+	var wrapper struct {
+		C json.RawMessage
+	}
+	if err := json.Unmarshal(standalone, &wrapper); err != nil {
+		return nil, err
+	}
+
+	return &conversion{
+		request:  compressed,
+		manifest: wrapper.C,
+		stats: actionStats{
+			ID:              id,
+			Mnemonic:        spawn.Mnemonic,
+			TargetLabel:     spawn.TargetLabel,
+			Mappings:        len(mappings),
+			PlainBytes:      len(plain),
+			MinimizedBytes:  len(minimized),
+			CompressedBytes: len(compressed),
+		},
+	}, nil
+}
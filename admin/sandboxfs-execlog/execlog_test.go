@@ -0,0 +1,187 @@
+// Copyright 2020 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not
+// use this file except in compliance with the License.  You may obtain a copy
+// of the License at:
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.  See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/bazelbuild/sandboxfs/integration/protocol"
+)
+
+func TestReadExecLog(t *testing.T) {
+	input := `{
+  "commandArgs": ["cc", "a.c"],
+  "inputs": [{
+    "path": "a.c",
+    "digest": {"hash": "1234", "sizeBytes": "10"}
+  }],
+  "mnemonic": "CppCompile",
+  "targetLabel": "//:a"
+}
+{"inputs": [{"path": "b"}, {"path": "c"}], "mnemonic": "Genrule", "execRoot": "/other"}
+`
+	spawns, err := readExecLog(strings.NewReader(input))
+	if err != nil {
+		t.Fatalf("Failed to read execution log: %v", err)
+	}
+	want := []spawnExec{
+		{Mnemonic: "CppCompile", TargetLabel: "//:a", Inputs: []spawnInput{{Path: "a.c"}}},
+		{Mnemonic: "Genrule", ExecRoot: "/other", Inputs: []spawnInput{{Path: "b"}, {Path: "c"}}},
+	}
+	if !reflect.DeepEqual(spawns, want) {
+		t.Errorf("Got %+v; want %+v", spawns, want)
+	}
+
+	if _, err := readExecLog(strings.NewReader(`{"inputs": []} {"inputs": 3}`)); err == nil || !strings.Contains(err.Error(), "invalid spawn 2") {
+		t.Errorf("Got %v; want error about spawn 2", err)
+	}
+}
+
+func TestConverter_Mappings(t *testing.T) {
+	spawn := &spawnExec{Inputs: []spawnInput{{Path: "src/b"}, {Path: "src"}, {Path: "./src/b"}, {Path: "/abs/tool"}}}
+
+	testData := []struct {
+		name string
+
+		converter converter
+		spawn     *spawnExec
+		want      []protocol.Mapping
+	}{
+		{
+			"Defaults",
+			converter{execRoot: "/exec", innerExecRoot: "/"},
+			spawn,
+			[]protocol.Mapping{
+				{Path: "/abs/tool", UnderlyingPath: "/abs/tool"},
+				{Path: "/src", UnderlyingPath: "/exec/src"},
+				{Path: "/src/b", UnderlyingPath: "/exec/src/b"},
+			},
+		},
+		{
+			"ScratchAndInnerExecRoot",
+			converter{execRoot: "/exec", innerExecRoot: "/execroot/ws", scratchDir: "/scratch"},
+			&spawnExec{Inputs: []spawnInput{{Path: "a"}}},
+			[]protocol.Mapping{
+				{Path: "/", UnderlyingPath: "/scratch/id", Writable: true},
+				{Path: "/execroot/ws/a", UnderlyingPath: "/exec/a"},
+			},
+		},
+		{
+			"SpawnExecRoot",
+			converter{innerExecRoot: "/"},
+			&spawnExec{ExecRoot: "/mine", Inputs: []spawnInput{{Path: "a"}}},
+			[]protocol.Mapping{
+				{Path: "/a", UnderlyingPath: "/mine/a"},
+			},
+		},
+	}
+	for _, d := range testData {
+		t.Run(d.name, func(t *testing.T) {
+			mappings, err := d.converter.mappings("id", d.spawn)
+			if err != nil {
+				t.Fatalf("Failed to compute mappings: %v", err)
+			}
+			if !reflect.DeepEqual(mappings, d.want) {
+				t.Errorf("Got %v; want %v", mappings, d.want)
+			}
+			if _, err := protocol.BuildLayout(mappings); err != nil {
+				t.Errorf("Got invalid layout: %v", err)
+			}
+		})
+	}
+
+	c := converter{innerExecRoot: "/"}
+	if _, err := c.mappings("id", spawn); err == nil || !strings.Contains(err.Error(), "--execroot") {
+		t.Errorf("Got %v; want error about missing execroot", err)
+	}
+}
+
+func TestConverter_Convert(t *testing.T) {
+	c := &converter{execRoot: "/home/user/.cache/bazel/execroot/workspace", innerExecRoot: "/"}
+	spawns := []spawnExec{
+		{Mnemonic: "First", Inputs: []spawnInput{{Path: "pkg/sub/a"}, {Path: "pkg/sub/b"}, {Path: "pkg/sub/c"}}},
+		{Mnemonic: "Second", Inputs: []spawnInput{{Path: "pkg/sub/d"}, {Path: "pkg/sub/e"}}},
+	}
+
+	compressor := protocol.NewPrefixCompressor()
+	prefixes := protocol.NewPrefixes()
+	for i, spawn := range spawns {
+		id := spawn.Mnemonic
+		result, err := c.convert(id, &spawns[i], compressor)
+		if err != nil {
+			t.Fatalf("Failed to convert spawn %d: %v", i, err)
+		}
+		want, err := c.mappings(id, &spawns[i])
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var req protocol.Request
+		if err := json.Unmarshal(result.request, &req); err != nil {
+			t.Fatalf("Invalid request %s: %v", result.request, err)
+		}
+		if got, err := prefixes.Resolve(req.CreateSandbox); err != nil || !reflect.DeepEqual(got, want) {
+			t.Errorf("Got request mappings %v (error %v); want %v", got, err, want)
+		}
+
+		var manifest protocol.CreateSandboxRequest
+		if err := json.Unmarshal(result.manifest, &manifest); err != nil {
+			t.Fatalf("Invalid manifest %s: %v", result.manifest, err)
+		}
+		if got, err := protocol.NewPrefixes().Resolve(&manifest); err != nil || !reflect.DeepEqual(got, want) {
+			t.Errorf("Got manifest mappings %v (error %v); want %v", got, err, want)
+		}
+
+		stats := result.stats
+		if stats.ID != id || stats.Mnemonic != spawn.Mnemonic || stats.Mappings != len(want) {
+			t.Errorf("Got stats %+v; want ID %s, mnemonic %s and %d mappings", stats, id, spawn.Mnemonic, len(want))
+		}
+		if !(stats.CompressedBytes < stats.MinimizedBytes && stats.MinimizedBytes < stats.PlainBytes) {
+			t.Errorf("Got sizes %+v; want compressed < minimized < plain", stats)
+		}
+	}
+}
+
+func TestNewReport(t *testing.T) {
+	r := newReport([]actionStats{
+		{ID: "a", Mappings: 5, PlainBytes: 100, MinimizedBytes: 50, CompressedBytes: 20},
+		{ID: "b", Mappings: 1, PlainBytes: 10, MinimizedBytes: 5, CompressedBytes: 5},
+		{ID: "c", Mappings: 3, PlainBytes: 30, MinimizedBytes: 15, CompressedBytes: 10},
+	})
+	want := totals{
+		Actions:         3,
+		Mappings:        9,
+		MinMappings:     1,
+		MedianMappings:  3,
+		MaxMappings:     5,
+		PlainBytes:      140,
+		MinimizedBytes:  70,
+		CompressedBytes: 35,
+	}
+	if r.Totals != want {
+		t.Errorf("Got %+v; want %+v", r.Totals, want)
+	}
+
+	var text strings.Builder
+	if err := r.printText(&text); err != nil {
+		t.Fatalf("Failed to print report: %v", err)
+	}
+	if !strings.Contains(text.String(), "Compressed requests: 35 bytes (25.0% of plain)") {
+		t.Errorf("Got report without compressed totals:\n%s", text.String())
+	}
+}
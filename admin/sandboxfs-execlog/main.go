@@ -0,0 +1,167 @@
+// Copyright 2020 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not
+// use this file except in compliance with the License.  You may obtain a copy
+// of the License at:
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.  See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+// The sandboxfs-execlog binary converts a Bazel execution log into sandboxfs requests.
+//
+// The execution log must be in the JSON form written by Bazel's --execution_log_json_file flag.
+// For each spawn in the log, the tool computes a sandbox that maps every input from the execroot
+// into the sandbox, read-only, and optionally maps a per-sandbox scratch directory as the writable
+// root of the sandbox.  The resulting create requests use minimized names and path prefixes.
+//
+// Requests can be written as a single stream, in which prefixes are shared across requests as a
+// real client would do, and as standalone manifests, one per spawn, that sandboxfsctl and
+// sandboxfs-exec accept.  A report with the number of mappings of each action and the size of its
+// request in different encodings is written to stderr, which allows benchmarking realistic
+// workloads offline.
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/bazelbuild/sandboxfs/integration/protocol"
+)
+
+// openOutput opens the given path for writing, or returns stdout if the path is "-".
+func openOutput(path string) (io.WriteCloser, error) {
+	if path == "-" {
+		return os.Stdout, nil
+	}
+	return os.Create(path)
+}
+
+// run parses the command line and converts the given execution log.
+func run(args []string) error {
+	fs := flag.NewFlagSet("sandboxfs-execlog", flag.ContinueOnError)
+	execRoot := fs.String("execroot", "", "absolute path to the execroot in which to resolve the exec paths of inputs")
+	innerExecRoot := fs.String("inner_execroot", "/", "directory within each sandbox where the execroot is exposed")
+	scratchDir := fs.String("scratch_dir", "", "directory holding the writable root of each sandbox, in a subdirectory named after it; none if empty")
+	idPrefix := fs.String("id_prefix", "action", "prefix for the identifiers of the sandboxes, which are suffixed with the spawn number")
+	requests := fs.String("requests", "", "path to which to write the stream of requests, or - for stdout")
+	destroy := fs.Bool("destroy", false, "follow each create request by its destroy request in --requests")
+	manifests := fs.String("manifests", "", "directory in which to write a manifest per spawn")
+	reportPath := fs.String("report", "", "path to which to write the report; stderr if empty")
+	reportFormat := fs.String("report_format", "text", "format of the report: text or json")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: sandboxfs-execlog [flags] [EXECUTION_LOG|-]\n\nFlags:\n")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *reportFormat != "text" && *reportFormat != "json" {
+		return fmt.Errorf("invalid --report_format %s; must be text or json", *reportFormat)
+	}
+	if *innerExecRoot == "" || (*innerExecRoot)[0] != '/' {
+		return fmt.Errorf("--inner_execroot must be absolute")
+	}
+	if *scratchDir != "" && !filepath.IsAbs(*scratchDir) {
+		return fmt.Errorf("--scratch_dir must be absolute")
+	}
+
+	var input io.Reader
+	switch {
+	case fs.NArg() == 0 || (fs.NArg() == 1 && fs.Arg(0) == "-"):
+		input = os.Stdin
+	case fs.NArg() == 1:
+		file, err := os.Open(fs.Arg(0))
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		input = file
+	default:
+		return fmt.Errorf("too many arguments")
+	}
+	spawns, err := readExecLog(input)
+	if err != nil {
+		return err
+	}
+
+	var stream *bufio.Writer
+	if *requests != "" {
+		file, err := openOutput(*requests)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		stream = bufio.NewWriter(file)
+	}
+	if *manifests != "" {
+		if err := os.MkdirAll(*manifests, 0755); err != nil {
+			return err
+		}
+	}
+
+	c := &converter{execRoot: *execRoot, innerExecRoot: *innerExecRoot, scratchDir: *scratchDir}
+	compressor := protocol.NewPrefixCompressor()
+	var actions []actionStats
+	for i := range spawns {
+		id := fmt.Sprintf("%s%d", *idPrefix, i+1)
+		result, err := c.convert(id, &spawns[i], compressor)
+		if err != nil {
+			return fmt.Errorf("cannot convert spawn %d: %v", i+1, err)
+		}
+		actions = append(actions, result.stats)
+
+		if stream != nil {
+			fmt.Fprintf(stream, "%s\n", result.request)
+			if *destroy {
+				destroyReq, err := protocol.EncodeMinimized(protocol.MakeDestroySandboxRequest(id))
+				if err != nil {
+					return err
+				}
+				fmt.Fprintf(stream, "%s\n", destroyReq)
+			}
+		}
+		if *manifests != "" {
+			path := filepath.Join(*manifests, id+".json")
+			if err := ioutil.WriteFile(path, append(result.manifest, '\n'), 0644); err != nil {
+				return fmt.Errorf("cannot write manifest: %v", err)
+			}
+		}
+	}
+	if stream != nil {
+		if err := stream.Flush(); err != nil {
+			return fmt.Errorf("cannot write requests: %v", err)
+		}
+	}
+
+	var output io.Writer = os.Stderr
+	if *reportPath != "" {
+		file, err := openOutput(*reportPath)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		output = file
+	}
+	r := newReport(actions)
+	if *reportFormat == "json" {
+		return r.printJSON(output)
+	}
+	return r.printText(output)
+}
+
+func main() {
+	if err := run(os.Args[1:]); err != nil {
+		fmt.Fprintf(os.Stderr, "sandboxfs-execlog: %v\n", err)
+		os.Exit(1)
+	}
+}
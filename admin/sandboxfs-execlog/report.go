@@ -0,0 +1,112 @@
+// Copyright 2020 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not
+// use this file except in compliance with the License.  You may obtain a copy
+// of the License at:
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.  See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+)
+
+// totals summarizes the requests generated for all spawns.
+type totals struct {
+	Actions         int `json:"actions"`
+	Mappings        int `json:"mappings"`
+	MinMappings     int `json:"min_mappings"`
+	MedianMappings  int `json:"median_mappings"`
+	MaxMappings     int `json:"max_mappings"`
+	PlainBytes      int `json:"plain_bytes"`
+	MinimizedBytes  int `json:"minimized_bytes"`
+	CompressedBytes int `json:"compressed_bytes"`
+}
+
+// report is the complete summary of a conversion.
+type report struct {
+	Totals  totals        `json:"totals"`
+	Actions []actionStats `json:"actions"`
+}
+
+// newReport computes the totals of the given per-action statistics.
+func newReport(actions []actionStats) *report {
+	r := &report{Actions: actions}
+	r.Totals.Actions = len(actions)
+	if len(actions) == 0 {
+		return r
+	}
+
+	counts := make([]int, 0, len(actions))
+	for _, action := range actions {
+		counts = append(counts, action.Mappings)
+		r.Totals.Mappings += action.Mappings
+		r.Totals.PlainBytes += action.PlainBytes
+		r.Totals.MinimizedBytes += action.MinimizedBytes
+		r.Totals.CompressedBytes += action.CompressedBytes
+	}
+	sort.Ints(counts)
+	r.Totals.MinMappings = counts[0]
+	r.Totals.MedianMappings = counts[len(counts)/2]
+	r.Totals.MaxMappings = counts[len(counts)-1]
+	return r
+}
+
+// percentage returns the size of part relative to total, formatted for display.
+func percentage(part int, total int) string {
+	if total == 0 {
+		return "-"
+	}
+	return fmt.Sprintf("%.1f%%", float64(part)*100/float64(total))
+}
+
+// printText writes the report as a table of actions followed by the totals.
+func (r *report) printText(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, strings.Join([]string{"ID", "MNEMONIC", "TARGET", "MAPPINGS", "PLAIN", "MINIMIZED", "COMPRESSED"}, "\t"))
+	for _, action := range r.Actions {
+		fmt.Fprintln(tw, strings.Join([]string{
+			action.ID,
+			action.Mnemonic,
+			action.TargetLabel,
+			strconv.Itoa(action.Mappings),
+			strconv.Itoa(action.PlainBytes),
+			strconv.Itoa(action.MinimizedBytes),
+			strconv.Itoa(action.CompressedBytes),
+		}, "\t"))
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+
+	t := r.Totals
+	fmt.Fprintf(w, "\nActions: %d\n", t.Actions)
+	fmt.Fprintf(w, "Mappings: %d total; %d min, %d median, %d max per action\n", t.Mappings, t.MinMappings, t.MedianMappings, t.MaxMappings)
+	fmt.Fprintf(w, "Plain requests: %d bytes\n", t.PlainBytes)
+	fmt.Fprintf(w, "Minimized requests: %d bytes (%s of plain)\n", t.MinimizedBytes, percentage(t.MinimizedBytes, t.PlainBytes))
+	_, err := fmt.Fprintf(w, "Compressed requests: %d bytes (%s of plain)\n", t.CompressedBytes, percentage(t.CompressedBytes, t.PlainBytes))
+	return err
+}
+
+// printJSON writes the report as indented JSON.
+func (r *report) printJSON(w io.Writer) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(r); err != nil {
+		return fmt.Errorf("cannot encode report: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,258 @@
+// Copyright 2020 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not
+// use this file except in compliance with the License.  You may obtain a copy
+// of the License at:
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.  See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+
+	"github.com/bazelbuild/sandboxfs/integration/protocol"
+)
+
+// node represents an entry in a rendered layout.
+type node struct {
+	// Name is the basename of the node, or "/" for the root.
+	Name string `json:"name"`
+
+	// Path is the absolute path of the node within the sandbox.
+	Path string `json:"path"`
+
+	// Kind is "scaffold", "ro" or "rw" for nodes defined by mappings, or empty for entries found
+	// in a live mount that come from the underlying file system.
+	Kind string `json:"kind,omitempty"`
+
+	// Type is the type of the entry found in a live mount, or empty if the mount was not inspected.
+	Type string `json:"type,omitempty"`
+
+	// UnderlyingPath is the path exposed by the node if it is a mapping.
+	UnderlyingPath string `json:"underlying_path,omitempty"`
+
+	// Shadows is the underlying path hidden by this node, if any.
+	Shadows string `json:"shadows,omitempty"`
+
+	// Conflicts contains the mappings of this node that sandboxfs rejects.
+	Conflicts []protocol.Mapping `json:"conflicts,omitempty"`
+
+	// Missing is true if the node is defined by the mappings but was not found in the live mount.
+	Missing bool `json:"missing,omitempty"`
+
+	// Elided is the number of entries of a live directory that were not inspected.
+	Elided int `json:"elided,omitempty"`
+
+	// Children contains the nodes beneath this one, sorted by name.
+	Children []*node `json:"children,omitempty"`
+}
+
+// child returns the child of the node with the given name, or nil if it does not exist.
+func (n *node) child(name string) *node {
+	for _, child := range n.Children {
+		if child.Name == name {
+			return child
+		}
+	}
+	return nil
+}
+
+// sortChildren sorts the children of the node by name.
+func (n *node) sortChildren() {
+	sort.Slice(n.Children, func(a, b int) bool { return n.Children[a].Name < n.Children[b].Name })
+}
+
+// fromLayout converts a layout computed from mappings into a tree of nodes.
+func fromLayout(layout *protocol.LayoutNode) *node {
+	n := &node{
+		Name:           layout.Name,
+		Path:           layout.Path,
+		Kind:           layout.Kind.String(),
+		UnderlyingPath: layout.UnderlyingPath,
+		Shadows:        layout.Shadows,
+		Conflicts:      layout.Conflicts,
+	}
+	for _, child := range layout.Children {
+		n.Children = append(n.Children, fromLayout(child))
+	}
+	return n
+}
+
+// markMissing returns a copy of an expected subtree with all of its nodes flagged as missing.
+func markMissing(expected *node) *node {
+	n := *expected
+	n.Missing = true
+	n.Children = nil
+	for _, child := range expected.Children {
+		n.Children = append(n.Children, markMissing(child))
+	}
+	return &n
+}
+
+// fileType returns the name of the type of a file.
+func fileType(mode os.FileMode) string {
+	switch {
+	case mode.IsDir():
+		return "dir"
+	case mode.IsRegular():
+		return "file"
+	case mode&os.ModeSymlink != 0:
+		return "symlink"
+	default:
+		return "other"
+	}
+}
+
+// inspector walks a live mount to reconstruct its layout.
+type inspector struct {
+	// maxDepth is the number of directory levels to list.  Directories that contain expected
+	// nodes are always listed, but only to look for those nodes.
+	maxDepth int
+
+	// maxEntries is the number of unexpected entries to list per directory, or 0 for no limit.
+	maxEntries int
+}
+
+// walk lists the directory at dir, which is represented by n and expected to match exp (possibly
+// nil), and populates the children of n.  depth is the level of the children of n.
+func (in *inspector) walk(dir string, n *node, exp *node, depth int) error {
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return fmt.Errorf("cannot list %s: %v", dir, err)
+	}
+
+	seen := make(map[string]bool)
+	shown := 0
+	for _, entry := range entries {
+		var expChild *node
+		if exp != nil {
+			expChild = exp.child(entry.Name())
+		}
+		if expChild == nil {
+			if depth > in.maxDepth || (in.maxEntries > 0 && shown >= in.maxEntries) {
+				n.Elided++
+				continue
+			}
+			shown++
+		}
+		seen[entry.Name()] = true
+
+		child := &node{Name: entry.Name(), Path: path.Join(n.Path, entry.Name()), Type: fileType(entry.Mode())}
+		if expChild != nil {
+			child.Kind = expChild.Kind
+			child.UnderlyingPath = expChild.UnderlyingPath
+			child.Shadows = expChild.Shadows
+			child.Conflicts = expChild.Conflicts
+		}
+		if entry.IsDir() && (depth < in.maxDepth || (expChild != nil && len(expChild.Children) > 0)) {
+			if err := in.walk(filepath.Join(dir, entry.Name()), child, expChild, depth+1); err != nil {
+				return err
+			}
+		}
+		n.Children = append(n.Children, child)
+	}
+
+	if exp != nil {
+		for _, expChild := range exp.Children {
+			if !seen[expChild.Name] {
+				n.Children = append(n.Children, markMissing(expChild))
+			}
+		}
+		n.sortChildren()
+	}
+	return nil
+}
+
+// inspectMount reconstructs the layout of the sandbox exposed at dir by listing its contents and
+// annotates it with the expected layout, if not nil.
+func inspectMount(dir string, expected *node, maxDepth int, maxEntries int) (*node, error) {
+	fileInfo, err := os.Stat(dir)
+	if err != nil {
+		return nil, err
+	}
+	root := &node{Name: "/", Path: "/", Type: fileType(fileInfo.Mode())}
+	if expected != nil {
+		root.Kind = expected.Kind
+		root.UnderlyingPath = expected.UnderlyingPath
+		root.Conflicts = expected.Conflicts
+	}
+	if !fileInfo.IsDir() {
+		return root, nil
+	}
+	in := &inspector{maxDepth: maxDepth, maxEntries: maxEntries}
+	if err := in.walk(dir, root, expected, 1); err != nil {
+		return nil, err
+	}
+	return root, nil
+}
+
+// sandboxLayout is the layout of a single sandbox.
+type sandboxLayout struct {
+	// ID is the identifier of the sandbox, or empty for the root of the file system.
+	ID string `json:"id,omitempty"`
+
+	// Root is the top of the tree of the sandbox.
+	Root *node `json:"root"`
+}
+
+// readRequests parses a stream of reconfiguration requests and returns the resolved mappings of
+// each sandbox that is live once all of them have been applied, in the order in which they were
+// first created.  As with sandboxfs, creating a sandbox that already exists extends it with the
+// new mappings, so conflicts between requests show up in its layout.  Destroyed sandboxes are
+// dropped, and a sandbox created again after being destroyed starts afresh and is ordered by its
+// new creation.
+func readRequests(input io.Reader) ([]string, map[string][]protocol.Mapping, error) {
+	prefixes := protocol.NewPrefixes()
+	var order []string
+	sandboxes := make(map[string][]protocol.Mapping)
+	decoder := json.NewDecoder(input)
+	for n := 1; ; n++ {
+		var req protocol.Request
+		if err := decoder.Decode(&req); err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, nil, fmt.Errorf("invalid request %d: %v", n, err)
+		}
+		if req.DestroySandbox != nil {
+			id := *req.DestroySandbox
+			if _, ok := sandboxes[id]; ok {
+				delete(sandboxes, id)
+				for i, other := range order {
+					if other == id {
+						order = append(order[:i], order[i+1:]...)
+						break
+					}
+				}
+			}
+			continue
+		}
+		if req.CreateSandbox == nil {
+			continue
+		}
+
+		mappings, err := prefixes.Resolve(req.CreateSandbox)
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid request %d: %v", n, err)
+		}
+		id := req.CreateSandbox.ID
+		if _, ok := sandboxes[id]; !ok {
+			order = append(order, id)
+		}
+		sandboxes[id] = append(sandboxes[id], mappings...)
+	}
+	return order, sandboxes, nil
+}
@@ -0,0 +1,210 @@
+// Copyright 2020 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not
+// use this file except in compliance with the License.  You may obtain a copy
+// of the License at:
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.  See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/bazelbuild/sandboxfs/integration/protocol"
+)
+
+// mustBuild computes the layout of a set of mappings and fails the test if that's not possible.
+func mustBuild(t *testing.T, mappings ...protocol.Mapping) *node {
+	t.Helper()
+	layout, err := protocol.BuildLayoutWithConflicts(mappings)
+	if err != nil {
+		t.Fatalf("Failed to build layout: %v", err)
+	}
+	return fromLayout(layout)
+}
+
+func TestRenderTree(t *testing.T) {
+	root := mustBuild(t,
+		protocol.Mapping{Path: "/", UnderlyingPath: "/root"},
+		protocol.Mapping{Path: "/a/b", UnderlyingPath: "/ab"},
+		protocol.Mapping{Path: "/a/c", UnderlyingPath: "/ac", Writable: true},
+		protocol.Mapping{Path: "/d", UnderlyingPath: "/d"},
+		protocol.Mapping{Path: "/a/b", UnderlyingPath: "/other", Writable: true})
+
+	var buffer bytes.Buffer
+	if err := renderTree(&buffer, []sandboxLayout{{ID: "first", Root: root}}); err != nil {
+		t.Fatalf("Failed to render tree: %v", err)
+	}
+	want := `sandbox first:
+/ [ro] -> /root
+├── a [scaffold] (shadows /root/a)
+│   ├── b [ro] -> /ab (shadows /root/a/b)
+│   │   !! CONFLICT: rw mapping to /other rejected: already mapped
+│   └── c [rw] -> /ac (shadows /root/a/c)
+└── d [ro] -> /d (shadows /root/d)
+`
+	if got := buffer.String(); got != want {
+		t.Errorf("Got tree:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestRenderDOT(t *testing.T) {
+	root := mustBuild(t,
+		protocol.Mapping{Path: "/", UnderlyingPath: "/root"},
+		protocol.Mapping{Path: `/we"ird`, UnderlyingPath: `/back\slash`, Writable: true},
+		protocol.Mapping{Path: "/", UnderlyingPath: "/again"})
+
+	var buffer bytes.Buffer
+	if err := renderDOT(&buffer, []sandboxLayout{{ID: "s", Root: root}}); err != nil {
+		t.Fatalf("Failed to render graph: %v", err)
+	}
+	got := buffer.String()
+	for _, want := range []string{
+		`label="sandbox s";`,
+		`"s:/" [label="/\nro: /root", color=red, penwidth=2];`,
+		`"s:/" -> "s:/we\"ird";`,
+		`"s:/we\"ird" [label="we\"ird\nrw: /back\\slash\nshadows /root/we\"ird", style=filled, fillcolor="#fff3c4", color=orange, penwidth=2];`,
+		`"s:/#conflict0" -> "s:/" [style=dashed, color=red, fontcolor=red, label="root can be mapped at most once"];`,
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("Graph does not contain %s; got:\n%s", want, got)
+		}
+	}
+}
+
+func TestInspectMount(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sandboxfs-layout")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	for _, subdir := range []string{"a/b/c", "a/d", "e"} {
+		if err := os.MkdirAll(filepath.Join(dir, subdir), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, file := range []string{"a/b/c/file", "f1", "f2", "f3"} {
+		if err := ioutil.WriteFile(filepath.Join(dir, file), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Symlink("f1", filepath.Join(dir, "link")); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := mustBuild(t,
+		protocol.Mapping{Path: "/a/b/c", UnderlyingPath: "/abc", Writable: true},
+		protocol.Mapping{Path: "/a/missing", UnderlyingPath: "/missing"})
+	root, err := inspectMount(dir, expected, 1, 3)
+	if err != nil {
+		t.Fatalf("Failed to inspect mount: %v", err)
+	}
+
+	var got []string
+	var walk func(*node)
+	walk = func(n *node) {
+		got = append(got, n.label())
+		for _, child := range n.Children {
+			walk(child)
+		}
+	}
+	walk(root)
+	want := []string{
+		"/ [scaffold]",
+		"a/ [scaffold]",
+		"b/ [scaffold]",
+		"c/ [rw] -> /abc",
+		"missing [ro] -> /missing MISSING",
+		"e/",
+		"f1",
+		"f2",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Got nodes %v; want %v", got, want)
+	}
+	if root.Elided != 2 {
+		t.Errorf("Got %d elided entries in root; want 2", root.Elided)
+	}
+	if a := root.child("a"); a == nil || a.Elided != 1 {
+		t.Errorf("Got %+v for a; want 1 elided entry", a)
+	}
+}
+
+func TestReadRequests(t *testing.T) {
+	input := `
+{"CreateSandbox": {"id": "first", "mappings": [{"path": "/", "underlying_path": "/x"}]}}
+{"C": {"i": "second", "m": [{"x": 1, "p": "a", "y": 1, "u": "b"}], "q": {"1": "/pre"}}}
+{"DestroySandbox": "first"}
+{"C": {"i": "first", "m": [{"x": 1, "p": "c", "u": "/y"}]}}
+{"C": {"i": "third", "m": [{"p": "/", "u": "/z"}]}}
+{"D": "third"}
+{"D": "unknown"}
+`
+	order, sandboxes, err := readRequests(strings.NewReader(input))
+	if err != nil {
+		t.Fatalf("Failed to read requests: %v", err)
+	}
+	if want := []string{"second", "first"}; !reflect.DeepEqual(order, want) {
+		t.Errorf("Got order %v; want %v", order, want)
+	}
+	want := map[string][]protocol.Mapping{
+		"first":  {{Path: "/pre/c", UnderlyingPath: "/y"}},
+		"second": {{Path: "/pre/a", UnderlyingPath: "/pre/b"}},
+	}
+	if !reflect.DeepEqual(sandboxes, want) {
+		t.Errorf("Got sandboxes %v; want %v", sandboxes, want)
+	}
+
+	if _, _, err := readRequests(strings.NewReader(`{"C": {"i": "x", "m": [{"x": 5, "p": "a", "u": "/b"}]}}`)); err == nil || !strings.Contains(err.Error(), "Prefix 5 does not exist") {
+		t.Errorf("Got %v; want unknown prefix error", err)
+	}
+}
+
+func TestReadRequests_CreateExtendsSandbox(t *testing.T) {
+	input := `
+{"C": {"i": "sb", "m": [{"p": "/a", "u": "/x"}]}}
+{"C": {"i": "sb", "m": [{"p": "/b", "u": "/y"}, {"p": "/a", "u": "/z", "w": true}]}}
+`
+	_, sandboxes, err := readRequests(strings.NewReader(input))
+	if err != nil {
+		t.Fatalf("Failed to read requests: %v", err)
+	}
+	want := []protocol.Mapping{
+		{Path: "/a", UnderlyingPath: "/x"},
+		{Path: "/b", UnderlyingPath: "/y"},
+		{Path: "/a", UnderlyingPath: "/z", Writable: true},
+	}
+	if !reflect.DeepEqual(sandboxes["sb"], want) {
+		t.Fatalf("Got mappings %v; want %v", sandboxes["sb"], want)
+	}
+
+	layout, err := protocol.BuildLayoutWithConflicts(sandboxes["sb"])
+	if err != nil {
+		t.Fatalf("Failed to build layout: %v", err)
+	}
+	var a *protocol.LayoutNode
+	layout.Walk(func(node *protocol.LayoutNode) {
+		if node.Path == "/a" {
+			a = node
+		}
+	})
+	if a == nil || a.UnderlyingPath != "/x" {
+		t.Fatalf("Got %+v for /a; want mapping to /x from the first request", a)
+	}
+	if wantConflicts := []protocol.Mapping{want[2]}; !reflect.DeepEqual(a.Conflicts, wantConflicts) {
+		t.Errorf("Got conflicts %v for /a; want %v", a.Conflicts, wantConflicts)
+	}
+}
@@ -0,0 +1,195 @@
+// Copyright 2020 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not
+// use this file except in compliance with the License.  You may obtain a copy
+// of the License at:
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.  See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+// The sandboxfs-layout binary renders the directory layout of sandboxes.
+//
+// The layout can be computed from the --mapping flags given to sandboxfs, from a manifest with the
+// body of a CreateSandbox request, or from a stream of raw reconfiguration requests.  Each node is
+// marked as a read-only mapping, a read/write mapping, or a scaffold directory synthesized by
+// sandboxfs to hold the mappings beneath it.  Nodes that hide entries of the underlying directory of
+// an enclosing mapping are marked as shadowing them, and mappings that sandboxfs rejects because
+// they target an existing node are reported as conflicts.
+//
+// With --mount, the layout is instead reconstructed by listing the contents of a live sandbox.  If
+// mappings are given too, the live entries are annotated with them and nodes that the mappings
+// define but that cannot be found are reported as missing.
+//
+// The layout can be rendered as an ASCII tree, as a Graphviz DOT graph, or as JSON.
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/bazelbuild/sandboxfs/integration/protocol"
+)
+
+// loadLayouts computes the expected layouts from the given sources, of which at most one can be
+// set.  Returns nil if no source was given.
+func loadLayouts(mappings []protocol.Mapping, manifest string, requests string, sandbox string) ([]sandboxLayout, error) {
+	build := func(id string, mappings []protocol.Mapping) (sandboxLayout, error) {
+		layout, err := protocol.BuildLayoutWithConflicts(mappings)
+		if err != nil {
+			return sandboxLayout{}, fmt.Errorf("invalid layout: %v", err)
+		}
+		return sandboxLayout{ID: id, Root: fromLayout(layout)}, nil
+	}
+
+	switch {
+	case len(mappings) > 0:
+		layout, err := build("", mappings)
+		if err != nil {
+			return nil, err
+		}
+		return []sandboxLayout{layout}, nil
+
+	case manifest != "":
+		create, err := protocol.ReadManifest(manifest)
+		if err != nil {
+			return nil, err
+		}
+		resolved, err := protocol.NewPrefixes().Resolve(create)
+		if err != nil {
+			return nil, fmt.Errorf("invalid manifest %s: %v", manifest, err)
+		}
+		layout, err := build(create.ID, resolved)
+		if err != nil {
+			return nil, err
+		}
+		return []sandboxLayout{layout}, nil
+
+	case requests != "":
+		var input io.Reader = os.Stdin
+		if requests != "-" {
+			file, err := os.Open(requests)
+			if err != nil {
+				return nil, err
+			}
+			defer file.Close()
+			input = file
+		}
+		order, sandboxes, err := readRequests(input)
+		if err != nil {
+			return nil, err
+		}
+		if sandbox != "" {
+			mappings, ok := sandboxes[sandbox]
+			if !ok {
+				return nil, fmt.Errorf("sandbox %s is not live after the requests in %s", sandbox, requests)
+			}
+			order, sandboxes = []string{sandbox}, map[string][]protocol.Mapping{sandbox: mappings}
+		}
+		var layouts []sandboxLayout
+		for _, id := range order {
+			layout, err := build(id, sandboxes[id])
+			if err != nil {
+				return nil, fmt.Errorf("sandbox %s: %v", id, err)
+			}
+			layouts = append(layouts, layout)
+		}
+		return layouts, nil
+
+	default:
+		return nil, nil
+	}
+}
+
+// run parses the command line and renders the requested layouts.
+func run(args []string) error {
+	fs := flag.NewFlagSet("sandboxfs-layout", flag.ContinueOnError)
+	var mappings protocol.MappingsFlag
+	fs.Var(&mappings, "mapping", "mapping as given to sandboxfs, in TYPE:PATH:UNDERLYING_PATH form; repeatable")
+	manifest := fs.String("manifest", "", "path to a JSON file with the body of a CreateSandbox request")
+	requests := fs.String("requests", "", "path to a stream of reconfiguration requests, or - for stdin")
+	sandbox := fs.String("sandbox", "", "identifier of the only sandbox to render from --requests")
+	mount := fs.String("mount", "", "path to a live sandbox whose contents to inspect")
+	maxDepth := fs.Int("max_depth", 3, "number of directory levels to list with --mount")
+	maxEntries := fs.Int("max_entries", 20, "number of unexpected entries to list per directory with --mount; 0 for all")
+	format := fs.String("format", "tree", "output format: tree, dot or json")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: sandboxfs-layout [flags]\n\nFlags:\n")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 0 {
+		return fmt.Errorf("too many arguments")
+	}
+	if *format != "tree" && *format != "dot" && *format != "json" {
+		return fmt.Errorf("invalid --format %s; must be tree, dot or json", *format)
+	}
+
+	sources := 0
+	for _, set := range []bool{len(mappings) > 0, *manifest != "", *requests != ""} {
+		if set {
+			sources++
+		}
+	}
+	if sources > 1 {
+		return fmt.Errorf("--mapping, --manifest and --requests are mutually exclusive")
+	}
+	if sources == 0 && *mount == "" {
+		return fmt.Errorf("must specify --mapping, --manifest, --requests or --mount")
+	}
+	if *sandbox != "" && *requests == "" {
+		return fmt.Errorf("--sandbox requires --requests")
+	}
+
+	layouts, err := loadLayouts(mappings, *manifest, *requests, *sandbox)
+	if err != nil {
+		return err
+	}
+	if *mount != "" {
+		var expected *node
+		switch len(layouts) {
+		case 0:
+		case 1:
+			expected = layouts[0].Root
+		default:
+			return fmt.Errorf("--requests defines multiple sandboxes; use --sandbox to select the one at --mount")
+		}
+		root, err := inspectMount(*mount, expected, *maxDepth, *maxEntries)
+		if err != nil {
+			return err
+		}
+		id := ""
+		if len(layouts) == 1 {
+			id = layouts[0].ID
+		}
+		layouts = []sandboxLayout{{ID: id, Root: root}}
+	}
+
+	switch *format {
+	case "dot":
+		return renderDOT(os.Stdout, layouts)
+	case "json":
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		encoder.SetEscapeHTML(false)
+		return encoder.Encode(layouts)
+	default:
+		return renderTree(os.Stdout, layouts)
+	}
+}
+
+func main() {
+	if err := run(os.Args[1:]); err != nil {
+		fmt.Fprintf(os.Stderr, "sandboxfs-layout: %v\n", err)
+		os.Exit(1)
+	}
+}
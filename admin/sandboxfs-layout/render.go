@@ -0,0 +1,191 @@
+// Copyright 2020 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not
+// use this file except in compliance with the License.  You may obtain a copy
+// of the License at:
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.  See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/bazelbuild/sandboxfs/integration/protocol"
+)
+
+// mappingKind returns the short name of the kind of a mapping.
+func mappingKind(m protocol.Mapping) string {
+	if m.Writable {
+		return "rw"
+	}
+	return "ro"
+}
+
+// conflictReason returns the reason why sandboxfs rejects a conflicting mapping of n.
+func (n *node) conflictReason() string {
+	if n.Path == "/" {
+		return "root can be mapped at most once"
+	}
+	return "already mapped"
+}
+
+// label returns the one-line description of the node used in tree renderings.
+func (n *node) label() string {
+	var b strings.Builder
+	b.WriteString(n.Name)
+	if n.Type == "dir" && n.Path != "/" {
+		b.WriteString("/")
+	} else if n.Type == "symlink" {
+		b.WriteString("@")
+	}
+	if n.Kind != "" {
+		fmt.Fprintf(&b, " [%s]", n.Kind)
+	}
+	if n.UnderlyingPath != "" {
+		fmt.Fprintf(&b, " -> %s", n.UnderlyingPath)
+	}
+	if n.Shadows != "" {
+		fmt.Fprintf(&b, " (shadows %s)", n.Shadows)
+	}
+	if n.Missing {
+		b.WriteString(" MISSING")
+	}
+	return b.String()
+}
+
+// writeTree renders the subtree rooted at n as ASCII art.  prefix is the indentation of the
+// lines of the node's children, which also applies to the node's annotations.
+func writeTree(w io.Writer, n *node, prefix string) {
+	for _, m := range n.Conflicts {
+		fmt.Fprintf(w, "%s!! CONFLICT: %s mapping to %s rejected: %s\n", prefix, mappingKind(m), m.UnderlyingPath, n.conflictReason())
+	}
+	for i, child := range n.Children {
+		branch, indent := "├── ", "│   "
+		if i == len(n.Children)-1 && n.Elided == 0 {
+			branch, indent = "└── ", "    "
+		}
+		fmt.Fprintf(w, "%s%s%s\n", prefix, branch, child.label())
+		writeTree(w, child, prefix+indent)
+	}
+	if n.Elided > 0 {
+		fmt.Fprintf(w, "%s└── ... %d more entries\n", prefix, n.Elided)
+	}
+}
+
+// renderTree writes the given layouts as ASCII trees.
+func renderTree(w io.Writer, layouts []sandboxLayout) error {
+	for i, layout := range layouts {
+		if i > 0 {
+			fmt.Fprintln(w)
+		}
+		if layout.ID != "" {
+			fmt.Fprintf(w, "sandbox %s:\n", layout.ID)
+		}
+		fmt.Fprintln(w, layout.Root.label())
+		writeTree(w, layout.Root, "")
+	}
+	return nil
+}
+
+// dotQuote quotes a list of lines for use as an identifier or label in a DOT file.
+func dotQuote(lines ...string) string {
+	escaped := make([]string, len(lines))
+	for i, line := range lines {
+		line = strings.Replace(line, `\`, `\\`, -1)
+		escaped[i] = strings.Replace(line, `"`, `\"`, -1)
+	}
+	return `"` + strings.Join(escaped, `\n`) + `"`
+}
+
+// dotAttributes returns the DOT attributes that represent a node, excluding its label.
+func (n *node) dotAttributes() string {
+	var attrs []string
+	switch n.Kind {
+	case "scaffold":
+		attrs = append(attrs, "style=dashed")
+	case "rw":
+		attrs = append(attrs, `style=filled`, `fillcolor="#fff3c4"`)
+	case "":
+		attrs = append(attrs, "color=gray", "fontcolor=gray")
+	}
+	switch {
+	case n.Missing:
+		attrs = append(attrs, "color=red", "fontcolor=red", "style=dotted")
+	case len(n.Conflicts) > 0:
+		attrs = append(attrs, "color=red", "penwidth=2")
+	case n.Shadows != "":
+		attrs = append(attrs, "color=orange", "penwidth=2")
+	}
+	return strings.Join(attrs, ", ")
+}
+
+// dotLabel returns the lines of the label of a node in a DOT rendering.
+func (n *node) dotLabel() []string {
+	lines := []string{n.Name}
+	if n.Kind != "" && n.Kind != "scaffold" {
+		lines = append(lines, fmt.Sprintf("%s: %s", n.Kind, n.UnderlyingPath))
+	}
+	if n.Shadows != "" {
+		lines = append(lines, "shadows "+n.Shadows)
+	}
+	if n.Missing {
+		lines = append(lines, "MISSING")
+	}
+	return lines
+}
+
+// writeDOT renders the subtree rooted at n as DOT statements.  Node identifiers are prefixed with
+// scope so that multiple sandboxes can be rendered in the same graph.
+func writeDOT(w io.Writer, n *node, scope string, indent string) {
+	id := dotQuote(scope + n.Path)
+	label := dotQuote(n.dotLabel()...)
+	attrs := n.dotAttributes()
+	if attrs != "" {
+		attrs = ", " + attrs
+	}
+	fmt.Fprintf(w, "%s%s [label=%s%s];\n", indent, id, label, attrs)
+
+	for i, m := range n.Conflicts {
+		conflictID := dotQuote(fmt.Sprintf("%s%s#conflict%d", scope, n.Path, i))
+		conflictLabel := dotQuote(fmt.Sprintf("%s: %s", mappingKind(m), m.UnderlyingPath))
+		fmt.Fprintf(w, "%s%s [label=%s, color=red, fontcolor=red];\n", indent, conflictID, conflictLabel)
+		fmt.Fprintf(w, "%s%s -> %s [style=dashed, color=red, fontcolor=red, label=%s];\n", indent, conflictID, id, dotQuote(n.conflictReason()))
+	}
+	for _, child := range n.Children {
+		fmt.Fprintf(w, "%s%s -> %s;\n", indent, id, dotQuote(scope+child.Path))
+		writeDOT(w, child, scope, indent)
+	}
+	if n.Elided > 0 {
+		elidedID := dotQuote(scope + n.Path + "#elided")
+		fmt.Fprintf(w, "%s%s [label=%s, shape=plaintext];\n", indent, elidedID, dotQuote(fmt.Sprintf("... %d more entries", n.Elided)))
+		fmt.Fprintf(w, "%s%s -> %s [style=dotted];\n", indent, id, elidedID)
+	}
+}
+
+// renderDOT writes the given layouts as a Graphviz graph with one cluster per sandbox.
+func renderDOT(w io.Writer, layouts []sandboxLayout) error {
+	fmt.Fprintf(w, "digraph layout {\n")
+	fmt.Fprintf(w, "  rankdir=LR;\n")
+	fmt.Fprintf(w, "  node [shape=box, fontname=monospace];\n")
+	for i, layout := range layouts {
+		if layout.ID == "" {
+			writeDOT(w, layout.Root, "", "  ")
+			continue
+		}
+		fmt.Fprintf(w, "  subgraph cluster_%d {\n", i)
+		fmt.Fprintf(w, "    label=%s;\n", dotQuote("sandbox "+layout.ID))
+		writeDOT(w, layout.Root, layout.ID+":", "    ")
+		fmt.Fprintf(w, "  }\n")
+	}
+	fmt.Fprintf(w, "}\n")
+	return nil
+}
@@ -0,0 +1,88 @@
+// Copyright 2020 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not
+// use this file except in compliance with the License.  You may obtain a copy
+// of the License at:
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.  See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+// The sandboxfs-mounts binary lists the active sandboxfs mounts.
+//
+// Mounts are found by parsing /proc/self/mountinfo.  For each of them, the tool shows the process
+// that serves it, the access mode given to --allow, whether the file system answers requests, and
+// the entries found at its root, which include the identifiers of the sandboxes.  This helps clean
+// up after crashes, which leave stray mounts behind.
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/bazelbuild/sandboxfs/integration/mounts"
+)
+
+// printTable writes the inventory as a table with one mount per line.
+func printTable(w io.Writer, infos []mounts.Info) error {
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, strings.Join([]string{"MOUNT POINT", "PID", "ALLOW", "STATUS", "ENTRIES"}, "\t"))
+	for _, info := range infos {
+		pid := "-"
+		if info.PID != 0 {
+			pid = strconv.Itoa(info.PID)
+		}
+		status := string(info.Status)
+		if info.Error != "" {
+			status += " (" + info.Error + ")"
+		}
+		fmt.Fprintln(tw, strings.Join([]string{info.Mount.MountPoint, pid, info.Allow, status, strings.Join(info.Entries, ",")}, "\t"))
+	}
+	return tw.Flush()
+}
+
+// run parses the command line and prints the inventory of mounts.
+func run(args []string) error {
+	fs := flag.NewFlagSet("sandboxfs-mounts", flag.ContinueOnError)
+	format := fs.String("format", "table", "output format: table or json")
+	timeout := fs.Duration("timeout", 2*time.Second, "maximum time to wait for each mount point to answer")
+	procRoot := fs.String("proc", "/proc", "path to the proc file system")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 0 {
+		return fmt.Errorf("too many arguments")
+	}
+	if *format != "table" && *format != "json" {
+		return fmt.Errorf("invalid --format %s; must be table or json", *format)
+	}
+
+	infos, err := mounts.Inventory(*procRoot, *timeout)
+	if err != nil {
+		return err
+	}
+	if *format == "json" {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(infos)
+	}
+	return printTable(os.Stdout, infos)
+}
+
+func main() {
+	if err := run(os.Args[1:]); err != nil {
+		fmt.Fprintf(os.Stderr, "sandboxfs-mounts: %v\n", err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,76 @@
+// Copyright 2020 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not
+// use this file except in compliance with the License.  You may obtain a copy
+// of the License at:
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.  See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+// The sandboxfs-prof binary summarizes a CPU profile written by sandboxfs via --cpu_profile.
+//
+// The profile is symbolized with the given sandboxfs binary, which must be the exact build that
+// produced the profile, and is summarized as a table of the functions with the most samples.  The
+// tool can also write the stack traces in the folded format consumed by flame graph generators.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/bazelbuild/sandboxfs/integration/cpuprof"
+)
+
+// writeFolded writes the folded stack traces to the file at path.
+func writeFolded(path string, stacks []cpuprof.Stack) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := cpuprof.WriteFolded(file, stacks); err != nil {
+		file.Close()
+		return fmt.Errorf("failed to write %s: %v", path, err)
+	}
+	return file.Close()
+}
+
+// run parses the command line and summarizes the profile.
+func run(args []string) error {
+	fs := flag.NewFlagSet("sandboxfs-prof", flag.ContinueOnError)
+	top := fs.Int("top", 20, "number of functions to show; 0 shows all")
+	folded := fs.String("folded", "", "path to which to write the stack traces in folded format")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: sandboxfs-prof [flags] BINARY PROFILE\n\nFlags:\n")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 2 {
+		return fmt.Errorf("expected a binary and a profile")
+	}
+
+	stacks, err := cpuprof.Load(fs.Arg(1), fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	if *folded != "" {
+		if err := writeFolded(*folded, stacks); err != nil {
+			return err
+		}
+	}
+	return cpuprof.WriteTop(os.Stdout, stacks, *top)
+}
+
+func main() {
+	if err := run(os.Args[1:]); err != nil {
+		fmt.Fprintf(os.Stderr, "sandboxfs-prof: %v\n", err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,143 @@
+// Copyright 2020 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not
+// use this file except in compliance with the License.  You may obtain a copy
+// of the License at:
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.  See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+// The sandboxfs-reaper binary cleans up the mounts of sandboxfs instances that died.
+//
+// When sandboxfs is killed, its mount point is left behind and returns "Transport endpoint is not
+// connected" on every access.  The reaper finds such mounts, aborts their FUSE connection through
+// the fusectl file system, and lazily unmounts them, which works even if they are busy.
+//
+// The reaper is meant to be run periodically, for example from cron: it only touches mounts that
+// the kernel reports as disconnected, it refuses to run concurrently with another instance of
+// itself, and it prints nothing unless it cleans something up, fails to, or finds another instance
+// running.  It exits with a non-zero code if any mount could not be unmounted.
+//
+// The lock that prevents concurrent runs lives in /run by default, which only root can write to.
+// A lock in a world-writable directory would let any user keep the reaper from running or, via a
+// symlink, make it create files elsewhere.
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"syscall"
+	"time"
+
+	"github.com/bazelbuild/sandboxfs/integration/mounts"
+)
+
+// lock acquires an exclusive lock on the given file without blocking.  Returns false if another
+// process holds the lock.  The lock is held until the process exits.  The file is not opened if it
+// is a symlink.
+func lock(path string) (bool, error) {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|syscall.O_NOFOLLOW, 0600)
+	if err != nil {
+		return false, fmt.Errorf("cannot open lock: %v", err)
+	}
+	if err := syscall.Flock(int(file.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		file.Close()
+		if err == syscall.EWOULDBLOCK {
+			return false, nil
+		}
+		return false, fmt.Errorf("cannot lock %s: %v", path, err)
+	}
+	return true, nil // Leak the file on purpose to keep the lock.
+}
+
+// printText writes one line per reaped mount.
+func printText(w io.Writer, results []mounts.ReapResult, dryRun bool) {
+	for _, r := range results {
+		if dryRun {
+			fmt.Fprintf(w, "would reap %s (connection %d)\n", r.Mount.MountPoint, r.Mount.ConnectionID())
+			continue
+		}
+		if r.AbortError != "" {
+			fmt.Fprintf(w, "%s: cannot abort connection %d: %s\n", r.Mount.MountPoint, r.Mount.ConnectionID(), r.AbortError)
+		}
+		if r.Unmounted {
+			fmt.Fprintf(w, "reaped %s (connection %d)\n", r.Mount.MountPoint, r.Mount.ConnectionID())
+		} else {
+			fmt.Fprintf(w, "%s\n", r.Error)
+		}
+	}
+}
+
+// run parses the command line and reaps stale mounts.  Returns the number of mounts that could not
+// be unmounted.
+func run(args []string) (int, error) {
+	fs := flag.NewFlagSet("sandboxfs-reaper", flag.ContinueOnError)
+	opts := &mounts.ReapOptions{}
+	fs.BoolVar(&opts.DryRun, "dry_run", false, "report stale mounts without touching them")
+	fs.BoolVar(&opts.AllFUSE, "all_fuse", false, "also reap FUSE file systems other than sandboxfs")
+	fs.DurationVar(&opts.Timeout, "timeout", 2*time.Second, "maximum time to wait for each mount point to answer")
+	fs.StringVar(&opts.ProcRoot, "proc", "/proc", "path to the proc file system")
+	fs.StringVar(&opts.FusectlRoot, "fusectl", "/sys/fs/fuse/connections", "path to the fusectl file system")
+	lockPath := fs.String("lock", "/run/sandboxfs-reaper.lock", "path to the file that prevents concurrent runs; must not be in a world-writable directory")
+	format := fs.String("format", "text", "output format: text or json")
+	if err := fs.Parse(args); err != nil {
+		return 0, err
+	}
+	if fs.NArg() != 0 {
+		return 0, fmt.Errorf("too many arguments")
+	}
+	if *format != "text" && *format != "json" {
+		return 0, fmt.Errorf("invalid --format %s; must be text or json", *format)
+	}
+
+	if ok, err := lock(*lockPath); err != nil {
+		return 0, err
+	} else if !ok {
+		// Another reaper is running and will do the work.  Say so, as it could also be an
+		// unrelated process holding the lock forever, which would otherwise go unnoticed.
+		fmt.Fprintf(os.Stderr, "sandboxfs-reaper: %s is held by another process; not running\n", *lockPath)
+		return 0, nil
+	}
+
+	results, err := mounts.Reap(opts)
+	if err != nil {
+		return 0, err
+	}
+	if *format == "json" {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(results); err != nil {
+			return 0, err
+		}
+	} else {
+		printText(os.Stdout, results, opts.DryRun)
+	}
+
+	failed := 0
+	for _, r := range results {
+		if !opts.DryRun && !r.Unmounted {
+			failed++
+		}
+	}
+	return failed, nil
+}
+
+func main() {
+	failed, err := run(os.Args[1:])
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "sandboxfs-reaper: %v\n", err)
+		os.Exit(1)
+	}
+	if failed > 0 {
+		fmt.Fprintf(os.Stderr, "sandboxfs-reaper: failed to reap %d mount(s)\n", failed)
+		os.Exit(1)
+	}
+}
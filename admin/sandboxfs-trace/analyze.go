@@ -0,0 +1,284 @@
+// Copyright 2020 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not
+// use this file except in compliance with the License.  You may obtain a copy
+// of the License at:
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.  See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"regexp"
+	"sort"
+	"time"
+
+	"github.com/bazelbuild/sandboxfs/integration/protocol"
+)
+
+// errorCategories classifies the error messages returned by sandboxfs.  The first matching
+// category wins; messages that match none are classified as "other".
+var errorCategories = []struct {
+	name    string
+	pattern *regexp.Regexp
+}{
+	{"already-mapped", regexp.MustCompile(`Already mapped`)},
+	{"root-mapping", regexp.MustCompile(`Root can be mapped at most once|Failed to map root`)},
+	{"bad-prefix", regexp.MustCompile(`Prefix \d+ (does not exist|already had path)|Suffix .* must be relative|Bad prefix number`)},
+	{"bad-identifier", regexp.MustCompile(`Identifier .*(empty|not a basename)`)},
+	{"unknown-sandbox", regexp.MustCompile(`Unknown entry|[Nn]ot a mapping`)},
+	{"missing-underlying", regexp.MustCompile(`[Ss]tat failed|No such file or directory`)},
+	{"not-absolute", regexp.MustCompile(`is not absolute`)},
+}
+
+// categorizeError returns the category of an error message returned by sandboxfs.
+func categorizeError(message string) string {
+	for _, category := range errorCategories {
+		if category.pattern.MatchString(message) {
+			return category.name
+		}
+	}
+	return "other"
+}
+
+// kindOf returns the name of the kind of a request.
+func kindOf(req protocol.Request) string {
+	if req.CreateSandbox != nil {
+		return "create"
+	}
+	return "destroy"
+}
+
+// outstandingRequest represents a request that has not been answered yet.
+type outstandingRequest struct {
+	// time is the moment at which the request was seen.
+	time time.Time
+
+	// kind is the name of the kind of request.
+	kind string
+}
+
+// streamState holds the state of a single stream in the trace.
+type streamState struct {
+	// prefixes tracks the prefixes defined by the stream's requests.
+	prefixes *protocol.Prefixes
+
+	// compressor computes the prefixes the stream could have used.
+	compressor *protocol.PrefixCompressor
+
+	// pending contains the queues of outstanding requests, keyed by sandbox identifier.
+	pending map[string][]outstandingRequest
+}
+
+// sample captures the load of sandboxfs right after an event.
+type sample struct {
+	// time is the moment of the event.
+	time time.Time
+
+	// inFlight is the number of requests sent but not yet answered.
+	inFlight int
+
+	// live is the number of sandboxes that exist.
+	live int
+
+	// isRequest is true if the event was a request.
+	isRequest bool
+}
+
+// encodingSizes accumulates the sizes of requests under different encodings.
+type encodingSizes struct {
+	// Requests is the number of requests whose sizes were accounted for.
+	Requests int `json:"requests"`
+
+	// Actual is the size of the requests as they were sent.
+	Actual int `json:"actual_bytes"`
+
+	// Canonical is the size of the requests with canonical names and without prefixes.
+	Canonical int `json:"canonical_bytes"`
+
+	// Minimized is the size of the requests with minimized names and without prefixes.
+	Minimized int `json:"minimized_bytes"`
+
+	// Prefixed is the size of the requests with canonical names and with compressed prefixes.
+	Prefixed int `json:"prefixed_bytes"`
+
+	// MinimizedPrefixed is the size of the requests with minimized names and compressed
+	// prefixes, which is the best encoding we know of.
+	MinimizedPrefixed int `json:"minimized_prefixed_bytes"`
+}
+
+// add accounts for the sizes of a request that was sent as raw and that, once its prefixes are
+// resolved, is equivalent to resolved.  compressed is the prefix-compressed form of resolved.
+func (s *encodingSizes) add(raw []byte, resolved protocol.Request, compressed protocol.Request) error {
+	canonical, err := json.Marshal(resolved)
+	if err != nil {
+		return err
+	}
+	minimized, err := protocol.EncodeMinimized(resolved)
+	if err != nil {
+		return err
+	}
+	prefixed, err := json.Marshal(compressed)
+	if err != nil {
+		return err
+	}
+	minimizedPrefixed, err := protocol.EncodeMinimized(compressed)
+	if err != nil {
+		return err
+	}
+
+	s.Requests++
+	s.Actual += len(raw)
+	s.Canonical += len(canonical)
+	s.Minimized += len(minimized)
+	s.Prefixed += len(prefixed)
+	s.MinimizedPrefixed += len(minimizedPrefixed)
+	return nil
+}
+
+// analysis contains the raw results of analyzing a trace.
+type analysis struct {
+	// requests is the number of requests in the trace.
+	requests int
+
+	// invalid is the number of requests that could not be parsed.
+	invalid int
+
+	// responses is the number of responses in the trace.
+	responses int
+
+	// stray is the number of responses that did not match any request.
+	stray int
+
+	// fatal is the number of responses that indicated that sandboxfs stopped processing a stream.
+	fatal int
+
+	// unanswered is the number of requests that never got a response.
+	unanswered int
+
+	// latencies contains the latencies of the answered requests, keyed by kind.
+	latencies map[string][]time.Duration
+
+	// errors counts the failed requests by category.
+	errors map[string]int
+
+	// lifetimes contains the time between the creation and destruction of each sandbox.
+	lifetimes []time.Duration
+
+	// neverDestroyed contains the identifiers of the sandboxes alive at the end of the trace.
+	neverDestroyed []string
+
+	// samples captures the load of sandboxfs after each event.
+	samples []sample
+
+	// sizes accumulates the sizes of the requests under different encodings.
+	sizes encodingSizes
+}
+
+// analyze processes all events in a trace.
+func analyze(events []protocol.TraceEvent) *analysis {
+	sorted := make([]protocol.TraceEvent, len(events))
+	copy(sorted, events)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].Time.Before(sorted[j].Time) })
+
+	a := &analysis{
+		latencies: make(map[string][]time.Duration),
+		errors:    make(map[string]int),
+	}
+	streams := make(map[int]*streamState)
+	live := make(map[string]time.Time)
+	inFlight := 0
+	for _, event := range sorted {
+		stream, ok := streams[event.Stream]
+		if !ok {
+			stream = &streamState{
+				prefixes:   protocol.NewPrefixes(),
+				compressor: protocol.NewPrefixCompressor(),
+				pending:    make(map[string][]outstandingRequest),
+			}
+			streams[event.Stream] = stream
+		}
+
+		if event.Request != nil {
+			a.requests++
+			var req protocol.Request
+			if err := json.Unmarshal(event.Request, &req); err != nil {
+				a.invalid++
+				continue
+			}
+			id := req.ID()
+			stream.pending[id] = append(stream.pending[id], outstandingRequest{time: event.Time, kind: kindOf(req)})
+			inFlight++
+			a.samples = append(a.samples, sample{time: event.Time, inFlight: inFlight, live: len(live), isRequest: true})
+
+			resolved, compressed := req, req
+			if req.CreateSandbox != nil {
+				mappings, err := stream.prefixes.Resolve(req.CreateSandbox)
+				if err != nil {
+					continue // sandboxfs will reject the request so there is nothing to save.
+				}
+				create := &protocol.CreateSandboxRequest{ID: id, Mappings: mappings}
+				resolved = protocol.Request{CreateSandbox: create}
+				compressed = protocol.Request{CreateSandbox: stream.compressor.Compress(create)}
+			}
+			if err := a.sizes.add(event.Request, resolved, compressed); err != nil {
+				panic("cannot encode request that was just decoded: " + err.Error())
+			}
+			continue
+		}
+
+		a.responses++
+		resp := event.Response
+		if resp.ID == nil {
+			a.fatal++
+			continue
+		}
+		queue := stream.pending[*resp.ID]
+		if len(queue) == 0 {
+			a.stray++
+			continue
+		}
+		req := queue[0]
+		if len(queue) == 1 {
+			delete(stream.pending, *resp.ID)
+		} else {
+			stream.pending[*resp.ID] = queue[1:]
+		}
+		inFlight--
+		latency := event.Time.Sub(req.time)
+		a.latencies["all"] = append(a.latencies["all"], latency)
+		a.latencies[req.kind] = append(a.latencies[req.kind], latency)
+
+		if resp.Error != nil {
+			a.errors[categorizeError(*resp.Error)]++
+		} else if req.kind == "create" {
+			if _, ok := live[*resp.ID]; !ok {
+				live[*resp.ID] = event.Time
+			}
+		} else {
+			if created, ok := live[*resp.ID]; ok {
+				a.lifetimes = append(a.lifetimes, event.Time.Sub(created))
+				delete(live, *resp.ID)
+			}
+		}
+		a.samples = append(a.samples, sample{time: event.Time, inFlight: inFlight, live: len(live)})
+	}
+
+	for _, stream := range streams {
+		for _, queue := range stream.pending {
+			a.unanswered += len(queue)
+		}
+	}
+	for id := range live {
+		a.neverDestroyed = append(a.neverDestroyed, id)
+	}
+	sort.Strings(a.neverDestroyed)
+	return a
+}
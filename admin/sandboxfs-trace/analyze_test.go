@@ -0,0 +1,136 @@
+// Copyright 2020 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not
+// use this file except in compliance with the License.  You may obtain a copy
+// of the License at:
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.  See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package main
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/bazelbuild/sandboxfs/integration/protocol"
+)
+
+// traceBuilder constructs a trace with events at fixed offsets from an arbitrary start time.
+type traceBuilder struct {
+	start  time.Time
+	events []protocol.TraceEvent
+}
+
+// request adds a request sent in stream 1 at the given offset in milliseconds.
+func (b *traceBuilder) request(offset int, raw string) {
+	b.events = append(b.events, protocol.TraceEvent{
+		Time:    b.start.Add(time.Duration(offset) * time.Millisecond),
+		Stream:  1,
+		Request: []byte(raw),
+	})
+}
+
+// response adds a response sent in stream 1 at the given offset in milliseconds.  An empty message
+// indicates success.
+func (b *traceBuilder) response(offset int, id string, message string) {
+	resp := protocol.Response{ID: &id}
+	if message != "" {
+		resp.Error = &message
+	}
+	b.events = append(b.events, protocol.TraceEvent{
+		Time:     b.start.Add(time.Duration(offset) * time.Millisecond),
+		Stream:   1,
+		Response: &resp,
+	})
+}
+
+func TestAnalyze(t *testing.T) {
+	b := &traceBuilder{start: time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)}
+	b.request(0, `{"CreateSandbox":{"id":"a","mappings":[{"path":"/","underlying_path":"/x"}]}}`)
+	b.request(1, `{"C":{"i":"b","m":[{"p":"f","x":1,"u":"/y"}],"q":{"1":"/dir"}}}`)
+	b.response(4, "b", "")
+	b.response(10, "a", "")
+	b.request(20, `{"DestroySandbox":"a"}`)
+	b.response(22, "a", "")
+	b.request(30, `{"D":"c"}`)
+	b.response(31, "c", "Unknown entry")
+	b.request(40, `{"D":"d"}`)
+
+	// Serializing and parsing the trace also exercises the trace format.
+	var buffer bytes.Buffer
+	writer := protocol.NewTraceWriter(&buffer)
+	for _, event := range b.events {
+		if event.Request != nil {
+			writer.RecordRequest(event.Stream, event.Request)
+		} else {
+			writer.RecordResponse(event.Stream, *event.Response)
+		}
+	}
+	events, err := protocol.ReadTrace(&buffer)
+	if err != nil {
+		t.Fatalf("Failed to read trace: %v", err)
+	}
+	if len(events) != len(b.events) {
+		t.Fatalf("Got %d events; want %d", len(events), len(b.events))
+	}
+
+	r := newReport(analyze(b.events), 4)
+	if r.Requests != 5 || r.Responses != 4 || r.Unanswered != 1 || r.StrayResponses != 0 {
+		t.Errorf("Got %d requests, %d responses, %d unanswered, %d stray; want 5, 4, 1, 0",
+			r.Requests, r.Responses, r.Unanswered, r.StrayResponses)
+	}
+	if got := r.Latency["create"]; got.Count != 2 || got.P50 != 3 || got.Max != 10 {
+		t.Errorf("Got create latencies %+v; want count 2, p50 3ms, max 10ms", got)
+	}
+	if want := map[string]int{"unknown-sandbox": 1}; !reflect.DeepEqual(r.ErrorCategories, want) {
+		t.Errorf("Got error categories %v; want %v", r.ErrorCategories, want)
+	}
+	if r.Lifetimes.Count != 1 || r.Lifetimes.Max != 12 {
+		t.Errorf("Got lifetimes %+v; want a single lifetime of 12ms", r.Lifetimes)
+	}
+	if want := []string{"b"}; !reflect.DeepEqual(r.NeverDestroyed, want) {
+		t.Errorf("Got never destroyed %v; want %v", r.NeverDestroyed, want)
+	}
+	if r.MaxInFlight != 2 || r.MaxLive != 2 {
+		t.Errorf("Got max in flight %d and max live %d; want 2 and 2", r.MaxInFlight, r.MaxLive)
+	}
+	if len(r.Windows) != 4 || r.Windows[0].Requests != 2 || r.Windows[3].Requests != 2 {
+		t.Errorf("Got windows %+v; want 4 with 2 requests in the first and the last", r.Windows)
+	}
+
+	e := r.Encoding
+	if e.Requests != 5 {
+		t.Errorf("Got %d requests with encoding stats; want 5", e.Requests)
+	}
+	if !(e.MinimizedPrefixed <= e.Minimized && e.Minimized < e.Actual && e.Actual < e.Canonical) {
+		t.Errorf("Got encoding sizes %+v; want minimized encodings to be smaller", e)
+	}
+}
+
+func TestCategorizeError(t *testing.T) {
+	testData := []struct {
+		message string
+		want    string
+	}{
+		{"Cannot map '/a -> /b (read-only)': Already mapped", "already-mapped"},
+		{"Root can be mapped at most once", "root-mapping"},
+		{"Prefix 3 does not exist", "bad-prefix"},
+		{"Identifier a/b is not a basename", "bad-identifier"},
+		{"Unknown entry", "unknown-sandbox"},
+		{"Cannot map '/a -> /b (read-only)': Stat failed for \"/b\": No such file or directory", "missing-underlying"},
+		{"Something else", "other"},
+	}
+	for _, d := range testData {
+		if got := categorizeError(d.message); got != d.want {
+			t.Errorf("Got category %s for %q; want %s", got, d.message, d.want)
+		}
+	}
+}
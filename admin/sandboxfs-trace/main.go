@@ -0,0 +1,91 @@
+// Copyright 2020 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not
+// use this file except in compliance with the License.  You may obtain a copy
+// of the License at:
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.  See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+// The sandboxfs-trace binary analyzes a captured reconfiguration protocol stream.
+//
+// Traces are recorded by "sandboxfsctl serve --trace".  The report includes the latency and error
+// rates of the requests, the lifetimes of the sandboxes, how the number of in-flight requests and
+// live sandboxes varied over time, and how much space the requests could save by using prefixes and
+// minimized names.  This is useful to tune --reconfig_threads and the batching done by clients.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/bazelbuild/sandboxfs/integration/protocol"
+)
+
+// run parses the command line and prints the report of the given trace.  Returns the exit code.
+func run(args []string) int {
+	fs := flag.NewFlagSet("sandboxfs-trace", flag.ContinueOnError)
+	format := fs.String("format", "text", "output format: text or json")
+	windows := fs.Int("windows", 10, "number of time windows in which to split the concurrency report")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: sandboxfs-trace [flags] [TRACE|-]\n\nFlags:\n")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if *format != "text" && *format != "json" {
+		fmt.Fprintf(os.Stderr, "sandboxfs-trace: invalid --format %s; must be text or json\n", *format)
+		return 2
+	}
+	if *windows < 1 {
+		fmt.Fprintf(os.Stderr, "sandboxfs-trace: --windows must be positive\n")
+		return 2
+	}
+
+	var input io.Reader
+	switch {
+	case fs.NArg() == 0 || (fs.NArg() == 1 && fs.Arg(0) == "-"):
+		input = os.Stdin
+	case fs.NArg() == 1:
+		file, err := os.Open(fs.Arg(0))
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "sandboxfs-trace: %v\n", err)
+			return 1
+		}
+		defer file.Close()
+		input = file
+	default:
+		fs.Usage()
+		return 2
+	}
+
+	events, err := protocol.ReadTrace(input)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "sandboxfs-trace: %v\n", err)
+		return 1
+	}
+
+	r := newReport(analyze(events), *windows)
+	if *format == "json" {
+		err = r.printJSON(os.Stdout)
+	} else {
+		err = r.printText(os.Stdout)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "sandboxfs-trace: %v\n", err)
+		return 1
+	}
+	return 0
+}
+
+func main() {
+	os.Exit(run(os.Args[1:]))
+}
@@ -0,0 +1,257 @@
+// Copyright 2020 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not
+// use this file except in compliance with the License.  You may obtain a copy
+// of the License at:
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.  See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"text/tabwriter"
+	"time"
+)
+
+// distribution summarizes a set of durations.
+type distribution struct {
+	Count int     `json:"count"`
+	P50   float64 `json:"p50_ms"`
+	P90   float64 `json:"p90_ms"`
+	P99   float64 `json:"p99_ms"`
+	Max   float64 `json:"max_ms"`
+}
+
+// milliseconds converts a duration to fractional milliseconds.
+func milliseconds(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+// summarize computes the distribution of a set of durations using nearest-rank percentiles.
+func summarize(values []time.Duration) distribution {
+	if len(values) == 0 {
+		return distribution{}
+	}
+	sorted := make([]time.Duration, len(values))
+	copy(sorted, values)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+
+	percentile := func(p int) float64 {
+		rank := (p*len(sorted) + 99) / 100
+		if rank < 1 {
+			rank = 1
+		}
+		return milliseconds(sorted[rank-1])
+	}
+	return distribution{
+		Count: len(sorted),
+		P50:   percentile(50),
+		P90:   percentile(90),
+		P99:   percentile(99),
+		Max:   milliseconds(sorted[len(sorted)-1]),
+	}
+}
+
+// window summarizes the load of sandboxfs during a slice of the trace.
+type window struct {
+	// Start is the offset of the window from the beginning of the trace.
+	Start float64 `json:"start_s"`
+
+	// Requests is the number of requests sent during the window.
+	Requests int `json:"requests"`
+
+	// MaxInFlight is the maximum number of requests awaiting a response during the window.
+	MaxInFlight int `json:"max_in_flight"`
+
+	// MaxLive is the maximum number of sandboxes alive during the window.
+	MaxLive int `json:"max_live"`
+}
+
+// report contains the results of analyzing a trace as presented to the user.
+type report struct {
+	Requests        int                     `json:"requests"`
+	InvalidRequests int                     `json:"invalid_requests"`
+	Unanswered      int                     `json:"unanswered_requests"`
+	Responses       int                     `json:"responses"`
+	StrayResponses  int                     `json:"stray_responses"`
+	FatalResponses  int                     `json:"fatal_responses"`
+	Latency         map[string]distribution `json:"latency"`
+	Errors          int                     `json:"errors"`
+	ErrorRate       float64                 `json:"error_rate"`
+	ErrorCategories map[string]int          `json:"error_categories"`
+	Lifetimes       distribution            `json:"sandbox_lifetimes"`
+	NeverDestroyed  []string                `json:"never_destroyed"`
+	MaxInFlight     int                     `json:"max_in_flight"`
+	MeanInFlight    float64                 `json:"mean_in_flight"`
+	MaxLive         int                     `json:"max_live"`
+	Windows         []window                `json:"windows"`
+	Encoding        encodingSizes           `json:"encoding"`
+}
+
+// computeWindows splits the time covered by the samples in n windows of equal duration and
+// summarizes the load within each.
+func computeWindows(samples []sample, n int) []window {
+	if len(samples) == 0 {
+		return nil
+	}
+	first := samples[0].time
+	total := samples[len(samples)-1].time.Sub(first)
+	if total == 0 {
+		n = 1
+	}
+
+	windows := make([]window, n)
+	for i := range windows {
+		windows[i].Start = (total * time.Duration(i) / time.Duration(n)).Seconds()
+	}
+	previous := sample{}
+	current := 0
+	for _, s := range samples {
+		index := n - 1
+		if total > 0 && s.time.Sub(first) < total {
+			index = int(int64(s.time.Sub(first)) * int64(n) / int64(total))
+		}
+		for current < index {
+			// Windows without events inherit the load left by the last event before them.
+			current++
+			windows[current].MaxInFlight = previous.inFlight
+			windows[current].MaxLive = previous.live
+		}
+
+		w := &windows[index]
+		if s.isRequest {
+			w.Requests++
+		}
+		if s.inFlight > w.MaxInFlight {
+			w.MaxInFlight = s.inFlight
+		}
+		if s.live > w.MaxLive {
+			w.MaxLive = s.live
+		}
+		previous = s
+	}
+	return windows
+}
+
+// newReport computes the report of an analysis.
+func newReport(a *analysis, windows int) *report {
+	r := &report{
+		Requests:        a.requests,
+		InvalidRequests: a.invalid,
+		Unanswered:      a.unanswered,
+		Responses:       a.responses,
+		StrayResponses:  a.stray,
+		FatalResponses:  a.fatal,
+		Latency:         make(map[string]distribution),
+		ErrorCategories: a.errors,
+		Lifetimes:       summarize(a.lifetimes),
+		NeverDestroyed:  a.neverDestroyed,
+		Windows:         computeWindows(a.samples, windows),
+		Encoding:        a.sizes,
+	}
+	for kind, latencies := range a.latencies {
+		r.Latency[kind] = summarize(latencies)
+	}
+	for _, count := range a.errors {
+		r.Errors += count
+	}
+	if answered := len(a.latencies["all"]); answered > 0 {
+		r.ErrorRate = float64(r.Errors) / float64(answered)
+	}
+
+	var weighted time.Duration
+	for i, s := range a.samples {
+		if s.inFlight > r.MaxInFlight {
+			r.MaxInFlight = s.inFlight
+		}
+		if s.live > r.MaxLive {
+			r.MaxLive = s.live
+		}
+		if i+1 < len(a.samples) {
+			weighted += time.Duration(s.inFlight) * a.samples[i+1].time.Sub(s.time)
+		}
+	}
+	if len(a.samples) > 1 {
+		if total := a.samples[len(a.samples)-1].time.Sub(a.samples[0].time); total > 0 {
+			r.MeanInFlight = float64(weighted) / float64(total)
+		}
+	}
+	return r
+}
+
+// savings formats the size of an encoding relative to the canonical one.
+func savings(size int, canonical int) string {
+	if canonical == 0 {
+		return "-"
+	}
+	return fmt.Sprintf("%.1f%%", 100.0*float64(canonical-size)/float64(canonical))
+}
+
+// printText writes the report to w in a human-readable form.
+func (r *report) printText(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+
+	fmt.Fprintf(tw, "Requests:\t%d\t(%d invalid, %d unanswered)\n", r.Requests, r.InvalidRequests, r.Unanswered)
+	fmt.Fprintf(tw, "Responses:\t%d\t(%d stray, %d fatal)\n", r.Responses, r.StrayResponses, r.FatalResponses)
+
+	fmt.Fprintf(tw, "\nLatency (ms)\tcount\tp50\tp90\tp99\tmax\n")
+	for _, kind := range []string{"all", "create", "destroy"} {
+		d := r.Latency[kind]
+		fmt.Fprintf(tw, "  %s\t%d\t%.3f\t%.3f\t%.3f\t%.3f\n", kind, d.Count, d.P50, d.P90, d.P99, d.Max)
+	}
+
+	fmt.Fprintf(tw, "\nErrors:\t%d\t(%.1f%% of answered requests)\n", r.Errors, 100.0*r.ErrorRate)
+	categories := make([]string, 0, len(r.ErrorCategories))
+	for category := range r.ErrorCategories {
+		categories = append(categories, category)
+	}
+	sort.Strings(categories)
+	for _, category := range categories {
+		fmt.Fprintf(tw, "  %s\t%d\n", category, r.ErrorCategories[category])
+	}
+
+	d := r.Lifetimes
+	fmt.Fprintf(tw, "\nSandbox lifetimes (ms)\tcount\tp50\tp90\tp99\tmax\n")
+	fmt.Fprintf(tw, "  destroyed\t%d\t%.3f\t%.3f\t%.3f\t%.3f\n", d.Count, d.P50, d.P90, d.P99, d.Max)
+	fmt.Fprintf(tw, "\nNever destroyed:\t%d\n", len(r.NeverDestroyed))
+	for _, id := range r.NeverDestroyed {
+		fmt.Fprintf(tw, "  %s\n", id)
+	}
+
+	fmt.Fprintf(tw, "\nIn-flight requests:\tmax %d, mean %.2f\n", r.MaxInFlight, r.MeanInFlight)
+	fmt.Fprintf(tw, "Live sandboxes:\tmax %d\n", r.MaxLive)
+	fmt.Fprintf(tw, "\nWindow start (s)\trequests\tmax in flight\tmax live\n")
+	for _, w := range r.Windows {
+		fmt.Fprintf(tw, "  %.3f\t%d\t%d\t%d\n", w.Start, w.Requests, w.MaxInFlight, w.MaxLive)
+	}
+
+	e := r.Encoding
+	fmt.Fprintf(tw, "\nEncoding of %d requests\tbytes\tsavings\n", e.Requests)
+	fmt.Fprintf(tw, "  canonical names, no prefixes\t%d\t%s\n", e.Canonical, savings(e.Canonical, e.Canonical))
+	fmt.Fprintf(tw, "  as sent\t%d\t%s\n", e.Actual, savings(e.Actual, e.Canonical))
+	fmt.Fprintf(tw, "  minimized names, no prefixes\t%d\t%s\n", e.Minimized, savings(e.Minimized, e.Canonical))
+	fmt.Fprintf(tw, "  canonical names, prefixes\t%d\t%s\n", e.Prefixed, savings(e.Prefixed, e.Canonical))
+	fmt.Fprintf(tw, "  minimized names, prefixes\t%d\t%s\n", e.MinimizedPrefixed, savings(e.MinimizedPrefixed, e.Canonical))
+
+	return tw.Flush()
+}
+
+// printJSON writes the report to w as indented JSON.
+func (r *report) printJSON(w io.Writer) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(r); err != nil {
+		return fmt.Errorf("cannot encode report: %v", err)
+	}
+	return nil
+}
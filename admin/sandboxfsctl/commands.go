@@ -0,0 +1,347 @@
+// Copyright 2020 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not
+// use this file except in compliance with the License.  You may obtain a copy
+// of the License at:
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.  See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"time"
+
+	"github.com/bazelbuild/sandboxfs/integration/protocol"
+)
+
+// newCommandFlags creates the flag set of a command.
+func newCommandFlags(name string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: sandboxfsctl [flags] %s %s\n", name, commands[name].synopsis)
+		fs.PrintDefaults()
+	}
+	return fs
+}
+
+// appendJournal records a request that sandboxfs applied successfully, if a journal was requested.
+func appendJournal(global *globalFlags, req protocol.Request) error {
+	if global.journal == "" {
+		return nil
+	}
+	return protocol.AppendJournal(global.journal, protocol.JournalEntry{Time: time.Now(), Request: req})
+}
+
+// pendingRequest represents a request to be sent to sandboxfs.
+type pendingRequest struct {
+	// id is the identifier of the sandbox the request refers to.
+	id string
+
+	// data is the encoded request to send verbatim.
+	data []byte
+
+	// journal is the prefix-free version of the request to record on success, or nil if the
+	// request must not be recorded.
+	journal *protocol.Request
+}
+
+// newPendingRequest encodes a request that does not use prefixes.
+func newPendingRequest(req protocol.Request) (pendingRequest, error) {
+	data, err := json.Marshal(req)
+	if err != nil {
+		return pendingRequest{}, fmt.Errorf("cannot encode request: %v", err)
+	}
+	return pendingRequest{id: req.ID(), data: data, journal: &req}, nil
+}
+
+// sendAll sends all requests to sandboxfs without waiting for their responses, and then waits for
+// all of them.  Returns the outcome of each request in the order in which they were given.
+func sendAll(global *globalFlags, reqs []pendingRequest) ([]result, error) {
+	conn, err := connect(global)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	calls := make([]*protocol.Call, len(reqs))
+	results := make([]result, len(reqs))
+	for i, req := range reqs {
+		results[i].ID = req.id
+		call, err := conn.StartRaw(req.id, req.data)
+		if err != nil {
+			results[i].Error = err.Error()
+			continue
+		}
+		calls[i] = call
+	}
+
+	for i, call := range calls {
+		if call == nil {
+			continue
+		}
+		resp, err := call.Wait(global.timeout)
+		if err == nil {
+			err = resp.Err()
+		}
+		if err != nil {
+			results[i].Error = err.Error()
+			continue
+		}
+		if reqs[i].journal != nil {
+			if err := appendJournal(global, *reqs[i].journal); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return results, nil
+}
+
+// sendAndReport sends all requests, prints their outcome and fails if any of them failed.
+func sendAndReport(global *globalFlags, reqs []pendingRequest) error {
+	results, err := sendAll(global, reqs)
+	if err != nil {
+		return err
+	}
+	if err := printResults(os.Stdout, global.format, results); err != nil {
+		return err
+	}
+	return countFailures(results)
+}
+
+// buildCreateRequest constructs a create request from an optional manifest, an optional identifier
+// and a set of mappings given as flags.  The returned request never uses prefixes.
+func buildCreateRequest(manifest string, args []string, mappings []protocol.Mapping) (*protocol.CreateSandboxRequest, error) {
+	req := &protocol.CreateSandboxRequest{}
+	if manifest != "" {
+		var err error
+		req, err = protocol.ReadManifest(manifest)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	switch len(args) {
+	case 0:
+		if req.ID == "" {
+			return nil, usageErrorf("must specify the sandbox identifier")
+		}
+	case 1:
+		req.ID = args[0]
+	default:
+		return nil, usageErrorf("too many arguments")
+	}
+	req.Mappings = append(req.Mappings, mappings...)
+
+	// Prefixes are global to a reconfiguration stream, so resolve them here to prevent clashes
+	// with the prefixes of other clients sharing the same stream via a daemon.
+	resolved, err := protocol.NewPrefixes().Resolve(req)
+	if err != nil {
+		return nil, fmt.Errorf("invalid request: %v", err)
+	}
+	return &protocol.CreateSandboxRequest{ID: req.ID, Mappings: resolved}, nil
+}
+
+// runCreate implements the "create" command.
+func runCreate(global *globalFlags, args []string) error {
+	fs := newCommandFlags("create")
+	manifest := fs.String("manifest", "", "path to a JSON file with the body of a CreateSandbox request")
+	var mappings protocol.MappingsFlag
+	fs.Var(&mappings, "mapping", "mapping to add to the sandbox as TYPE:PATH:UNDERLYING_PATH; repeatable")
+	if err := fs.Parse(args); err != nil {
+		return usageErrorf("%v", err)
+	}
+
+	create, err := buildCreateRequest(*manifest, fs.Args(), mappings)
+	if err != nil {
+		return err
+	}
+	req, err := newPendingRequest(protocol.Request{CreateSandbox: create})
+	if err != nil {
+		return err
+	}
+	return sendAndReport(global, []pendingRequest{req})
+}
+
+// runDestroy implements the "destroy" command.
+func runDestroy(global *globalFlags, args []string) error {
+	fs := newCommandFlags("destroy")
+	if err := fs.Parse(args); err != nil {
+		return usageErrorf("%v", err)
+	}
+	if fs.NArg() == 0 {
+		return usageErrorf("must specify at least one sandbox identifier")
+	}
+
+	reqs := make([]pendingRequest, 0, fs.NArg())
+	for _, id := range fs.Args() {
+		req, err := newPendingRequest(protocol.MakeDestroySandboxRequest(id))
+		if err != nil {
+			return err
+		}
+		reqs = append(reqs, req)
+	}
+	return sendAndReport(global, reqs)
+}
+
+// runList implements the "list" command.
+func runList(global *globalFlags, args []string) error {
+	fs := newCommandFlags("list")
+	if err := fs.Parse(args); err != nil {
+		return usageErrorf("%v", err)
+	}
+	if fs.NArg() != 0 {
+		return usageErrorf("too many arguments")
+	}
+	if global.mountPoint == "" {
+		return usageErrorf("must specify --mount_point")
+	}
+
+	// sandboxfs exposes each sandbox as a directory at the top of the mount point.  If the root
+	// of the file system is mapped, its contents show up here as well.
+	entries, err := ioutil.ReadDir(global.mountPoint)
+	if err != nil {
+		return fmt.Errorf("failed to list sandboxes: %v", err)
+	}
+	ids := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		if entry.IsDir() {
+			ids = append(ids, entry.Name())
+		}
+	}
+
+	if global.format == "json" {
+		return printJSON(os.Stdout, ids)
+	}
+	rows := make([][]string, 0, len(ids))
+	for _, id := range ids {
+		rows = append(rows, []string{id})
+	}
+	return printTable(os.Stdout, []string{"ID"}, rows)
+}
+
+// runShow implements the "show" command.
+func runShow(global *globalFlags, args []string) error {
+	fs := newCommandFlags("show")
+	manifest := fs.String("manifest", "", "path to a JSON file with the body of a CreateSandbox request")
+	if err := fs.Parse(args); err != nil {
+		return usageErrorf("%v", err)
+	}
+
+	var mappings []protocol.Mapping
+	switch {
+	case *manifest != "" && fs.NArg() == 0:
+		create, err := buildCreateRequest(*manifest, nil, nil)
+		if err != nil {
+			return err
+		}
+		mappings = create.Mappings
+	case *manifest == "" && fs.NArg() == 1:
+		if global.journal == "" {
+			return usageErrorf("must specify --journal to show a live sandbox")
+		}
+		entries, err := protocol.ReadJournal(global.journal)
+		if err != nil {
+			return err
+		}
+		create, ok := protocol.ReplayJournal(entries)[fs.Arg(0)]
+		if !ok {
+			return fmt.Errorf("sandbox %s not found in journal %s", fs.Arg(0), global.journal)
+		}
+		mappings = create.Mappings
+	default:
+		return usageErrorf("must specify either a sandbox identifier or --manifest")
+	}
+
+	root, err := protocol.BuildLayout(mappings)
+	if err != nil {
+		return fmt.Errorf("invalid layout: %v", err)
+	}
+
+	if global.format == "json" {
+		return printJSON(os.Stdout, root)
+	}
+	var rows [][]string
+	root.Walk(func(node *protocol.LayoutNode) {
+		rows = append(rows, []string{node.Path, node.Kind.String(), node.UnderlyingPath})
+	})
+	return printTable(os.Stdout, []string{"PATH", "TYPE", "UNDERLYING PATH"}, rows)
+}
+
+// readRawRequests parses a stream of JSON requests, keeping their original encoding.  Prefixes are
+// tracked across the stream so that successful requests can be journaled in a prefix-free form.
+func readRawRequests(input io.Reader) ([]pendingRequest, error) {
+	prefixes := protocol.NewPrefixes()
+	var reqs []pendingRequest
+	decoder := json.NewDecoder(input)
+	for {
+		var raw json.RawMessage
+		if err := decoder.Decode(&raw); err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("malformed request %d: %v", len(reqs)+1, err)
+		}
+
+		var req protocol.Request
+		if err := json.Unmarshal(raw, &req); err != nil {
+			return nil, fmt.Errorf("invalid request %d: %v", len(reqs)+1, err)
+		}
+
+		var compact bytes.Buffer
+		if err := json.Compact(&compact, raw); err != nil {
+			return nil, fmt.Errorf("malformed request %d: %v", len(reqs)+1, err)
+		}
+
+		pending := pendingRequest{id: req.ID(), data: compact.Bytes()}
+		if req.CreateSandbox == nil {
+			pending.journal = &req
+		} else if mappings, err := prefixes.Resolve(req.CreateSandbox); err == nil {
+			pending.journal = &protocol.Request{
+				CreateSandbox: &protocol.CreateSandboxRequest{ID: req.CreateSandbox.ID, Mappings: mappings},
+			}
+		}
+		reqs = append(reqs, pending)
+	}
+	return reqs, nil
+}
+
+// runRaw implements the "raw" command.
+func runRaw(global *globalFlags, args []string) error {
+	fs := newCommandFlags("raw")
+	if err := fs.Parse(args); err != nil {
+		return usageErrorf("%v", err)
+	}
+
+	var input io.Reader
+	switch {
+	case fs.NArg() == 0 || (fs.NArg() == 1 && fs.Arg(0) == "-"):
+		input = os.Stdin
+	case fs.NArg() == 1:
+		file, err := os.Open(fs.Arg(0))
+		if err != nil {
+			return fmt.Errorf("failed to open requests: %v", err)
+		}
+		defer file.Close()
+		input = file
+	default:
+		return usageErrorf("too many arguments")
+	}
+
+	reqs, err := readRawRequests(input)
+	if err != nil {
+		return err
+	}
+	return sendAndReport(global, reqs)
+}
@@ -0,0 +1,243 @@
+// Copyright 2020 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not
+// use this file except in compliance with the License.  You may obtain a copy
+// of the License at:
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.  See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package main
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/bazelbuild/sandboxfs/integration/protocol"
+)
+
+func TestBuildCreateRequest(t *testing.T) {
+	dir, err := ioutil.TempDir("", "test")
+	if err != nil {
+		t.Fatalf("Failed to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	manifest := filepath.Join(dir, "manifest.json")
+	if err := ioutil.WriteFile(manifest, []byte(`{"i":"from-manifest","m":[{"p":"a","x":1,"u":"/x"}],"q":{"1":"/prefix"}}`), 0644); err != nil {
+		t.Fatalf("Failed to write manifest: %v", err)
+	}
+
+	flag := protocol.Mapping{Path: "/flag", UnderlyingPath: "/y", Writable: true}
+	testData := []struct {
+		name         string
+		manifest     string
+		args         []string
+		mappings     []protocol.Mapping
+		wantRequest  *protocol.CreateSandboxRequest
+		wantError    string
+		wantUsageErr bool
+	}{
+		{
+			"FlagsOnly", "", []string{"sb"}, []protocol.Mapping{flag},
+			&protocol.CreateSandboxRequest{ID: "sb", Mappings: []protocol.Mapping{flag}}, "", false,
+		},
+		{
+			"ManifestResolvesPrefixes", manifest, nil, []protocol.Mapping{flag},
+			&protocol.CreateSandboxRequest{ID: "from-manifest", Mappings: []protocol.Mapping{
+				{Path: "/prefix/a", UnderlyingPath: "/x"},
+				flag,
+			}}, "", false,
+		},
+		{
+			"ArgumentOverridesManifest", manifest, []string{"sb"}, nil,
+			&protocol.CreateSandboxRequest{ID: "sb", Mappings: []protocol.Mapping{
+				{Path: "/prefix/a", UnderlyingPath: "/x"},
+			}}, "", false,
+		},
+		{
+			"MissingIdentifier", "", nil, []protocol.Mapping{flag},
+			nil, "must specify the sandbox identifier", true,
+		},
+		{
+			"TooManyArguments", "", []string{"a", "b"}, nil,
+			nil, "too many arguments", true,
+		},
+		{
+			"UndefinedPrefix", "", []string{"sb"}, []protocol.Mapping{{Path: "a", PathPrefix: 5, UnderlyingPath: "/x"}},
+			nil, "invalid request", false,
+		},
+		{
+			"MissingManifest", filepath.Join(dir, "missing.json"), []string{"sb"}, nil,
+			nil, "failed to read manifest", false,
+		},
+	}
+	for _, d := range testData {
+		t.Run(d.name, func(t *testing.T) {
+			req, err := buildCreateRequest(d.manifest, d.args, d.mappings)
+			if d.wantError == "" {
+				if err != nil {
+					t.Fatalf("Got error %v; want success", err)
+				}
+				if !reflect.DeepEqual(req, d.wantRequest) {
+					t.Errorf("Got request %v; want %v", req, d.wantRequest)
+				}
+				return
+			}
+
+			if err == nil || !strings.Contains(err.Error(), d.wantError) {
+				t.Fatalf("Got error %v; want error containing %q", err, d.wantError)
+			}
+			if _, ok := err.(*usageError); ok != d.wantUsageErr {
+				t.Errorf("Got usage error %v; want %v", ok, d.wantUsageErr)
+			}
+		})
+	}
+}
+
+func TestReadRawRequests(t *testing.T) {
+	input := `{"C": {"i": "first", "m": [{"p": "a", "x": 1, "u": "/x"}], "q": {"1": "/prefix"}}}
+{"C": {"i": "second", "m": [{"p": "b", "x": 1, "u": "/y"}]}}
+{"C": {"i": "third", "m": [{"p": "c", "x": 2, "u": "/z"}]}}
+{"D": "first"}
+`
+	reqs, err := readRawRequests(strings.NewReader(input))
+	if err != nil {
+		t.Fatalf("Failed to read requests: %v", err)
+	}
+
+	wantIDs := []string{"first", "second", "third", "first"}
+	wantData := []string{
+		`{"C":{"i":"first","m":[{"p":"a","x":1,"u":"/x"}],"q":{"1":"/prefix"}}}`,
+		`{"C":{"i":"second","m":[{"p":"b","x":1,"u":"/y"}]}}`,
+		`{"C":{"i":"third","m":[{"p":"c","x":2,"u":"/z"}]}}`,
+		`{"D":"first"}`,
+	}
+	wantJournal := []*protocol.Request{
+		{CreateSandbox: &protocol.CreateSandboxRequest{ID: "first", Mappings: []protocol.Mapping{{Path: "/prefix/a", UnderlyingPath: "/x"}}}},
+		{CreateSandbox: &protocol.CreateSandboxRequest{ID: "second", Mappings: []protocol.Mapping{{Path: "/prefix/b", UnderlyingPath: "/y"}}}},
+		nil, // Prefix 2 is undefined, so sandboxfs will reject the request.
+		{DestroySandbox: strPtr("first")},
+	}
+	if len(reqs) != len(wantIDs) {
+		t.Fatalf("Got %d requests; want %d", len(reqs), len(wantIDs))
+	}
+	for i, req := range reqs {
+		if req.id != wantIDs[i] {
+			t.Errorf("Got identifier %s for request %d; want %s", req.id, i, wantIDs[i])
+		}
+		if string(req.data) != wantData[i] {
+			t.Errorf("Got data %s for request %d; want %s", req.data, i, wantData[i])
+		}
+		if !reflect.DeepEqual(req.journal, wantJournal[i]) {
+			t.Errorf("Got journal entry %v for request %d; want %v", req.journal, i, wantJournal[i])
+		}
+	}
+}
+
+func TestReadRawRequests_Malformed(t *testing.T) {
+	testData := []struct {
+		name      string
+		input     string
+		wantError string
+	}{
+		{"BadSyntax", "{\"D\": \"a\"}\n{\"D\": ", "malformed request 2"},
+		{"BadType", "{\"D\": 5}", "invalid request 1"},
+	}
+	for _, d := range testData {
+		t.Run(d.name, func(t *testing.T) {
+			_, err := readRawRequests(strings.NewReader(d.input))
+			if err == nil || !strings.Contains(err.Error(), d.wantError) {
+				t.Errorf("Got %v; want error containing %q", err, d.wantError)
+			}
+		})
+	}
+}
+
+// strPtr returns a pointer to a copy of s.
+func strPtr(s string) *string {
+	return &s
+}
+
+func TestSendAll(t *testing.T) {
+	dir, err := ioutil.TempDir("", "test")
+	if err != nil {
+		t.Fatalf("Failed to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	// Stand in for a "sandboxfsctl serve" daemon by serving each connection with the fake.
+	socket := filepath.Join(dir, "socket")
+	listener, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatalf("Failed to listen on %s: %v", socket, err)
+	}
+	defer listener.Close()
+	fake := protocol.NewFake("")
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				fake.Serve(conn, conn)
+			}()
+		}
+	}()
+
+	global := &globalFlags{socket: socket, timeout: timeout, journal: filepath.Join(dir, "journal")}
+	good := protocol.MakeCreateSandboxRequest("good", protocol.Mapping{Path: "/a", UnderlyingPath: dir})
+	bad := protocol.MakeCreateSandboxRequest("bad", protocol.Mapping{Path: "/a", UnderlyingPath: filepath.Join(dir, "missing")})
+	destroy := protocol.MakeDestroySandboxRequest("good")
+	var reqs []pendingRequest
+	for _, req := range []protocol.Request{good, bad, destroy} {
+		pending, err := newPendingRequest(req)
+		if err != nil {
+			t.Fatalf("Failed to encode request: %v", err)
+		}
+		reqs = append(reqs, pending)
+	}
+
+	results, err := sendAll(global, reqs)
+	if err != nil {
+		t.Fatalf("Failed to send requests: %v", err)
+	}
+	if len(results) != 3 {
+		t.Fatalf("Got %d results; want 3", len(results))
+	}
+	for i, wantError := range []bool{false, true, false} {
+		if (results[i].Error != "") != wantError {
+			t.Errorf("Got result %v for request %d; want error %v", results[i], i, wantError)
+		}
+	}
+	if err := countFailures(results); err == nil || err.(*requestsFailedError).failed != 1 {
+		t.Errorf("Got %v; want 1 failed request", err)
+	}
+
+	// Only the requests that sandboxfs applied make it to the journal.
+	entries, err := protocol.ReadJournal(global.journal)
+	if err != nil {
+		t.Fatalf("Failed to read journal: %v", err)
+	}
+	var journaled []string
+	for _, entry := range entries {
+		journaled = append(journaled, entry.Request.ID())
+	}
+	if want := []string{"good", "good"}; !reflect.DeepEqual(journaled, want) {
+		t.Errorf("Got journal for %v; want %v", journaled, want)
+	}
+	if entries[0].Request.CreateSandbox == nil || entries[1].Request.DestroySandbox == nil {
+		t.Errorf("Got journal %v; want the creation and destruction of the sandbox", entries)
+	}
+}
@@ -0,0 +1,31 @@
+// Copyright 2020 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not
+// use this file except in compliance with the License.  You may obtain a copy
+// of the License at:
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.  See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package main
+
+import (
+	"github.com/bazelbuild/sandboxfs/integration/protocol"
+)
+
+// connect opens a channel to sandboxfs as configured by the global flags.
+func connect(global *globalFlags) (*protocol.Conn, error) {
+	if global.socket != "" {
+		if global.input != "" || global.output != "" {
+			return nil, usageErrorf("--socket and --input/--output are mutually exclusive")
+		}
+	} else if global.input == "" || global.output == "" {
+		return nil, usageErrorf("must specify either --socket or both --input and --output")
+	}
+	return protocol.Connect(global.socket, global.input, global.output)
+}
@@ -0,0 +1,187 @@
+// Copyright 2020 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not
+// use this file except in compliance with the License.  You may obtain a copy
+// of the License at:
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.  See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/bazelbuild/sandboxfs/integration/protocol"
+)
+
+// sandboxesDifferError indicates that the compared sandboxes are not equivalent.  The differences
+// have already been printed to the user when this error is returned, so main exits with status 1
+// without printing anything else, like diff(1) does.
+type sandboxesDifferError struct{}
+
+// Error returns a summary of the comparison.
+func (e *sandboxesDifferError) Error() string {
+	return "sandboxes differ"
+}
+
+// diffFailedError indicates that the sandboxes could not be compared.  main reports these errors
+// with exit status 2 to distinguish them from differences, like diff(1) does.
+type diffFailedError struct {
+	err error
+}
+
+// Error returns the message of the wrapped error.
+func (e *diffFailedError) Error() string {
+	return e.err.Error()
+}
+
+// loadSandbox returns the prefix-free mappings of a sandbox, which is given either as the path to
+// a manifest or, if journal is not empty, as the identifier of a sandbox in that journal.  Journaled
+// sandboxes are found even if they were destroyed, as is usually the case once their actions
+// finish.  Also returns a label that describes where the sandbox came from.
+func loadSandbox(spec string, journal string) (string, []protocol.Mapping, error) {
+	if journal == "" {
+		create, err := buildCreateRequest(spec, nil, nil)
+		if err != nil {
+			return "", nil, err
+		}
+		return spec, create.Mappings, nil
+	}
+
+	entries, err := protocol.ReadJournal(journal)
+	if err != nil {
+		return "", nil, err
+	}
+	create, ok := protocol.ReplayJournalWithDestroyed(entries)[spec]
+	if !ok {
+		return "", nil, fmt.Errorf("sandbox %s not found in journal %s", spec, journal)
+	}
+	return fmt.Sprintf("%s:%s", journal, spec), create.Mappings, nil
+}
+
+// formatMapping returns the textual representation of a mapping in a diff.
+func formatMapping(m *protocol.Mapping) string {
+	kind := "ro"
+	if m.Writable {
+		kind = "rw"
+	}
+	return fmt.Sprintf("%s %s -> %s", kind, m.Path, m.UnderlyingPath)
+}
+
+// formatNode returns the textual representation of a node of the effective tree in a diff.
+func formatNode(path string, state *protocol.NodeState) string {
+	if state.UnderlyingPath == "" {
+		return fmt.Sprintf("%s %v", path, state.Kind)
+	}
+	return fmt.Sprintf("%s %v -> %s", path, state.Kind, state.UnderlyingPath)
+}
+
+// describeChange returns a summary of how a mapping changed.
+func describeChange(c *protocol.MappingChange) string {
+	var changes []string
+	switch {
+	case c.Added:
+		changes = append(changes, "added")
+	case c.Removed:
+		changes = append(changes, "removed")
+	}
+	if c.Retargeted {
+		changes = append(changes, "retargeted")
+	}
+	if c.ModeChanged {
+		if c.New.Writable {
+			changes = append(changes, "ro -> rw")
+		} else {
+			changes = append(changes, "rw -> ro")
+		}
+	}
+	return strings.Join(changes, ", ")
+}
+
+// printDiff writes a diff in a form that resembles a unified diff.  Each changed mapping gets its
+// own hunk, and the changes to the effective tree are grouped in a final hunk.
+func printDiff(w io.Writer, oldLabel string, newLabel string, diff *protocol.SandboxDiff) error {
+	fmt.Fprintf(w, "--- %s\n", oldLabel)
+	fmt.Fprintf(w, "+++ %s\n", newLabel)
+	for i := range diff.Mappings {
+		c := &diff.Mappings[i]
+		fmt.Fprintf(w, "@@ %s: %s @@\n", c.Path, describeChange(c))
+		if c.Old != nil {
+			fmt.Fprintf(w, "-%s\n", formatMapping(c.Old))
+		}
+		if c.New != nil {
+			fmt.Fprintf(w, "+%s\n", formatMapping(c.New))
+		}
+	}
+	if len(diff.Tree) > 0 {
+		fmt.Fprintf(w, "@@ effective tree @@\n")
+	}
+	for _, c := range diff.Tree {
+		if c.Old != nil {
+			fmt.Fprintf(w, "-%s\n", formatNode(c.Path, c.Old))
+		}
+		if c.New != nil {
+			fmt.Fprintf(w, "+%s\n", formatNode(c.Path, c.New))
+		}
+	}
+	return nil
+}
+
+// runDiff implements the "diff" command.
+func runDiff(global *globalFlags, args []string) error {
+	err := compareSandboxes(global, args)
+	switch err.(type) {
+	case nil, *usageError, *sandboxesDifferError:
+		return err
+	default:
+		return &diffFailedError{err: err}
+	}
+}
+
+// compareSandboxes implements runDiff without classifying the errors it returns.
+func compareSandboxes(global *globalFlags, args []string) error {
+	fs := newCommandFlags("diff")
+	oldJournal := fs.String("old_journal", global.journal, "journal in which to look up OLD as a sandbox identifier; OLD is a manifest if empty")
+	newJournal := fs.String("new_journal", global.journal, "journal in which to look up NEW as a sandbox identifier; NEW is a manifest if empty")
+	if err := fs.Parse(args); err != nil {
+		return usageErrorf("%v", err)
+	}
+	if fs.NArg() != 2 {
+		return usageErrorf("must specify the two sandboxes to compare")
+	}
+
+	oldLabel, oldMappings, err := loadSandbox(fs.Arg(0), *oldJournal)
+	if err != nil {
+		return err
+	}
+	newLabel, newMappings, err := loadSandbox(fs.Arg(1), *newJournal)
+	if err != nil {
+		return err
+	}
+	diff, err := protocol.DiffSandboxes(oldMappings, newMappings)
+	if err != nil {
+		return fmt.Errorf("invalid layout: %v", err)
+	}
+
+	if global.format == "json" {
+		err = printJSON(os.Stdout, diff)
+	} else {
+		err = printDiff(os.Stdout, oldLabel, newLabel, diff)
+	}
+	if err != nil {
+		return err
+	}
+	if !diff.Empty() {
+		return &sandboxesDifferError{}
+	}
+	return nil
+}
@@ -0,0 +1,190 @@
+// Copyright 2020 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not
+// use this file except in compliance with the License.  You may obtain a copy
+// of the License at:
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.  See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+// The sandboxfsctl binary operates a running sandboxfs instance.
+//
+// sandboxfsctl talks to sandboxfs through the reconfiguration protocol, either directly via the
+// FIFOs given to the --input and --output flags of sandboxfs or via the socket of a "sandboxfsctl
+// serve" daemon.
+//
+// Note that sandboxfs stops accepting reconfiguration requests once its input reaches EOF, which
+// happens as soon as the last writer closes the input FIFO.  As a result, talking to the FIFOs
+// directly is single-shot: the first sandboxfsctl invocation to exit freezes the mappings of the
+// file system.  To issue more than one command, start "sandboxfsctl serve" to keep the FIFOs open
+// and point all other invocations to its --socket.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"time"
+)
+
+// usageError represents an invalid invocation of the tool.
+type usageError struct {
+	message string
+}
+
+// Error returns the message of the usage error.
+func (e *usageError) Error() string {
+	return e.message
+}
+
+// usageErrorf formats a new usage error.
+func usageErrorf(format string, args ...interface{}) error {
+	return &usageError{message: fmt.Sprintf(format, args...)}
+}
+
+// requestsFailedError indicates that sandboxfs rejected at least one request.  The details have
+// already been printed to the user when this error is returned.
+type requestsFailedError struct {
+	failed int
+}
+
+// Error returns a summary of the failed requests.
+func (e *requestsFailedError) Error() string {
+	return fmt.Sprintf("%d request(s) failed", e.failed)
+}
+
+// globalFlags contains the values of the flags shared by all commands.
+type globalFlags struct {
+	input      string
+	output     string
+	socket     string
+	format     string
+	timeout    time.Duration
+	journal    string
+	mountPoint string
+}
+
+// command represents a subcommand of the tool.
+type command struct {
+	// synopsis describes the arguments of the command.
+	synopsis string
+
+	// description is a one-line summary of the command.
+	description string
+
+	// run executes the command with the given global flags and arguments.
+	run func(global *globalFlags, args []string) error
+}
+
+// commands contains all known subcommands, keyed by name.  Populated by init to break the
+// initialization cycle between the table and the commands that print their own usage.
+var commands map[string]command
+
+func init() {
+	commands = map[string]command{
+		"create": {
+			"[--manifest=FILE] [--mapping=TYPE:PATH:UNDERLYING_PATH ...] [ID]",
+			"creates a sandbox or extends an existing one",
+			runCreate,
+		},
+		"diff": {
+			"[--old_journal=FILE] [--new_journal=FILE] OLD NEW",
+			"compares the layouts of two sandboxes given as manifests or journaled identifiers",
+			runDiff,
+		},
+		"destroy": {
+			"ID ...",
+			"destroys one or more sandboxes",
+			runDestroy,
+		},
+		"list": {
+			"",
+			"lists the sandboxes present in the mount point",
+			runList,
+		},
+		"raw": {
+			"[FILE|-]",
+			"sends requests given as JSON verbatim",
+			runRaw,
+		},
+		"serve": {
+			"[--trace=FILE]",
+			"multiplexes the reconfiguration FIFOs on a socket",
+			runServe,
+		},
+		"show": {
+			"ID | --manifest=FILE",
+			"shows the resolved layout of a sandbox",
+			runShow,
+		},
+	}
+}
+
+// usage prints the help message of the tool to stderr.
+func usage(fs *flag.FlagSet) {
+	fmt.Fprintf(os.Stderr, "Usage: sandboxfsctl [flags] command [command flags] [args]\n\n")
+	fmt.Fprintf(os.Stderr, "Commands:\n")
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(os.Stderr, "  %s %s\n        %s\n", name, commands[name].synopsis, commands[name].description)
+	}
+	fmt.Fprintf(os.Stderr, "\nFlags:\n")
+	fs.PrintDefaults()
+}
+
+// run parses the command line and executes the requested command.
+func run(args []string) error {
+	var global globalFlags
+	fs := flag.NewFlagSet("sandboxfsctl", flag.ContinueOnError)
+	fs.StringVar(&global.input, "input", "", "path to the FIFO sandboxfs reads requests from")
+	fs.StringVar(&global.output, "output", "", "path to the FIFO sandboxfs writes responses to")
+	fs.StringVar(&global.socket, "socket", "", "path to the socket of a sandboxfsctl serve daemon")
+	fs.StringVar(&global.format, "format", "table", "output format: table or json")
+	fs.DurationVar(&global.timeout, "timeout", 10*time.Second, "maximum time to wait for each response")
+	fs.StringVar(&global.journal, "journal", "", "path to the journal of applied requests")
+	fs.StringVar(&global.mountPoint, "mount_point", "", "path to the mount point of sandboxfs")
+	fs.Usage = func() { usage(fs) }
+	if err := fs.Parse(args); err != nil {
+		return usageErrorf("%v", err)
+	}
+
+	if global.format != "table" && global.format != "json" {
+		return usageErrorf("invalid --format %s; must be table or json", global.format)
+	}
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return usageErrorf("no command specified")
+	}
+	name := fs.Arg(0)
+	cmd, ok := commands[name]
+	if !ok {
+		return usageErrorf("unknown command %s", name)
+	}
+	return cmd.run(&global, fs.Args()[1:])
+}
+
+func main() {
+	err := run(os.Args[1:])
+	switch err.(type) {
+	case nil:
+		os.Exit(0)
+	case *sandboxesDifferError:
+		os.Exit(1)
+	case *usageError, *diffFailedError:
+		fmt.Fprintf(os.Stderr, "sandboxfsctl: %v\n", err)
+		os.Exit(2)
+	default:
+		fmt.Fprintf(os.Stderr, "sandboxfsctl: %v\n", err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,81 @@
+// Copyright 2020 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not
+// use this file except in compliance with the License.  You may obtain a copy
+// of the License at:
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.  See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+)
+
+// printJSON writes value to w as indented JSON.
+func printJSON(w io.Writer, value interface{}) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetEscapeHTML(false) // Mappings are printed as "path -> underlying" in errors.
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(value); err != nil {
+		return fmt.Errorf("cannot encode output: %v", err)
+	}
+	return nil
+}
+
+// printTable writes rows to w as a table with aligned columns under the given header.
+func printTable(w io.Writer, header []string, rows [][]string) error {
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, strings.Join(header, "\t"))
+	for _, row := range rows {
+		fmt.Fprintln(tw, strings.Join(row, "\t"))
+	}
+	return tw.Flush()
+}
+
+// result represents the outcome of a single request as reported to the user.
+type result struct {
+	ID    string `json:"id"`
+	Error string `json:"error,omitempty"`
+}
+
+// printResults writes the outcome of a set of requests to w in the given format.
+func printResults(w io.Writer, format string, results []result) error {
+	if format == "json" {
+		return printJSON(w, results)
+	}
+
+	rows := make([][]string, 0, len(results))
+	for _, r := range results {
+		status := "ok"
+		if r.Error != "" {
+			status = "failed: " + r.Error
+		}
+		rows = append(rows, []string{r.ID, status})
+	}
+	return printTable(w, []string{"ID", "STATUS"}, rows)
+}
+
+// countFailures returns an error if any of the results represents a failed request.
+func countFailures(results []result) error {
+	failed := 0
+	for _, r := range results {
+		if r.Error != "" {
+			failed++
+		}
+	}
+	if failed > 0 {
+		return &requestsFailedError{failed: failed}
+	}
+	return nil
+}
@@ -0,0 +1,76 @@
+// Copyright 2020 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not
+// use this file except in compliance with the License.  You may obtain a copy
+// of the License at:
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.  See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package main
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestPrintResults(t *testing.T) {
+	results := []result{
+		{ID: "first"},
+		{ID: "second-sandbox", Error: "Cannot map '/a -> /b (read-only)': Already mapped"},
+	}
+
+	testData := []struct {
+		name   string
+		format string
+		want   string
+	}{
+		{
+			"Table",
+			"table",
+			"ID              STATUS\n" +
+				"first           ok\n" +
+				"second-sandbox  failed: Cannot map '/a -> /b (read-only)': Already mapped\n",
+		},
+		{
+			"JSON",
+			"json",
+			"[\n" +
+				"  {\n" +
+				"    \"id\": \"first\"\n" +
+				"  },\n" +
+				"  {\n" +
+				"    \"id\": \"second-sandbox\",\n" +
+				"    \"error\": \"Cannot map '/a -> /b (read-only)': Already mapped\"\n" +
+				"  }\n" +
+				"]\n",
+		},
+	}
+	for _, d := range testData {
+		t.Run(d.name, func(t *testing.T) {
+			var output bytes.Buffer
+			if err := printResults(&output, d.format, results); err != nil {
+				t.Fatalf("Failed to print results: %v", err)
+			}
+			if output.String() != d.want {
+				t.Errorf("Got %q; want %q", output.String(), d.want)
+			}
+		})
+	}
+}
+
+func TestCountFailures(t *testing.T) {
+	if err := countFailures([]result{{ID: "a"}, {ID: "b"}}); err != nil {
+		t.Errorf("Got %v; want no error", err)
+	}
+
+	err := countFailures([]result{{ID: "a", Error: "x"}, {ID: "b"}, {ID: "c", Error: "y"}})
+	if err == nil || err.Error() != "2 request(s) failed" {
+		t.Errorf("Got %v; want 2 failed requests", err)
+	}
+}
@@ -0,0 +1,297 @@
+// Copyright 2020 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not
+// use this file except in compliance with the License.  You may obtain a copy
+// of the License at:
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.  See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/bazelbuild/sandboxfs/integration/protocol"
+)
+
+// sandboxOwner tracks the use of a sandbox identifier by a session.
+type sandboxOwner struct {
+	// stream is the number of the session that uses the identifier.
+	stream int
+
+	// pending is the number of requests for the identifier that are waiting for a response.
+	pending int
+
+	// live is true if the sandbox may exist in sandboxfs.  This is set by any create request,
+	// even a failed one, because sandboxfs may leave a partially-configured sandbox behind.
+	live bool
+}
+
+// sandboxOwners tracks which session each sandbox identifier belongs to.
+//
+// sandboxfs has a single namespace of sandbox identifiers and the shared client matches responses
+// to requests by identifier.  Letting two sessions use the same identifier would allow them to
+// modify each other's sandboxes and to receive each other's responses, so identifiers are reserved
+// for the first session that uses them until the sandbox is destroyed.
+type sandboxOwners struct {
+	// mu protects all fields below.
+	mu sync.Mutex
+
+	// ids contains the owners of all identifiers in use, keyed by the identifier.
+	ids map[string]*sandboxOwner
+}
+
+// newSandboxOwners creates an empty set of owners.
+func newSandboxOwners() *sandboxOwners {
+	return &sandboxOwners{ids: make(map[string]*sandboxOwner)}
+}
+
+// acquire records that the session identified by stream is about to send a request for id.  Fails
+// if the identifier belongs to another session.  Must be paired with a call to release.
+func (o *sandboxOwners) acquire(id string, stream int) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	owner, ok := o.ids[id]
+	if !ok {
+		owner = &sandboxOwner{stream: stream}
+		o.ids[id] = owner
+	} else if owner.stream != stream {
+		return fmt.Errorf("sandbox %s belongs to another connection", id)
+	}
+	owner.pending++
+	return nil
+}
+
+// release records that the request req for id, previously passed to acquire, completed with err.
+// The identifier becomes available to other sessions once it has no pending requests and its
+// sandbox has been destroyed.
+func (o *sandboxOwners) release(id string, req protocol.Request, err error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	owner := o.ids[id]
+	owner.pending--
+	if req.CreateSandbox != nil {
+		owner.live = true
+	} else if err == nil {
+		owner.live = false
+	}
+	if owner.pending == 0 && !owner.live {
+		delete(o.ids, id)
+	}
+}
+
+// session holds the state of a single connection to the daemon.
+type session struct {
+	// conn is the connection to the daemon's client.
+	conn net.Conn
+
+	// client is the shared client to sandboxfs.
+	client *protocol.Client
+
+	// timeout is the maximum time to wait for each response from sandboxfs.
+	timeout time.Duration
+
+	// prefixes tracks the prefixes defined by this connection, which are private to it.
+	prefixes *protocol.Prefixes
+
+	// owners tracks the sandbox identifiers used by all connections, and is shared by them.
+	owners *sandboxOwners
+
+	// stream is the number of this connection, used to tell connections apart in the trace.
+	stream int
+
+	// trace records all requests and responses of the connection, if not nil.
+	trace *protocol.TraceWriter
+
+	// writeMu serializes writes to conn so that responses are not interleaved.
+	writeMu sync.Mutex
+}
+
+// respond sends a response to the session's client.  A nil id indicates a fatal error.
+func (s *session) respond(id *string, err error) {
+	resp := protocol.Response{ID: id}
+	if err != nil {
+		message := err.Error()
+		resp.Error = &message
+	}
+	data, err := json.Marshal(resp)
+	if err != nil {
+		panic(fmt.Sprintf("cannot encode response: %v", err))
+	}
+	if s.trace != nil {
+		if err := s.trace.RecordResponse(s.stream, resp); err != nil {
+			log.Print(err)
+		}
+	}
+
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+	if _, err := s.conn.Write(append(data, '\n')); err != nil {
+		log.Printf("failed to send response: %v", err)
+	}
+}
+
+// serve processes all requests received on the session's connection.  Requests are forwarded to
+// sandboxfs with their prefixes resolved, and requests for sandboxes that belong to other
+// connections are rejected, so that different connections cannot clash with each other.  As with
+// sandboxfs, a malformed request causes the connection to stop being processed.
+func (s *session) serve() {
+	defer s.conn.Close()
+
+	var wg sync.WaitGroup
+	defer wg.Wait()
+
+	decoder := json.NewDecoder(s.conn)
+	for {
+		var raw json.RawMessage
+		if err := decoder.Decode(&raw); err == io.EOF {
+			return
+		} else if err != nil {
+			s.respond(nil, err)
+			return
+		}
+		if s.trace != nil {
+			if err := s.trace.RecordRequest(s.stream, raw); err != nil {
+				log.Print(err)
+			}
+		}
+
+		var req protocol.Request
+		if err := json.Unmarshal(raw, &req); err != nil {
+			s.respond(nil, err)
+			return
+		}
+
+		id := req.ID()
+		if req.CreateSandbox != nil {
+			mappings, err := s.prefixes.Resolve(req.CreateSandbox)
+			if err != nil {
+				s.respond(&id, err)
+				continue
+			}
+			req = protocol.Request{CreateSandbox: &protocol.CreateSandboxRequest{ID: id, Mappings: mappings}}
+		}
+
+		if err := s.owners.acquire(id, s.stream); err != nil {
+			s.respond(&id, err)
+			continue
+		}
+		call, err := s.client.Start(req)
+		if err != nil {
+			s.owners.release(id, protocol.Request{}, err) // Not sent, so nothing changed.
+			s.respond(&id, err)
+			continue
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			resp, err := call.Wait(s.timeout)
+			if err == nil {
+				err = resp.Err()
+			}
+			s.owners.release(id, req, err)
+			s.respond(&id, err)
+		}()
+	}
+}
+
+// runServe implements the "serve" command.
+func runServe(global *globalFlags, args []string) error {
+	fs := newCommandFlags("serve")
+	tracePath := fs.String("trace", "", "path to a file in which to record all requests and responses")
+	if err := fs.Parse(args); err != nil {
+		return usageErrorf("%v", err)
+	}
+	if fs.NArg() != 0 {
+		return usageErrorf("too many arguments")
+	}
+	if global.input == "" || global.output == "" || global.socket == "" {
+		return usageErrorf("must specify --input, --output and --socket")
+	}
+
+	listener, err := net.Listen("unix", global.socket)
+	if err != nil {
+		return fmt.Errorf("failed to listen on %s: %v", global.socket, err)
+	}
+
+	input, output, err := protocol.OpenFIFOs(global.input, global.output)
+	if err != nil {
+		listener.Close()
+		return err
+	}
+	// Closing the input causes sandboxfs to freeze its mappings, so only do so on exit.
+	defer output.Close()
+	defer input.Close()
+	client := protocol.NewClient(input, output)
+
+	var trace *protocol.TraceWriter
+	if *tracePath != "" {
+		file, err := os.Create(*tracePath)
+		if err != nil {
+			listener.Close()
+			return fmt.Errorf("failed to create trace: %v", err)
+		}
+		defer file.Close()
+		trace = protocol.NewTraceWriter(file)
+	}
+
+	stopping := make(chan string, 2)
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(signals)
+	go func() {
+		sig := <-signals
+		stopping <- fmt.Sprintf("caught %v", sig)
+		listener.Close()
+	}()
+	go func() {
+		err := client.Wait()
+		stopping <- err.Error()
+		listener.Close()
+	}()
+
+	owners := newSandboxOwners()
+	log.Printf("serving requests on %s", global.socket)
+	for stream := 1; ; stream++ {
+		conn, err := listener.Accept()
+		if err != nil {
+			select {
+			case reason := <-stopping:
+				log.Printf("shutting down: %s", reason)
+				if clientErr := client.Err(); clientErr != nil {
+					return clientErr
+				}
+				return nil
+			default:
+				return fmt.Errorf("failed to accept connection: %v", err)
+			}
+		}
+		s := &session{
+			conn:     conn,
+			client:   client,
+			timeout:  global.timeout,
+			prefixes: protocol.NewPrefixes(),
+			owners:   owners,
+			stream:   stream,
+			trace:    trace,
+		}
+		go s.serve()
+	}
+}
@@ -0,0 +1,312 @@
+// Copyright 2020 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not
+// use this file except in compliance with the License.  You may obtain a copy
+// of the License at:
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.  See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bazelbuild/sandboxfs/integration/protocol"
+)
+
+// timeout is the maximum time to wait for responses in the tests.
+const timeout = 10 * time.Second
+
+// daemonState holds the components of a daemon whose shared client talks to a fake sandboxfs.
+type daemonState struct {
+	fake   *protocol.Fake
+	client *protocol.Client
+	owners *sandboxOwners
+	root   string
+	input  io.Closer
+
+	// streams is the number of sessions created so far.
+	streams int
+}
+
+// startDaemon starts a fake sandboxfs in the background and returns the state needed to attach
+// sessions to it.  The root of the fake contains a "dir" directory to use as an underlying path.
+func startDaemon(t *testing.T) *daemonState {
+	t.Helper()
+
+	root, err := ioutil.TempDir("", "test")
+	if err != nil {
+		t.Fatalf("Failed to create temporary directory: %v", err)
+	}
+	if err := os.Mkdir(filepath.Join(root, "dir"), 0755); err != nil {
+		t.Fatalf("Failed to create test directory: %v", err)
+	}
+
+	fake := protocol.NewFake("")
+	inputReader, inputWriter := io.Pipe()
+	outputReader, outputWriter := io.Pipe()
+	go func() {
+		fake.Serve(inputReader, outputWriter)
+		outputWriter.Close()
+	}()
+	return &daemonState{
+		fake:   fake,
+		client: protocol.NewClient(inputWriter, outputReader),
+		owners: newSandboxOwners(),
+		root:   root,
+		input:  inputWriter,
+	}
+}
+
+// tearDown stops the fake sandboxfs, checks that all of its responses reached a request, and cleans
+// up after the daemon.
+func (d *daemonState) tearDown(t *testing.T) {
+	t.Helper()
+
+	d.input.Close()
+	d.client.Wait()
+	if d.client.Stray() != 0 {
+		t.Errorf("Got %d stray responses; want 0", d.client.Stray())
+	}
+	os.RemoveAll(d.root)
+}
+
+// connect attaches a new session to the daemon and returns the client end of its connection.
+func (d *daemonState) connect() net.Conn {
+	d.streams++
+	server, client := net.Pipe()
+	s := &session{
+		conn:     server,
+		client:   d.client,
+		timeout:  timeout,
+		prefixes: protocol.NewPrefixes(),
+		owners:   d.owners,
+		stream:   d.streams,
+	}
+	go s.serve()
+	return client
+}
+
+// mustDo sends a request through a session and returns the error reported by the daemon, if any.
+func mustDo(t *testing.T, client *protocol.Client, req protocol.Request) error {
+	t.Helper()
+
+	resp, err := client.Do(req, timeout)
+	if err != nil {
+		t.Fatalf("Request for %s did not complete: %v", req.ID(), err)
+	}
+	return resp.Err()
+}
+
+func TestSession_ForwardsRequests(t *testing.T) {
+	daemon := startDaemon(t)
+	defer daemon.tearDown(t)
+	conn := daemon.connect()
+	defer conn.Close()
+	client := protocol.NewClient(conn, conn)
+
+	dir := filepath.Join(daemon.root, "dir")
+	for _, req := range []protocol.Request{
+		protocol.MakeCreateSandboxRequest("first", protocol.Mapping{Path: "/a", UnderlyingPath: dir}),
+		protocol.MakeCreateSandboxRequest("second", protocol.Mapping{Path: "/b", UnderlyingPath: dir}),
+		protocol.MakeDestroySandboxRequest("first"),
+	} {
+		if err := mustDo(t, client, req); err != nil {
+			t.Errorf("Request for %s failed: %v", req.ID(), err)
+		}
+	}
+	if got, want := daemon.fake.Sandboxes(), []string{"second"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Got sandboxes %v; want %v", got, want)
+	}
+
+	err := mustDo(t, client, protocol.MakeCreateSandboxRequest("third", protocol.Mapping{Path: "/a", UnderlyingPath: "/non-existent"}))
+	if err == nil || !strings.Contains(err.Error(), "Stat failed") {
+		t.Errorf("Got %v; want error from the fake", err)
+	}
+}
+
+func TestSession_PrefixesArePrivate(t *testing.T) {
+	daemon := startDaemon(t)
+	defer daemon.tearDown(t)
+	conn1 := daemon.connect()
+	defer conn1.Close()
+	client1 := protocol.NewClient(conn1, conn1)
+	conn2 := daemon.connect()
+	defer conn2.Close()
+	client2 := protocol.NewClient(conn2, conn2)
+
+	create := func(id string, prefix string) protocol.Request {
+		return protocol.Request{CreateSandbox: &protocol.CreateSandboxRequest{
+			ID:       id,
+			Mappings: []protocol.Mapping{{Path: "x", PathPrefix: 1, UnderlyingPath: daemon.root + "/dir"}},
+			Prefixes: map[string]string{"1": prefix},
+		}}
+	}
+	if err := mustDo(t, client1, create("first", "/one")); err != nil {
+		t.Fatalf("Failed to create sandbox: %v", err)
+	}
+	if err := mustDo(t, client2, create("second", "/two")); err != nil {
+		t.Fatalf("Failed to create sandbox: %v", err)
+	}
+	for _, path := range []string{"first/one/x", "second/two/x"} {
+		if err := daemon.fake.Stat(path); err != nil {
+			t.Errorf("Mapping %s not present: %v", path, err)
+		}
+	}
+
+	// The prefix defined by the first connection must not be visible to the second.
+	reuse := protocol.Request{CreateSandbox: &protocol.CreateSandboxRequest{
+		ID:       "third",
+		Mappings: []protocol.Mapping{{Path: "y", PathPrefix: 1, UnderlyingPath: daemon.root + "/dir"}},
+	}}
+	if err := mustDo(t, client1, reuse); err != nil {
+		t.Errorf("Failed to reuse prefix in the same connection: %v", err)
+	}
+	reuse.CreateSandbox.ID = "fourth"
+	reuse.CreateSandbox.Prefixes = nil
+	conn3 := daemon.connect()
+	defer conn3.Close()
+	client3 := protocol.NewClient(conn3, conn3)
+	if err := mustDo(t, client3, reuse); err == nil || !strings.Contains(err.Error(), "1") {
+		t.Errorf("Got %v; want error about undefined prefix 1", err)
+	}
+}
+
+func TestSession_SandboxesBelongToOneConnection(t *testing.T) {
+	daemon := startDaemon(t)
+	defer daemon.tearDown(t)
+	conn1 := daemon.connect()
+	defer conn1.Close()
+	client1 := protocol.NewClient(conn1, conn1)
+	conn2 := daemon.connect()
+	defer conn2.Close()
+	client2 := protocol.NewClient(conn2, conn2)
+
+	dir := filepath.Join(daemon.root, "dir")
+	a := protocol.Mapping{Path: "/a", UnderlyingPath: dir}
+	b := protocol.Mapping{Path: "/b", UnderlyingPath: dir}
+	if err := mustDo(t, client1, protocol.MakeCreateSandboxRequest("sb", a)); err != nil {
+		t.Fatalf("Failed to create sandbox: %v", err)
+	}
+
+	for i, req := range []protocol.Request{
+		protocol.MakeCreateSandboxRequest("sb", b),
+		protocol.MakeDestroySandboxRequest("sb"),
+	} {
+		if err := mustDo(t, client2, req); err == nil || !strings.Contains(err.Error(), "another connection") {
+			t.Errorf("Got %v for request %d; want error about another connection", err, i)
+		}
+	}
+	if err := daemon.fake.Stat("sb/a"); err != nil {
+		t.Errorf("Sandbox modified by another connection: %v", err)
+	}
+
+	if err := mustDo(t, client1, protocol.MakeCreateSandboxRequest("sb", b)); err != nil {
+		t.Errorf("Failed to extend own sandbox: %v", err)
+	}
+	if err := mustDo(t, client1, protocol.MakeDestroySandboxRequest("sb")); err != nil {
+		t.Fatalf("Failed to destroy own sandbox: %v", err)
+	}
+
+	// Once destroyed, the identifier is free for any connection to take.
+	if err := mustDo(t, client2, protocol.MakeCreateSandboxRequest("sb", b)); err != nil {
+		t.Errorf("Failed to reuse identifier of destroyed sandbox: %v", err)
+	}
+	if err := mustDo(t, client1, protocol.MakeCreateSandboxRequest("sb", a)); err == nil {
+		t.Errorf("Reused identifier of a sandbox owned by another connection")
+	}
+}
+
+func TestSession_ConcurrentConnections(t *testing.T) {
+	daemon := startDaemon(t)
+	defer daemon.tearDown(t)
+
+	const connections = 4
+	const sandboxes = 20
+	dir := filepath.Join(daemon.root, "dir")
+
+	var wg sync.WaitGroup
+	errs := make(chan error, connections*sandboxes)
+	for i := 0; i < connections; i++ {
+		conn := daemon.connect()
+		defer conn.Close()
+		client := protocol.NewClient(conn, conn)
+
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+
+			// Issue all requests before waiting for any of them so that they are all in
+			// flight at once within the shared client.
+			var calls []*protocol.Call
+			for j := 0; j < sandboxes; j++ {
+				id := fmt.Sprintf("sb%d-%d", i, j)
+				call, err := client.Start(protocol.MakeCreateSandboxRequest(id, protocol.Mapping{Path: "/a", UnderlyingPath: dir}))
+				if err != nil {
+					errs <- err
+					return
+				}
+				calls = append(calls, call)
+			}
+			for _, call := range calls {
+				resp, err := call.Wait(timeout)
+				if err == nil {
+					err = resp.Err()
+				}
+				if err != nil {
+					errs <- err
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Errorf("Request failed: %v", err)
+	}
+
+	if got := len(daemon.fake.Sandboxes()); got != connections*sandboxes {
+		t.Errorf("Got %d sandboxes; want %d", got, connections*sandboxes)
+	}
+	if got := len(daemon.owners.ids); got != connections*sandboxes {
+		t.Errorf("Got %d owned identifiers; want %d", got, connections*sandboxes)
+	}
+}
+
+func TestSession_MalformedRequestClosesConnection(t *testing.T) {
+	daemon := startDaemon(t)
+	defer daemon.tearDown(t)
+	conn := daemon.connect()
+	defer conn.Close()
+
+	go io.WriteString(conn, "this is not JSON\n")
+	data, err := ioutil.ReadAll(conn)
+	if err != nil {
+		t.Fatalf("Failed to read response: %v", err)
+	}
+
+	var resp protocol.Response
+	if err := json.Unmarshal(data, &resp); err != nil {
+		t.Fatalf("Got %q; want a single response: %v", data, err)
+	}
+	if resp.ID != nil || resp.Err() == nil {
+		t.Errorf("Got %q; want a fatal error without an identifier", data)
+	}
+}
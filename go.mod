@@ -1,6 +1,6 @@
 module github.com/bazelbuild/sandboxfs
 
-go 1.12
+go 1.14
 
 require (
 	golang.org/x/lint v0.0.0-20200302205851-738671d3881b
@@ -16,6 +16,7 @@ package integration
 
 import (
 	"fmt"
+	"path/filepath"
 	"runtime"
 	"testing"
 
@@ -27,187 +28,95 @@ var (
 	versionPattern = `sandboxfs [0-9]+\.[0-9]+`
 )
 
-func TestCli_Help(t *testing.T) {
-	wantStdout := fmt.Sprintf(`Usage: sandboxfs [options] MOUNT_POINT
+// programNameSubstitutions returns the golden file substitutions that replace the name of the
+// program in the messages of sandboxfs with PROGNAME.  sandboxfs derives its name from the basename
+// of argv[0], so the name depends on the binary under test.
+func programNameSubstitutions() []string {
+	name := filepath.Base(utils.GetConfig().SandboxfsBinary)
+	return []string{
+		"Usage: " + name + " ", "Usage: PROGNAME ",
+		"Type " + name + " --help", "Type PROGNAME --help",
+	}
+}
 
-Options:
-    --allow other|root|self
-                        specifies who should have access to the file system
-                        (default: self)
-    --cpu_profile PATH  enables CPU profiling and writes a profile to the
-                        given path
-    --help              prints usage information and exits
-    --input PATH        where to read reconfiguration data from (- for stdin)
-    --mapping TYPE:PATH:UNDERLYING_PATH
-                        type and locations of a mapping
-    --node_cache        enables the path-based node cache (known broken)
-    --output PATH       where to write the reconfiguration status to (- for
-                        stdout)
-    --reconfig_threads COUNT
-                        number of reconfiguration threads (default: %d)
-    --ttl TIMEs         how long the kernel is allowed to keep file metadata
-                        (default: 60s)
-    --version           prints version information and exits
-    --xattrs            enables support for extended attributes
-`, runtime.NumCPU())
+func TestCli_Help(t *testing.T) {
+	t.Parallel()
 
-	stdout, stderr, err := utils.RunAndWait(0, "--help")
-	if err != nil {
-		t.Fatal(err)
-	}
-	if wantStdout != stdout {
-		t.Errorf("Got %s; want stdout to match %s", stdout, wantStdout)
-	}
-	if len(stderr) > 0 {
-		t.Errorf("Got %s; want stderr to be empty", stderr)
-	}
+	ncpus := fmt.Sprintf("(default: %d)", runtime.NumCPU())
+	substitutions := append(programNameSubstitutions(), ncpus, "(default: NCPUS)")
+	utils.RunAndExpect(t, utils.Expect{Stdout: utils.Golden("cli/help.stdout.golden", substitutions...)}, "--help")
 }
+
 func TestCli_Version(t *testing.T) {
-	stdout, stderr, err := utils.RunAndWait(0, "--version")
-	if err != nil {
-		t.Fatal(err)
-	}
-	if !utils.MatchesRegexp(versionPattern, stdout) {
-		t.Errorf("Got %s; want stdout to match %s", stdout, versionPattern)
-	}
-	if len(stderr) > 0 {
-		t.Errorf("Got %s; want stderr to be empty", stderr)
-	}
+	t.Parallel()
+
+	utils.RunAndExpect(t, utils.Expect{Stdout: utils.Regexp(versionPattern)}, "--version")
 }
 
 func TestCli_ExclusiveFlagsPriority(t *testing.T) {
+	t.Parallel()
+
 	testData := []struct {
 		name string
 
-		args           []string
-		wantExitStatus int
-		wantStdout     string
-		wantStderr     string
+		args   []string
+		expect utils.Expect
 	}{
 		{
 			"BogusFlagsWinOverEverything",
 			[]string{"--version", "--help", "--foo"},
-			2,
-			"",
-			"Unrecognized option.*'foo'",
+			utils.Expect{Exit: 2, Stderr: utils.Regexp("Unrecognized option.*'foo'")},
 		},
 		{
 			"BogusHFlagWinsOverEverything",
 			[]string{"--version", "--help", "-h"},
-			2,
-			"",
-			"Unrecognized option.*'h'",
+			utils.Expect{Exit: 2, Stderr: utils.Regexp("Unrecognized option.*'h'")},
 		},
 		{
 			"HelpWinsOverValidArgs",
 			[]string{"--version", "--allow=self", "--help", "/mnt"},
-			0,
-			"Usage:",
-			"",
+			utils.Expect{Stdout: utils.Regexp("Usage:")},
 		},
 		{
 			"VersionWinsOverValidArgsButHelp",
 			[]string{"--allow=other", "--version", "/mnt"},
-			0,
-			versionPattern,
-			"",
+			utils.Expect{Stdout: utils.Regexp(versionPattern)},
 		},
 	}
 	for _, d := range testData {
 		t.Run(d.name, func(t *testing.T) {
-			stdout, stderr, err := utils.RunAndWait(d.wantExitStatus, d.args...)
-			if err != nil {
-				t.Fatal(err)
-			}
-			if len(d.wantStdout) == 0 && len(stdout) > 0 {
-				t.Errorf("Got %s; want stdout to be empty", stdout)
-			} else if len(d.wantStdout) > 0 && !utils.MatchesRegexp(d.wantStdout, stdout) {
-				t.Errorf("Got %s; want stdout to match %s", stdout, d.wantStdout)
-			}
-			if len(d.wantStderr) == 0 && len(stderr) > 0 {
-				t.Errorf("Got %s; want stderr to be empty", stderr)
-			} else if len(d.wantStderr) > 0 && !utils.MatchesRegexp(d.wantStderr, stderr) {
-				t.Errorf("Got %s; want stderr to match %s", stderr, d.wantStderr)
-			}
+			utils.RunAndExpect(t, d.expect, d.args...)
 		})
 	}
 }
 
 func TestCli_Syntax(t *testing.T) {
+	t.Parallel()
+
+	// The golden files for each test case live in testdata/cli/syntax/ and are named after the
+	// test case.  They contain the full error message, which must mention --help.
 	testData := []struct {
 		name string
 
-		args       []string
-		wantStderr string
+		args []string
 	}{
-		{
-			"InvalidFlag",
-			[]string{"--foo"},
-			"Unrecognized option.*'foo'",
-		},
-		{
-			"InvalidHFlag",
-			[]string{"-h"},
-			"Unrecognized option.*'h'",
-		},
-		{
-			"NoArguments",
-			[]string{},
-			"invalid number of arguments",
-		},
-		{
-			"TooManyArguments",
-			[]string{"mount-point", "extra"},
-			"invalid number of arguments",
-		},
-		{
-			"InvalidFlagWinsOverHelp",
-			[]string{"--invalid_flag", "--help"},
-			"Unrecognized option.*'invalid_flag'",
-		},
+		{"InvalidFlag", []string{"--foo"}},
+		{"InvalidHFlag", []string{"-h"}},
+		{"NoArguments", []string{}},
+		{"TooManyArguments", []string{"mount-point", "extra"}},
+		{"InvalidFlagWinsOverHelp", []string{"--invalid_flag", "--help"}},
 		// TODO(jmmv): For consistency with all previous tests, an invalid number of
 		// arguments should win over --help, but it currently does not.
-		// {
-		// 	"InvalidArgumentsWinOverHelp",
-		// 	[]string{"--help", "foo"},
-		// 	"invalid number of arguments",
-		// },
-		{
-			"MappingMissingTarget",
-			[]string{"--mapping=ro:/foo"},
-			`bad mapping ro:/foo: expected three colon-separated fields`,
-		},
-		{
-			"MappingRelativeTarget",
-			[]string{"--mapping=rw:/:relative/path"},
-			`bad mapping rw:/:relative/path: path "relative/path" is not absolute`,
-		},
-		{
-			"MappingBadType",
-			[]string{"--mapping=row:/foo:/bar"},
-			`bad mapping row:/foo:/bar: type was row but should be ro or rw`,
-		},
-		{
-			"ReconfigThreadsBadValue",
-			[]string{"--reconfig_threads=-1"},
-			`invalid thread count -1: .*invalid digit`,
-		},
+		// {"InvalidArgumentsWinOverHelp", []string{"--help", "foo"}},
+		{"MappingMissingTarget", []string{"--mapping=ro:/foo"}},
+		{"MappingRelativeTarget", []string{"--mapping=rw:/:relative/path"}},
+		{"MappingBadType", []string{"--mapping=row:/foo:/bar"}},
+		{"ReconfigThreadsBadValue", []string{"--reconfig_threads=-1"}},
 	}
 	for _, d := range testData {
 		t.Run(d.name, func(t *testing.T) {
-			stdout, stderr, err := utils.RunAndWait(2, d.args...)
-			if err != nil {
-				t.Fatal(err)
-			}
-			if len(stdout) > 0 {
-				t.Errorf("Got %s; want stdout to be empty", stdout)
-			}
-			if !utils.MatchesRegexp(d.wantStderr, stderr) {
-				t.Errorf("Got %s; want stderr to match %s", stderr, d.wantStderr)
-			}
-			if !utils.MatchesRegexp("--help", stderr) {
-				t.Errorf("Got %s; want --help mention in stderr", stderr)
-			}
+			golden := fmt.Sprintf("cli/syntax/%s.stderr.golden", d.name)
+			utils.RunAndExpect(t, utils.Expect{Exit: 2, Stderr: utils.Golden(golden, programNameSubstitutions()...)}, d.args...)
 		})
 	}
 }
@@ -0,0 +1,94 @@
+// Copyright 2020 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not
+// use this file except in compliance with the License.  You may obtain a copy
+// of the License at:
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.  See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package cpuprof
+
+import (
+	"strconv"
+	"strings"
+)
+
+// rustEscapes maps the escape sequences used by Rust's legacy symbol mangling to their values.
+var rustEscapes = strings.NewReplacer(
+	"$SP$", "@",
+	"$BP$", "*",
+	"$RF$", "&",
+	"$LT$", "<",
+	"$GT$", ">",
+	"$LP$", "(",
+	"$RP$", ")",
+	"$C$", ",",
+	"$u20$", " ",
+	"$u21$", "!",
+	"$u22$", "\"",
+	"$u27$", "'",
+	"$u2b$", "+",
+	"$u3b$", ";",
+	"$u5b$", "[",
+	"$u5d$", "]",
+	"$u7b$", "{",
+	"$u7d$", "}",
+	"$u7e$", "~",
+	"..", "::",
+)
+
+// isRustHash returns true if a name component is the hash that Rust appends to mangled names.
+func isRustHash(component string) bool {
+	if len(component) != 17 || component[0] != 'h' {
+		return false
+	}
+	_, err := strconv.ParseUint(component[1:], 16, 64)
+	return err == nil
+}
+
+// demangle decodes symbols that use the Itanium nested name encoding, which covers Rust's legacy
+// mangling and simple C++ names.  Function parameters, template arguments and any other constructs
+// are dropped, and unknown symbols are returned verbatim.
+func demangle(symbol string) string {
+	rest := strings.TrimPrefix(symbol, "_ZN")
+	if rest == symbol {
+		return symbol
+	}
+
+	var components []string
+	for len(rest) > 0 && rest[0] != 'E' {
+		digits := 0
+		for digits < len(rest) && rest[digits] >= '0' && rest[digits] <= '9' {
+			digits++
+		}
+		if digits == 0 {
+			return symbol
+		}
+		length, err := strconv.Atoi(rest[:digits])
+		if err != nil || digits+length > len(rest) {
+			return symbol
+		}
+		components = append(components, rest[digits:digits+length])
+		rest = rest[digits+length:]
+	}
+	if len(rest) == 0 || len(components) == 0 {
+		return symbol
+	}
+
+	if isRustHash(components[len(components)-1]) {
+		components = components[:len(components)-1]
+		for i, component := range components {
+			if strings.HasPrefix(component, "_$") {
+				component = component[1:] // Added by rustc when the component starts with an escape.
+			}
+			components[i] = rustEscapes.Replace(component)
+		}
+	}
+	return strings.Join(components, "::")
+}
@@ -0,0 +1,45 @@
+// Copyright 2020 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not
+// use this file except in compliance with the License.  You may obtain a copy
+// of the License at:
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.  See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package cpuprof
+
+import (
+	"testing"
+)
+
+func TestDemangle(t *testing.T) {
+	testData := []struct {
+		name string
+
+		symbol string
+		want   string
+	}{
+		{"NotMangled", "main", "main"},
+		{"C", "__libc_start_main", "__libc_start_main"},
+		{"Cpp", "_ZN9sandboxfs4Node6lookupEv", "sandboxfs::Node::lookup"},
+		{"Rust", "_ZN9sandboxfs5nodes3dir3Dir6lookup17h0123456789abcdefE", "sandboxfs::nodes::dir::Dir::lookup"},
+		{"RustEscapes", "_ZN51_$LT$sandboxfs..Foo$u20$as$u20$core..fmt..Debug$GT$3fmt17hfedcba9876543210E", "<sandboxfs::Foo as core::fmt::Debug>::fmt"},
+		{"RustClosure", "_ZN3std2rt10lang_start28_$u7b$$u7b$closure$u7d$$u7d$17h0000000000000000E", "std::rt::lang_start::{{closure}}"},
+		{"Truncated", "_ZN3foo", "_ZN3foo"},
+		{"BadLength", "_ZN30fooE", "_ZN30fooE"},
+		{"Empty", "_ZNE", "_ZNE"},
+	}
+	for _, d := range testData {
+		t.Run(d.name, func(t *testing.T) {
+			if got := demangle(d.symbol); got != d.want {
+				t.Errorf("Got %s; want %s", got, d.want)
+			}
+		})
+	}
+}
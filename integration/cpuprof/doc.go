@@ -0,0 +1,21 @@
+// Copyright 2020 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not
+// use this file except in compliance with the License.  You may obtain a copy
+// of the License at:
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.  See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+// Package cpuprof provides support code to analyze the CPU profiles written by sandboxfs.
+//
+// sandboxfs writes profiles via gperftools when given --cpu_profile, which requires a build with
+// the "profiling" feature.  These profiles are in the legacy binary format described in
+// gperftools' cpuprofile-fileformat documentation and only contain raw program counters, so they
+// must be symbolized with the binary that produced them.
+package cpuprof
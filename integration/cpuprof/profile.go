@@ -0,0 +1,201 @@
+// Copyright 2020 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not
+// use this file except in compliance with the License.  You may obtain a copy
+// of the License at:
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.  See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package cpuprof
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Sample represents a unique stack trace and the number of times it was observed.
+type Sample struct {
+	// Count is the number of times the stack trace was observed.
+	Count uint64
+
+	// PCs contains the program counters of the stack trace, starting with the leaf frame.  All
+	// but the first are return addresses.
+	PCs []uint64
+}
+
+// Mapping represents a memory region of the profiled process, as recorded at the end of the
+// profile from /proc/self/maps.
+type Mapping struct {
+	// Start and End delimit the memory region.
+	Start uint64
+	End   uint64
+
+	// Offset is the offset of the memory region within the mapped file.
+	Offset uint64
+
+	// Executable is true if the region is mapped with execute permissions.
+	Executable bool
+
+	// Path is the path of the mapped file, or empty for anonymous mappings.
+	Path string
+}
+
+// Profile represents a parsed CPU profile.
+type Profile struct {
+	// Period is the interval between samples.
+	Period time.Duration
+
+	// Samples contains all stack traces in the profile.
+	Samples []Sample
+
+	// Mappings contains the memory regions of the profiled process.
+	Mappings []Mapping
+}
+
+// TotalSamples returns the total number of samples in the profile.
+func (p *Profile) TotalSamples() uint64 {
+	var total uint64
+	for _, s := range p.Samples {
+		total += s.Count
+	}
+	return total
+}
+
+// findMapping returns the mapping that contains the given address, or nil if none does.
+func (p *Profile) findMapping(addr uint64) *Mapping {
+	for i, m := range p.Mappings {
+		if addr >= m.Start && addr < m.End {
+			return &p.Mappings[i]
+		}
+	}
+	return nil
+}
+
+// detectFormat determines the word size and the byte order of a profile by looking for the
+// header's initial words, which are always 0 and 3.
+func detectFormat(data []byte) (int, binary.ByteOrder, error) {
+	for _, size := range []int{8, 4} {
+		for _, order := range []binary.ByteOrder{binary.LittleEndian, binary.BigEndian} {
+			if len(data) < 2*size {
+				continue
+			}
+			if readWord(data, 0, size, order) == 0 && readWord(data, size, size, order) == 3 {
+				return size, order, nil
+			}
+		}
+	}
+	return 0, nil, fmt.Errorf("not a gperftools CPU profile")
+}
+
+// readWord reads the word of the given size at offset.
+func readWord(data []byte, offset int, size int, order binary.ByteOrder) uint64 {
+	if size == 8 {
+		return order.Uint64(data[offset:])
+	}
+	return uint64(order.Uint32(data[offset:]))
+}
+
+// parseMappings parses the /proc/self/maps text that trails the binary profile.  Lines that do not
+// look like memory mappings are ignored, as gperftools may append other information.
+func parseMappings(text []byte) []Mapping {
+	var mappings []Mapping
+	scanner := bufio.NewScanner(bytes.NewReader(text))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 5 {
+			continue
+		}
+		bounds := strings.SplitN(fields[0], "-", 2)
+		if len(bounds) != 2 {
+			continue
+		}
+		start, err1 := strconv.ParseUint(bounds[0], 16, 64)
+		end, err2 := strconv.ParseUint(bounds[1], 16, 64)
+		offset, err3 := strconv.ParseUint(fields[2], 16, 64)
+		if err1 != nil || err2 != nil || err3 != nil {
+			continue
+		}
+		m := Mapping{Start: start, End: end, Offset: offset, Executable: strings.Contains(fields[1], "x")}
+		if len(fields) >= 6 {
+			m.Path = strings.Join(fields[5:], " ")
+		}
+		mappings = append(mappings, m)
+	}
+	return mappings
+}
+
+// Parse parses a CPU profile in the legacy gperftools format.
+func Parse(r io.Reader) (*Profile, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	size, order, err := detectFormat(data)
+	if err != nil {
+		return nil, err
+	}
+	words := len(data) / size
+	word := func(i int) uint64 { return readWord(data, i*size, size, order) }
+
+	if words < 5 {
+		return nil, fmt.Errorf("truncated profile header")
+	}
+	// Lengths come straight from the file, so check them against its size before converting them
+	// to int: a corrupt profile could otherwise make them negative or make us allocate too much.
+	// detectFormat already requires a header length of 3, but do not rely on that here.
+	if word(1) > uint64(words) {
+		return nil, fmt.Errorf("header length %d exceeds profile size", word(1))
+	}
+	headerWords := int(word(1))
+	if version := word(2); version != 0 {
+		return nil, fmt.Errorf("unsupported profile version %d", version)
+	}
+	p := &Profile{Period: time.Duration(word(3)) * time.Microsecond}
+
+	for i := 2 + headerWords; ; {
+		if i+2 > words {
+			return nil, fmt.Errorf("profile lacks a trailer")
+		}
+		count, length := word(i), word(i+1)
+		if length > uint64(words-i-2) {
+			return nil, fmt.Errorf("truncated sample at word %d", i)
+		}
+		n := int(length)
+		if count == 0 && n == 1 && word(i+2) == 0 {
+			p.Mappings = parseMappings(data[(i+3)*size:])
+			return p, nil
+		}
+		sample := Sample{Count: count, PCs: make([]uint64, n)}
+		for j := 0; j < n; j++ {
+			sample.PCs[j] = word(i + 2 + j)
+		}
+		p.Samples = append(p.Samples, sample)
+		i += 2 + n
+	}
+}
+
+// ParseFile parses the CPU profile stored in the given file.
+func ParseFile(path string) (*Profile, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	p, err := Parse(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("invalid profile %s: %v", path, err)
+	}
+	return p, nil
+}
@@ -0,0 +1,120 @@
+// Copyright 2020 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not
+// use this file except in compliance with the License.  You may obtain a copy
+// of the License at:
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.  See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package cpuprof
+
+import (
+	"bytes"
+	"encoding/binary"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// encodeProfile generates a profile in the legacy format with the given words and trailing text.
+func encodeProfile(t *testing.T, size int, order binary.ByteOrder, words []uint64, maps string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	for _, word := range words {
+		var err error
+		if size == 8 {
+			err = binary.Write(&buf, order, word)
+		} else {
+			err = binary.Write(&buf, order, uint32(word))
+		}
+		if err != nil {
+			t.Fatalf("Failed to encode word: %v", err)
+		}
+	}
+	buf.WriteString(maps)
+	return buf.Bytes()
+}
+
+func TestParse(t *testing.T) {
+	words := []uint64{
+		0, 3, 0, 10000, 0, // Header.
+		5, 2, 0x1010, 0x2020, // First sample.
+		1, 3, 0x1020, 0x1010, 0x2020, // Second sample.
+		0, 1, 0, // Trailer.
+	}
+	maps := `00001000-00002000 r-xp 00000000 08:01 1234 /usr/bin/sandboxfs
+00002000-00003000 rw-p 00001000 08:01 1234 /usr/bin/sandboxfs
+7f0000000000-7f0000001000 rw-p 00000000 00:00 0
+build=abc
+`
+	want := &Profile{
+		Period: 10 * time.Millisecond,
+		Samples: []Sample{
+			{Count: 5, PCs: []uint64{0x1010, 0x2020}},
+			{Count: 1, PCs: []uint64{0x1020, 0x1010, 0x2020}},
+		},
+		Mappings: []Mapping{
+			{Start: 0x1000, End: 0x2000, Offset: 0, Executable: true, Path: "/usr/bin/sandboxfs"},
+			{Start: 0x2000, End: 0x3000, Offset: 0x1000, Executable: false, Path: "/usr/bin/sandboxfs"},
+			{Start: 0x7f0000000000, End: 0x7f0000001000, Offset: 0, Executable: false, Path: ""},
+		},
+	}
+
+	testData := []struct {
+		name string
+
+		size  int
+		order binary.ByteOrder
+	}{
+		{"64BitLittleEndian", 8, binary.LittleEndian},
+		{"64BitBigEndian", 8, binary.BigEndian},
+		{"32BitLittleEndian", 4, binary.LittleEndian},
+		{"32BitBigEndian", 4, binary.BigEndian},
+	}
+	for _, d := range testData {
+		t.Run(d.name, func(t *testing.T) {
+			p, err := Parse(bytes.NewReader(encodeProfile(t, d.size, d.order, words, maps)))
+			if err != nil {
+				t.Fatalf("Failed to parse profile: %v", err)
+			}
+			if !reflect.DeepEqual(p, want) {
+				t.Errorf("Got %+v; want %+v", p, want)
+			}
+			if got := p.TotalSamples(); got != 6 {
+				t.Errorf("Got %d total samples; want 6", got)
+			}
+		})
+	}
+}
+
+func TestParse_Errors(t *testing.T) {
+	testData := []struct {
+		name string
+
+		words     []uint64
+		wantError string
+	}{
+		{"NotAProfile", []uint64{1, 2, 3, 4, 5}, "not a gperftools CPU profile"},
+		{"TruncatedHeader", []uint64{0, 3, 0}, "truncated profile header"},
+		{"BadVersion", []uint64{0, 3, 1, 10000, 0, 0, 1, 0}, "unsupported profile version 1"},
+		{"NoTrailer", []uint64{0, 3, 0, 10000, 0, 1, 1, 0x10}, "lacks a trailer"},
+		{"TruncatedSample", []uint64{0, 3, 0, 10000, 0, 1, 5, 0x10}, "truncated sample"},
+		{"HugeSample", []uint64{0, 3, 0, 10000, 0, 1, 1 << 62, 0x10}, "truncated sample"},
+		{"NegativeSample", []uint64{0, 3, 0, 10000, 0, 1, ^uint64(0), 0x10}, "truncated sample"},
+	}
+	for _, d := range testData {
+		t.Run(d.name, func(t *testing.T) {
+			_, err := Parse(bytes.NewReader(encodeProfile(t, 8, binary.LittleEndian, d.words, "")))
+			if err == nil || !strings.Contains(err.Error(), d.wantError) {
+				t.Errorf("Got error %v; want error containing %s", err, d.wantError)
+			}
+		})
+	}
+}
@@ -0,0 +1,151 @@
+// Copyright 2020 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not
+// use this file except in compliance with the License.  You may obtain a copy
+// of the License at:
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.  See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package cpuprof
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// Entry represents the cost of a single function in a profile.
+type Entry struct {
+	// Function is the name of the function.
+	Function string
+
+	// Flat is the number of samples in which the function was the leaf frame.
+	Flat uint64
+
+	// Cum is the number of samples in which the function appeared anywhere in the stack trace.
+	// Recursive calls are only counted once per stack trace.
+	Cum uint64
+}
+
+// Top computes the cost of every function in the given stack traces and returns the n most
+// expensive ones, sorted by decreasing flat cost and then by decreasing cumulative cost.  Returns
+// all functions if n is not positive.
+func Top(stacks []Stack, n int) []Entry {
+	entries := make(map[string]*Entry)
+	get := func(function string) *Entry {
+		e, ok := entries[function]
+		if !ok {
+			e = &Entry{Function: function}
+			entries[function] = e
+		}
+		return e
+	}
+
+	for _, stack := range stacks {
+		if len(stack.Functions) == 0 {
+			continue
+		}
+		get(stack.Functions[0]).Flat += stack.Count
+		seen := make(map[string]bool, len(stack.Functions))
+		for _, function := range stack.Functions {
+			if !seen[function] {
+				get(function).Cum += stack.Count
+				seen[function] = true
+			}
+		}
+	}
+
+	all := make([]Entry, 0, len(entries))
+	for _, e := range entries {
+		all = append(all, *e)
+	}
+	sort.Slice(all, func(i, j int) bool {
+		if all[i].Flat != all[j].Flat {
+			return all[i].Flat > all[j].Flat
+		}
+		if all[i].Cum != all[j].Cum {
+			return all[i].Cum > all[j].Cum
+		}
+		return all[i].Function < all[j].Function
+	})
+	if n > 0 && len(all) > n {
+		all = all[:n]
+	}
+	return all
+}
+
+// totalCount returns the number of samples across all stack traces.
+func totalCount(stacks []Stack) uint64 {
+	var total uint64
+	for _, stack := range stacks {
+		total += stack.Count
+	}
+	return total
+}
+
+// WriteTop writes a table with the n most expensive functions in the given stack traces, in a
+// format similar to that of pprof's top command.
+func WriteTop(w io.Writer, stacks []Stack, n int) error {
+	total := totalCount(stacks)
+	percent := func(count uint64) float64 {
+		if total == 0 {
+			return 0
+		}
+		return float64(count) * 100 / float64(total)
+	}
+
+	if _, err := fmt.Fprintf(w, "Total: %d samples\n", total); err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintf(w, "%10s %7s %7s %10s %7s  %s\n", "flat", "flat%", "sum%", "cum", "cum%", "function"); err != nil {
+		return err
+	}
+	var sum uint64
+	for _, e := range Top(stacks, n) {
+		sum += e.Flat
+		_, err := fmt.Fprintf(w, "%10d %6.2f%% %6.2f%% %10d %6.2f%%  %s\n", e.Flat, percent(e.Flat), percent(sum), e.Cum, percent(e.Cum), e.Function)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// WriteFolded writes the given stack traces in the folded format consumed by flame graph tools:
+// one line per unique stack trace with its functions separated by semicolons, starting with the
+// root, followed by the number of samples.  Lines are sorted to make the output deterministic.
+func WriteFolded(w io.Writer, stacks []Stack) error {
+	counts := make(map[string]uint64)
+	for _, stack := range stacks {
+		if len(stack.Functions) == 0 {
+			continue
+		}
+		functions := make([]string, len(stack.Functions))
+		for i, function := range stack.Functions {
+			// Semicolons separate frames and spaces separate the count, so neither can appear
+			// in a function name.
+			function = strings.Replace(function, ";", ":", -1)
+			functions[len(functions)-1-i] = strings.Replace(function, " ", "_", -1)
+		}
+		counts[strings.Join(functions, ";")] += stack.Count
+	}
+
+	lines := make([]string, 0, len(counts))
+	for line := range counts {
+		lines = append(lines, line)
+	}
+	sort.Strings(lines)
+	for _, line := range lines {
+		if _, err := fmt.Fprintf(w, "%s %d\n", line, counts[line]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,95 @@
+// Copyright 2020 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not
+// use this file except in compliance with the License.  You may obtain a copy
+// of the License at:
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.  See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package cpuprof
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// testStacks contains a set of stack traces shared by the report tests.
+var testStacks = []Stack{
+	{Count: 5, Functions: []string{"read", "lookup", "main"}},
+	{Count: 3, Functions: []string{"lookup", "main"}},
+	{Count: 2, Functions: []string{"walk", "walk", "main"}},
+	{Count: 1, Functions: []string{"read", "lookup", "main"}},
+	{Count: 4},
+}
+
+func TestTop(t *testing.T) {
+	testData := []struct {
+		name string
+
+		n    int
+		want []Entry
+	}{
+		{"All", 0, []Entry{
+			{Function: "read", Flat: 6, Cum: 6},
+			{Function: "lookup", Flat: 3, Cum: 9},
+			{Function: "walk", Flat: 2, Cum: 2},
+			{Function: "main", Flat: 0, Cum: 11},
+		}},
+		{"Limited", 2, []Entry{
+			{Function: "read", Flat: 6, Cum: 6},
+			{Function: "lookup", Flat: 3, Cum: 9},
+		}},
+	}
+	for _, d := range testData {
+		t.Run(d.name, func(t *testing.T) {
+			if got := Top(testStacks, d.n); !reflect.DeepEqual(got, d.want) {
+				t.Errorf("Got %+v; want %+v", got, d.want)
+			}
+		})
+	}
+}
+
+func TestWriteTop(t *testing.T) {
+	var buf bytes.Buffer
+	if err := WriteTop(&buf, testStacks, 2); err != nil {
+		t.Fatalf("WriteTop failed: %v", err)
+	}
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 4 {
+		t.Fatalf("Got %d lines; want 4:\n%s", len(lines), buf.String())
+	}
+	if lines[0] != "Total: 15 samples" {
+		t.Errorf("Got header %q; want total of 15 samples", lines[0])
+	}
+	want := []string{
+		"         6  40.00%  40.00%          6  40.00%  read",
+		"         3  20.00%  60.00%          9  60.00%  lookup",
+	}
+	if !reflect.DeepEqual(lines[2:], want) {
+		t.Errorf("Got %q; want %q", lines[2:], want)
+	}
+}
+
+func TestWriteFolded(t *testing.T) {
+	stacks := append([]Stack{{Count: 1, Functions: []string{"a b;c", "main"}}}, testStacks...)
+	var buf bytes.Buffer
+	if err := WriteFolded(&buf, stacks); err != nil {
+		t.Fatalf("WriteFolded failed: %v", err)
+	}
+	want := `main;a_b:c 1
+main;lookup 3
+main;lookup;read 6
+main;walk;walk 2
+`
+	if got := buf.String(); got != want {
+		t.Errorf("Got:\n%s\nwant:\n%s", got, want)
+	}
+}
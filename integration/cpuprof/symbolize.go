@@ -0,0 +1,213 @@
+// Copyright 2020 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not
+// use this file except in compliance with the License.  You may obtain a copy
+// of the License at:
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.  See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package cpuprof
+
+import (
+	"debug/elf"
+	"fmt"
+	"path/filepath"
+	"sort"
+)
+
+// symbol represents a function in a binary.
+type symbol struct {
+	name  string
+	start uint64
+	end   uint64
+}
+
+// object holds the symbols of a mapped binary.
+type object struct {
+	// file is the parsed binary, or nil if it could not be opened.
+	file *elf.File
+
+	// symbols contains the functions of the binary sorted by address.
+	symbols []symbol
+}
+
+// loadObject reads the function symbols of the binary at path.
+func loadObject(path string) (*object, error) {
+	file, err := elf.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	symbols, err := file.Symbols()
+	if err != nil && err != elf.ErrNoSymbols {
+		file.Close()
+		return nil, err
+	}
+	if dynamic, err := file.DynamicSymbols(); err == nil {
+		symbols = append(symbols, dynamic...)
+	}
+
+	obj := &object{file: file}
+	for _, s := range symbols {
+		if elf.ST_TYPE(s.Info) != elf.STT_FUNC || s.Value == 0 {
+			continue
+		}
+		obj.symbols = append(obj.symbols, symbol{name: demangle(s.Name), start: s.Value, end: s.Value + s.Size})
+	}
+	sort.Slice(obj.symbols, func(i, j int) bool { return obj.symbols[i].start < obj.symbols[j].start })
+	return obj, nil
+}
+
+// lookup returns the name of the function that contains the given file offset, if any.
+func (o *object) lookup(fileOffset uint64) (string, bool) {
+	// Convert the offset within the file to the virtual address used by the symbol table.
+	addr, found := uint64(0), false
+	for _, prog := range o.file.Progs {
+		if prog.Type == elf.PT_LOAD && fileOffset >= prog.Off && fileOffset < prog.Off+prog.Filesz {
+			addr, found = fileOffset-prog.Off+prog.Vaddr, true
+			break
+		}
+	}
+	if !found {
+		return "", false
+	}
+
+	i := sort.Search(len(o.symbols), func(i int) bool { return o.symbols[i].start > addr }) - 1
+	for ; i >= 0; i-- {
+		s := o.symbols[i]
+		if addr < s.end || (s.start == s.end && i == len(o.symbols)-1) {
+			return s.name, true
+		}
+		if s.start != s.end {
+			break // Symbols with a size do not overlap, so no earlier symbol can match.
+		}
+	}
+	return "", false
+}
+
+// Symbolizer resolves program counters in a profile to function names by reading the symbol tables
+// of the binaries that were mapped in the profiled process.
+type Symbolizer struct {
+	// profile is the profile whose addresses are resolved.
+	profile *Profile
+
+	// overrides maps the paths recorded in the profile to the paths of the binaries to read.
+	overrides map[string]string
+
+	// objects caches the binaries that have been loaded, keyed by their path in the profile.  A
+	// nil value indicates that the binary could not be loaded.
+	objects map[string]*object
+
+	// cache memoizes the result of resolving each address.
+	cache map[uint64]string
+}
+
+// NewSymbolizer creates a symbolizer for the given profile.  binary, if not empty, is the path to
+// the binary that was profiled and is used in place of the main executable recorded in the profile,
+// which is useful when the binary has moved since it was run.
+func NewSymbolizer(p *Profile, binary string) *Symbolizer {
+	s := &Symbolizer{
+		profile:   p,
+		overrides: make(map[string]string),
+		objects:   make(map[string]*object),
+		cache:     make(map[uint64]string),
+	}
+	if binary != "" {
+		for _, m := range p.Mappings {
+			if m.Executable && m.Path != "" && filepath.Base(m.Path) == filepath.Base(binary) {
+				s.overrides[m.Path] = binary
+			}
+		}
+	}
+	return s
+}
+
+// Close releases the binaries opened by the symbolizer.
+func (s *Symbolizer) Close() {
+	for _, obj := range s.objects {
+		if obj != nil {
+			obj.file.Close()
+		}
+	}
+}
+
+// object returns the loaded binary for a path in the profile, or nil if it is not available.
+func (s *Symbolizer) object(path string) *object {
+	if obj, ok := s.objects[path]; ok {
+		return obj
+	}
+	actual := path
+	if override, ok := s.overrides[path]; ok {
+		actual = override
+	}
+	obj, err := loadObject(actual)
+	if err != nil {
+		obj = nil
+	}
+	s.objects[path] = obj
+	return obj
+}
+
+// Resolve returns the name of the function that contains addr.  Addresses that cannot be resolved
+// are described by the binary they belong to and their offset within it.
+func (s *Symbolizer) Resolve(addr uint64) string {
+	if name, ok := s.cache[addr]; ok {
+		return name
+	}
+
+	name := fmt.Sprintf("0x%x", addr)
+	if m := s.profile.findMapping(addr); m != nil && m.Path != "" {
+		fileOffset := addr - m.Start + m.Offset
+		name = fmt.Sprintf("%s+0x%x", filepath.Base(m.Path), fileOffset)
+		if obj := s.object(m.Path); obj != nil {
+			if function, ok := obj.lookup(fileOffset); ok {
+				name = function
+			}
+		}
+	}
+	s.cache[addr] = name
+	return name
+}
+
+// Stack represents a symbolized stack trace and the number of times it was observed.
+type Stack struct {
+	// Count is the number of times the stack trace was observed.
+	Count uint64
+
+	// Functions contains the names of the functions in the stack trace, starting with the leaf.
+	Functions []string
+}
+
+// Symbolize resolves all stack traces in the profile.
+func (s *Symbolizer) Symbolize() []Stack {
+	stacks := make([]Stack, 0, len(s.profile.Samples))
+	for _, sample := range s.profile.Samples {
+		stack := Stack{Count: sample.Count, Functions: make([]string, len(sample.PCs))}
+		for i, pc := range sample.PCs {
+			if i > 0 && pc > 0 {
+				pc-- // Return addresses point past the call instruction.
+			}
+			stack.Functions[i] = s.Resolve(pc)
+		}
+		stacks = append(stacks, stack)
+	}
+	return stacks
+}
+
+// Load parses the CPU profile in the given file and symbolizes it using binary, which may be empty
+// to rely on the paths recorded in the profile.
+func Load(path string, binary string) ([]Stack, error) {
+	p, err := ParseFile(path)
+	if err != nil {
+		return nil, err
+	}
+	s := NewSymbolizer(p, binary)
+	defer s.Close()
+	return s.Symbolize(), nil
+}
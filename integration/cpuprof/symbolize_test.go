@@ -0,0 +1,137 @@
+// Copyright 2020 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not
+// use this file except in compliance with the License.  You may obtain a copy
+// of the License at:
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.  See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package cpuprof
+
+import (
+	"debug/elf"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"testing"
+)
+
+// buildTestBinary compiles a trivial Go program with its symbol table and returns its path.
+func buildTestBinary(t *testing.T, dir string) string {
+	t.Helper()
+	if runtime.GOOS != "linux" {
+		t.Skipf("Requires ELF binaries")
+	}
+	goTool := filepath.Join(runtime.GOROOT(), "bin", "go")
+	if _, err := os.Stat(goTool); err != nil {
+		t.Skipf("Go toolchain not available: %v", err)
+	}
+
+	source := filepath.Join(dir, "main.go")
+	if err := ioutil.WriteFile(source, []byte("package main\n\nfunc main() { println(\"hello\") }\n"), 0644); err != nil {
+		t.Fatalf("Failed to write test program: %v", err)
+	}
+	binary := filepath.Join(dir, "hello")
+	cmd := exec.Command(goTool, "build", "-o", binary, source)
+	cmd.Env = append(os.Environ(), "GO111MODULE=off", "GOFLAGS=")
+	if output, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("Failed to build test program: %v\n%s", err, output)
+	}
+	return binary
+}
+
+// findFunction returns the virtual address of a function in a binary and the load segment that
+// contains it.
+func findFunction(t *testing.T, binary string, name string) (uint64, *elf.Prog) {
+	t.Helper()
+	file, err := elf.Open(binary)
+	if err != nil {
+		t.Fatalf("Failed to open %s: %v", binary, err)
+	}
+	defer file.Close()
+	symbols, err := file.Symbols()
+	if err != nil {
+		t.Fatalf("Failed to read symbols of %s: %v", binary, err)
+	}
+	for _, s := range symbols {
+		if s.Name != name {
+			continue
+		}
+		for _, prog := range file.Progs {
+			if prog.Type == elf.PT_LOAD && s.Value >= prog.Vaddr && s.Value < prog.Vaddr+prog.Filesz {
+				return s.Value, prog
+			}
+		}
+	}
+	t.Fatalf("Cannot find %s in %s", name, binary)
+	return 0, nil
+}
+
+func TestSymbolizer_Resolve(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cpuprof")
+	if err != nil {
+		t.Fatalf("Failed to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	binary := buildTestBinary(t, dir)
+	addr, prog := findFunction(t, binary, "main.main")
+
+	// Simulate that the binary was loaded at a different address than its link address, as
+	// happens with position-independent executables, and that it has since been moved.
+	const base = 0x10000000
+	p := &Profile{
+		Mappings: []Mapping{
+			{Start: base, End: base + prog.Filesz, Offset: prog.Off, Executable: true, Path: "/old/location/hello"},
+		},
+	}
+	pc := base + addr - prog.Vaddr
+	p.Samples = []Sample{{Count: 3, PCs: []uint64{pc + 1, pc + 2}}}
+
+	s := NewSymbolizer(p, binary)
+	defer s.Close()
+	if got := s.Resolve(pc); got != "main.main" {
+		t.Errorf("Got %s for the main function; want main.main", got)
+	}
+	want := []Stack{{Count: 3, Functions: []string{"main.main", "main.main"}}}
+	if got := s.Symbolize(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Got %v; want %v", got, want)
+	}
+}
+
+func TestSymbolizer_ResolveFallbacks(t *testing.T) {
+	p := &Profile{
+		Mappings: []Mapping{
+			{Start: 0x1000, End: 0x2000, Offset: 0x500, Executable: true, Path: "/non-existent/libfoo.so"},
+			{Start: 0x3000, End: 0x4000, Executable: true},
+		},
+	}
+	s := NewSymbolizer(p, "")
+	defer s.Close()
+
+	testData := []struct {
+		name string
+
+		addr uint64
+		want string
+	}{
+		{"MissingBinary", 0x1234, "libfoo.so+0x734"},
+		{"AnonymousMapping", 0x3010, "0x3010"},
+		{"NoMapping", 0x5000, "0x5000"},
+	}
+	for _, d := range testData {
+		t.Run(d.name, func(t *testing.T) {
+			if got := s.Resolve(d.addr); got != d.want {
+				t.Errorf("Got %s; want %s", got, d.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,52 @@
+// Copyright 2020 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not
+// use this file except in compliance with the License.  You may obtain a copy
+// of the License at:
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.  See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package integration
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/bazelbuild/sandboxfs/integration/fsdiff"
+	"github.com/bazelbuild/sandboxfs/integration/utils"
+)
+
+func TestDifferential_RandomOperations(t *testing.T) {
+	t.Parallel()
+
+	// Fixed seeds so that failures are reproducible across runs.  Add the seed of any sequence
+	// found to misbehave elsewhere to keep it covered.
+	seeds := []int64{1, 2, 3, 4}
+	for _, seed := range seeds {
+		seed := seed
+		t.Run(fmt.Sprintf("Seed%d", seed), func(t *testing.T) {
+			t.Parallel()
+
+			state := utils.MountSetup(t, utils.WithArgs("--xattrs", "--mapping=rw:/:%ROOT%"))
+
+			// The reference tree lives next to the root of the mapping so that both are
+			// backed by the same underlying file system.
+			reference := state.TempPath("reference")
+			utils.MustMkdirAll(t, reference, 0755)
+
+			trees := []fsdiff.Tree{
+				{Name: "reference", Path: reference},
+				{Name: "mount", Path: state.MountPath()},
+			}
+			if err := fsdiff.Check(trees, seed, 20, 30); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
@@ -0,0 +1,22 @@
+// Copyright 2020 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not
+// use this file except in compliance with the License.  You may obtain a copy
+// of the License at:
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.  See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+// Package fsdiff implements differential testing of file systems.
+//
+// The tests generate random sequences of file system operations, apply them to several directory
+// trees, and compare the outcomes of every operation and the final contents of the trees against
+// those of a reference tree.  The integration tests use this to verify that a sandboxfs read/write
+// mapping behaves exactly like the underlying file system.  When a sequence makes the trees diverge,
+// the sequence is shrunk to a minimal reproducer.
+package fsdiff
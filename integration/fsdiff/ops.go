@@ -0,0 +1,247 @@
+// Copyright 2020 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not
+// use this file except in compliance with the License.  You may obtain a copy
+// of the License at:
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.  See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package fsdiff
+
+import (
+	"fmt"
+	"math/rand"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// OpKind represents the type of an operation.
+type OpKind int
+
+// Valid operation types.
+const (
+	Create OpKind = iota
+	Write
+	Truncate
+	Rename
+	Unlink
+	Mkdir
+	Rmdir
+	Chmod
+	Utimes
+	Symlink
+	Setxattr
+	Removexattr
+
+	// numOpKinds is the number of valid operation types.  Must be last.
+	numOpKinds
+)
+
+// opNames contains the display names of the operation types.
+var opNames = [numOpKinds]string{
+	Create:      "create",
+	Write:       "write",
+	Truncate:    "truncate",
+	Rename:      "rename",
+	Unlink:      "unlink",
+	Mkdir:       "mkdir",
+	Rmdir:       "rmdir",
+	Chmod:       "chmod",
+	Utimes:      "utimes",
+	Symlink:     "symlink",
+	Setxattr:    "setxattr",
+	Removexattr: "removexattr",
+}
+
+// String returns the name of the operation type.
+func (k OpKind) String() string {
+	if k < 0 || k >= numOpKinds {
+		return fmt.Sprintf("OpKind(%d)", int(k))
+	}
+	return opNames[k]
+}
+
+// Op represents a file system operation.  Only the fields relevant to the operation's kind are set.
+type Op struct {
+	// Kind is the type of the operation.
+	Kind OpKind
+
+	// Path is the path of the entry to operate on, relative to the root of the tree.
+	Path string
+
+	// Target is the new path of the entry for Rename, relative to the root of the tree, and the
+	// target of the link for Symlink, relative to the directory of the link.
+	Target string
+
+	// Mode contains the permissions for Create, Mkdir and Chmod.
+	Mode uint32
+
+	// Offset is the position to write at for Write and the new size for Truncate.
+	Offset int64
+
+	// Data contains the bytes to write for Write and the value of the attribute for Setxattr.
+	Data string
+
+	// Name is the name of the extended attribute for Setxattr and Removexattr.
+	Name string
+
+	// Time is the new access and modification time for Utimes.
+	Time time.Time
+}
+
+// String formats the operation as a function call.
+func (op Op) String() string {
+	switch op.Kind {
+	case Create, Mkdir, Chmod:
+		return fmt.Sprintf("%s(%q, %#o)", op.Kind, op.Path, op.Mode)
+	case Write:
+		return fmt.Sprintf("%s(%q, %d, %q)", op.Kind, op.Path, op.Offset, op.Data)
+	case Truncate:
+		return fmt.Sprintf("%s(%q, %d)", op.Kind, op.Path, op.Offset)
+	case Rename:
+		return fmt.Sprintf("%s(%q, %q)", op.Kind, op.Path, op.Target)
+	case Symlink:
+		return fmt.Sprintf("%s(%q, %q)", op.Kind, op.Target, op.Path)
+	case Utimes:
+		return fmt.Sprintf("%s(%q, %d)", op.Kind, op.Path, op.Time.Unix())
+	case Setxattr:
+		return fmt.Sprintf("%s(%q, %q, %q)", op.Kind, op.Path, op.Name, op.Data)
+	case Removexattr:
+		return fmt.Sprintf("%s(%q, %q)", op.Kind, op.Path, op.Name)
+	default:
+		return fmt.Sprintf("%s(%q)", op.Kind, op.Path)
+	}
+}
+
+// affectedPath returns the path whose status is compared after applying the operation.
+func (op Op) affectedPath() string {
+	if op.Kind == Rename {
+		return op.Target
+	}
+	return op.Path
+}
+
+// Names used to generate paths.  The set is tiny on purpose so that operations often refer to the
+// same entries, which exercises interesting interactions such as renaming a directory over a file.
+var pathComponents = []string{"a", "b", "c"}
+
+// Names of the extended attributes to operate on.
+var xattrNames = []string{"user.x", "user.y"}
+
+// Permissions to choose from.  All of them give the owner full access to keep the trees walkable.
+var modes = []uint32{0700, 0750, 0755, 0777}
+
+// randomPath returns a random relative path with up to three components.
+func randomPath(rng *rand.Rand) string {
+	components := make([]string, 1+rng.Intn(3))
+	for i := range components {
+		components[i] = pathComponents[rng.Intn(len(pathComponents))]
+	}
+	return filepath.Join(components...)
+}
+
+// randomData returns a short random string.
+func randomData(rng *rand.Rand) string {
+	var data strings.Builder
+	for i := 0; i < 1+rng.Intn(16); i++ {
+		data.WriteByte(byte('a' + rng.Intn(26)))
+	}
+	return data.String()
+}
+
+// Generate returns a random sequence of n operations.
+func Generate(rng *rand.Rand, n int) []Op {
+	ops := make([]Op, n)
+	for i := range ops {
+		op := Op{Kind: OpKind(rng.Intn(int(numOpKinds))), Path: randomPath(rng)}
+		switch op.Kind {
+		case Create, Mkdir, Chmod:
+			op.Mode = modes[rng.Intn(len(modes))]
+		case Write:
+			op.Offset = int64(rng.Intn(32))
+			op.Data = randomData(rng)
+		case Truncate:
+			op.Offset = int64(rng.Intn(32))
+		case Rename:
+			op.Target = randomPath(rng)
+		case Symlink:
+			op.Target = randomPath(rng)
+		case Utimes:
+			op.Time = time.Unix(1000000000+int64(rng.Intn(1000000)), 0)
+		case Setxattr:
+			op.Name = xattrNames[rng.Intn(len(xattrNames))]
+			op.Data = randomData(rng)
+		case Removexattr:
+			op.Name = xattrNames[rng.Intn(len(xattrNames))]
+		}
+		ops[i] = op
+	}
+	return ops
+}
+
+// applyOp applies the operation to the tree rooted at root.
+func applyOp(root string, op Op) error {
+	path := filepath.Join(root, op.Path)
+	switch op.Kind {
+	case Create:
+		fd, err := unix.Open(path, unix.O_WRONLY|unix.O_CREAT|unix.O_EXCL, op.Mode)
+		if err != nil {
+			return err
+		}
+		return unix.Close(fd)
+
+	case Write:
+		fd, err := unix.Open(path, unix.O_WRONLY, 0)
+		if err != nil {
+			return err
+		}
+		_, err = unix.Pwrite(fd, []byte(op.Data), op.Offset)
+		if closeErr := unix.Close(fd); err == nil {
+			err = closeErr
+		}
+		return err
+
+	case Truncate:
+		return unix.Truncate(path, op.Offset)
+
+	case Rename:
+		return unix.Rename(path, filepath.Join(root, op.Target))
+
+	case Unlink:
+		return unix.Unlink(path)
+
+	case Mkdir:
+		return unix.Mkdir(path, op.Mode)
+
+	case Rmdir:
+		return unix.Rmdir(path)
+
+	case Chmod:
+		return unix.Chmod(path, op.Mode)
+
+	case Utimes:
+		ts := unix.NsecToTimespec(op.Time.UnixNano())
+		return unix.UtimesNano(path, []unix.Timespec{ts, ts})
+
+	case Symlink:
+		return unix.Symlink(op.Target, path)
+
+	case Setxattr:
+		return unix.Lsetxattr(path, op.Name, []byte(op.Data), 0)
+
+	case Removexattr:
+		return unix.Lremovexattr(path, op.Name)
+
+	default:
+		panic(fmt.Sprintf("unknown operation kind %d", op.Kind))
+	}
+}
@@ -0,0 +1,74 @@
+// Copyright 2020 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not
+// use this file except in compliance with the License.  You may obtain a copy
+// of the License at:
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.  See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package fsdiff
+
+import (
+	"math/rand"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestGenerate_Deterministic(t *testing.T) {
+	ops1 := Generate(rand.New(rand.NewSource(1)), 100)
+	ops2 := Generate(rand.New(rand.NewSource(1)), 100)
+	if !reflect.DeepEqual(ops1, ops2) {
+		t.Errorf("Got different sequences for the same seed")
+	}
+}
+
+func TestGenerate_CoversAllKinds(t *testing.T) {
+	seen := make(map[OpKind]bool)
+	for _, op := range Generate(rand.New(rand.NewSource(1)), 1000) {
+		if op.Kind < 0 || op.Kind >= numOpKinds {
+			t.Fatalf("Got invalid operation kind %d", op.Kind)
+		}
+		if op.Path == "" {
+			t.Errorf("Got operation %s without a path", op)
+		}
+		seen[op.Kind] = true
+	}
+	for kind := OpKind(0); kind < numOpKinds; kind++ {
+		if !seen[kind] {
+			t.Errorf("Operation kind %s never generated", kind)
+		}
+	}
+}
+
+func TestOp_String(t *testing.T) {
+	testData := []struct {
+		op   Op
+		want string
+	}{
+		{Op{Kind: Create, Path: "a/b", Mode: 0755}, `create("a/b", 0755)`},
+		{Op{Kind: Write, Path: "a", Offset: 3, Data: "xyz"}, `write("a", 3, "xyz")`},
+		{Op{Kind: Truncate, Path: "a", Offset: 7}, `truncate("a", 7)`},
+		{Op{Kind: Rename, Path: "a", Target: "b/c"}, `rename("a", "b/c")`},
+		{Op{Kind: Unlink, Path: "a"}, `unlink("a")`},
+		{Op{Kind: Mkdir, Path: "a", Mode: 0700}, `mkdir("a", 0700)`},
+		{Op{Kind: Rmdir, Path: "a"}, `rmdir("a")`},
+		{Op{Kind: Chmod, Path: "a", Mode: 0777}, `chmod("a", 0777)`},
+		{Op{Kind: Utimes, Path: "a", Time: time.Unix(1234, 0)}, `utimes("a", 1234)`},
+		{Op{Kind: Symlink, Path: "a", Target: "b"}, `symlink("b", "a")`},
+		{Op{Kind: Setxattr, Path: "a", Name: "user.x", Data: "v"}, `setxattr("a", "user.x", "v")`},
+		{Op{Kind: Removexattr, Path: "a", Name: "user.x"}, `removexattr("a", "user.x")`},
+		{Op{Kind: OpKind(100), Path: "a"}, `OpKind(100)("a")`},
+	}
+	for _, d := range testData {
+		if got := d.op.String(); got != d.want {
+			t.Errorf("Got %s; want %s", got, d.want)
+		}
+	}
+}
@@ -0,0 +1,216 @@
+// Copyright 2020 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not
+// use this file except in compliance with the License.  You may obtain a copy
+// of the License at:
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.  See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package fsdiff
+
+import (
+	"fmt"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync/atomic"
+)
+
+// Tree represents a directory tree on which to apply operations.
+type Tree struct {
+	// Name identifies the tree in error messages, such as "mount".
+	Name string
+
+	// Path is the directory in which to create the scratch directories for every run.  The
+	// contents of the directory are not otherwise touched.
+	Path string
+}
+
+// Divergence describes how a tree diverged from the reference tree.
+type Divergence struct {
+	// Ops is the sequence of operations that caused the divergence.
+	Ops []Op
+
+	// Step is the index of the operation in Ops whose outcome differed, or len(Ops) if all
+	// outcomes matched but the final contents of the trees did not.
+	Step int
+
+	// Tree is the name of the tree that diverged.
+	Tree string
+
+	// Got describes what happened in the diverging tree.
+	Got string
+
+	// Want describes what happened in the reference tree.
+	Want string
+}
+
+// Error formats the divergence as a reproducer.
+func (d *Divergence) Error() string {
+	var lines []string
+	for i, op := range d.Ops {
+		marker := "  "
+		if i == d.Step {
+			marker = "->"
+		}
+		lines = append(lines, fmt.Sprintf("%s %s", marker, op))
+	}
+	what := fmt.Sprintf("outcome of step %d", d.Step)
+	if d.Step == len(d.Ops) {
+		what = "final contents"
+	}
+	return fmt.Sprintf("tree %s diverged in %s; got %s; want %s; sequence:\n%s", d.Tree, what, d.Got, d.Want, strings.Join(lines, "\n"))
+}
+
+// Hooks that tests replace to simulate diverging trees.
+var (
+	apply = applyOp
+)
+
+// runCounter is used to generate unique names for the scratch directories of every run.
+var runCounter int32
+
+// Run applies the given operations to fresh scratch directories within each of the trees and
+// compares the outcomes of every operation and the final contents of every tree against those of
+// the first tree, which is the reference.  Returns the first divergence found, or nil if none.
+// The scratch directories are deleted on return.
+func Run(trees []Tree, ops []Op) (*Divergence, error) {
+	name := fmt.Sprintf("run-%d-%d", os.Getpid(), atomic.AddInt32(&runCounter, 1))
+	var roots []string
+	defer func() {
+		for _, root := range roots {
+			os.RemoveAll(root)
+		}
+	}()
+	for _, tree := range trees {
+		root := filepath.Join(tree.Path, name)
+		if err := os.Mkdir(root, 0755); err != nil {
+			return nil, fmt.Errorf("cannot create scratch directory in tree %s: %v", tree.Name, err)
+		}
+		roots = append(roots, root)
+	}
+
+	return run(trees, roots, ops)
+}
+
+// run implements Run once the scratch directories exist.
+func run(trees []Tree, roots []string, ops []Op) (*Divergence, error) {
+	for step, op := range ops {
+		outcomes := make([]Outcome, len(roots))
+		for i, root := range roots {
+			err := apply(root, op)
+			outcomes[i] = Outcome{
+				Errno:  errnoOf(err),
+				Status: lstatus(filepath.Join(root, op.affectedPath()), op.Kind == Utimes && err == nil),
+			}
+		}
+		for i := 1; i < len(outcomes); i++ {
+			if !reflect.DeepEqual(outcomes[i], outcomes[0]) {
+				return &Divergence{Ops: ops, Step: step, Tree: trees[i].Name, Got: outcomes[i].String(), Want: outcomes[0].String()}, nil
+			}
+		}
+	}
+
+	want, err := Snapshot(roots[0])
+	if err != nil {
+		return nil, err
+	}
+	for i := 1; i < len(roots); i++ {
+		got, err := Snapshot(roots[i])
+		if err != nil {
+			return nil, err
+		}
+		if paths := diffSnapshots(want, got); len(paths) > 0 {
+			path := paths[0]
+			return &Divergence{Ops: ops, Step: len(ops), Tree: trees[i].Name, Got: fmt.Sprintf("%s is %s", path, got[path]), Want: fmt.Sprintf("%s is %s", path, want[path])}, nil
+		}
+	}
+	return nil, nil
+}
+
+// Shrink reduces a sequence of operations for which fails returns true to a smaller sequence for
+// which fails still returns true.  The result is minimal in the sense that removing any single
+// operation from it makes fails return false.
+//
+// This implements a simplified version of the delta debugging algorithm: it tries to remove chunks
+// of decreasing sizes from the sequence and keeps every removal that preserves the failure.
+func Shrink(ops []Op, fails func([]Op) bool) []Op {
+	for chunk := len(ops) / 2; chunk > 1; chunk /= 2 {
+		ops, _ = removeChunks(ops, chunk, fails)
+	}
+	// Removing an operation can make earlier ones removable, so a single pass over individual
+	// operations is not enough to guarantee minimality.
+	for {
+		var changed bool
+		ops, changed = removeChunks(ops, 1, fails)
+		if !changed {
+			return ops
+		}
+	}
+}
+
+// removeChunks makes one pass over ops trying to remove consecutive chunks of the given size, and
+// keeps every removal for which fails returns true.  Returns the reduced sequence and whether any
+// operation was removed.
+func removeChunks(ops []Op, chunk int, fails func([]Op) bool) ([]Op, bool) {
+	changed := false
+	for start := 0; start+chunk <= len(ops); {
+		candidate := append(append([]Op{}, ops[:start]...), ops[start+chunk:]...)
+		if fails(candidate) {
+			ops = candidate
+			changed = true
+		} else {
+			start += chunk
+		}
+	}
+	return ops, changed
+}
+
+// Check generates count random sequences of length operations each, using the given seed, and
+// runs them on the trees.  Returns the first divergence found, shrunk to a minimal reproducer, or
+// nil if the trees always behaved the same.
+func Check(trees []Tree, seed int64, count int, length int) error {
+	rng := rand.New(rand.NewSource(seed))
+	for i := 0; i < count; i++ {
+		ops := Generate(rng, length)
+		divergence, err := Run(trees, ops)
+		if err != nil {
+			return err
+		}
+		if divergence == nil {
+			continue
+		}
+
+		var runErr error
+		fails := func(ops []Op) bool {
+			candidate, err := Run(trees, ops)
+			if err != nil && runErr == nil {
+				runErr = err
+			}
+			if candidate != nil {
+				divergence = candidate
+				return true
+			}
+			return false
+		}
+		ops = Shrink(ops, fails)
+		if runErr != nil {
+			return runErr
+		}
+		// Re-run the minimal sequence so that the reported divergence matches it, as the
+		// last call to fails may have been for a sequence that did not fail.
+		if final, err := Run(trees, ops); err == nil && final != nil {
+			divergence = final
+		}
+		return fmt.Errorf("sequence %d from seed %d: %v", i, seed, divergence)
+	}
+	return nil
+}
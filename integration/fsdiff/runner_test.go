@@ -0,0 +1,208 @@
+// Copyright 2020 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not
+// use this file except in compliance with the License.  You may obtain a copy
+// of the License at:
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.  See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package fsdiff
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"golang.org/x/sys/unix"
+)
+
+// setUpTrees creates the given number of empty trees in a temporary directory.
+func setUpTrees(t *testing.T, names ...string) []Tree {
+	t.Helper()
+
+	dir, err := ioutil.TempDir("", "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	var trees []Tree
+	for _, name := range names {
+		path := filepath.Join(dir, name)
+		if err := os.Mkdir(path, 0755); err != nil {
+			t.Fatal(err)
+		}
+		trees = append(trees, Tree{Name: name, Path: path})
+	}
+	return trees
+}
+
+// injectFault makes every Rmdir applied to the tree named "faulty" fail with EBUSY.
+func injectFault(t *testing.T) {
+	t.Helper()
+
+	apply = func(root string, op Op) error {
+		if op.Kind == Rmdir && strings.Contains(root, "faulty") {
+			return unix.EBUSY
+		}
+		return applyOp(root, op)
+	}
+	t.Cleanup(func() { apply = applyOp })
+}
+
+// isEmpty returns true if the given directory has no entries.
+func isEmpty(t *testing.T, dir string) bool {
+	t.Helper()
+
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return len(entries) == 0
+}
+
+func TestCheck_IdenticalTrees(t *testing.T) {
+	trees := setUpTrees(t, "reference", "copy")
+	if err := Check(trees, 1, 50, 40); err != nil {
+		t.Errorf("Got divergence between identical trees: %v", err)
+	}
+	for _, tree := range trees {
+		if !isEmpty(t, tree.Path) {
+			t.Errorf("Tree %s not cleaned up", tree.Name)
+		}
+	}
+}
+
+func TestRun_DivergentOutcome(t *testing.T) {
+	injectFault(t)
+	trees := setUpTrees(t, "reference", "faulty")
+
+	ops := []Op{
+		{Kind: Mkdir, Path: "a", Mode: 0755},
+		{Kind: Rmdir, Path: "a"},
+		{Kind: Mkdir, Path: "b", Mode: 0755},
+	}
+	divergence, err := Run(trees, ops)
+	if err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if divergence == nil {
+		t.Fatalf("Got no divergence; want one")
+	}
+	if divergence.Step != 1 || divergence.Tree != "faulty" {
+		t.Errorf("Got divergence at step %d in tree %s; want step 1 in tree faulty", divergence.Step, divergence.Tree)
+	}
+	if !strings.HasPrefix(divergence.Got, "EBUSY") || !strings.HasPrefix(divergence.Want, "ok") {
+		t.Errorf("Got outcomes %s and %s; want EBUSY and ok", divergence.Got, divergence.Want)
+	}
+	if !strings.Contains(divergence.Error(), "-> rmdir(\"a\")") {
+		t.Errorf("Got %s; want the diverging step to be marked", divergence.Error())
+	}
+}
+
+func TestRun_DivergentContents(t *testing.T) {
+	trees := setUpTrees(t, "reference", "other")
+
+	apply = func(root string, op Op) error {
+		if strings.Contains(root, "other") && op.Kind == Setxattr {
+			return nil // Pretend the operation worked but do nothing.
+		}
+		return applyOp(root, op)
+	}
+	defer func() { apply = applyOp }()
+
+	// Run a sequence whose only observable difference is in the final contents.  Extended
+	// attributes are not part of the outcomes, so make sure the tree supports them first.
+	ops := []Op{
+		{Kind: Create, Path: "a", Mode: 0644},
+		{Kind: Setxattr, Path: "a", Name: "user.x", Data: "v"},
+	}
+	if err := unix.Lsetxattr(trees[0].Path, "user.probe", []byte("1"), 0); err != nil {
+		t.Skipf("Extended attributes not supported by the temporary directory: %v", err)
+	}
+	divergence, err := Run(trees, ops)
+	if err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if divergence == nil {
+		t.Fatalf("Got no divergence; want one")
+	}
+	if divergence.Step != len(ops) || !strings.Contains(divergence.Error(), "final contents") {
+		t.Errorf("Got %v; want a divergence in the final contents", divergence)
+	}
+}
+
+func TestShrink(t *testing.T) {
+	var ops []Op
+	for _, path := range []string{"a", "b", "c", "d", "e", "f", "g", "h", "i"} {
+		ops = append(ops, Op{Kind: Mkdir, Path: path})
+	}
+
+	// The sequence fails if it contains both "c" and "g".
+	fails := func(ops []Op) bool {
+		found := 0
+		for _, op := range ops {
+			if op.Path == "c" || op.Path == "g" {
+				found++
+			}
+		}
+		return found == 2
+	}
+
+	want := []Op{{Kind: Mkdir, Path: "c"}, {Kind: Mkdir, Path: "g"}}
+	if got := Shrink(ops, fails); !reflect.DeepEqual(got, want) {
+		t.Errorf("Got %v; want %v", got, want)
+	}
+}
+
+func TestShrink_RepeatsUntilMinimal(t *testing.T) {
+	ops := []Op{{Kind: Mkdir, Path: "a"}, {Kind: Mkdir, Path: "b"}, {Kind: Mkdir, Path: "c"}}
+
+	// The sequence fails if it contains "b" and, whenever it contains "c", also "a".  "a" can
+	// only be removed once "c" is gone, which a single pass over the operations misses.
+	fails := func(ops []Op) bool {
+		present := make(map[string]bool)
+		for _, op := range ops {
+			present[op.Path] = true
+		}
+		return present["b"] && (!present["c"] || present["a"])
+	}
+
+	want := []Op{{Kind: Mkdir, Path: "b"}}
+	if got := Shrink(ops, fails); !reflect.DeepEqual(got, want) {
+		t.Errorf("Got %v; want %v", got, want)
+	}
+}
+
+func TestCheck_ShrinksDivergence(t *testing.T) {
+	injectFault(t)
+	trees := setUpTrees(t, "reference", "faulty")
+
+	err := Check(trees, 1, 100, 40)
+	if err == nil {
+		t.Fatalf("Got no divergence; want one")
+	}
+	// The minimal reproducer is a single rmdir that fails in the reference tree with an error
+	// other than EBUSY, or a mkdir followed by an rmdir that succeeds in the reference tree.
+	lines := strings.Split(err.Error(), "\n")
+	if len(lines) > 3 {
+		t.Errorf("Got %s; want a reproducer with at most two operations", err)
+	}
+	if !strings.Contains(lines[len(lines)-1], "-> rmdir(") {
+		t.Errorf("Got %s; want the last operation to be the diverging rmdir", err)
+	}
+	for _, tree := range trees {
+		if !isEmpty(t, tree.Path) {
+			t.Errorf("Tree %s not cleaned up", tree.Name)
+		}
+	}
+}
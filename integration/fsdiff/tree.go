@@ -0,0 +1,214 @@
+// Copyright 2020 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not
+// use this file except in compliance with the License.  You may obtain a copy
+// of the License at:
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.  See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package fsdiff
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// Status represents the observable properties of a file system entry that must match across trees.
+type Status struct {
+	// Type is the type of the entry, such as os.ModeDir, or 0 for regular files.
+	Type os.FileMode
+
+	// Perm contains the permissions of the entry.
+	Perm os.FileMode
+
+	// Size is the size of regular files and symlinks.  Zero for directories, whose sizes depend
+	// on the history of the underlying file system.
+	Size int64
+
+	// Mtime is the modification time of the entry.  Only set when the entry's timestamps were
+	// explicitly changed, because timestamps set by the system differ across trees.
+	Mtime time.Time
+}
+
+// String formats the status for display.
+func (s *Status) String() string {
+	if s == nil {
+		return "missing"
+	}
+	str := fmt.Sprintf("type=%v perm=%v size=%d", s.Type, s.Perm, s.Size)
+	if !s.Mtime.IsZero() {
+		str += fmt.Sprintf(" mtime=%d", s.Mtime.Unix())
+	}
+	return str
+}
+
+// lstatus returns the status of the entry at path, or nil if it does not exist.  The modification
+// time is only recorded if withMtime is true and the entry is not a symlink, as utimes follows
+// symlinks and never changes their own timestamps.
+func lstatus(path string, withMtime bool) *Status {
+	info, err := os.Lstat(path)
+	if err != nil {
+		return nil
+	}
+	status := &Status{Type: info.Mode() & os.ModeType, Perm: info.Mode() & os.ModePerm}
+	if !info.IsDir() {
+		status.Size = info.Size()
+	}
+	if withMtime && info.Mode()&os.ModeSymlink == 0 {
+		status.Mtime = info.ModTime()
+	}
+	return status
+}
+
+// Outcome represents the observable result of applying an operation to a tree.
+type Outcome struct {
+	// Errno is the error returned by the operation, or 0 if it succeeded.
+	Errno syscall.Errno
+
+	// Status is the status of the entry affected by the operation once it completed.
+	Status *Status
+}
+
+// String formats the outcome for display.
+func (o Outcome) String() string {
+	result := "ok"
+	if o.Errno != 0 {
+		result = fmt.Sprintf("%s (%d)", unix.ErrnoName(o.Errno), int(o.Errno))
+	}
+	return fmt.Sprintf("%s, then %s", result, o.Status)
+}
+
+// errnoOf extracts the error number from the error of an operation.
+func errnoOf(err error) syscall.Errno {
+	if err == nil {
+		return 0
+	}
+	if errno, ok := err.(syscall.Errno); ok {
+		return errno
+	}
+	if pathErr, ok := err.(*os.PathError); ok {
+		return errnoOf(pathErr.Err)
+	}
+	panic(fmt.Sprintf("operation returned a non-errno error: %v", err))
+}
+
+// Entry represents the full contents of a file system entry for the comparison of whole trees.
+type Entry struct {
+	// Status contains the properties of the entry.
+	Status
+
+	// Contents holds the contents of regular files and the targets of symlinks.
+	Contents string
+
+	// Xattrs maps the names of the user extended attributes of the entry to their values.
+	Xattrs map[string]string
+}
+
+// String formats the entry for display.
+func (e *Entry) String() string {
+	if e == nil {
+		return "missing"
+	}
+	return fmt.Sprintf("%s contents=%q xattrs=%v", &e.Status, e.Contents, e.Xattrs)
+}
+
+// readXattrs returns the user extended attributes of the entry at path.  Failures are ignored
+// because they cannot be compared meaningfully: both trees fail to list attributes if the
+// underlying file system does not support them.
+func readXattrs(path string) map[string]string {
+	buf := make([]byte, 4096)
+	size, err := unix.Llistxattr(path, buf)
+	if err != nil {
+		return nil
+	}
+	xattrs := make(map[string]string)
+	for _, name := range strings.Split(string(buf[:size]), "\x00") {
+		if !strings.HasPrefix(name, "user.") {
+			continue
+		}
+		value := make([]byte, 4096)
+		if size, err := unix.Lgetxattr(path, name, value); err == nil {
+			xattrs[name] = string(value[:size])
+		}
+	}
+	if len(xattrs) == 0 {
+		return nil
+	}
+	return xattrs
+}
+
+// Snapshot returns the full contents of the tree rooted at root, keyed by the relative paths of
+// the entries.
+func Snapshot(root string) (map[string]*Entry, error) {
+	entries := make(map[string]*Entry)
+	walker := func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if path == root {
+			return nil
+		}
+		relative, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+
+		status := lstatus(path, false)
+		if status == nil {
+			return fmt.Errorf("%s vanished while taking the snapshot", relative)
+		}
+		entry := &Entry{Status: *status, Xattrs: readXattrs(path)}
+		switch info.Mode() & os.ModeType {
+		case 0:
+			contents, err := ioutil.ReadFile(path)
+			if err != nil {
+				return err
+			}
+			entry.Contents = string(contents)
+		case os.ModeSymlink:
+			target, err := os.Readlink(path)
+			if err != nil {
+				return err
+			}
+			entry.Contents = target
+		}
+		entries[relative] = entry
+		return nil
+	}
+	if err := filepath.Walk(root, walker); err != nil {
+		return nil, fmt.Errorf("cannot snapshot %s: %v", root, err)
+	}
+	return entries, nil
+}
+
+// diffSnapshots compares two snapshots and returns the sorted paths whose entries differ.
+func diffSnapshots(want map[string]*Entry, got map[string]*Entry) []string {
+	var paths []string
+	for path, wantEntry := range want {
+		if gotEntry, ok := got[path]; !ok || !reflect.DeepEqual(gotEntry, wantEntry) {
+			paths = append(paths, path)
+		}
+	}
+	for path := range got {
+		if _, ok := want[path]; !ok {
+			paths = append(paths, path)
+		}
+	}
+	sort.Strings(paths)
+	return paths
+}
@@ -0,0 +1,77 @@
+// Copyright 2020 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not
+// use this file except in compliance with the License.  You may obtain a copy
+// of the License at:
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.  See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package fsdiff
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestSnapshot(t *testing.T) {
+	dir, err := ioutil.TempDir("", "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	if err := os.Mkdir(filepath.Join(dir, "dir"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chmod(filepath.Join(dir, "dir"), 0750); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "dir/file"), []byte("contents"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chmod(filepath.Join(dir, "dir/file"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("dir/file", filepath.Join(dir, "link")); err != nil {
+		t.Fatal(err)
+	}
+
+	snapshot, err := Snapshot(dir)
+	if err != nil {
+		t.Fatalf("Snapshot failed: %v", err)
+	}
+	want := map[string]*Entry{
+		"dir":      {Status: Status{Type: os.ModeDir, Perm: 0750}},
+		"dir/file": {Status: Status{Perm: 0600, Size: 8}, Contents: "contents"},
+		"link":     {Status: Status{Type: os.ModeSymlink, Perm: 0777, Size: 8}, Contents: "dir/file"},
+	}
+	if !reflect.DeepEqual(snapshot, want) {
+		t.Errorf("Got %v; want %v", snapshot, want)
+	}
+}
+
+func TestDiffSnapshots(t *testing.T) {
+	want := map[string]*Entry{
+		"same":    {Contents: "a"},
+		"changed": {Contents: "b"},
+		"missing": {},
+	}
+	got := map[string]*Entry{
+		"same":    {Contents: "a"},
+		"changed": {Contents: "c"},
+		"extra":   {},
+	}
+	wantPaths := []string{"changed", "extra", "missing"}
+	if paths := diffSnapshots(want, got); !reflect.DeepEqual(paths, wantPaths) {
+		t.Errorf("Got %v; want %v", paths, wantPaths)
+	}
+}
@@ -24,6 +24,8 @@ import (
 )
 
 func TestLayout_MountPointDoesNotExist(t *testing.T) {
+	t.Parallel()
+
 	tempDir, err := ioutil.TempDir("", "test")
 	if err != nil {
 		t.Fatalf("Failed to create temporary directory: %v", err)
@@ -46,6 +48,8 @@ func TestLayout_MountPointDoesNotExist(t *testing.T) {
 }
 
 func TestLayout_RootMustBeDirectory(t *testing.T) {
+	t.Parallel()
+
 	tempDir, err := ioutil.TempDir("", "test")
 	if err != nil {
 		t.Fatalf("Failed to create temporary directory: %v", err)
@@ -70,6 +74,8 @@ func TestLayout_RootMustBeDirectory(t *testing.T) {
 }
 
 func TestLayout_TargetDoesNotExist(t *testing.T) {
+	t.Parallel()
+
 	wantStderr := "Failed to map root: stat failed .*/non-existent"
 
 	stdout, stderr, err := utils.RunAndWait(1, "--mapping=ro:/:/non-existent", "irrelevant-mount-point")
@@ -85,6 +91,8 @@ func TestLayout_TargetDoesNotExist(t *testing.T) {
 }
 
 func TestLayout_DuplicateMapping(t *testing.T) {
+	t.Parallel()
+
 	tempDir, err := ioutil.TempDir("", "test")
 	if err != nil {
 		t.Fatalf("Failed to create temporary directory: %v", err)
@@ -111,6 +119,8 @@ func TestLayout_DuplicateMapping(t *testing.T) {
 }
 
 func TestLayout_TargetIsScaffoldDirectory(t *testing.T) {
+	t.Parallel()
+
 	tempDir, err := ioutil.TempDir("", "test")
 	if err != nil {
 		t.Fatalf("Failed to create temporary directory: %v", err)
@@ -0,0 +1,23 @@
+// Copyright 2020 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not
+// use this file except in compliance with the License.  You may obtain a copy
+// of the License at:
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.  See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+// Package leaks provides support code to detect resources leaked by a running sandboxfs instance.
+//
+// The tests take snapshots of the file descriptors, threads and memory of the sandboxfs process,
+// plus the number of requests waiting in its FUSE connection, and compare them against a baseline
+// taken when the process was idle.
+//
+// The information is gathered from the /proc and fusectl file systems, so this package is only
+// functional on Linux.
+package leaks
@@ -0,0 +1,194 @@
+// Copyright 2020 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not
+// use this file except in compliance with the License.  You may obtain a copy
+// of the License at:
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.  See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package leaks
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Snapshot represents the resources held by a process at a point in time.
+type Snapshot struct {
+	// Label describes the point in time at which the snapshot was taken, such as "setup".
+	Label string
+
+	// FDs maps the open file descriptors of the process to the targets they point to.
+	FDs map[int]string
+
+	// Threads is the number of threads in the process.
+	Threads int
+
+	// RSSKB is the resident set size of the process in kilobytes.
+	RSSKB int64
+
+	// FUSEWaiting is the number of requests waiting to be answered in the FUSE connection
+	// served by the process, or -1 if unknown.
+	FUSEWaiting int
+}
+
+// String formats the snapshot for display.
+func (s *Snapshot) String() string {
+	waiting := "unknown"
+	if s.FUSEWaiting >= 0 {
+		waiting = strconv.Itoa(s.FUSEWaiting)
+	}
+	return fmt.Sprintf("%s: %d fds, %d threads, VmRSS %d kB, %s FUSE requests waiting", s.Label, len(s.FDs), s.Threads, s.RSSKB, waiting)
+}
+
+// ParseStatus extracts the number of threads and the resident set size in kilobytes from the
+// contents of a /proc/<pid>/status file.
+func ParseStatus(r io.Reader) (int, int64, error) {
+	threads := -1
+	var rss int64 = -1
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 {
+			continue
+		}
+		switch fields[0] {
+		case "Threads:":
+			value, err := strconv.Atoi(fields[1])
+			if err != nil {
+				return 0, 0, fmt.Errorf("invalid Threads value %s: %v", fields[1], err)
+			}
+			threads = value
+		case "VmRSS:":
+			value, err := strconv.ParseInt(fields[1], 10, 64)
+			if err != nil {
+				return 0, 0, fmt.Errorf("invalid VmRSS value %s: %v", fields[1], err)
+			}
+			rss = value
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return 0, 0, err
+	}
+	if threads == -1 || rss == -1 {
+		return 0, 0, fmt.Errorf("missing Threads or VmRSS in status file")
+	}
+	return threads, rss, nil
+}
+
+// readFDs returns the open file descriptors listed in the given /proc/<pid>/fd directory and the
+// targets they point to.
+func readFDs(fdDir string) (map[int]string, error) {
+	entries, err := ioutil.ReadDir(fdDir)
+	if err != nil {
+		return nil, err
+	}
+	fds := make(map[int]string, len(entries))
+	for _, entry := range entries {
+		fd, err := strconv.Atoi(entry.Name())
+		if err != nil {
+			continue
+		}
+		target, err := os.Readlink(filepath.Join(fdDir, entry.Name()))
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue // Closed while we were reading the directory.
+			}
+			return nil, err
+		}
+		fds[fd] = target
+	}
+	return fds, nil
+}
+
+// readWaiting returns the number of requests waiting in the given FUSE connection, as exposed by
+// the fusectl file system mounted at fusectlRoot.
+func readWaiting(fusectlRoot string, connection int) (int, error) {
+	content, err := ioutil.ReadFile(filepath.Join(fusectlRoot, strconv.Itoa(connection), "waiting"))
+	if err != nil {
+		return 0, err
+	}
+	return strconv.Atoi(strings.TrimSpace(string(content)))
+}
+
+// Take takes a snapshot of the resources held by the process pid, as listed by the proc file
+// system mounted at procRoot.
+//
+// connection is the identifier of the FUSE connection served by the process, or -1 if unknown.
+// The number of waiting requests is only recorded if the connection is known and the fusectl file
+// system is mounted at fusectlRoot; otherwise, it is set to -1.
+func Take(label string, procRoot string, fusectlRoot string, pid int, connection int) (*Snapshot, error) {
+	procDir := filepath.Join(procRoot, strconv.Itoa(pid))
+
+	fds, err := readFDs(filepath.Join(procDir, "fd"))
+	if err != nil {
+		return nil, fmt.Errorf("cannot read file descriptors of process %d: %v", pid, err)
+	}
+
+	status, err := os.Open(filepath.Join(procDir, "status"))
+	if err != nil {
+		return nil, fmt.Errorf("cannot read status of process %d: %v", pid, err)
+	}
+	defer status.Close()
+	threads, rss, err := ParseStatus(status)
+	if err != nil {
+		return nil, fmt.Errorf("cannot parse status of process %d: %v", pid, err)
+	}
+
+	waiting := -1
+	if connection >= 0 {
+		if value, err := readWaiting(fusectlRoot, connection); err == nil {
+			waiting = value
+		}
+	}
+
+	return &Snapshot{
+		Label:       label,
+		FDs:         fds,
+		Threads:     threads,
+		RSSKB:       rss,
+		FUSEWaiting: waiting,
+	}, nil
+}
+
+// Diff compares a snapshot against a baseline and returns a description of every resource that
+// leaked: file descriptors that were not open in the baseline, threads beyond those in the
+// baseline, and FUSE requests that are still waiting.  Memory usage is not compared because it
+// fluctuates too much to be a reliable signal; it is only reported by String.
+func Diff(baseline *Snapshot, current *Snapshot) []string {
+	var problems []string
+
+	var fds []int
+	for fd, target := range current.FDs {
+		if baseTarget, ok := baseline.FDs[fd]; !ok || baseTarget != target {
+			fds = append(fds, fd)
+		}
+	}
+	sort.Ints(fds)
+	for _, fd := range fds {
+		problems = append(problems, fmt.Sprintf("fd %d (%s) was not open at %s", fd, current.FDs[fd], baseline.Label))
+	}
+
+	if current.Threads > baseline.Threads {
+		problems = append(problems, fmt.Sprintf("thread count grew from %d at %s to %d", baseline.Threads, baseline.Label, current.Threads))
+	}
+
+	if current.FUSEWaiting > 0 {
+		problems = append(problems, fmt.Sprintf("%d FUSE requests still waiting", current.FUSEWaiting))
+	}
+
+	return problems
+}
@@ -0,0 +1,231 @@
+// Copyright 2020 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not
+// use this file except in compliance with the License.  You may obtain a copy
+// of the License at:
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.  See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package leaks
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// sampleStatus contains an excerpt of a real /proc/<pid>/status file.
+const sampleStatus = `Name:	sandboxfs
+State:	S (sleeping)
+Pid:	1234
+VmPeak:	  123456 kB
+VmRSS:	    5678 kB
+Threads:	4
+SigQ:	0/12345
+`
+
+func TestParseStatus(t *testing.T) {
+	threads, rss, err := ParseStatus(strings.NewReader(sampleStatus))
+	if err != nil {
+		t.Fatalf("ParseStatus failed: %v", err)
+	}
+	if threads != 4 {
+		t.Errorf("Got %d threads; want 4", threads)
+	}
+	if rss != 5678 {
+		t.Errorf("Got VmRSS %d; want 5678", rss)
+	}
+}
+
+func TestParseStatus_Errors(t *testing.T) {
+	testData := []struct {
+		name string
+
+		status    string
+		wantError string
+	}{
+		{"Empty", "", "missing"},
+		{"NoThreads", "VmRSS:	1 kB\n", "missing"},
+		{"NoVmRSS", "Threads:	1\n", "missing"},
+		{"BadThreads", "Threads:	x\nVmRSS:	1 kB\n", "invalid Threads"},
+		{"BadVmRSS", "Threads:	1\nVmRSS:	x kB\n", "invalid VmRSS"},
+	}
+	for _, d := range testData {
+		t.Run(d.name, func(t *testing.T) {
+			_, _, err := ParseStatus(strings.NewReader(d.status))
+			if err == nil || !strings.Contains(err.Error(), d.wantError) {
+				t.Errorf("Got error %v; want it to contain %s", err, d.wantError)
+			}
+		})
+	}
+}
+
+// fakeProcess creates the files of a process in a fake proc file system rooted at procRoot.
+func fakeProcess(t *testing.T, procRoot string, pid string, status string, fds map[string]string) {
+	t.Helper()
+
+	procDir := filepath.Join(procRoot, pid)
+	if err := os.MkdirAll(filepath.Join(procDir, "fd"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(procDir, "status"), []byte(status), 0644); err != nil {
+		t.Fatal(err)
+	}
+	for fd, target := range fds {
+		if err := os.Symlink(target, filepath.Join(procDir, "fd", fd)); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestTake(t *testing.T) {
+	dir, err := ioutil.TempDir("", "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	procRoot := filepath.Join(dir, "proc")
+	fusectlRoot := filepath.Join(dir, "fusectl")
+	fakeProcess(t, procRoot, "1234", sampleStatus, map[string]string{
+		"0": "/dev/null",
+		"3": "/dev/fuse",
+		"7": "/tmp/root/file (deleted)",
+	})
+	if err := os.MkdirAll(filepath.Join(fusectlRoot, "42"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(fusectlRoot, "42", "waiting"), []byte("2\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	testData := []struct {
+		name string
+
+		connection  int
+		wantWaiting int
+	}{
+		{"KnownConnection", 42, 2},
+		{"UnknownConnection", -1, -1},
+		{"MissingConnection", 43, -1},
+	}
+	for _, d := range testData {
+		t.Run(d.name, func(t *testing.T) {
+			snapshot, err := Take("label", procRoot, fusectlRoot, 1234, d.connection)
+			if err != nil {
+				t.Fatalf("Take failed: %v", err)
+			}
+			want := &Snapshot{
+				Label: "label",
+				FDs: map[int]string{
+					0: "/dev/null",
+					3: "/dev/fuse",
+					7: "/tmp/root/file (deleted)",
+				},
+				Threads:     4,
+				RSSKB:       5678,
+				FUSEWaiting: d.wantWaiting,
+			}
+			if !reflect.DeepEqual(snapshot, want) {
+				t.Errorf("Got %+v; want %+v", snapshot, want)
+			}
+		})
+	}
+}
+
+func TestTake_MissingProcess(t *testing.T) {
+	dir, err := ioutil.TempDir("", "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	if _, err := Take("label", dir, dir, 1234, -1); err == nil || !strings.Contains(err.Error(), "process 1234") {
+		t.Errorf("Got error %v; want it to mention process 1234", err)
+	}
+}
+
+func TestSnapshot_String(t *testing.T) {
+	testData := []struct {
+		snapshot Snapshot
+		want     string
+	}{
+		{
+			Snapshot{"setup", map[int]string{0: "a", 1: "b"}, 3, 100, 0},
+			"setup: 2 fds, 3 threads, VmRSS 100 kB, 0 FUSE requests waiting",
+		},
+		{
+			Snapshot{"teardown", map[int]string{}, 1, 50, -1},
+			"teardown: 0 fds, 1 threads, VmRSS 50 kB, unknown FUSE requests waiting",
+		},
+	}
+	for _, d := range testData {
+		if got := d.snapshot.String(); got != d.want {
+			t.Errorf("Got %s; want %s", got, d.want)
+		}
+	}
+}
+
+func TestDiff(t *testing.T) {
+	baseline := &Snapshot{
+		Label:       "setup",
+		FDs:         map[int]string{0: "/dev/null", 3: "/dev/fuse"},
+		Threads:     4,
+		RSSKB:       100,
+		FUSEWaiting: 0,
+	}
+
+	testData := []struct {
+		name string
+
+		current *Snapshot
+		want    []string
+	}{
+		{
+			"NoLeaks",
+			&Snapshot{Label: "check", FDs: map[int]string{0: "/dev/null", 3: "/dev/fuse"}, Threads: 4, RSSKB: 200, FUSEWaiting: 0},
+			nil,
+		},
+		{
+			"FewerResources",
+			&Snapshot{Label: "check", FDs: map[int]string{3: "/dev/fuse"}, Threads: 2, RSSKB: 50, FUSEWaiting: -1},
+			nil,
+		},
+		{
+			"Leaks",
+			&Snapshot{
+				Label:       "check",
+				FDs:         map[int]string{0: "/dev/null", 3: "/dev/fuse", 9: "/b", 7: "/a (deleted)"},
+				Threads:     6,
+				FUSEWaiting: 1,
+			},
+			[]string{
+				"fd 7 (/a (deleted)) was not open at setup",
+				"fd 9 (/b) was not open at setup",
+				"thread count grew from 4 at setup to 6",
+				"1 FUSE requests still waiting",
+			},
+		},
+		{
+			"ReusedFD",
+			&Snapshot{Label: "check", FDs: map[int]string{0: "/dev/null", 3: "/c"}, Threads: 4},
+			[]string{"fd 3 (/c) was not open at setup"},
+		},
+	}
+	for _, d := range testData {
+		t.Run(d.name, func(t *testing.T) {
+			if got := Diff(baseline, d.current); !reflect.DeepEqual(got, d.want) {
+				t.Errorf("Got %q; want %q", got, d.want)
+			}
+		})
+	}
+}
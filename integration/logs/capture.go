@@ -0,0 +1,179 @@
+// Copyright 2020 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not
+// use this file except in compliance with the License.  You may obtain a copy
+// of the License at:
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.  See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package logs
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"regexp"
+	"sync"
+)
+
+// expectation represents a record that must appear in the logs.
+type expectation struct {
+	// level is the exact level of the wanted record.
+	level Level
+
+	// pattern is the regexp that the message of the wanted record must match.
+	pattern *regexp.Regexp
+}
+
+// matches returns true if the given record satisfies the expectation.
+func (e *expectation) matches(record Record) bool {
+	return record.Level == e.level && e.pattern.MatchString(record.Message)
+}
+
+// Capture is an io.Writer that parses the env_logger output written to it into records, and that
+// verifies those records against a set of expectations.  The output is also forwarded verbatim to
+// another writer, if any.
+//
+// Capture is safe for concurrent use.
+type Capture struct {
+	// mu protects all other fields.
+	mu sync.Mutex
+
+	// out is the writer to forward the output to, or nil to discard it.
+	out io.Writer
+
+	// partial contains the last line written so far if it was not yet terminated.
+	partial []byte
+
+	// records contains the records parsed so far.
+	records []Record
+
+	// expectations contains the records that must appear in the logs.
+	expectations []expectation
+
+	// forbidden is the least severe level for which records must not appear in the logs, unless
+	// they match an expectation.  Zero if no records are forbidden.
+	forbidden Level
+}
+
+// NewCapture creates a new capture that forwards its output to out, which may be nil.
+func NewCapture(out io.Writer) *Capture {
+	return &Capture{out: out}
+}
+
+// addLine parses a complete line of output and records it.
+func (c *Capture) addLine(line string) {
+	if record, ok := ParseLine(line); ok {
+		c.records = append(c.records, record)
+		return
+	}
+	if len(c.records) > 0 && line != "" {
+		last := &c.records[len(c.records)-1]
+		last.Message += "\n" + line
+	}
+}
+
+// Write parses the given output and forwards it to the underlying writer.
+func (c *Capture) Write(p []byte) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.partial = append(c.partial, p...)
+	for {
+		i := bytes.IndexByte(c.partial, '\n')
+		if i == -1 {
+			break
+		}
+		c.addLine(string(c.partial[:i]))
+		c.partial = c.partial[i+1:]
+	}
+
+	if c.out != nil {
+		return c.out.Write(p)
+	}
+	return len(p), nil
+}
+
+// Flush processes the last line of output even if it was not terminated.  Must be called once the
+// writer of the output is gone.
+func (c *Capture) Flush() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if len(c.partial) > 0 {
+		c.addLine(string(c.partial))
+		c.partial = nil
+	}
+}
+
+// Records returns a copy of the records captured so far.
+func (c *Capture) Records() []Record {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	records := make([]Record, len(c.records))
+	copy(records, c.records)
+	return records
+}
+
+// Expect registers that a record with the given level and a message matching the given regexp
+// must appear in the logs.  Panics if the pattern is invalid.
+func (c *Capture) Expect(level Level, pattern string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.expectations = append(c.expectations, expectation{level: level, pattern: regexp.MustCompile(pattern)})
+}
+
+// Forbid registers that no records with the given level, or with a more severe one, can appear in
+// the logs unless they match an expectation.  Calling this more than once keeps the least severe
+// of the given levels.
+func (c *Capture) Forbid(level Level) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if level > c.forbidden {
+		c.forbidden = level
+	}
+}
+
+// Verify checks the captured records against the registered expectations and returns one error
+// for every unmet expectation and for every forbidden record.
+func (c *Capture) Verify() []error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var errs []error
+	for _, e := range c.expectations {
+		found := false
+		for _, record := range c.records {
+			if e.matches(record) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			errs = append(errs, fmt.Errorf("no %s log record matches %s", e.level, e.pattern))
+		}
+	}
+
+Records:
+	for _, record := range c.records {
+		if record.Level > c.forbidden {
+			continue
+		}
+		for _, e := range c.expectations {
+			if e.matches(record) {
+				continue Records
+			}
+		}
+		errs = append(errs, fmt.Errorf("unexpected log record: %s", record))
+	}
+	return errs
+}
@@ -0,0 +1,165 @@
+// Copyright 2020 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not
+// use this file except in compliance with the License.  You may obtain a copy
+// of the License at:
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.  See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package logs
+
+import (
+	"bytes"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// sampleOutput contains the output of a fictitious sandboxfs run.
+const sampleOutput = `[2020-01-02T03:04:05Z INFO  sandboxfs] Mounting file system onto "/mnt"
+[2020-01-02T03:04:06Z WARN  sandboxfs::concurrent] Unmounting file system failed with 'busy'; will retry in 1s
+thread 'main' panicked at 'oops'
+note: run with RUST_BACKTRACE=1
+[2020-01-02T03:04:07Z INFO  sandboxfs] Caught signal 2`
+
+// writeInChunks writes the given output to w in small pieces that split lines.
+func writeInChunks(t *testing.T, w io.Writer, output string) {
+	t.Helper()
+
+	for len(output) > 0 {
+		n := 7
+		if n > len(output) {
+			n = len(output)
+		}
+		if _, err := w.Write([]byte(output[:n])); err != nil {
+			t.Fatalf("Write failed: %v", err)
+		}
+		output = output[n:]
+	}
+}
+
+// messages extracts the messages of the given records.
+func messages(records []Record) []string {
+	var messages []string
+	for _, record := range records {
+		messages = append(messages, record.Message)
+	}
+	return messages
+}
+
+func TestCapture_Records(t *testing.T) {
+	var out bytes.Buffer
+	capture := NewCapture(&out)
+	writeInChunks(t, capture, sampleOutput)
+
+	want := []string{
+		`Mounting file system onto "/mnt"`,
+		"Unmounting file system failed with 'busy'; will retry in 1s\nthread 'main' panicked at 'oops'\nnote: run with RUST_BACKTRACE=1",
+	}
+	if got := messages(capture.Records()); !reflect.DeepEqual(got, want) {
+		t.Errorf("Got %q before Flush; want %q", got, want)
+	}
+
+	capture.Flush()
+	want = append(want, "Caught signal 2")
+	if got := messages(capture.Records()); !reflect.DeepEqual(got, want) {
+		t.Errorf("Got %q after Flush; want %q", got, want)
+	}
+
+	if out.String() != sampleOutput {
+		t.Errorf("Got forwarded output %q; want %q", out.String(), sampleOutput)
+	}
+}
+
+func TestCapture_NilWriter(t *testing.T) {
+	capture := NewCapture(nil)
+	writeInChunks(t, capture, sampleOutput+"\n")
+	if got := len(capture.Records()); got != 3 {
+		t.Errorf("Got %d records; want 3", got)
+	}
+}
+
+func TestCapture_Verify(t *testing.T) {
+	testData := []struct {
+		name string
+
+		setup      func(*Capture)
+		wantErrors []string
+	}{
+		{"Nothing", func(c *Capture) {}, nil},
+		{
+			"ExpectMet",
+			func(c *Capture) {
+				c.Expect(Info, "Caught signal [0-9]+")
+				c.Expect(Warn, "will retry")
+			},
+			nil,
+		},
+		{
+			"ExpectWrongLevel",
+			func(c *Capture) { c.Expect(Error, "will retry") },
+			[]string{"no ERROR log record matches will retry"},
+		},
+		{
+			"ExpectUnmet",
+			func(c *Capture) { c.Expect(Info, "^Unmounting") },
+			[]string{"no INFO log record matches ^Unmounting"},
+		},
+		{
+			"ForbidWarn",
+			func(c *Capture) { c.Forbid(Warn) },
+			[]string{"unexpected log record: WARN sandboxfs::concurrent: Unmounting"},
+		},
+		{
+			"ForbidError",
+			func(c *Capture) { c.Forbid(Error) },
+			nil,
+		},
+		{
+			"ForbidInfo",
+			func(c *Capture) {
+				c.Forbid(Error)
+				c.Forbid(Info)
+				c.Forbid(Warn)
+			},
+			[]string{
+				"unexpected log record: INFO sandboxfs: Mounting",
+				"unexpected log record: WARN sandboxfs::concurrent: Unmounting",
+				"unexpected log record: INFO sandboxfs: Caught signal 2",
+			},
+		},
+		{
+			"ForbidExceptExpected",
+			func(c *Capture) {
+				c.Forbid(Warn)
+				c.Expect(Warn, "Unmounting.*failed")
+			},
+			nil,
+		},
+	}
+	for _, d := range testData {
+		t.Run(d.name, func(t *testing.T) {
+			capture := NewCapture(nil)
+			d.setup(capture)
+			writeInChunks(t, capture, sampleOutput)
+			capture.Flush()
+
+			errs := capture.Verify()
+			if len(errs) != len(d.wantErrors) {
+				t.Fatalf("Got errors %v; want %d errors", errs, len(d.wantErrors))
+			}
+			for i, err := range errs {
+				if !strings.HasPrefix(err.Error(), d.wantErrors[i]) {
+					t.Errorf("Got error %v; want it to start with %s", err, d.wantErrors[i])
+				}
+			}
+		})
+	}
+}
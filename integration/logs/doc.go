@@ -0,0 +1,21 @@
+// Copyright 2020 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not
+// use this file except in compliance with the License.  You may obtain a copy
+// of the License at:
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.  See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+// Package logs provides support code to capture and inspect the logs that sandboxfs writes to
+// stderr via the env_logger crate.
+//
+// The env_logger output is meant for humans, so this package parses it on a best-effort basis:
+// lines that do not look like the header of a log record, such as those in panic messages, are
+// considered continuations of the previous record.
+package logs
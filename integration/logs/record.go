@@ -0,0 +1,117 @@
+// Copyright 2020 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not
+// use this file except in compliance with the License.  You may obtain a copy
+// of the License at:
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.  See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package logs
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Level represents the severity of a log record.  Lower values are more severe, which matches the
+// ordering of the levels in the log crate.
+type Level int
+
+// Valid levels.
+const (
+	Error Level = iota + 1
+	Warn
+	Info
+	Debug
+	Trace
+)
+
+// levelNames maps the names used by env_logger to levels.
+var levelNames = map[string]Level{
+	"ERROR": Error,
+	"WARN":  Warn,
+	"INFO":  Info,
+	"DEBUG": Debug,
+	"TRACE": Trace,
+}
+
+// String formats the level as env_logger does.
+func (l Level) String() string {
+	for name, level := range levelNames {
+		if level == l {
+			return name
+		}
+	}
+	return fmt.Sprintf("Level(%d)", int(l))
+}
+
+// Record represents a single log record.
+type Record struct {
+	// Level is the severity of the record.
+	Level Level
+
+	// Module is the path of the Rust module that emitted the record, such as
+	// "sandboxfs::concurrent".
+	Module string
+
+	// Message is the text of the record, which spans multiple lines if the record did.
+	Message string
+
+	// Timestamp is the time at which the record was emitted.  Zero if the header of the record
+	// did not include a timestamp or if it could not be parsed.
+	Timestamp time.Time
+}
+
+// String formats the record for display.
+func (r Record) String() string {
+	return fmt.Sprintf("%s %s: %s", r.Level, r.Module, r.Message)
+}
+
+// headerRegexps contains the formats of the header line of a record that env_logger emits by
+// default.  Older versions print the level first and the timestamp later, as in "INFO
+// 2020-01-02T03:04:05Z: module: message", while newer versions enclose the header in brackets, as
+// in "[2020-01-02T03:04:05Z INFO  module] message".
+//
+// Each regexp must provide the level, timestamp, module and message named groups.
+var headerRegexps = []*regexp.Regexp{
+	regexp.MustCompile(`^\s*(?P<level>ERROR|WARN|INFO|DEBUG|TRACE) (?P<timestamp>\S+): (?P<module>[^\s:]+(?:::[^\s:]+)*): (?P<message>.*)$`),
+	regexp.MustCompile(`^\[(?:(?P<timestamp>\S+) )?(?P<level>ERROR|WARN|INFO|DEBUG|TRACE)\s+(?P<module>\S+)\] (?P<message>.*)$`),
+}
+
+// ParseLine parses the header line of a log record.  Returns false if the line does not look like
+// the beginning of a record.
+func ParseLine(line string) (Record, bool) {
+	line = strings.TrimRight(line, "\r\n")
+	for _, re := range headerRegexps {
+		match := re.FindStringSubmatch(line)
+		if match == nil {
+			continue
+		}
+
+		var record Record
+		for i, name := range re.SubexpNames() {
+			switch name {
+			case "level":
+				record.Level = levelNames[match[i]]
+			case "module":
+				record.Module = match[i]
+			case "message":
+				record.Message = match[i]
+			case "timestamp":
+				if timestamp, err := time.Parse(time.RFC3339Nano, match[i]); err == nil {
+					record.Timestamp = timestamp
+				}
+			}
+		}
+		return record, true
+	}
+	return Record{}, false
+}
@@ -0,0 +1,103 @@
+// Copyright 2020 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not
+// use this file except in compliance with the License.  You may obtain a copy
+// of the License at:
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.  See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package logs
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseLine(t *testing.T) {
+	timestamp := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	testData := []struct {
+		name string
+
+		line       string
+		wantRecord Record
+		wantOk     bool
+	}{
+		{
+			"OldFormat",
+			" INFO 2020-01-02T03:04:05Z: sandboxfs: Mounting file system onto \"/mnt\"",
+			Record{Info, "sandboxfs", "Mounting file system onto \"/mnt\"", timestamp},
+			true,
+		},
+		{
+			"OldFormatNestedModule",
+			"WARN 2020-01-02T03:04:05Z: sandboxfs::concurrent: Failed to close fd 3: EBADF",
+			Record{Warn, "sandboxfs::concurrent", "Failed to close fd 3: EBADF", timestamp},
+			true,
+		},
+		{
+			"NewFormat",
+			"[2020-01-02T03:04:05Z ERROR sandboxfs::lib] Something: bad\n",
+			Record{Error, "sandboxfs::lib", "Something: bad", timestamp},
+			true,
+		},
+		{
+			"NewFormatPadding",
+			"[2020-01-02T03:04:05Z INFO  sandboxfs] Caught signal 2",
+			Record{Info, "sandboxfs", "Caught signal 2", timestamp},
+			true,
+		},
+		{
+			"NewFormatNoTimestamp",
+			"[DEBUG sandboxfs::nodes] Lookup foo",
+			Record{Debug, "sandboxfs::nodes", "Lookup foo", time.Time{}},
+			true,
+		},
+		{
+			"BadTimestamp",
+			"[yesterday TRACE sandboxfs] Message",
+			Record{Trace, "sandboxfs", "Message", time.Time{}},
+			true,
+		},
+		{"Empty", "", Record{}, false},
+		{"Panic", "thread 'main' panicked at 'oops', src/main.rs:1:1", Record{}, false},
+		{"UnknownLevel", "[2020-01-02T03:04:05Z FATAL sandboxfs] Message", Record{}, false},
+	}
+	for _, d := range testData {
+		t.Run(d.name, func(t *testing.T) {
+			record, ok := ParseLine(d.line)
+			if ok != d.wantOk {
+				t.Fatalf("Got ok %v; want %v", ok, d.wantOk)
+			}
+			if !reflect.DeepEqual(record, d.wantRecord) {
+				t.Errorf("Got %+v; want %+v", record, d.wantRecord)
+			}
+		})
+	}
+}
+
+func TestLevel_String(t *testing.T) {
+	testData := []struct {
+		level Level
+		want  string
+	}{
+		{Error, "ERROR"},
+		{Warn, "WARN"},
+		{Info, "INFO"},
+		{Debug, "DEBUG"},
+		{Trace, "TRACE"},
+		{Level(10), "Level(10)"},
+	}
+	for _, d := range testData {
+		if got := d.level.String(); got != d.want {
+			t.Errorf("Got %s; want %s", got, d.want)
+		}
+	}
+}
@@ -19,7 +19,9 @@ import (
 	"log"
 	"os"
 	"testing"
+	"time"
 
+	"github.com/bazelbuild/sandboxfs/integration/posix"
 	"github.com/bazelbuild/sandboxfs/integration/utils"
 )
 
@@ -27,15 +29,39 @@ var (
 	features         = flag.String("features", "", "Whitespace-separated list of features enabled during the build")
 	releaseBuild     = flag.Bool("release_build", true, "Whether the tested binary was built for release or not")
 	sandboxfsBinary  = flag.String("sandboxfs_binary", "", "Path to the sandboxfs binary to test; cannot be empty and must point to an existent binary")
-	unprivilegedUser = flag.String("unprivileged_user", "", "Username of the system user to use for tests that require non-root permissions; can be empty, in which case those tests are skipped")
+	timeScale        = flag.Float64("time_scale", 1.0, "Factor by which to multiply all timeouts; use values greater than 1 when sandboxfs runs slower than usual, such as under sanitizers or on loaded machines")
+	tortureDuration  = flag.Duration("torture_duration", 5*time.Second, "Time during which the torture tests keep issuing operations; increase to hunt for races")
+	updateGolden     = flag.Bool("update", false, "Whether to rewrite the golden files with the actual outputs of the tests instead of comparing against them")
+	unprivilegedUser = flag.String("unprivileged_user", "", "Username of the system user to use for tests that require non-root permissions; can be empty, in which case those tests are skipped unless --user_namespace is set")
+	userNamespace    = flag.Bool("user_namespace", false, "Whether to run the tests within a new user namespace in which the caller is root and synthetic users exist; requires newuidmap, newgidmap and subordinate IDs for the caller")
 )
 
 func TestMain(m *testing.M) {
+	// The POSIX conformance tests re-execute this binary to run operations as other users.
+	posix.HelperMain()
+
 	flag.Parse()
 	if len(*sandboxfsBinary) == 0 {
 		log.Fatalf("--sandboxfs_binary must be provided")
 	}
-	if err := utils.SetConfigFromFlags(*features, *releaseBuild, *sandboxfsBinary, *unprivilegedUser); err != nil {
+	if *userNamespace {
+		if err := utils.EnterUserNamespace(); err != nil {
+			log.Fatalf("cannot run tests within a user namespace: %v", err)
+		}
+		if *unprivilegedUser == "" {
+			*unprivilegedUser = utils.SyntheticUsername(1)
+		}
+	}
+	err := utils.SetConfigFromFlags(&utils.Flags{
+		Features:         *features,
+		ReleaseBinary:    *releaseBuild,
+		SandboxfsBinary:  *sandboxfsBinary,
+		UnprivilegedUser: *unprivilegedUser,
+		TimeScale:        *timeScale,
+		TortureDuration:  *tortureDuration,
+		UpdateGolden:     *updateGolden,
+	})
+	if err != nil {
 		log.Fatalf("invalid flags configuration: %v", err)
 	}
 
@@ -0,0 +1,233 @@
+// Copyright 2020 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not
+// use this file except in compliance with the License.  You may obtain a copy
+// of the License at:
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.  See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package mounts
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+// fuseProcess represents a process that holds an open file descriptor to /dev/fuse.
+type fuseProcess struct {
+	// PID is the process identifier.
+	PID int
+
+	// Args contains the command line of the process.
+	Args []string
+
+	// Cwd is the working directory of the process, used to resolve relative arguments.
+	Cwd string
+}
+
+// holdsFUSE returns true if the process whose directory in the proc file system is procDir has an
+// open file descriptor to /dev/fuse.
+func holdsFUSE(procDir string) bool {
+	fdDir := filepath.Join(procDir, "fd")
+	fds, err := ioutil.ReadDir(fdDir)
+	if err != nil {
+		return false // The process is gone or we have no access to it.
+	}
+	for _, fd := range fds {
+		if target, err := os.Readlink(filepath.Join(fdDir, fd.Name())); err == nil && target == "/dev/fuse" {
+			return true
+		}
+	}
+	return false
+}
+
+// findFUSEProcesses returns all processes that hold an open file descriptor to /dev/fuse, as
+// listed by the proc file system mounted at procRoot.
+func findFUSEProcesses(procRoot string) ([]fuseProcess, error) {
+	entries, err := ioutil.ReadDir(procRoot)
+	if err != nil {
+		return nil, err
+	}
+
+	var processes []fuseProcess
+	for _, entry := range entries {
+		pid, err := strconv.Atoi(entry.Name())
+		if err != nil {
+			continue
+		}
+		procDir := filepath.Join(procRoot, entry.Name())
+		if !holdsFUSE(procDir) {
+			continue
+		}
+
+		process := fuseProcess{PID: pid}
+		if cmdline, err := ioutil.ReadFile(filepath.Join(procDir, "cmdline")); err == nil {
+			for _, arg := range bytes.Split(bytes.TrimRight(cmdline, "\x00"), []byte{0}) {
+				process.Args = append(process.Args, string(arg))
+			}
+		}
+		process.Cwd, _ = os.Readlink(filepath.Join(procDir, "cwd"))
+		processes = append(processes, process)
+	}
+	sort.Slice(processes, func(i, j int) bool { return processes[i].PID < processes[j].PID })
+	return processes, nil
+}
+
+// findOwner returns the process that serves the given mount point, or nil if unknown.
+//
+// The kernel does not expose which FUSE connection a file descriptor belongs to, so the owner is
+// identified as the process holding /dev/fuse whose arguments include the mount point, which is
+// how sandboxfs is invoked.
+func findOwner(mountPoint string, processes []fuseProcess) *fuseProcess {
+	for i, process := range processes {
+		if len(process.Args) < 2 {
+			continue
+		}
+		for _, arg := range process.Args[1:] {
+			if !filepath.IsAbs(arg) {
+				if process.Cwd == "" {
+					continue
+				}
+				arg = filepath.Join(process.Cwd, arg)
+			}
+			if filepath.Clean(arg) == mountPoint {
+				return &processes[i]
+			}
+		}
+	}
+	return nil
+}
+
+// Status describes whether a mount point answers requests.
+type Status string
+
+const (
+	// Responsive indicates that the file system answered a listing of its root.
+	Responsive Status = "responsive"
+
+	// Disconnected indicates that the process serving the file system is gone.
+	Disconnected Status = "disconnected"
+
+	// Hung indicates that the file system did not answer before the deadline.
+	Hung Status = "hung"
+
+	// Failed indicates that the file system answered with an unexpected error.
+	Failed Status = "failed"
+)
+
+// Probe lists the root of a mount point to check whether it is responsive and, if it is, returns
+// the names of the entries found there.
+//
+// A hung file system blocks the listing indefinitely, so the listing happens in the background and
+// is abandoned after timeout.  The goroutine doing the listing leaks in that case.
+func Probe(mountPoint string, timeout time.Duration) (Status, []string, error) {
+	type result struct {
+		names []string
+		err   error
+	}
+	done := make(chan result, 1)
+	go func() {
+		dir, err := os.Open(mountPoint)
+		if err != nil {
+			done <- result{err: err}
+			return
+		}
+		defer dir.Close()
+		names, err := dir.Readdirnames(-1)
+		done <- result{names: names, err: err}
+	}()
+
+	select {
+	case r := <-done:
+		switch {
+		case r.err == nil:
+			sort.Strings(r.names)
+			return Responsive, r.names, nil
+		case isErrno(r.err, syscall.ENOTCONN):
+			return Disconnected, nil, r.err
+		default:
+			return Failed, nil, r.err
+		}
+	case <-time.After(timeout):
+		return Hung, nil, nil
+	}
+}
+
+// isErrno returns true if err is the given system error or wraps it.
+func isErrno(err error, errno syscall.Errno) bool {
+	switch err := err.(type) {
+	case *os.PathError:
+		return err.Err == errno
+	case *os.SyscallError:
+		return err.Err == errno
+	default:
+		return err == errno
+	}
+}
+
+// Info describes a sandboxfs mount and the process that serves it.
+type Info struct {
+	// Mount is the entry of the mount in the mountinfo file.
+	Mount Mount `json:"mount"`
+
+	// PID is the identifier of the process serving the mount, or 0 if it could not be found.
+	PID int `json:"pid,omitempty"`
+
+	// Args contains the command line of the process serving the mount, if found.
+	Args []string `json:"args,omitempty"`
+
+	// Allow is the value of the --allow flag that sandboxfs was started with.
+	Allow string `json:"allow"`
+
+	// Status indicates whether the mount is responsive.
+	Status Status `json:"status"`
+
+	// Error contains the error returned by the file system when probing it, if any.
+	Error string `json:"error,omitempty"`
+
+	// Entries contains the names found at the root of the mount, which include the identifiers
+	// of the sandboxes, if the mount is responsive.
+	Entries []string `json:"entries,omitempty"`
+}
+
+// Inventory returns information about all sandboxfs mounts visible to the current process, as
+// listed by the proc file system mounted at procRoot.  Each mount is given timeout to answer.
+func Inventory(procRoot string, timeout time.Duration) ([]Info, error) {
+	mounts, err := SandboxfsMounts(procRoot)
+	if err != nil {
+		return nil, err
+	}
+	processes, err := findFUSEProcesses(procRoot)
+	if err != nil {
+		return nil, err
+	}
+
+	infos := make([]Info, 0, len(mounts))
+	for _, m := range mounts {
+		info := Info{Mount: m, Allow: m.AllowMode()}
+		if owner := findOwner(m.MountPoint, processes); owner != nil {
+			info.PID = owner.PID
+			info.Args = owner.Args
+		}
+		status, entries, err := Probe(m.MountPoint, timeout)
+		info.Status = status
+		info.Entries = entries
+		if err != nil {
+			info.Error = err.Error()
+		}
+		infos = append(infos, info)
+	}
+	return infos, nil
+}
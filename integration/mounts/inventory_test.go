@@ -0,0 +1,162 @@
+// Copyright 2020 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not
+// use this file except in compliance with the License.  You may obtain a copy
+// of the License at:
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.  See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package mounts
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeProcess describes a process to create in a fake proc file system.
+type fakeProcess struct {
+	pid  string
+	args []string
+	cwd  string
+	fds  []string
+}
+
+// setUpFakeProc creates a fake proc file system under dir with the given processes and mountinfo.
+func setUpFakeProc(t *testing.T, dir string, mountInfo string, processes []fakeProcess) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Join(dir, "self"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "self/mountinfo"), []byte(mountInfo), 0644); err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range processes {
+		procDir := filepath.Join(dir, p.pid)
+		if err := os.MkdirAll(filepath.Join(procDir, "fd"), 0755); err != nil {
+			t.Fatal(err)
+		}
+		cmdline := strings.Join(p.args, "\x00") + "\x00"
+		if err := ioutil.WriteFile(filepath.Join(procDir, "cmdline"), []byte(cmdline), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Symlink(p.cwd, filepath.Join(procDir, "cwd")); err != nil {
+			t.Fatal(err)
+		}
+		for i, target := range p.fds {
+			if err := os.Symlink(target, filepath.Join(procDir, "fd", string(rune('0'+i)))); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+}
+
+func TestFindFUSEProcessesAndOwner(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mounts")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	setUpFakeProc(t, dir, "", []fakeProcess{
+		{"10", []string{"sandboxfs", "--mapping=ro:/:/src", "/mnt/absolute"}, "/", []string{"/dev/null", "/dev/fuse"}},
+		{"20", []string{"sandboxfs", "--allow", "other", "relative"}, "/home/user", []string{"/dev/fuse"}},
+		{"30", []string{"bash"}, "/", []string{"/dev/tty"}},
+		{"self", []string{"ignored"}, "/", []string{"/dev/fuse"}},
+	})
+
+	processes, err := findFUSEProcesses(dir)
+	if err != nil {
+		t.Fatalf("Failed to find FUSE processes: %v", err)
+	}
+	var pids []int
+	for _, p := range processes {
+		pids = append(pids, p.PID)
+	}
+	if want := []int{10, 20}; !reflect.DeepEqual(pids, want) {
+		t.Fatalf("Got PIDs %v; want %v", pids, want)
+	}
+
+	testData := []struct {
+		mountPoint string
+		wantPID    int
+	}{
+		{"/mnt/absolute", 10},
+		{"/home/user/relative", 20},
+		{"/mnt/unknown", 0},
+	}
+	for _, d := range testData {
+		pid := 0
+		if owner := findOwner(d.mountPoint, processes); owner != nil {
+			pid = owner.PID
+		}
+		if pid != d.wantPID {
+			t.Errorf("Got owner %d for %s; want %d", pid, d.mountPoint, d.wantPID)
+		}
+	}
+}
+
+func TestProbe(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mounts")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	for _, name := range []string{"sandbox2", "sandbox1"} {
+		if err := os.Mkdir(filepath.Join(dir, name), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	status, entries, err := Probe(dir, time.Second)
+	if status != Responsive || err != nil {
+		t.Errorf("Got status %s and error %v; want %s", status, err, Responsive)
+	}
+	if want := []string{"sandbox1", "sandbox2"}; !reflect.DeepEqual(entries, want) {
+		t.Errorf("Got entries %v; want %v", entries, want)
+	}
+
+	status, _, err = Probe(filepath.Join(dir, "missing"), time.Second)
+	if status != Failed || !os.IsNotExist(err) {
+		t.Errorf("Got status %s and error %v; want %s and not found error", status, err, Failed)
+	}
+}
+
+func TestInventory(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mounts")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	mountPoint := filepath.Join(dir, "mnt")
+	if err := os.MkdirAll(filepath.Join(mountPoint, "sandbox"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	mountInfo := "1 0 0:1 / / rw - ext4 /dev/sda1 rw\n" +
+		"2 1 0:40 / " + mountPoint + " rw,nosuid - fuse sandboxfs rw,user_id=0,allow_other\n"
+	proc := filepath.Join(dir, "proc")
+	setUpFakeProc(t, proc, mountInfo, []fakeProcess{
+		{"42", []string{"sandboxfs", mountPoint}, "/", []string{"/dev/fuse"}},
+	})
+
+	infos, err := Inventory(proc, time.Second)
+	if err != nil {
+		t.Fatalf("Failed to take inventory: %v", err)
+	}
+	if len(infos) != 1 {
+		t.Fatalf("Got %d mounts; want 1", len(infos))
+	}
+	info := infos[0]
+	if info.Mount.MountPoint != mountPoint || info.PID != 42 || info.Allow != "other" || info.Status != Responsive || !reflect.DeepEqual(info.Entries, []string{"sandbox"}) {
+		t.Errorf("Got %+v; want mount at %s owned by 42, allowing other, responsive, with a sandbox", info, mountPoint)
+	}
+}
@@ -0,0 +1,199 @@
+// Copyright 2020 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not
+// use this file except in compliance with the License.  You may obtain a copy
+// of the License at:
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.  See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package mounts
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Mount represents an entry in a mountinfo file.  See proc(5) for details on the fields.
+type Mount struct {
+	// ID is the unique identifier of the mount.
+	ID int `json:"id"`
+
+	// ParentID is the identifier of the parent mount.
+	ParentID int `json:"parent_id"`
+
+	// Major and Minor are the device numbers of the file system.  For FUSE file systems, Minor
+	// is also the identifier of the connection in /sys/fs/fuse/connections.
+	Major int `json:"major"`
+	Minor int `json:"minor"`
+
+	// Root is the path within the file system that forms the root of the mount.
+	Root string `json:"root"`
+
+	// MountPoint is the path of the mount point relative to the reader's root.
+	MountPoint string `json:"mount_point"`
+
+	// Options contains the per-mount options.
+	Options []string `json:"options"`
+
+	// FSType is the type of the file system, such as "fuse".
+	FSType string `json:"fs_type"`
+
+	// Source is the file system specific source of the mount, which sandboxfs sets to its name.
+	Source string `json:"source"`
+
+	// SuperOptions contains the per-superblock options.
+	SuperOptions []string `json:"super_options"`
+}
+
+// IsFUSE returns true if the mount is served by a FUSE file system.
+func (m *Mount) IsFUSE() bool {
+	return m.FSType == "fuse" || m.FSType == "fuseblk" || strings.HasPrefix(m.FSType, "fuse.")
+}
+
+// IsSandboxfs returns true if the mount is served by sandboxfs.
+func (m *Mount) IsSandboxfs() bool {
+	return m.IsFUSE() && m.Source == "sandboxfs"
+}
+
+// ConnectionID returns the identifier of the FUSE connection of the mount, which names its
+// directory in the fusectl file system.  This is the kernel's encoding of the device number.
+func (m *Mount) ConnectionID() int {
+	return m.Major<<20 | m.Minor
+}
+
+// HasOption returns true if the given option, with or without a value, is present in either the
+// per-mount or the per-superblock options.
+func (m *Mount) HasOption(name string) bool {
+	for _, options := range [][]string{m.Options, m.SuperOptions} {
+		for _, option := range options {
+			if option == name || strings.HasPrefix(option, name+"=") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// AllowMode returns the value of the --allow flag of sandboxfs that yields the mount's options.
+func (m *Mount) AllowMode() string {
+	switch {
+	case m.HasOption("allow_other"):
+		return "other"
+	case m.HasOption("allow_root"):
+		return "root"
+	default:
+		return "self"
+	}
+}
+
+// unescape decodes the octal escape sequences that the kernel uses for whitespace and backslashes
+// in the paths of a mountinfo file.
+func unescape(s string) string {
+	if !strings.Contains(s, `\`) {
+		return s
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+3 < len(s) {
+			if value, err := strconv.ParseUint(s[i+1:i+4], 8, 8); err == nil {
+				b.WriteByte(byte(value))
+				i += 3
+				continue
+			}
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
+
+// parseMountInfoLine parses a single line of a mountinfo file.
+func parseMountInfoLine(line string) (Mount, error) {
+	fields := strings.Fields(line)
+	separator := -1
+	for i := 6; i < len(fields); i++ {
+		if fields[i] == "-" {
+			separator = i
+			break
+		}
+	}
+	if separator == -1 || len(fields) < separator+4 {
+		return Mount{}, fmt.Errorf("malformed entry %q", line)
+	}
+
+	var m Mount
+	var err error
+	if m.ID, err = strconv.Atoi(fields[0]); err != nil {
+		return Mount{}, fmt.Errorf("invalid mount ID in %q", line)
+	}
+	if m.ParentID, err = strconv.Atoi(fields[1]); err != nil {
+		return Mount{}, fmt.Errorf("invalid parent ID in %q", line)
+	}
+	if _, err := fmt.Sscanf(fields[2], "%d:%d", &m.Major, &m.Minor); err != nil {
+		return Mount{}, fmt.Errorf("invalid device number in %q", line)
+	}
+	m.Root = unescape(fields[3])
+	m.MountPoint = unescape(fields[4])
+	m.Options = strings.Split(fields[5], ",")
+	m.FSType = fields[separator+1]
+	m.Source = unescape(fields[separator+2])
+	m.SuperOptions = strings.Split(fields[separator+3], ",")
+	return m, nil
+}
+
+// ParseMountInfo parses the contents of a mountinfo file.
+func ParseMountInfo(r io.Reader) ([]Mount, error) {
+	var mounts []Mount
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		if strings.TrimSpace(scanner.Text()) == "" {
+			continue
+		}
+		m, err := parseMountInfoLine(scanner.Text())
+		if err != nil {
+			return nil, err
+		}
+		mounts = append(mounts, m)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return mounts, nil
+}
+
+// SandboxfsMounts returns the sandboxfs mounts visible to the current process, as listed by the
+// mountinfo file of the proc file system mounted at procRoot.
+func SandboxfsMounts(procRoot string) ([]Mount, error) {
+	return ListMounts(procRoot, (*Mount).IsSandboxfs)
+}
+
+// ListMounts returns the mounts visible to the current process that match filter, as listed by
+// the mountinfo file of the proc file system mounted at procRoot.
+func ListMounts(procRoot string, filter func(*Mount) bool) ([]Mount, error) {
+	file, err := os.Open(procRoot + "/self/mountinfo")
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	all, err := ParseMountInfo(file)
+	if err != nil {
+		return nil, fmt.Errorf("cannot parse %s: %v", file.Name(), err)
+	}
+	var mounts []Mount
+	for _, m := range all {
+		if filter(&m) {
+			mounts = append(mounts, m)
+		}
+	}
+	return mounts, nil
+}
@@ -0,0 +1,103 @@
+// Copyright 2020 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not
+// use this file except in compliance with the License.  You may obtain a copy
+// of the License at:
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.  See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package mounts
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseMountInfo(t *testing.T) {
+	input := `23 28 0:22 / /proc rw,relatime - proc proc rw
+140 28 0:52 / /tmp/with\040space rw,nosuid,nodev,relatime shared:77 - fuse sandboxfs rw,user_id=1000,group_id=1000,allow_other
+
+141 28 0:53 /sub /mnt/other rw,relatime master:1 shared:2 - fuse.sshfs host:/ rw,user_id=0,group_id=0
+`
+	mounts, err := ParseMountInfo(strings.NewReader(input))
+	if err != nil {
+		t.Fatalf("Failed to parse mountinfo: %v", err)
+	}
+	want := []Mount{
+		{
+			ID: 23, ParentID: 28, Major: 0, Minor: 22, Root: "/", MountPoint: "/proc",
+			Options: []string{"rw", "relatime"}, FSType: "proc", Source: "proc", SuperOptions: []string{"rw"},
+		},
+		{
+			ID: 140, ParentID: 28, Major: 0, Minor: 52, Root: "/", MountPoint: "/tmp/with space",
+			Options: []string{"rw", "nosuid", "nodev", "relatime"}, FSType: "fuse", Source: "sandboxfs",
+			SuperOptions: []string{"rw", "user_id=1000", "group_id=1000", "allow_other"},
+		},
+		{
+			ID: 141, ParentID: 28, Major: 0, Minor: 53, Root: "/sub", MountPoint: "/mnt/other",
+			Options: []string{"rw", "relatime"}, FSType: "fuse.sshfs", Source: "host:/",
+			SuperOptions: []string{"rw", "user_id=0", "group_id=0"},
+		},
+	}
+	if !reflect.DeepEqual(mounts, want) {
+		t.Errorf("Got %+v; want %+v", mounts, want)
+	}
+
+	wantSandboxfs := []bool{false, true, false}
+	wantAllow := []string{"self", "other", "self"}
+	for i, m := range mounts {
+		if got := m.IsSandboxfs(); got != wantSandboxfs[i] {
+			t.Errorf("Got IsSandboxfs() %v for %s; want %v", got, m.MountPoint, wantSandboxfs[i])
+		}
+		if got := m.AllowMode(); got != wantAllow[i] {
+			t.Errorf("Got AllowMode() %s for %s; want %s", got, m.MountPoint, wantAllow[i])
+		}
+	}
+}
+
+func TestParseMountInfo_Errors(t *testing.T) {
+	testData := []struct {
+		name string
+
+		line      string
+		wantError string
+	}{
+		{"NoSeparator", "1 2 0:3 / /a rw fuse sandboxfs rw", "malformed entry"},
+		{"Truncated", "1 2 0:3 / /a rw - fuse", "malformed entry"},
+		{"BadID", "x 2 0:3 / /a rw - fuse sandboxfs rw", "invalid mount ID"},
+		{"BadParentID", "1 x 0:3 / /a rw - fuse sandboxfs rw", "invalid parent ID"},
+		{"BadDevice", "1 2 3 / /a rw - fuse sandboxfs rw", "invalid device number"},
+	}
+	for _, d := range testData {
+		t.Run(d.name, func(t *testing.T) {
+			_, err := ParseMountInfo(strings.NewReader(d.line))
+			if err == nil || !strings.Contains(err.Error(), d.wantError) {
+				t.Errorf("Got %v; want error containing %s", err, d.wantError)
+			}
+		})
+	}
+}
+
+func TestUnescape(t *testing.T) {
+	testData := []struct {
+		input string
+		want  string
+	}{
+		{"/plain", "/plain"},
+		{`/a\040b\011c\012d\134e`, "/a b\tc\nd\\e"},
+		{`/trailing\04`, `/trailing\04`},
+		{`/not\09octal`, `/not\09octal`},
+	}
+	for _, d := range testData {
+		if got := unescape(d.input); got != d.want {
+			t.Errorf("Got %q for %q; want %q", got, d.input, d.want)
+		}
+	}
+}
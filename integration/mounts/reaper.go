@@ -0,0 +1,157 @@
+// Copyright 2020 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not
+// use this file except in compliance with the License.  You may obtain a copy
+// of the License at:
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.  See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package mounts
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+// ReapOptions holds the settings of a reaper run.
+type ReapOptions struct {
+	// ProcRoot is the path to the proc file system.
+	ProcRoot string
+
+	// FusectlRoot is the path to the fusectl file system, which exposes the FUSE connections.
+	FusectlRoot string
+
+	// Timeout is the maximum time to wait for each mount point to answer.
+	Timeout time.Duration
+
+	// AllFUSE extends the search to FUSE file systems other than sandboxfs.
+	AllFUSE bool
+
+	// DryRun reports the stale mounts without touching them.
+	DryRun bool
+}
+
+// ReapResult describes the cleanup of a stale mount.
+type ReapResult struct {
+	// Mount is the entry of the stale mount in the mountinfo file.
+	Mount Mount `json:"mount"`
+
+	// Aborted is true if the FUSE connection of the mount was aborted.
+	Aborted bool `json:"aborted"`
+
+	// AbortError explains why the connection could not be aborted, if it wasn't.  This is not
+	// fatal because connections of dead servers are already mostly inert.
+	AbortError string `json:"abort_error,omitempty"`
+
+	// Unmounted is true if the mount was detached.
+	Unmounted bool `json:"unmounted"`
+
+	// Error explains why the mount could not be detached, if it wasn't.
+	Error string `json:"error,omitempty"`
+}
+
+// Hooks that tests replace to simulate stale mounts.
+var (
+	probe       = Probe
+	lazyUnmount = lazyUnmountImpl
+)
+
+// filter returns the filter that selects the mounts the reaper considers.
+func (opts *ReapOptions) filter() func(*Mount) bool {
+	if opts.AllFUSE {
+		return (*Mount).IsFUSE
+	}
+	return (*Mount).IsSandboxfs
+}
+
+// FindStale returns the mounts whose FUSE server is gone.
+//
+// A mount is only deemed stale if the kernel reports it as disconnected.  Mounts that do not answer
+// in time are left alone because their server may still be alive, for example if it is stopped
+// under a debugger, and unmounting them would break it.
+func FindStale(opts *ReapOptions) ([]Mount, error) {
+	mounts, err := ListMounts(opts.ProcRoot, opts.filter())
+	if err != nil {
+		return nil, err
+	}
+	var stale []Mount
+	for _, m := range mounts {
+		if status, _, _ := probe(m.MountPoint, opts.Timeout); status == Disconnected {
+			stale = append(stale, m)
+		}
+	}
+	return stale, nil
+}
+
+// abort aborts the FUSE connection of a mount so that any process blocked on it is released.
+func abort(fusectlRoot string, m *Mount) error {
+	path := filepath.Join(fusectlRoot, strconv.Itoa(m.ConnectionID()), "abort")
+	file, err := os.OpenFile(path, os.O_WRONLY, 0)
+	if err != nil {
+		return err
+	}
+	_, err = file.Write([]byte("1"))
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// stillMounted returns true if the mount with the given identifier still exists.
+func stillMounted(procRoot string, m *Mount) (bool, error) {
+	mounts, err := ListMounts(procRoot, func(other *Mount) bool { return other.ID == m.ID })
+	if err != nil {
+		return false, err
+	}
+	return len(mounts) > 0, nil
+}
+
+// Reap finds the mounts whose FUSE server is gone and cleans them up by aborting their connection
+// and lazily unmounting them.  Failures to clean up individual mounts are reported in the results
+// instead of stopping the process.
+func Reap(opts *ReapOptions) ([]ReapResult, error) {
+	stale, err := FindStale(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([]ReapResult, 0, len(stale))
+	for i := range stale {
+		m := &stale[i]
+		result := ReapResult{Mount: *m}
+		if opts.DryRun {
+			results = append(results, result)
+			continue
+		}
+
+		// Probing can take a while, so make sure we don't act on a mount point that was
+		// unmounted in the meantime, possibly to be reused by a new instance.
+		if ok, err := stillMounted(opts.ProcRoot, m); err != nil {
+			return results, err
+		} else if !ok {
+			continue
+		}
+
+		if err := abort(opts.FusectlRoot, m); err != nil {
+			result.AbortError = err.Error()
+		} else {
+			result.Aborted = true
+		}
+		if err := lazyUnmount(m.MountPoint); err != nil {
+			result.Error = fmt.Sprintf("cannot unmount %s: %v", m.MountPoint, err)
+		} else {
+			result.Unmounted = true
+		}
+		results = append(results, result)
+	}
+	return results, nil
+}
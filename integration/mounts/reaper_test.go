@@ -0,0 +1,160 @@
+// Copyright 2020 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not
+// use this file except in compliance with the License.  You may obtain a copy
+// of the License at:
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.  See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package mounts
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// stubHooks replaces the probe and unmount hooks for the duration of a test.  Mount points listed
+// in disconnected are reported as such and all others as responsive.  Returns the list where the
+// unmounted paths are recorded and a function to restore the hooks.
+func stubHooks(disconnected map[string]bool, unmountErr error) (*[]string, func()) {
+	oldProbe, oldLazyUnmount := probe, lazyUnmount
+	var unmounted []string
+	probe = func(mountPoint string, timeout time.Duration) (Status, []string, error) {
+		if disconnected[mountPoint] {
+			return Disconnected, nil, fmt.Errorf("transport endpoint is not connected")
+		}
+		return Responsive, nil, nil
+	}
+	lazyUnmount = func(mountPoint string) error {
+		unmounted = append(unmounted, mountPoint)
+		return unmountErr
+	}
+	return &unmounted, func() { probe, lazyUnmount = oldProbe, oldLazyUnmount }
+}
+
+// setUpReaperTest creates a fake proc file system with three FUSE mounts, of which two belong to
+// sandboxfs, and a fake fusectl file system with the connection of the first one.
+func setUpReaperTest(t *testing.T) (*ReapOptions, func()) {
+	t.Helper()
+	dir, err := ioutil.TempDir("", "reaper")
+	if err != nil {
+		t.Fatal(err)
+	}
+	mountInfo := "1 0 0:1 / / rw - ext4 /dev/sda1 rw\n" +
+		"2 1 0:40 / /mnt/dead rw - fuse sandboxfs rw\n" +
+		"3 1 0:41 / /mnt/alive rw - fuse sandboxfs rw\n" +
+		"4 1 0:42 / /mnt/other rw - fuse.sshfs host:/ rw\n"
+	setUpFakeProc(t, filepath.Join(dir, "proc"), mountInfo, nil)
+	fusectl := filepath.Join(dir, "fusectl")
+	if err := os.MkdirAll(filepath.Join(fusectl, "40"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(fusectl, "40", "abort"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	opts := &ReapOptions{ProcRoot: filepath.Join(dir, "proc"), FusectlRoot: fusectl, Timeout: time.Second}
+	return opts, func() { os.RemoveAll(dir) }
+}
+
+func TestReap(t *testing.T) {
+	opts, cleanup := setUpReaperTest(t)
+	defer cleanup()
+	unmounted, restore := stubHooks(map[string]bool{"/mnt/dead": true, "/mnt/other": true}, nil)
+	defer restore()
+
+	results, err := Reap(opts)
+	if err != nil {
+		t.Fatalf("Reap failed: %v", err)
+	}
+	if len(results) != 1 {
+		t.Fatalf("Got %d results; want 1", len(results))
+	}
+	if r := results[0]; r.Mount.MountPoint != "/mnt/dead" || !r.Aborted || r.AbortError != "" || !r.Unmounted || r.Error != "" {
+		t.Errorf("Got %+v; want /mnt/dead aborted and unmounted", r)
+	}
+	if want := []string{"/mnt/dead"}; !reflect.DeepEqual(*unmounted, want) {
+		t.Errorf("Got unmounts of %v; want %v", *unmounted, want)
+	}
+	if content, err := ioutil.ReadFile(filepath.Join(opts.FusectlRoot, "40", "abort")); err != nil || string(content) != "1" {
+		t.Errorf("Got abort file content %q (error %v); want 1", content, err)
+	}
+}
+
+func TestReap_AllFUSE(t *testing.T) {
+	opts, cleanup := setUpReaperTest(t)
+	defer cleanup()
+	unmounted, restore := stubHooks(map[string]bool{"/mnt/dead": true, "/mnt/other": true}, fmt.Errorf("busy"))
+	defer restore()
+
+	opts.AllFUSE = true
+	results, err := Reap(opts)
+	if err != nil {
+		t.Fatalf("Reap failed: %v", err)
+	}
+	if want := []string{"/mnt/dead", "/mnt/other"}; !reflect.DeepEqual(*unmounted, want) {
+		t.Errorf("Got unmounts of %v; want %v", *unmounted, want)
+	}
+	if len(results) != 2 {
+		t.Fatalf("Got %d results; want 2", len(results))
+	}
+	other := results[1]
+	if other.Aborted || other.AbortError == "" || other.Unmounted || other.Error != "cannot unmount /mnt/other: busy" {
+		t.Errorf("Got %+v; want failed abort and unmount", other)
+	}
+}
+
+func TestReap_DryRun(t *testing.T) {
+	opts, cleanup := setUpReaperTest(t)
+	defer cleanup()
+	unmounted, restore := stubHooks(map[string]bool{"/mnt/dead": true}, nil)
+	defer restore()
+
+	opts.DryRun = true
+	results, err := Reap(opts)
+	if err != nil {
+		t.Fatalf("Reap failed: %v", err)
+	}
+	if len(results) != 1 || results[0].Mount.MountPoint != "/mnt/dead" || results[0].Aborted || results[0].Unmounted {
+		t.Errorf("Got %+v; want /mnt/dead reported but untouched", results)
+	}
+	if len(*unmounted) != 0 {
+		t.Errorf("Got unmounts of %v; want none", *unmounted)
+	}
+	if content, _ := ioutil.ReadFile(filepath.Join(opts.FusectlRoot, "40", "abort")); len(content) != 0 {
+		t.Errorf("Got abort file content %q; want untouched", content)
+	}
+}
+
+func TestReap_MountGoneWhileProbing(t *testing.T) {
+	opts, cleanup := setUpReaperTest(t)
+	defer cleanup()
+	unmounted, restore := stubHooks(nil, nil)
+	defer restore()
+
+	probe = func(mountPoint string, timeout time.Duration) (Status, []string, error) {
+		// Simulate another process cleaning up the mount right after we probed it.
+		mountInfo := "1 0 0:1 / / rw - ext4 /dev/sda1 rw\n"
+		if err := ioutil.WriteFile(filepath.Join(opts.ProcRoot, "self/mountinfo"), []byte(mountInfo), 0644); err != nil {
+			t.Fatal(err)
+		}
+		return Disconnected, nil, nil
+	}
+	results, err := Reap(opts)
+	if err != nil {
+		t.Fatalf("Reap failed: %v", err)
+	}
+	if len(results) != 0 || len(*unmounted) != 0 {
+		t.Errorf("Got results %+v and unmounts of %v; want nothing done", results, *unmounted)
+	}
+}
@@ -12,7 +12,7 @@
 // License for the specific language governing permissions and limitations
 // under the License.
 
-package utils
+package mounts
 
 import (
 	"fmt"
@@ -20,6 +20,12 @@ import (
 	"os/exec"
 )
 
+// lazyUnmountImpl detaches the file system at mountPoint from the tree so that it is released as
+// soon as it stops being busy.  Not supported on this platform.
+func lazyUnmountImpl(mountPoint string) error {
+	return fmt.Errorf("lazy unmounts are not supported on this platform")
+}
+
 // Unmount unmounts the given file system.
 func Unmount(path string) error {
 	cmd := exec.Command("umount", path)
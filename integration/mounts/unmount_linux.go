@@ -0,0 +1,50 @@
+// Copyright 2020 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not
+// use this file except in compliance with the License.  You may obtain a copy
+// of the License at:
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.  See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package mounts
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+
+	"golang.org/x/sys/unix"
+)
+
+// lazyUnmountImpl detaches the file system at mountPoint from the tree so that it is released as
+// soon as it stops being busy.  Unprivileged users cannot unmount directly, so this falls back to
+// fusermount, which is setuid and allows users to unmount their own FUSE file systems.
+func lazyUnmountImpl(mountPoint string) error {
+	err := unix.Unmount(mountPoint, unix.MNT_DETACH)
+	if err != unix.EPERM {
+		return err
+	}
+	output, err := exec.Command("fusermount", "-u", "-z", mountPoint).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("fusermount -u -z %s failed: %v: %s", mountPoint, err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
+// Unmount unmounts the given file system.
+func Unmount(path string) error {
+	cmd := exec.Command("fusermount", "-u", path)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("exec of fusermount -u %s failed: %v", path, err)
+	}
+	return nil
+}
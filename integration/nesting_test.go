@@ -25,8 +25,9 @@ import (
 )
 
 func TestNesting_ScaffoldIntermediateComponents(t *testing.T) {
-	state := utils.MountSetup(t, "--mapping=ro:/:%ROOT%", "--mapping=ro:/1/2/3/4/5:%ROOT%/subdir")
-	defer state.TearDown(t)
+	t.Parallel()
+
+	state := utils.MountSetup(t, utils.WithArgs("--mapping=ro:/:%ROOT%", "--mapping=ro:/1/2/3/4/5:%ROOT%/subdir"))
 
 	utils.MustWriteFile(t, state.RootPath("subdir", "file"), 0644, "some contents")
 
@@ -53,14 +54,15 @@ func TestNesting_ScaffoldIntermediateComponents(t *testing.T) {
 }
 
 func TestNesting_ScaffoldIntermediateComponentsAreImmutable(t *testing.T) {
+	t.Parallel()
+
 	// Scaffold directories have mode 0555 to signal that they are read-only.  The mode alone
 	// prevents unprivileged users from writing to those directories, but the mode has no effect
 	// on root accesses.  Therefore, run the test as root to bypass permission checks and
 	// attempt real writes.
 	root := utils.RequireRoot(t, "Requires root privileges to write to directories with mode 0555")
 
-	state := utils.MountSetupWithUser(t, root, "--mapping=ro:/:%ROOT%", "--mapping=rw:/1/2/3:%ROOT%/subdir")
-	defer state.TearDown(t)
+	state := utils.MountSetup(t, utils.WithUser(root), utils.WithArgs("--mapping=ro:/:%ROOT%", "--mapping=rw:/1/2/3:%ROOT%/subdir"))
 
 	for _, dir := range []string{"1/foo", "1/2/foo"} {
 		err := os.Mkdir(state.MountPath(dir), 0755)
@@ -75,8 +77,9 @@ func TestNesting_ScaffoldIntermediateComponentsAreImmutable(t *testing.T) {
 }
 
 func TestNesting_ReadWriteWithinReadOnly(t *testing.T) {
-	state := utils.MountSetup(t, "--mapping=rw:/:%ROOT%", "--mapping=ro:/ro:%ROOT%/one/two", "--mapping=rw:/ro/rw:%ROOT%")
-	defer state.TearDown(t)
+	t.Parallel()
+
+	state := utils.MountSetup(t, utils.WithArgs("--mapping=rw:/:%ROOT%", "--mapping=ro:/ro:%ROOT%/one/two", "--mapping=rw:/ro/rw:%ROOT%"))
 
 	if err := os.MkdirAll(state.MountPath("ro/hello"), 0755); err == nil {
 		t.Errorf("Mkdir succeeded in read-only mapping")
@@ -87,8 +90,9 @@ func TestNesting_ReadWriteWithinReadOnly(t *testing.T) {
 }
 
 func TestNesting_SameTarget(t *testing.T) {
-	state := utils.MountSetup(t, "--node_cache", "--mapping=ro:/:%ROOT%", "--mapping=rw:/dir1:%ROOT%/same", "--mapping=rw:/dir2/dir3/dir4:%ROOT%/same")
-	defer state.TearDown(t)
+	t.Parallel()
+
+	state := utils.MountSetup(t, utils.WithArgs("--node_cache", "--mapping=ro:/:%ROOT%", "--mapping=rw:/dir1:%ROOT%/same", "--mapping=rw:/dir2/dir3/dir4:%ROOT%/same"))
 
 	utils.MustWriteFile(t, state.MountPath("dir1/file"), 0644, "old contents")
 	utils.MustWriteFile(t, state.MountPath("dir2/dir3/dir4/file"), 0644, "new contents")
@@ -117,8 +121,9 @@ func TestNesting_SameTarget(t *testing.T) {
 }
 
 func TestNesting_PreserveSymlinks(t *testing.T) {
-	state := utils.MountSetup(t, "--mapping=ro:/:%ROOT%", "--mapping=ro:/dir1/dir2:%ROOT%")
-	defer state.TearDown(t)
+	t.Parallel()
+
+	state := utils.MountSetup(t, utils.WithArgs("--mapping=ro:/:%ROOT%", "--mapping=ro:/dir1/dir2:%ROOT%"))
 
 	utils.MustWriteFile(t, state.RootPath("file"), 0644, "file in root directory")
 	utils.MustMkdirAll(t, state.RootPath("dir"), 0755)
@@ -15,6 +15,7 @@
 package integration
 
 import (
+	"fmt"
 	"io/ioutil"
 	"os"
 	"runtime"
@@ -24,6 +25,8 @@ import (
 )
 
 func TestOptions_Allow(t *testing.T) {
+	t.Parallel()
+
 	root := utils.RequireRoot(t, "Requires root privileges to spawn sandboxfs under different users")
 
 	user := utils.GetConfig().UnprivilegedUser
@@ -85,8 +88,7 @@ func TestOptions_Allow(t *testing.T) {
 				args = append(args, d.allowFlag)
 			}
 
-			state := utils.MountSetupWithUser(t, user, args...)
-			defer state.TearDown(t)
+			state := utils.MountSetup(t, utils.WithUser(user), utils.WithArgs(args...))
 
 			utils.MustWriteFile(t, state.RootPath("file"), 0444, "")
 			file := state.MountPath("file")
@@ -107,29 +109,21 @@ func TestOptions_Allow(t *testing.T) {
 }
 
 func TestOptions_Syntax(t *testing.T) {
+	t.Parallel()
+
+	// The golden files for each test case live in testdata/options/syntax/ and are named after
+	// the test case.
 	testData := []struct {
 		name string
 
-		args       []string
-		wantStderr string
+		args []string
 	}{
-		{"AllowBadValue", []string{"--allow=foo"}, "foo.*must be one of.*other"},
+		{"AllowBadValue", []string{"--allow=foo"}},
 	}
 	for _, d := range testData {
 		t.Run(d.name, func(t *testing.T) {
-			stdout, stderr, err := utils.RunAndWait(2, d.args...)
-			if err != nil {
-				t.Fatal(err)
-			}
-			if len(stdout) > 0 {
-				t.Errorf("Got %s; want stdout to be empty", stdout)
-			}
-			if !utils.MatchesRegexp(d.wantStderr, stderr) {
-				t.Errorf("Got %s; want stderr to match %s", stderr, d.wantStderr)
-			}
-			if !utils.MatchesRegexp("--help", stderr) {
-				t.Errorf("Got %s; want --help mention in stderr", stderr)
-			}
+			golden := fmt.Sprintf("options/syntax/%s.stderr.golden", d.name)
+			utils.RunAndExpect(t, utils.Expect{Exit: 2, Stderr: utils.Golden(golden, programNameSubstitutions()...)}, d.args...)
 		})
 	}
 }
@@ -0,0 +1,606 @@
+// Copyright 2020 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not
+// use this file except in compliance with the License.  You may obtain a copy
+// of the License at:
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.  See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package posix
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/bazelbuild/sandboxfs/integration/utils"
+	"golang.org/x/sys/unix"
+)
+
+// longName is a path component longer than NAME_MAX.
+var longName = strings.Repeat("x", 256)
+
+// longPath is a path longer than PATH_MAX made of valid components.
+var longPath = strings.Repeat(strings.Repeat("x", 200)+"/", 21)
+
+// created returns the permissions that an entry created with the given mode gets once the umask
+// is applied.
+func created(mode uint32) uint32 {
+	return mode &^ uint32(utils.Umask())
+}
+
+// fileStatus returns the result of a Stat operation on a regular file.
+func fileStatus(perm uint32, size int) Result {
+	return Outputs(fmt.Sprintf("file %04o size=%d", perm, size))
+}
+
+// dirStatus returns the result of a Stat operation on a directory.
+func dirStatus(perm uint32) Result {
+	return Outputs(fmt.Sprintf("dir %04o", perm))
+}
+
+// noHardLinks is the deviation of sandboxfs for all attempts to create hard links.
+var noHardLinks = &Deviation{Want: Fails(unix.EPERM), Reason: "hard links are not supported"}
+
+// Cases contains the conformance suite.
+var Cases = []Case{
+	// Open flags.
+	{
+		Name: "OpenMissing",
+		Steps: []Step{
+			{Op: Op{Kind: Open, Path: "missing", Flags: unix.O_RDONLY}, Want: Fails(unix.ENOENT)},
+		},
+	},
+	{
+		Name: "OpenCreate",
+		Steps: []Step{
+			{Op: Op{Kind: Open, Path: "file", Flags: unix.O_WRONLY | unix.O_CREAT, Mode: 0640}, Want: Ok},
+			{Op: Op{Kind: Stat, Path: "file"}, Want: fileStatus(created(0640), 0)},
+		},
+	},
+	{
+		Name: "OpenCreateExisting",
+		Setup: []Op{
+			{Kind: Open, Path: "file", Flags: unix.O_WRONLY | unix.O_CREAT, Mode: 0644},
+			{Kind: Chmod, Path: "file", Mode: 0644},
+			{Kind: Write, Data: "abc"},
+		},
+		Steps: []Step{
+			{Op: Op{Kind: Open, Path: "file", Flags: unix.O_WRONLY | unix.O_CREAT, Mode: 0600, FD: 1}, Want: Ok},
+			{Op: Op{Kind: Stat, Path: "file"}, Want: Outputs("file 0644 size=3")},
+		},
+	},
+	{
+		Name: "OpenCreateInMissingDirectory",
+		Steps: []Step{
+			{Op: Op{Kind: Open, Path: "missing/file", Flags: unix.O_WRONLY | unix.O_CREAT, Mode: 0644}, Want: Fails(unix.ENOENT)},
+		},
+	},
+	{
+		Name: "OpenThroughFile",
+		Setup: []Op{
+			{Kind: Open, Path: "file", Flags: unix.O_WRONLY | unix.O_CREAT, Mode: 0644},
+		},
+		Steps: []Step{
+			{Op: Op{Kind: Open, Path: "file/other", Flags: unix.O_RDONLY, FD: 1}, Want: Fails(unix.ENOTDIR)},
+			{Op: Op{Kind: Open, Path: "file/other", Flags: unix.O_WRONLY | unix.O_CREAT, Mode: 0644, FD: 1}, Want: Fails(unix.ENOTDIR)},
+		},
+	},
+	{
+		Name: "OpenExclusive",
+		Steps: []Step{
+			{Op: Op{Kind: Open, Path: "file", Flags: unix.O_WRONLY | unix.O_CREAT | unix.O_EXCL, Mode: 0644}, Want: Ok},
+			{Op: Op{Kind: Open, Path: "file", Flags: unix.O_WRONLY | unix.O_CREAT | unix.O_EXCL, Mode: 0644, FD: 1}, Want: Fails(unix.EEXIST)},
+		},
+	},
+	{
+		Name: "OpenExclusiveDirectory",
+		Setup: []Op{
+			{Kind: Mkdir, Path: "dir", Mode: 0755},
+		},
+		Steps: []Step{
+			{Op: Op{Kind: Open, Path: "dir", Flags: unix.O_WRONLY | unix.O_CREAT | unix.O_EXCL, Mode: 0644}, Want: Fails(unix.EEXIST)},
+		},
+	},
+	{
+		Name: "OpenExclusiveDanglingSymlink",
+		Setup: []Op{
+			{Kind: Symlink, Path: "link", Target: "missing"},
+		},
+		Steps: []Step{
+			{Op: Op{Kind: Open, Path: "link", Flags: unix.O_WRONLY | unix.O_CREAT | unix.O_EXCL, Mode: 0644}, Want: Fails(unix.EEXIST)},
+			{Op: Op{Kind: Stat, Path: "missing"}, Want: Fails(unix.ENOENT)},
+		},
+	},
+	{
+		Name: "OpenCreateThroughDanglingSymlink",
+		Setup: []Op{
+			{Kind: Symlink, Path: "link", Target: "target"},
+		},
+		Steps: []Step{
+			{Op: Op{Kind: Open, Path: "link", Flags: unix.O_WRONLY | unix.O_CREAT, Mode: 0600}, Want: Ok},
+			{Op: Op{Kind: Stat, Path: "target"}, Want: fileStatus(created(0600), 0)},
+		},
+	},
+	{
+		Name: "OpenTruncate",
+		Setup: []Op{
+			{Kind: Open, Path: "file", Flags: unix.O_WRONLY | unix.O_CREAT, Mode: 0644},
+			{Kind: Write, Data: "some contents"},
+			{Kind: Close},
+		},
+		Steps: []Step{
+			{Op: Op{Kind: Open, Path: "file", Flags: unix.O_RDWR | unix.O_TRUNC}, Want: Ok},
+			{Op: Op{Kind: Stat, Path: "file"}, Want: fileStatus(created(0644), 0)},
+			{Op: Op{Kind: Read}, Want: Outputs(`""`)},
+		},
+	},
+	{
+		Name: "OpenTruncateReadOnly",
+		Setup: []Op{
+			{Kind: Open, Path: "file", Flags: unix.O_WRONLY | unix.O_CREAT, Mode: 0444},
+			{Kind: Write, Data: "abc"},
+			{Kind: Chmod, Path: "file", Mode: 0444},
+			{Kind: Chown, Path: "file", Owner: Owner},
+		},
+		Steps: []Step{
+			{As: Owner, Op: Op{Kind: Open, Path: "file", Flags: unix.O_RDONLY | unix.O_TRUNC}, Want: Fails(unix.EACCES)},
+			{Op: Op{Kind: Stat, Path: "file"}, Want: Outputs("file 0444 size=3")},
+		},
+	},
+	{
+		Name: "OpenAppend",
+		Setup: []Op{
+			{Kind: Open, Path: "file", Flags: unix.O_WRONLY | unix.O_CREAT, Mode: 0644},
+			{Kind: Write, Data: "abc"},
+			{Kind: Close},
+		},
+		Steps: []Step{
+			{Op: Op{Kind: Open, Path: "file", Flags: unix.O_RDWR | unix.O_APPEND}, Want: Ok},
+			{Op: Op{Kind: Write, Data: "def"}, Want: Ok},
+			{Op: Op{Kind: Read}, Want: Outputs(`"abcdef"`)},
+		},
+	},
+	{
+		Name: "OpenDirectoryFlag",
+		Setup: []Op{
+			{Kind: Mkdir, Path: "dir", Mode: 0755},
+			{Kind: Open, Path: "file", Flags: unix.O_WRONLY | unix.O_CREAT, Mode: 0644},
+		},
+		Steps: []Step{
+			{Op: Op{Kind: Open, Path: "dir", Flags: unix.O_RDONLY | unix.O_DIRECTORY, FD: 1}, Want: Ok},
+			{Op: Op{Kind: Open, Path: "file", Flags: unix.O_RDONLY | unix.O_DIRECTORY, FD: 2}, Want: Fails(unix.ENOTDIR)},
+		},
+	},
+	{
+		Name: "OpenDirectoryForWrite",
+		Setup: []Op{
+			{Kind: Mkdir, Path: "dir", Mode: 0755},
+		},
+		Steps: []Step{
+			{Op: Op{Kind: Open, Path: "dir", Flags: unix.O_WRONLY}, Want: Fails(unix.EISDIR)},
+			{Op: Op{Kind: Open, Path: "dir", Flags: unix.O_RDWR}, Want: Fails(unix.EISDIR)},
+		},
+	},
+	{
+		Name: "OpenNoFollow",
+		Setup: []Op{
+			{Kind: Open, Path: "file", Flags: unix.O_WRONLY | unix.O_CREAT, Mode: 0644},
+			{Kind: Symlink, Path: "link", Target: "file"},
+		},
+		Steps: []Step{
+			{Op: Op{Kind: Open, Path: "link", Flags: unix.O_RDONLY | unix.O_NOFOLLOW, FD: 1}, Want: Fails(unix.ELOOP)},
+			{Op: Op{Kind: Open, Path: "link", Flags: unix.O_RDONLY, FD: 1}, Want: Ok},
+		},
+	},
+
+	// Rename.
+	{
+		Name: "RenameFileOverFile",
+		Setup: []Op{
+			{Kind: Open, Path: "old", Flags: unix.O_WRONLY | unix.O_CREAT, Mode: 0600},
+			{Kind: Write, Data: "old"},
+			{Kind: Open, Path: "new", Flags: unix.O_WRONLY | unix.O_CREAT, Mode: 0644, FD: 1},
+			{Kind: Chmod, Path: "old", Mode: 0600},
+			{Kind: Chmod, Path: "new", Mode: 0644},
+		},
+		Steps: []Step{
+			{Op: Op{Kind: Rename, Path: "old", Target: "new"}, Want: Ok},
+			{Op: Op{Kind: Stat, Path: "old"}, Want: Fails(unix.ENOENT)},
+			{Op: Op{Kind: Stat, Path: "new"}, Want: Outputs("file 0600 size=3")},
+		},
+	},
+	{
+		Name: "RenameFileOverItself",
+		Setup: []Op{
+			{Kind: Open, Path: "file", Flags: unix.O_WRONLY | unix.O_CREAT, Mode: 0644},
+		},
+		Steps: []Step{
+			{Op: Op{Kind: Rename, Path: "file", Target: "file"}, Want: Ok},
+			{Op: Op{Kind: Stat, Path: "file"}, Want: fileStatus(created(0644), 0)},
+		},
+	},
+	{
+		Name: "RenameFileOverDirectory",
+		Setup: []Op{
+			{Kind: Open, Path: "file", Flags: unix.O_WRONLY | unix.O_CREAT, Mode: 0644},
+			{Kind: Mkdir, Path: "dir", Mode: 0755},
+		},
+		Steps: []Step{
+			{Op: Op{Kind: Rename, Path: "file", Target: "dir"}, Want: Fails(unix.EISDIR)},
+		},
+	},
+	{
+		Name: "RenameDirectoryOverFile",
+		Setup: []Op{
+			{Kind: Open, Path: "file", Flags: unix.O_WRONLY | unix.O_CREAT, Mode: 0644},
+			{Kind: Mkdir, Path: "dir", Mode: 0755},
+		},
+		Steps: []Step{
+			{Op: Op{Kind: Rename, Path: "dir", Target: "file"}, Want: Fails(unix.ENOTDIR)},
+		},
+	},
+	{
+		Name: "RenameDirectoryOverEmptyDirectory",
+		Setup: []Op{
+			{Kind: Mkdir, Path: "old", Mode: 0700},
+			{Kind: Mkdir, Path: "old/sub", Mode: 0755},
+			{Kind: Mkdir, Path: "new", Mode: 0755},
+			{Kind: Chmod, Path: "old", Mode: 0700},
+			{Kind: Chmod, Path: "old/sub", Mode: 0755},
+		},
+		Steps: []Step{
+			{Op: Op{Kind: Rename, Path: "old", Target: "new"}, Want: Ok},
+			{Op: Op{Kind: Stat, Path: "new"}, Want: Outputs("dir 0700")},
+			{Op: Op{Kind: Stat, Path: "new/sub"}, Want: Outputs("dir 0755")},
+		},
+	},
+	{
+		Name: "RenameDirectoryOverNonEmptyDirectory",
+		Setup: []Op{
+			{Kind: Mkdir, Path: "old", Mode: 0755},
+			{Kind: Mkdir, Path: "new", Mode: 0755},
+			{Kind: Mkdir, Path: "new/sub", Mode: 0755},
+		},
+		Steps: []Step{
+			{Op: Op{Kind: Rename, Path: "old", Target: "new"}, Want: Fails(unix.ENOTEMPTY)},
+			{Op: Op{Kind: Stat, Path: "old"}, Want: dirStatus(created(0755))},
+		},
+	},
+	{
+		Name: "RenameDirectoryIntoItself",
+		Setup: []Op{
+			{Kind: Mkdir, Path: "dir", Mode: 0755},
+			{Kind: Mkdir, Path: "dir/sub", Mode: 0755},
+		},
+		Steps: []Step{
+			{Op: Op{Kind: Rename, Path: "dir", Target: "dir/sub/dir"}, Want: Fails(unix.EINVAL)},
+		},
+	},
+	{
+		Name: "RenameMissing",
+		Steps: []Step{
+			{Op: Op{Kind: Rename, Path: "missing", Target: "new"}, Want: Fails(unix.ENOENT)},
+		},
+	},
+	{
+		Name: "RenameSymlink",
+		Setup: []Op{
+			{Kind: Symlink, Path: "link", Target: "missing"},
+		},
+		Steps: []Step{
+			{Op: Op{Kind: Rename, Path: "link", Target: "new"}, Want: Ok},
+			{Op: Op{Kind: Stat, Path: "new"}, Want: Outputs("symlink 0777 size=7")},
+		},
+	},
+
+	// Unlink and rmdir.
+	{
+		Name: "UnlinkOpenFile",
+		Setup: []Op{
+			{Kind: Open, Path: "file", Flags: unix.O_RDWR | unix.O_CREAT, Mode: 0644},
+			{Kind: Write, Data: "still here"},
+		},
+		Steps: []Step{
+			{Op: Op{Kind: Unlink, Path: "file"}, Want: Ok},
+			{Op: Op{Kind: Stat, Path: "file"}, Want: Fails(unix.ENOENT)},
+			{Op: Op{Kind: Read}, Want: Outputs(`"still here"`)},
+			{Op: Op{Kind: Write, Data: "!"}, Want: Ok},
+			{Op: Op{Kind: Read}, Want: Outputs(`"still here!"`)},
+			{Op: Op{Kind: Close}, Want: Ok},
+		},
+	},
+	{
+		Name: "UnlinkDirectory",
+		Setup: []Op{
+			{Kind: Mkdir, Path: "dir", Mode: 0755},
+		},
+		Steps: []Step{
+			{Op: Op{Kind: Unlink, Path: "dir"}, Want: Fails(unix.EISDIR)},
+		},
+	},
+	{
+		Name: "UnlinkMissing",
+		Steps: []Step{
+			{Op: Op{Kind: Unlink, Path: "missing"}, Want: Fails(unix.ENOENT)},
+		},
+	},
+	{
+		Name: "UnlinkSymlinkKeepsTarget",
+		Setup: []Op{
+			{Kind: Open, Path: "file", Flags: unix.O_WRONLY | unix.O_CREAT, Mode: 0644},
+			{Kind: Symlink, Path: "link", Target: "file"},
+		},
+		Steps: []Step{
+			{Op: Op{Kind: Unlink, Path: "link"}, Want: Ok},
+			{Op: Op{Kind: Stat, Path: "file"}, Want: fileStatus(created(0644), 0)},
+		},
+	},
+	{
+		Name: "RmdirNonEmpty",
+		Setup: []Op{
+			{Kind: Mkdir, Path: "dir", Mode: 0755},
+			{Kind: Open, Path: "dir/file", Flags: unix.O_WRONLY | unix.O_CREAT, Mode: 0644},
+		},
+		Steps: []Step{
+			{Op: Op{Kind: Rmdir, Path: "dir"}, Want: Fails(unix.ENOTEMPTY)},
+			{Op: Op{Kind: Unlink, Path: "dir/file"}, Want: Ok},
+			{Op: Op{Kind: Rmdir, Path: "dir"}, Want: Ok},
+		},
+	},
+	{
+		Name: "RmdirFile",
+		Setup: []Op{
+			{Kind: Open, Path: "file", Flags: unix.O_WRONLY | unix.O_CREAT, Mode: 0644},
+		},
+		Steps: []Step{
+			{Op: Op{Kind: Rmdir, Path: "file"}, Want: Fails(unix.ENOTDIR)},
+		},
+	},
+	{
+		Name: "RmdirDot",
+		Setup: []Op{
+			{Kind: Mkdir, Path: "dir", Mode: 0755},
+		},
+		Steps: []Step{
+			{Op: Op{Kind: Rmdir, Path: "dir/."}, Want: Fails(unix.EINVAL)},
+		},
+	},
+	{
+		Name: "MkdirExisting",
+		Setup: []Op{
+			{Kind: Mkdir, Path: "dir", Mode: 0755},
+			{Kind: Symlink, Path: "link", Target: "missing"},
+		},
+		Steps: []Step{
+			{Op: Op{Kind: Mkdir, Path: "dir", Mode: 0755}, Want: Fails(unix.EEXIST)},
+			{Op: Op{Kind: Mkdir, Path: "link", Mode: 0755}, Want: Fails(unix.EEXIST)},
+		},
+	},
+
+	// Hard links.
+	{
+		Name: "LinkFile",
+		Setup: []Op{
+			{Kind: Open, Path: "file", Flags: unix.O_WRONLY | unix.O_CREAT, Mode: 0644},
+			{Kind: Write, Data: "abc"},
+		},
+		Steps: []Step{
+			{Op: Op{Kind: Link, Path: "file", Target: "link"}, Want: Ok, Sandboxfs: noHardLinks},
+			{Op: Op{Kind: Stat, Path: "link"}, Want: fileStatus(created(0644), 3), Sandboxfs: &Deviation{Want: Fails(unix.ENOENT), Reason: noHardLinks.Reason}},
+		},
+	},
+	{
+		Name: "LinkDirectory",
+		Setup: []Op{
+			{Kind: Mkdir, Path: "dir", Mode: 0755},
+		},
+		Steps: []Step{
+			{Op: Op{Kind: Link, Path: "dir", Target: "link"}, Want: Fails(unix.EPERM)},
+		},
+	},
+	{
+		Name: "LinkOverExisting",
+		Setup: []Op{
+			{Kind: Open, Path: "file", Flags: unix.O_WRONLY | unix.O_CREAT, Mode: 0644},
+			{Kind: Open, Path: "other", Flags: unix.O_WRONLY | unix.O_CREAT, Mode: 0644, FD: 1},
+		},
+		Steps: []Step{
+			{Op: Op{Kind: Link, Path: "file", Target: "other"}, Want: Fails(unix.EEXIST)},
+		},
+	},
+
+	// Permission checks for the owner, the group and others.
+	{
+		Name: "PermissionsRead",
+		Setup: []Op{
+			{Kind: Open, Path: "file", Flags: unix.O_WRONLY | unix.O_CREAT, Mode: 0640},
+			{Kind: Chmod, Path: "file", Mode: 0640},
+			{Kind: Chown, Path: "file", Owner: Owner},
+		},
+		Steps: []Step{
+			{As: Owner, Op: Op{Kind: Open, Path: "file", Flags: unix.O_RDONLY}, Want: Ok},
+			{As: Group, Op: Op{Kind: Open, Path: "file", Flags: unix.O_RDONLY}, Want: Ok},
+			{As: Other, Op: Op{Kind: Open, Path: "file", Flags: unix.O_RDONLY}, Want: Fails(unix.EACCES)},
+		},
+	},
+	{
+		Name: "PermissionsWrite",
+		Setup: []Op{
+			{Kind: Open, Path: "file", Flags: unix.O_WRONLY | unix.O_CREAT, Mode: 0604},
+			{Kind: Chmod, Path: "file", Mode: 0604},
+			{Kind: Chown, Path: "file", Owner: Owner},
+		},
+		Steps: []Step{
+			{As: Owner, Op: Op{Kind: Open, Path: "file", Flags: unix.O_WRONLY}, Want: Ok},
+			{As: Group, Op: Op{Kind: Open, Path: "file", Flags: unix.O_WRONLY}, Want: Fails(unix.EACCES)},
+			{As: Other, Op: Op{Kind: Open, Path: "file", Flags: unix.O_RDONLY}, Want: Ok},
+			{As: Other, Op: Op{Kind: Truncate, Path: "file"}, Want: Fails(unix.EACCES)},
+		},
+	},
+	{
+		Name: "PermissionsOwnerClassOnly",
+		Setup: []Op{
+			{Kind: Open, Path: "file", Flags: unix.O_WRONLY | unix.O_CREAT, Mode: 0644},
+			{Kind: Chmod, Path: "file", Mode: 0077},
+			{Kind: Chown, Path: "file", Owner: Owner},
+		},
+		Steps: []Step{
+			{As: Owner, Op: Op{Kind: Open, Path: "file", Flags: unix.O_RDONLY}, Want: Fails(unix.EACCES)},
+			{As: Group, Op: Op{Kind: Open, Path: "file", Flags: unix.O_RDONLY}, Want: Ok},
+		},
+	},
+	{
+		Name: "PermissionsCreateInDirectory",
+		Setup: []Op{
+			{Kind: Mkdir, Path: "dir", Mode: 0755},
+			{Kind: Chmod, Path: "dir", Mode: 0775},
+			{Kind: Chown, Path: "dir", Owner: Owner},
+		},
+		Steps: []Step{
+			{As: Owner, Op: Op{Kind: Mkdir, Path: "dir/owner", Mode: 0755}, Want: Ok},
+			{As: Group, Op: Op{Kind: Open, Path: "dir/group", Flags: unix.O_WRONLY | unix.O_CREAT, Mode: 0644}, Want: Ok},
+			{As: Other, Op: Op{Kind: Open, Path: "dir/other", Flags: unix.O_WRONLY | unix.O_CREAT, Mode: 0644}, Want: Fails(unix.EACCES)},
+			{As: Other, Op: Op{Kind: Unlink, Path: "dir/group"}, Want: Fails(unix.EACCES)},
+			{As: Other, Op: Op{Kind: Rename, Path: "dir/group", Target: "moved"}, Want: Fails(unix.EACCES)},
+		},
+	},
+	{
+		Name: "PermissionsSearchDirectory",
+		Setup: []Op{
+			{Kind: Mkdir, Path: "dir", Mode: 0754},
+			{Kind: Open, Path: "dir/file", Flags: unix.O_WRONLY | unix.O_CREAT, Mode: 0644},
+			{Kind: Chmod, Path: "dir", Mode: 0754},
+			{Kind: Chmod, Path: "dir/file", Mode: 0644},
+			{Kind: Chown, Path: "dir", Owner: Owner},
+		},
+		Steps: []Step{
+			{As: Owner, Op: Op{Kind: Stat, Path: "dir/file"}, Want: Outputs("file 0644 size=0")},
+			{As: Group, Op: Op{Kind: Stat, Path: "dir/file"}, Want: Outputs("file 0644 size=0")},
+			{As: Other, Op: Op{Kind: Stat, Path: "dir/file"}, Want: Fails(unix.EACCES)},
+			{As: Other, Op: Op{Kind: Open, Path: "dir", Flags: unix.O_RDONLY}, Want: Ok},
+		},
+	},
+	{
+		Name: "PermissionsChmod",
+		Setup: []Op{
+			{Kind: Open, Path: "file", Flags: unix.O_WRONLY | unix.O_CREAT, Mode: 0644},
+			{Kind: Chmod, Path: "file", Mode: 0666},
+			{Kind: Chown, Path: "file", Owner: Owner},
+		},
+		Steps: []Step{
+			{As: Group, Op: Op{Kind: Chmod, Path: "file", Mode: 0600}, Want: Fails(unix.EPERM)},
+			{As: Other, Op: Op{Kind: Chmod, Path: "file", Mode: 0600}, Want: Fails(unix.EPERM)},
+			{As: Owner, Op: Op{Kind: Chmod, Path: "file", Mode: 0600}, Want: Ok},
+			{Op: Op{Kind: Stat, Path: "file"}, Want: Outputs("file 0600 size=0")},
+		},
+	},
+	{
+		Name: "PermissionsChown",
+		Setup: []Op{
+			{Kind: Open, Path: "file", Flags: unix.O_WRONLY | unix.O_CREAT, Mode: 0644},
+			{Kind: Chmod, Path: "file", Mode: 0666},
+			{Kind: Chown, Path: "file", Owner: Owner},
+		},
+		Steps: []Step{
+			{As: Owner, Op: Op{Kind: Chown, Path: "file", Owner: Other}, Want: Fails(unix.EPERM)},
+			{As: Other, Op: Op{Kind: Chown, Path: "file", Owner: Other}, Want: Fails(unix.EPERM)},
+		},
+	},
+
+	// Sticky directories.
+	{
+		Name: "StickyDirectory",
+		Setup: []Op{
+			{Kind: Mkdir, Path: "dir", Mode: 0755},
+			{Kind: Chmod, Path: "dir", Mode: 01777},
+			{Kind: Open, Path: "dir/file", Flags: unix.O_WRONLY | unix.O_CREAT, Mode: 0644},
+			{Kind: Chown, Path: "dir/file", Owner: Owner},
+			{Kind: Open, Path: "dir/other", Flags: unix.O_WRONLY | unix.O_CREAT, Mode: 0644, FD: 1},
+			{Kind: Chown, Path: "dir/other", Owner: Owner},
+		},
+		Steps: []Step{
+			{Op: Op{Kind: Stat, Path: "dir"}, Want: Outputs("dir 1777")},
+			{As: Other, Op: Op{Kind: Unlink, Path: "dir/file"}, Want: Fails(unix.EPERM)},
+			{As: Group, Op: Op{Kind: Rename, Path: "dir/file", Target: "dir/renamed"}, Want: Fails(unix.EPERM)},
+			{As: Other, Op: Op{Kind: Open, Path: "dir/new", Flags: unix.O_WRONLY | unix.O_CREAT, Mode: 0644}, Want: Ok},
+			{As: Other, Op: Op{Kind: Rename, Path: "dir/new", Target: "dir/other"}, Want: Fails(unix.EPERM)},
+			{As: Owner, Op: Op{Kind: Unlink, Path: "dir/file"}, Want: Ok},
+			{Op: Op{Kind: Unlink, Path: "dir/other"}, Want: Ok},
+		},
+	},
+	{
+		Name: "StickyDirectoryOwner",
+		Setup: []Op{
+			{Kind: Mkdir, Path: "dir", Mode: 0755},
+			{Kind: Chmod, Path: "dir", Mode: 01777},
+			{Kind: Chown, Path: "dir", Owner: Owner},
+			{Kind: Open, Path: "dir/file", Flags: unix.O_WRONLY | unix.O_CREAT, Mode: 0644},
+			{Kind: Chown, Path: "dir/file", Owner: Other},
+		},
+		Steps: []Step{
+			{As: Group, Op: Op{Kind: Unlink, Path: "dir/file"}, Want: Fails(unix.EPERM)},
+			{As: Owner, Op: Op{Kind: Unlink, Path: "dir/file"}, Want: Ok},
+		},
+	},
+
+	// Symlink loops.
+	{
+		Name: "SymlinkLoop",
+		Setup: []Op{
+			{Kind: Symlink, Path: "a", Target: "b"},
+			{Kind: Symlink, Path: "b", Target: "a"},
+		},
+		Steps: []Step{
+			{Op: Op{Kind: Open, Path: "a", Flags: unix.O_RDONLY}, Want: Fails(unix.ELOOP)},
+			{Op: Op{Kind: Open, Path: "a", Flags: unix.O_WRONLY | unix.O_CREAT, Mode: 0644}, Want: Fails(unix.ELOOP)},
+			{Op: Op{Kind: Stat, Path: "a/file"}, Want: Fails(unix.ELOOP)},
+			{Op: Op{Kind: Truncate, Path: "b"}, Want: Fails(unix.ELOOP)},
+			{Op: Op{Kind: Stat, Path: "a"}, Want: Outputs("symlink 0777 size=1")},
+		},
+	},
+	{
+		Name: "SymlinkSelfLoop",
+		Setup: []Op{
+			{Kind: Symlink, Path: "self", Target: "self"},
+		},
+		Steps: []Step{
+			{Op: Op{Kind: Chmod, Path: "self", Mode: 0644}, Want: Fails(unix.ELOOP)},
+			{Op: Op{Kind: Unlink, Path: "self"}, Want: Ok},
+		},
+	},
+
+	// Name limits.
+	{
+		Name: "NameTooLong",
+		Steps: []Step{
+			{Op: Op{Kind: Open, Path: longName, Flags: unix.O_WRONLY | unix.O_CREAT, Mode: 0644}, Want: Fails(unix.ENAMETOOLONG)},
+			{Op: Op{Kind: Mkdir, Path: longName, Mode: 0755}, Want: Fails(unix.ENAMETOOLONG)},
+			{Op: Op{Kind: Symlink, Path: longName, Target: "target"}, Want: Fails(unix.ENAMETOOLONG)},
+			{Op: Op{Kind: Stat, Path: longName}, Want: Fails(unix.ENAMETOOLONG)},
+			{Op: Op{Kind: Mkdir, Path: longName[1:], Mode: 0755}, Want: Ok},
+		},
+	},
+	{
+		Name: "RenameNameTooLong",
+		Setup: []Op{
+			{Kind: Open, Path: "file", Flags: unix.O_WRONLY | unix.O_CREAT, Mode: 0644},
+		},
+		Steps: []Step{
+			{Op: Op{Kind: Rename, Path: "file", Target: longName}, Want: Fails(unix.ENAMETOOLONG)},
+			{Op: Op{Kind: Stat, Path: "file"}, Want: fileStatus(created(0644), 0)},
+		},
+	},
+	{
+		Name: "PathTooLong",
+		Steps: []Step{
+			{Op: Op{Kind: Stat, Path: longPath}, Want: Fails(unix.ENAMETOOLONG)},
+			{Op: Op{Kind: Mkdir, Path: longPath, Mode: 0755}, Want: Fails(unix.ENAMETOOLONG)},
+		},
+	},
+}
@@ -0,0 +1,26 @@
+// Copyright 2020 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not
+// use this file except in compliance with the License.  You may obtain a copy
+// of the License at:
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.  See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+// Package posix implements a table-driven POSIX conformance suite in the style of pjdfstest.
+//
+// Every case prepares a few entries in a fresh directory and then runs a sequence of steps, each of
+// which applies an operation on behalf of a role (the test itself, the owner of the entries, a
+// member of the owner's group, or anybody else) and compares its result against the one mandated
+// by POSIX.  Operations on behalf of roles other than the test itself run in a helper process with
+// the credentials of the role, which the test binary provides by calling HelperMain.
+//
+// The expectations in the table describe the behavior of a native Linux file system.  Steps for
+// which sandboxfs knowingly deviates from that behavior carry an override with the result that
+// sandboxfs produces and the reason for the deviation.
+package posix
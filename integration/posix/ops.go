@@ -0,0 +1,341 @@
+// Copyright 2020 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not
+// use this file except in compliance with the License.  You may obtain a copy
+// of the License at:
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.  See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package posix
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// Kind represents the type of an operation.
+type Kind int
+
+// List of valid operation kinds.
+const (
+	Open Kind = iota
+	Close
+	Read
+	Write
+	Stat
+	Truncate
+	Mkdir
+	Rmdir
+	Unlink
+	Rename
+	Link
+	Symlink
+	Chmod
+	Chown
+)
+
+// kindNames contains the names of the operation kinds, indexed by their values.
+var kindNames = [...]string{
+	Open:     "open",
+	Close:    "close",
+	Read:     "read",
+	Write:    "write",
+	Stat:     "stat",
+	Truncate: "truncate",
+	Mkdir:    "mkdir",
+	Rmdir:    "rmdir",
+	Unlink:   "unlink",
+	Rename:   "rename",
+	Link:     "link",
+	Symlink:  "symlink",
+	Chmod:    "chmod",
+	Chown:    "chown",
+}
+
+// String returns the name of the operation kind.
+func (k Kind) String() string {
+	if k < 0 || int(k) >= len(kindNames) {
+		return fmt.Sprintf("Kind(%d)", int(k))
+	}
+	return kindNames[k]
+}
+
+// Op represents a single file system operation.  Paths are relative to the directory of the case.
+type Op struct {
+	// Kind is the type of the operation.
+	Kind Kind
+
+	// Path is the entry affected by the operation.
+	Path string
+
+	// Target is the new name of the entry for Rename and Link, and the target of the link for
+	// Symlink.
+	Target string
+
+	// Flags contains the flags passed to Open.
+	Flags int
+
+	// Mode contains the permissions for Open, Mkdir and Chmod.
+	Mode uint32
+
+	// Size is the new size of the entry for Truncate.
+	Size int64
+
+	// FD is the slot of the descriptor that Open fills and that Close, Read and Write use.
+	// Descriptors only survive across steps run on behalf of Self.
+	FD int
+
+	// Data contains the bytes to write for Write.
+	Data string
+
+	// Owner is the role whose user and group become the owners of the entry for Chown.
+	Owner Role
+}
+
+// String formats the operation as a function call.
+func (op Op) String() string {
+	switch op.Kind {
+	case Open:
+		return fmt.Sprintf("open(%q, %s, %04o) = fd%d", op.Path, flagsString(op.Flags), op.Mode, op.FD)
+	case Close:
+		return fmt.Sprintf("close(fd%d)", op.FD)
+	case Read:
+		return fmt.Sprintf("read(fd%d)", op.FD)
+	case Write:
+		return fmt.Sprintf("write(fd%d, %q)", op.FD, op.Data)
+	case Truncate:
+		return fmt.Sprintf("truncate(%q, %d)", op.Path, op.Size)
+	case Mkdir, Chmod:
+		return fmt.Sprintf("%s(%q, %04o)", op.Kind, op.Path, op.Mode)
+	case Rename, Link:
+		return fmt.Sprintf("%s(%q, %q)", op.Kind, op.Path, op.Target)
+	case Symlink:
+		return fmt.Sprintf("symlink(%q, %q)", op.Target, op.Path)
+	case Chown:
+		return fmt.Sprintf("chown(%q, %s)", op.Path, op.Owner)
+	default:
+		return fmt.Sprintf("%s(%q)", op.Kind, op.Path)
+	}
+}
+
+// openFlags contains the names of the flags that flagsString knows how to format.
+var openFlags = []struct {
+	flag int
+	name string
+}{
+	{unix.O_CREAT, "O_CREAT"},
+	{unix.O_EXCL, "O_EXCL"},
+	{unix.O_TRUNC, "O_TRUNC"},
+	{unix.O_APPEND, "O_APPEND"},
+	{unix.O_DIRECTORY, "O_DIRECTORY"},
+	{unix.O_NOFOLLOW, "O_NOFOLLOW"},
+}
+
+// flagsString formats the flags of an open call symbolically.
+func flagsString(flags int) string {
+	var names []string
+	switch flags & unix.O_ACCMODE {
+	case unix.O_RDONLY:
+		names = append(names, "O_RDONLY")
+	case unix.O_WRONLY:
+		names = append(names, "O_WRONLY")
+	case unix.O_RDWR:
+		names = append(names, "O_RDWR")
+	}
+	flags &^= unix.O_ACCMODE
+	for _, f := range openFlags {
+		if flags&f.flag != 0 {
+			names = append(names, f.name)
+			flags &^= f.flag
+		}
+	}
+	if flags != 0 {
+		names = append(names, fmt.Sprintf("%#x", flags))
+	}
+	return strings.Join(names, "|")
+}
+
+// Result represents the outcome of an operation.
+type Result struct {
+	// Errno is the error returned by the operation, or 0 if it succeeded.
+	Errno syscall.Errno
+
+	// Output contains the observable output of successful Read and Stat operations, and is
+	// empty for all others.
+	Output string
+}
+
+// String formats the result for display.
+func (r Result) String() string {
+	if r.Errno != 0 {
+		name := unix.ErrnoName(r.Errno)
+		if name == "" {
+			name = fmt.Sprintf("errno %d", int(r.Errno))
+		}
+		return name
+	}
+	if r.Output != "" {
+		return fmt.Sprintf("ok (%s)", r.Output)
+	}
+	return "ok"
+}
+
+// Ok is the result of an operation that succeeds without producing output.
+var Ok = Result{}
+
+// Fails returns the result of an operation that fails with the given error.
+func Fails(errno syscall.Errno) Result {
+	return Result{Errno: errno}
+}
+
+// Outputs returns the result of an operation that succeeds and produces the given output.
+func Outputs(output string) Result {
+	return Result{Output: output}
+}
+
+// errnoOf extracts the error number from an error returned by the unix package.
+func errnoOf(err error) syscall.Errno {
+	if err == nil {
+		return 0
+	}
+	if errno, ok := err.(syscall.Errno); ok {
+		return errno
+	}
+	panic(fmt.Sprintf("unexpected non-errno error %v", err))
+}
+
+// describe formats the properties of the entry at path that Stat reports.  Directory sizes are
+// omitted because they depend on the underlying file system.
+func describe(path string) (string, error) {
+	var st unix.Stat_t
+	if err := unix.Lstat(path, &st); err != nil {
+		return "", err
+	}
+	var kind string
+	switch st.Mode & unix.S_IFMT {
+	case unix.S_IFREG:
+		kind = "file"
+	case unix.S_IFDIR:
+		return fmt.Sprintf("dir %04o", st.Mode&07777), nil
+	case unix.S_IFLNK:
+		kind = "symlink"
+	case unix.S_IFIFO:
+		kind = "fifo"
+	default:
+		kind = fmt.Sprintf("type %#o", st.Mode&unix.S_IFMT)
+	}
+	return fmt.Sprintf("%s %04o size=%d", kind, st.Mode&07777, st.Size), nil
+}
+
+// join concatenates dir and a relative path without cleaning the result, which would otherwise
+// collapse the special components that some cases rely on, like a trailing dot.
+func join(dir string, rel string) string {
+	return dir + "/" + rel
+}
+
+// apply runs an operation within dir.  fds holds the descriptors opened by previous operations and
+// ids resolves the roles given to Chown.
+func apply(dir string, op Op, fds map[int]int, ids *Identities) Result {
+	path := join(dir, op.Path)
+	var err error
+	switch op.Kind {
+	case Open:
+		var fd int
+		fd, err = unix.Open(path, op.Flags, op.Mode)
+		if err == nil {
+			if old, ok := fds[op.FD]; ok {
+				unix.Close(old)
+			}
+			fds[op.FD] = fd
+		}
+
+	case Close, Read, Write:
+		fd, ok := fds[op.FD]
+		if !ok {
+			return Fails(unix.EBADF)
+		}
+		switch op.Kind {
+		case Close:
+			delete(fds, op.FD)
+			err = unix.Close(fd)
+		case Read:
+			buf := make([]byte, 4096)
+			var n int
+			n, err = unix.Pread(fd, buf, 0)
+			if err == nil {
+				return Outputs(fmt.Sprintf("%q", buf[:n]))
+			}
+		case Write:
+			_, err = unix.Write(fd, []byte(op.Data))
+		}
+
+	case Stat:
+		var output string
+		output, err = describe(path)
+		if err == nil {
+			return Outputs(output)
+		}
+
+	case Truncate:
+		err = unix.Truncate(path, op.Size)
+
+	case Mkdir:
+		err = unix.Mkdir(path, op.Mode)
+
+	case Rmdir:
+		err = unix.Rmdir(path)
+
+	case Unlink:
+		err = unix.Unlink(path)
+
+	case Rename:
+		err = unix.Rename(path, join(dir, op.Target))
+
+	case Link:
+		err = unix.Link(path, join(dir, op.Target))
+
+	case Symlink:
+		err = unix.Symlink(op.Target, path)
+
+	case Chmod:
+		err = unix.Chmod(path, op.Mode)
+
+	case Chown:
+		user := ids.get(op.Owner)
+		err = unix.Lchown(path, user.UID, user.GID)
+
+	default:
+		panic(fmt.Sprintf("unknown operation kind %d", op.Kind))
+	}
+	return Fails(errnoOf(err))
+}
+
+// closeAll closes all descriptors in fds.
+func closeAll(fds map[int]int) {
+	for slot, fd := range fds {
+		unix.Close(fd)
+		delete(fds, slot)
+	}
+}
+
+// makeAccessible gives the owner full access to all directories under dir so that the tree can
+// be deleted even if a case revoked permissions from some of them.
+func makeAccessible(dir string) {
+	filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err == nil && info.IsDir() {
+			os.Chmod(path, info.Mode().Perm()|0700)
+		}
+		return nil
+	})
+}
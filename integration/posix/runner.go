@@ -0,0 +1,347 @@
+// Copyright 2020 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not
+// use this file except in compliance with the License.  You may obtain a copy
+// of the License at:
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.  See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package posix
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	"github.com/bazelbuild/sandboxfs/integration/utils"
+)
+
+// Role represents the identity on whose behalf a step runs.
+type Role int
+
+// List of valid roles.
+const (
+	// Self is the identity of the test itself.  Steps run by Self happen in-process.
+	Self Role = iota
+
+	// Owner is the user that the cases make the owner of the entries they test.
+	Owner
+
+	// Group is a user that is not the owner but belongs to the owner's group.
+	Group
+
+	// Other is a user that is neither the owner nor a member of the owner's group.
+	Other
+)
+
+// String returns the name of the role.
+func (r Role) String() string {
+	switch r {
+	case Self:
+		return "self"
+	case Owner:
+		return "owner"
+	case Group:
+		return "group"
+	case Other:
+		return "other"
+	default:
+		return fmt.Sprintf("Role(%d)", int(r))
+	}
+}
+
+// Identities maps the roles other than Self to users.
+type Identities struct {
+	Owner *utils.UnixUser
+	Group *utils.UnixUser
+	Other *utils.UnixUser
+}
+
+// LookupIdentities finds three users with distinct primary groups to act as the Owner, Group and
+// Other roles, skipping the given usernames.
+//
+// Real users rarely share groups, so the Group role joins the primary group of the Owner only in
+// the credentials that the helper runs with.  The users are found with the harness' own lookup,
+// which knows about the synthetic users of a user namespace.
+func LookupIdentities(exclude ...string) (*Identities, error) {
+	var users []*utils.UnixUser
+	gids := make(map[int]bool)
+	for len(users) < 3 {
+		user, err := utils.LookupUserOtherThan(exclude...)
+		if err != nil {
+			return nil, err
+		}
+		exclude = append(exclude, user.Username)
+		if gids[user.GID] {
+			continue
+		}
+		gids[user.GID] = true
+		users = append(users, user)
+	}
+
+	withGroups := func(user *utils.UnixUser, groups ...int) *utils.UnixUser {
+		with := *user
+		with.Groups = groups
+		return &with
+	}
+	owner, group, other := users[0], users[1], users[2]
+	return &Identities{
+		Owner: withGroups(owner, owner.GID),
+		Group: withGroups(group, group.GID, owner.GID),
+		Other: withGroups(other, other.GID),
+	}, nil
+}
+
+// get returns the user of the given role, which cannot be Self.
+func (ids *Identities) get(role Role) *utils.UnixUser {
+	switch role {
+	case Owner:
+		return ids.Owner
+	case Group:
+		return ids.Group
+	case Other:
+		return ids.Other
+	default:
+		panic(fmt.Sprintf("role %s has no user", role))
+	}
+}
+
+// Deviation describes a known divergence of sandboxfs from the expected behavior of a step.
+type Deviation struct {
+	// Want is the result that sandboxfs produces.
+	Want Result
+
+	// Reason explains why sandboxfs deviates.
+	Reason string
+}
+
+// Step represents an operation to run and its expected result.
+type Step struct {
+	// As is the role on whose behalf the operation runs.
+	As Role
+
+	// Op is the operation to run.
+	Op Op
+
+	// Want is the result mandated by POSIX.
+	Want Result
+
+	// Sandboxfs overrides Want when running on sandboxfs, or is nil if sandboxfs conforms.
+	Sandboxfs *Deviation
+}
+
+// Case represents a single conformance test.
+type Case struct {
+	// Name is the unique name of the case, which must be usable as a file name.
+	Name string
+
+	// Setup contains operations that prepare the directory of the case.  They run on behalf of
+	// Self and must succeed.  Entries whose permissions matter must be given them with Chmod, as
+	// the modes passed to Open and Mkdir are subject to the umask.
+	Setup []Op
+
+	// Steps contains the operations under test.
+	Steps []Step
+}
+
+// needsHelper returns true if any of the steps of the case run on behalf of a role other than Self.
+func (c *Case) needsHelper() bool {
+	for _, step := range c.Steps {
+		if step.As != Self {
+			return true
+		}
+	}
+	return false
+}
+
+// ErrNoHelper indicates that a case could not run because it needs a helper process to switch
+// credentials and the runner has none, or has no users to switch to.
+var ErrNoHelper = errors.New("case requires running steps as other users but there is no helper")
+
+// Runner runs conformance cases within a directory.
+type Runner struct {
+	// Dir is the directory in which cases create their own subdirectories.
+	Dir string
+
+	// Helper is the path to the binary that runs operations on behalf of roles other than
+	// Self, or empty if there is none.  See PrepareHelper.
+	Helper string
+
+	// Identities contains the users of the roles, or nil if there are none.  See LookupIdentities.
+	Identities *Identities
+
+	// Sandboxfs is true if the directory is backed by sandboxfs, in which case the known
+	// deviations of each step replace its expected result.
+	Sandboxfs bool
+}
+
+// Run runs a single case in a new subdirectory of the runner's directory, which is deleted on
+// return.  Returns ErrNoHelper if the case cannot run, or an error listing all steps whose result
+// did not match the expectation.  A known deviation that does not happen is an error too, because
+// it means the override is stale and must be removed.
+func (r *Runner) Run(c *Case) error {
+	if c.needsHelper() && (r.Helper == "" || r.Identities == nil) {
+		return ErrNoHelper
+	}
+
+	dir := filepath.Join(r.Dir, c.Name)
+	if err := os.Mkdir(dir, 0755); err != nil {
+		return err
+	}
+	// The directory may be left with any permissions by Setup, so explicitly set them.
+	if err := os.Chmod(dir, 0755); err != nil {
+		return err
+	}
+	fds := make(map[int]int)
+	defer func() {
+		closeAll(fds)
+		makeAccessible(dir)
+		os.RemoveAll(dir)
+	}()
+
+	for _, op := range c.Setup {
+		if result := apply(dir, op, fds, r.Identities); result.Errno != 0 {
+			return fmt.Errorf("setup %s failed: %s", op, result)
+		}
+	}
+
+	var problems []string
+	for i, step := range c.Steps {
+		var result Result
+		if step.As == Self {
+			result = apply(dir, step.Op, fds, r.Identities)
+		} else {
+			var err error
+			result, err = r.runHelper(dir, step)
+			if err != nil {
+				return fmt.Errorf("step %d: %v", i, err)
+			}
+		}
+
+		want := step.Want
+		if r.Sandboxfs && step.Sandboxfs != nil {
+			want = step.Sandboxfs.Want
+			if result == step.Want && result != want {
+				problems = append(problems, fmt.Sprintf("step %d: %s as %s: got %s; known deviation (%s) no longer happens", i, step.Op, step.As, result, step.Sandboxfs.Reason))
+				continue
+			}
+		}
+		if result != want {
+			problems = append(problems, fmt.Sprintf("step %d: %s as %s: got %s; want %s", i, step.Op, step.As, result, want))
+		}
+	}
+	if len(problems) > 0 {
+		return errors.New(strings.Join(problems, "\n"))
+	}
+	return nil
+}
+
+// helperEnv is the name of the environment variable that makes HelperMain take over the process.
+const helperEnv = "SANDBOXFS_POSIX_HELPER"
+
+// helperRequest is the message that the runner sends to the helper process.
+type helperRequest struct {
+	Dir        string
+	Op         Op
+	Identities *Identities
+}
+
+// runHelper runs the operation of a step in a helper process with the credentials of its role.
+func (r *Runner) runHelper(dir string, step Step) (Result, error) {
+	request, err := json.Marshal(&helperRequest{Dir: dir, Op: step.Op, Identities: r.Identities})
+	if err != nil {
+		return Result{}, err
+	}
+
+	cmd := exec.Command(r.Helper)
+	cmd.Env = append(os.Environ(), helperEnv+"=1")
+	cmd.Stdin = bytes.NewReader(request)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	cmd.SysProcAttr = &syscall.SysProcAttr{
+		Credential: r.Identities.get(step.As).ToCredential(),
+	}
+	if err := cmd.Run(); err != nil {
+		return Result{}, fmt.Errorf("helper failed: %v; stderr: %s", err, stderr.String())
+	}
+
+	var result Result
+	if err := json.Unmarshal(stdout.Bytes(), &result); err != nil {
+		return Result{}, fmt.Errorf("invalid helper output %q: %v", stdout.String(), err)
+	}
+	return result, nil
+}
+
+// serveHelper runs the operation given in the request read from in and writes its result to out.
+func serveHelper(in io.Reader, out io.Writer) error {
+	var request helperRequest
+	if err := json.NewDecoder(in).Decode(&request); err != nil {
+		return err
+	}
+	fds := make(map[int]int)
+	defer closeAll(fds)
+	result := apply(request.Dir, request.Op, fds, request.Identities)
+	return json.NewEncoder(out).Encode(&result)
+}
+
+// HelperMain turns the current process into the helper that runs operations on behalf of other
+// roles, if the runner started it for that purpose, and exits.  Otherwise returns immediately.
+// Test binaries that use a Runner with a Helper must call this first thing in TestMain.
+func HelperMain() {
+	if os.Getenv(helperEnv) == "" {
+		return
+	}
+	if err := serveHelper(os.Stdin, os.Stdout); err != nil {
+		fmt.Fprintf(os.Stderr, "posix helper: %v\n", err)
+		os.Exit(1)
+	}
+	os.Exit(0)
+}
+
+// PrepareHelper copies the current binary into dir so that it can serve as the helper of a Runner,
+// and returns the path to the copy.  The copy is necessary because test binaries usually live in
+// directories that other users cannot access.  dir must be accessible by all roles.
+func PrepareHelper(dir string) (string, error) {
+	self, err := os.Executable()
+	if err != nil {
+		return "", err
+	}
+	input, err := os.Open(self)
+	if err != nil {
+		return "", err
+	}
+	defer input.Close()
+
+	helper := filepath.Join(dir, "posix-helper")
+	output, err := os.OpenFile(helper, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0755)
+	if err != nil {
+		return "", err
+	}
+	if _, err := io.Copy(output, input); err != nil {
+		output.Close()
+		return "", err
+	}
+	if err := output.Close(); err != nil {
+		return "", err
+	}
+	// The mode given to OpenFile is subject to the umask, but all roles must be able to run the
+	// helper.
+	if err := os.Chmod(helper, 0755); err != nil {
+		return "", err
+	}
+	return helper, nil
+}
@@ -0,0 +1,159 @@
+// Copyright 2020 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not
+// use this file except in compliance with the License.  You may obtain a copy
+// of the License at:
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.  See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package posix
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+
+	"golang.org/x/sys/unix"
+)
+
+func TestMain(m *testing.M) {
+	HelperMain()
+	os.Exit(m.Run())
+}
+
+// setUpRunner creates a runner on a temporary directory that all roles can access.  The runner
+// only has a helper if the test runs as root, which is necessary to switch credentials.
+func setUpRunner(t *testing.T, sandboxfs bool) *Runner {
+	t.Helper()
+
+	dir, err := ioutil.TempDir("", "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	if err := os.Chmod(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	var helper string
+	var ids *Identities
+	if os.Getuid() == 0 {
+		helper, err = PrepareHelper(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids, err = LookupIdentities()
+		if err != nil {
+			t.Logf("Cases that need other users will be skipped: %v", err)
+		}
+	}
+	return &Runner{Dir: dir, Helper: helper, Identities: ids, Sandboxfs: sandboxfs}
+}
+
+func TestCases_Unique(t *testing.T) {
+	names := make(map[string]bool)
+	for _, c := range Cases {
+		if names[c.Name] {
+			t.Errorf("Duplicate case %s", c.Name)
+		}
+		names[c.Name] = true
+	}
+}
+
+// TestCases_Native validates the expectations of the suite against the native file system.
+func TestCases_Native(t *testing.T) {
+	runner := setUpRunner(t, false)
+	for _, c := range Cases {
+		c := c
+		t.Run(c.Name, func(t *testing.T) {
+			if err := runner.Run(&c); err == ErrNoHelper {
+				t.Skipf("Requires root privileges to run steps as other users")
+			} else if err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
+
+func TestRunner_Deviations(t *testing.T) {
+	testData := []struct {
+		name string
+
+		want      Result
+		deviation *Deviation
+		sandboxfs bool
+		wantError string
+	}{
+		{"ConformingNative", Fails(unix.ENOENT), nil, false, ""},
+		{"ConformingSandboxfs", Fails(unix.ENOENT), nil, true, ""},
+		{"MismatchNative", Ok, nil, false, "got ENOENT; want ok"},
+		{"DeviationIgnoredOnNative", Ok, &Deviation{Want: Fails(unix.ENOENT)}, false, "got ENOENT; want ok"},
+		{"DeviationApplied", Ok, &Deviation{Want: Fails(unix.ENOENT)}, true, ""},
+		{"DeviationStale", Fails(unix.ENOENT), &Deviation{Want: Fails(unix.EPERM), Reason: "foo"}, true, "known deviation (foo) no longer happens"},
+		{"DeviationMismatch", Ok, &Deviation{Want: Fails(unix.EPERM)}, true, "got ENOENT; want EPERM"},
+	}
+	for _, d := range testData {
+		t.Run(d.name, func(t *testing.T) {
+			runner := setUpRunner(t, d.sandboxfs)
+			c := Case{
+				Name: "case",
+				Steps: []Step{
+					{Op: Op{Kind: Unlink, Path: "missing"}, Want: d.want, Sandboxfs: d.deviation},
+				},
+			}
+			err := runner.Run(&c)
+			if d.wantError == "" {
+				if err != nil {
+					t.Errorf("Got %v; want no error", err)
+				}
+			} else if err == nil || !strings.Contains(err.Error(), d.wantError) {
+				t.Errorf("Got %v; want error containing %s", err, d.wantError)
+			}
+		})
+	}
+}
+
+func TestRunner_SetupFailure(t *testing.T) {
+	runner := setUpRunner(t, false)
+	c := Case{Name: "case", Setup: []Op{{Kind: Rmdir, Path: "missing"}}}
+	if err := runner.Run(&c); err == nil || !strings.Contains(err.Error(), `setup rmdir("missing") failed: ENOENT`) {
+		t.Errorf("Got %v; want setup failure", err)
+	}
+}
+
+func TestRunner_NoHelper(t *testing.T) {
+	runner := setUpRunner(t, false)
+	runner.Helper = ""
+	c := Case{Name: "case", Steps: []Step{{As: Other, Op: Op{Kind: Stat, Path: "."}}}}
+	if err := runner.Run(&c); err != ErrNoHelper {
+		t.Errorf("Got %v; want %v", err, ErrNoHelper)
+	}
+}
+
+func TestOp_String(t *testing.T) {
+	testData := []struct {
+		op   Op
+		want string
+	}{
+		{Op{Kind: Open, Path: "a", Flags: unix.O_WRONLY | unix.O_CREAT | unix.O_EXCL, Mode: 0644, FD: 1}, `open("a", O_WRONLY|O_CREAT|O_EXCL, 0644) = fd1`},
+		{Op{Kind: Read, FD: 2}, `read(fd2)`},
+		{Op{Kind: Write, Data: "x"}, `write(fd0, "x")`},
+		{Op{Kind: Mkdir, Path: "a", Mode: 0755}, `mkdir("a", 0755)`},
+		{Op{Kind: Rename, Path: "a", Target: "b"}, `rename("a", "b")`},
+		{Op{Kind: Symlink, Path: "a", Target: "b"}, `symlink("b", "a")`},
+		{Op{Kind: Chown, Path: "a", Owner: Group}, `chown("a", group)`},
+		{Op{Kind: Unlink, Path: "a"}, `unlink("a")`},
+	}
+	for _, d := range testData {
+		if got := d.op.String(); got != d.want {
+			t.Errorf("Got %s; want %s", got, d.want)
+		}
+	}
+}
@@ -0,0 +1,62 @@
+// Copyright 2020 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not
+// use this file except in compliance with the License.  You may obtain a copy
+// of the License at:
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.  See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package integration
+
+import (
+	"os"
+	"testing"
+
+	"github.com/bazelbuild/sandboxfs/integration/posix"
+	"github.com/bazelbuild/sandboxfs/integration/utils"
+)
+
+func TestPosix_Conformance(t *testing.T) {
+	t.Parallel()
+
+	utils.RequireRoot(t, "Requires root privileges to run operations as other users")
+
+	ids, err := posix.LookupIdentities()
+	if err != nil {
+		t.Skipf("Cannot find users for all roles: %v", err)
+	}
+
+	// Other users must be able to reach the mount point and the helper binary, and the file
+	// system must let them in.
+	state := utils.MountSetup(t, utils.WithArgs("--allow=other", "--mapping=rw:/:%ROOT%"))
+	if err := os.Chmod(state.TempPath(), 0755); err != nil {
+		t.Fatal(err)
+	}
+	helper, err := posix.PrepareHelper(state.TempPath())
+	if err != nil {
+		t.Fatalf("Failed to prepare helper binary: %v", err)
+	}
+
+	runner := &posix.Runner{
+		Dir:        state.MountPath(),
+		Helper:     helper,
+		Identities: ids,
+		Sandboxfs:  true,
+	}
+	for _, c := range posix.Cases {
+		c := c
+		t.Run(c.Name, func(t *testing.T) {
+			t.Parallel()
+
+			if err := runner.Run(&c); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
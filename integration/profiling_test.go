@@ -19,11 +19,15 @@ import (
 	"os"
 	"path/filepath"
 	"testing"
+	"time"
 
+	"github.com/bazelbuild/sandboxfs/integration/cpuprof"
 	"github.com/bazelbuild/sandboxfs/integration/utils"
 )
 
 func TestProfiling_OptionalSupport(t *testing.T) {
+	t.Parallel()
+
 	tempDir, err := ioutil.TempDir("", "test")
 	if err != nil {
 		t.Fatalf("Failed to create temporary directory: %v", err)
@@ -34,7 +38,10 @@ func TestProfiling_OptionalSupport(t *testing.T) {
 	arg := "--cpu_profile=" + profile
 
 	if _, ok := utils.GetConfig().Features["profiling"]; ok {
-		state := utils.MountSetup(t, arg, "--mapping=ro:/:%ROOT%")
+		// The profiler slows sandboxfs down, and writing the profile on exit takes longer
+		// than a plain unmount.
+		state := utils.MountSetup(t, utils.WithArgs(arg, "--mapping=ro:/:%ROOT%"),
+			utils.WithStartupDeadline(20*time.Second), utils.WithShutdownDeadline(10*time.Second))
 		// Explicitly stop sandboxfs (which is different to what most other tests do).
 		// We need to do this here to cause the profiles to be written to disk.
 		state.TearDown(t)
@@ -49,6 +56,19 @@ func TestProfiling_OptionalSupport(t *testing.T) {
 		if stat.Size() == 0 {
 			t.Errorf("Expected profile %s is empty", profile)
 		}
+
+		// The file system is mostly idle during the test so the profile may not contain
+		// any samples, but it must still be well-formed and describe the process' memory.
+		p, err := cpuprof.ParseFile(profile)
+		if err != nil {
+			t.Fatalf("Failed to parse profile: %v", err)
+		}
+		if p.Period <= 0 {
+			t.Errorf("Got sampling period %v; want a positive value", p.Period)
+		}
+		if len(p.Mappings) == 0 {
+			t.Errorf("Profile %s lacks memory mappings", profile)
+		}
 	} else {
 		_, stderr, err := utils.RunAndWait(1, arg, filepath.Join(tempDir, "root"))
 		if err != nil {
@@ -0,0 +1,252 @@
+// Copyright 2020 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not
+// use this file except in compliance with the License.  You may obtain a copy
+// of the License at:
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.  See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package protocol
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+// Err returns the error carried by a response, if any.
+func (resp Response) Err() error {
+	if resp.Error == nil {
+		return nil
+	}
+	return fmt.Errorf("%s", *resp.Error)
+}
+
+// Client sends reconfiguration requests to a sandboxfs instance and matches them with their
+// responses.
+//
+// sandboxfs may process requests in parallel and thus may respond to them in any order.  The only
+// correlation between a request and its response is the sandbox identifier, so the client keeps
+// a queue of outstanding requests per identifier and hands each response to the oldest request
+// waiting for it.  Responses that nobody is waiting for (e.g. because the request timed out or
+// because the response was duplicated) are discarded.  Note that this means that a late response
+// may be mistaken for the answer to a newer request on the same sandbox.
+//
+// Once the responses stream breaks (because it reached EOF, because it contained malformed data,
+// or because sandboxfs reported an unrecoverable error), all outstanding and future requests fail
+// with the error that broke the stream.
+type Client struct {
+	// input is the stream to which requests are written.
+	input io.Writer
+
+	// writeMu serializes writes to input so that requests are not interleaved.
+	writeMu sync.Mutex
+
+	// done is closed once the responses stream is broken and err is set.
+	done chan struct{}
+
+	// mu protects all fields below.
+	mu sync.Mutex
+
+	// pending contains the queues of outstanding requests, keyed by sandbox identifier.
+	pending map[string][]chan Response
+
+	// stray counts the responses that were discarded because nobody was waiting for them.
+	stray int
+
+	// err is the error that broke the responses stream, if any.
+	err error
+}
+
+// NewClient instantiates a new client that sends requests to input and reads responses from
+// output.  Responses are consumed in the background until output is exhausted.
+func NewClient(input io.Writer, output io.Reader) *Client {
+	c := &Client{
+		input:   input,
+		done:    make(chan struct{}),
+		pending: make(map[string][]chan Response),
+	}
+	go c.readResponses(output)
+	return c
+}
+
+// readResponses consumes all responses from output and dispatches them to their waiters.
+func (c *Client) readResponses(output io.Reader) {
+	decoder := json.NewDecoder(output)
+	for {
+		var resp Response
+		if err := decoder.Decode(&resp); err == io.EOF {
+			c.fail(fmt.Errorf("responses stream closed"))
+			return
+		} else if err != nil {
+			c.fail(fmt.Errorf("malformed response: %v", err))
+			return
+		}
+
+		if resp.ID == nil {
+			message := "no details"
+			if resp.Error != nil {
+				message = *resp.Error
+			}
+			c.fail(fmt.Errorf("sandboxfs stopped processing requests: %s", message))
+			return
+		}
+
+		c.mu.Lock()
+		waiters := c.pending[*resp.ID]
+		if len(waiters) == 0 {
+			c.stray++
+		} else {
+			waiters[0] <- resp
+			c.removeWaiterLocked(*resp.ID, waiters[0])
+		}
+		c.mu.Unlock()
+	}
+}
+
+// fail marks the responses stream as broken due to err and wakes up all waiters.
+func (c *Client) fail(err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.err = err
+	c.pending = make(map[string][]chan Response)
+	close(c.done)
+}
+
+// removeWaiterLocked removes a waiter from the queue of the given sandbox identifier.  Must be
+// called with mu held.
+func (c *Client) removeWaiterLocked(id string, waiter chan Response) {
+	waiters := c.pending[id]
+	for i, other := range waiters {
+		if other == waiter {
+			waiters = append(waiters[:i], waiters[i+1:]...)
+			break
+		}
+	}
+	if len(waiters) == 0 {
+		delete(c.pending, id)
+	} else {
+		c.pending[id] = waiters
+	}
+}
+
+// Call represents a request that has been sent and that is waiting for its response.
+type Call struct {
+	// client is the client that sent the request.
+	client *Client
+
+	// id is the sandbox identifier of the request.
+	id string
+
+	// waiter is the channel through which the response is delivered.
+	waiter chan Response
+}
+
+// Start sends a request without waiting for its response.  Requests are guaranteed to be written
+// to the input stream in the order in which Start is called.
+func (c *Client) Start(req Request) (*Call, error) {
+	data, err := json.Marshal(req)
+	if err != nil {
+		return nil, fmt.Errorf("cannot encode request: %v", err)
+	}
+	return c.StartRaw(req.ID(), data)
+}
+
+// StartRaw is like Start but sends the given encoded request verbatim.  id must be the sandbox
+// identifier the request refers to so that its response can be matched.
+func (c *Client) StartRaw(id string, data []byte) (*Call, error) {
+	if len(data) == 0 || data[len(data)-1] != '\n' {
+		data = append(data, '\n')
+	}
+
+	call := &Call{client: c, id: id, waiter: make(chan Response, 1)}
+	c.mu.Lock()
+	if c.err != nil {
+		c.mu.Unlock()
+		return nil, c.err
+	}
+	c.pending[call.id] = append(c.pending[call.id], call.waiter)
+	c.mu.Unlock()
+
+	c.writeMu.Lock()
+	_, err := c.input.Write(data)
+	c.writeMu.Unlock()
+	if err != nil {
+		call.forget()
+		return nil, fmt.Errorf("failed to send request for sandbox %s: %v", call.id, err)
+	}
+	return call, nil
+}
+
+// forget stops waiting for the response to the call.
+func (call *Call) forget() {
+	call.client.mu.Lock()
+	defer call.client.mu.Unlock()
+	call.client.removeWaiterLocked(call.id, call.waiter)
+}
+
+// Wait waits up to timeout for the response to the call.  Note that the returned response may
+// carry an error reported by sandboxfs, which callers must check for.
+func (call *Call) Wait(timeout time.Duration) (Response, error) {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case resp := <-call.waiter:
+		return resp, nil
+	case <-call.client.done:
+		select {
+		case resp := <-call.waiter:
+			return resp, nil // Response arrived right before the stream broke.
+		default:
+		}
+		return Response{}, call.client.Err()
+	case <-timer.C:
+		call.forget()
+		select {
+		case resp := <-call.waiter:
+			return resp, nil // Response arrived right as the timer fired.
+		default:
+		}
+		return Response{}, fmt.Errorf("timed out after %v waiting for response to request for sandbox %s", timeout, call.id)
+	}
+}
+
+// Do sends a request and waits up to timeout for its response.  Note that the returned response
+// may carry an error reported by sandboxfs, which callers must check for.
+func (c *Client) Do(req Request, timeout time.Duration) (Response, error) {
+	call, err := c.Start(req)
+	if err != nil {
+		return Response{}, err
+	}
+	return call.Wait(timeout)
+}
+
+// Err returns the error that broke the responses stream, or nil if the stream is still healthy.
+func (c *Client) Err() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.err
+}
+
+// Stray returns the number of responses that were discarded because no request was waiting for
+// them.
+func (c *Client) Stray() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.stray
+}
+
+// Wait blocks until the responses stream is exhausted or broken and returns the reason.
+func (c *Client) Wait() error {
+	<-c.done
+	return c.Err()
+}
@@ -0,0 +1,271 @@
+// Copyright 2020 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not
+// use this file except in compliance with the License.  You may obtain a copy
+// of the License at:
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.  See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package protocol
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+const (
+	// shortTimeout is the timeout for requests whose responses are expected to never arrive.
+	shortTimeout = 100 * time.Millisecond
+
+	// longTimeout is the timeout for requests whose responses are expected to arrive.
+	longTimeout = 10 * time.Second
+)
+
+// faultyFakeState holds the components of a client talking to a fake server through a fault peer.
+type faultyFakeState struct {
+	client *Client
+	peer   *FaultPeer
+	root   string
+	done   chan error
+}
+
+// startFaultyFake starts a fake server in the background behind a fault peer configured with the
+// given schedule, and returns a client connected to it.
+func startFaultyFake(t *testing.T, schedule map[int]Fault) *faultyFakeState {
+	t.Helper()
+
+	root, err := ioutil.TempDir("", "test")
+	if err != nil {
+		t.Fatalf("Failed to create temporary directory: %v", err)
+	}
+	if err := os.Mkdir(root+"/dir", 0755); err != nil {
+		t.Fatalf("Failed to create test directory: %v", err)
+	}
+
+	fake := NewFake("")
+	inputReader, inputWriter := io.Pipe()
+	outputReader, outputWriter := io.Pipe()
+	done := make(chan error, 1)
+	go func() {
+		done <- fake.Serve(inputReader, outputWriter)
+		outputWriter.Close()
+	}()
+
+	peer := NewFaultPeer(inputWriter, outputReader, schedule)
+	return &faultyFakeState{
+		client: NewClient(peer.Input, peer.Output),
+		peer:   peer,
+		root:   root,
+		done:   done,
+	}
+}
+
+// tearDown closes the client's input and waits for all components to finish.  Returns the error
+// with which the client's responses stream terminated.
+func (s *faultyFakeState) tearDown(t *testing.T) error {
+	t.Helper()
+
+	s.peer.Input.Close()
+	<-s.done
+	s.peer.Wait()
+	err := s.client.Wait()
+	s.peer.Output.Close()
+	os.RemoveAll(s.root)
+	return err
+}
+
+// create issues a request to create a sandbox named id that maps the test's directory.
+func (s *faultyFakeState) create(id string, timeout time.Duration) (Response, error) {
+	req := MakeCreateSandboxRequest(id, Mapping{Path: "/", UnderlyingPath: s.root + "/dir"})
+	return s.client.Do(req, timeout)
+}
+
+// mustCreate is like create but fails the test if the sandbox cannot be created.
+func (s *faultyFakeState) mustCreate(t *testing.T, id string) {
+	t.Helper()
+
+	resp, err := s.create(id, longTimeout)
+	if err != nil {
+		t.Fatalf("Request for %s failed: %v", id, err)
+	}
+	if resp.ID == nil || *resp.ID != id {
+		t.Fatalf("Got response for id %v; want %s", resp.ID, id)
+	}
+	if resp.Error != nil {
+		t.Fatalf("Create of %s failed: %s", id, *resp.Error)
+	}
+}
+
+// matches returns true if s matches the given regular expression.
+func matches(pattern string, s string) bool {
+	return regexp.MustCompile(pattern).MatchString(s)
+}
+
+func TestClient_NoFaults(t *testing.T) {
+	state := startFaultyFake(t, nil)
+
+	state.mustCreate(t, "first")
+	state.mustCreate(t, "second")
+	resp, err := state.create("first", longTimeout)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Err() == nil || !strings.Contains(resp.Err().Error(), "Already mapped") {
+		t.Errorf("Got %v; want duplicate create to fail", resp.Err())
+	}
+
+	if err := state.tearDown(t); !strings.Contains(err.Error(), "closed") {
+		t.Errorf("Got %v; want stream to be closed", err)
+	}
+	if state.client.Stray() != 0 {
+		t.Errorf("Got %d stray responses; want 0", state.client.Stray())
+	}
+}
+
+func TestClient_DelayWithinTimeout(t *testing.T) {
+	state := startFaultyFake(t, map[int]Fault{0: {Kind: FaultDelay, Delay: shortTimeout}})
+	state.mustCreate(t, "first")
+	state.mustCreate(t, "second")
+	state.tearDown(t)
+}
+
+func TestClient_TimeoutAndRecovery(t *testing.T) {
+	testData := []struct {
+		name string
+
+		fault Fault
+	}{
+		{"Delay", Fault{Kind: FaultDelay, Delay: 5 * shortTimeout}},
+		{"Drop", Fault{Kind: FaultDrop}},
+	}
+	for _, d := range testData {
+		t.Run(d.name, func(t *testing.T) {
+			state := startFaultyFake(t, map[int]Fault{0: d.fault})
+
+			_, err := state.create("lost", shortTimeout)
+			if err == nil || !strings.Contains(err.Error(), "timed out") {
+				t.Fatalf("Got %v; want request to time out", err)
+			}
+
+			// The client must continue to work for other sandboxes, and the late response
+			// (if any) must not confuse it.
+			state.mustCreate(t, "recovered")
+
+			state.tearDown(t)
+			wantStray := 0
+			if d.fault.Kind == FaultDelay {
+				wantStray = 1
+			}
+			if state.client.Stray() != wantStray {
+				t.Errorf("Got %d stray responses; want %d", state.client.Stray(), wantStray)
+			}
+		})
+	}
+}
+
+func TestClient_Reorder(t *testing.T) {
+	state := startFaultyFake(t, map[int]Fault{0: {Kind: FaultReorder}, 2: {Kind: FaultReorder}})
+
+	// Send all requests before waiting for any response so that the responses held back by the
+	// peer do not prevent the following requests from being processed.
+	ids := []string{"a", "b", "c", "d"}
+	calls := make([]*Call, len(ids))
+	for i, id := range ids {
+		req := MakeCreateSandboxRequest(id, Mapping{Path: "/", UnderlyingPath: state.root + "/dir"})
+		call, err := state.client.Start(req)
+		if err != nil {
+			t.Fatalf("Failed to send request for %s: %v", id, err)
+		}
+		calls[i] = call
+	}
+	for i, call := range calls {
+		resp, err := call.Wait(longTimeout)
+		if err != nil {
+			t.Errorf("Request for %s failed: %v", ids[i], err)
+		} else if resp.ID == nil || *resp.ID != ids[i] {
+			t.Errorf("Got response for %v; want %s", resp.ID, ids[i])
+		} else if resp.Error != nil {
+			t.Errorf("Create of %s failed: %s", ids[i], *resp.Error)
+		}
+	}
+
+	state.tearDown(t)
+	if state.client.Stray() != 0 {
+		t.Errorf("Got %d stray responses; want 0", state.client.Stray())
+	}
+}
+
+func TestClient_DuplicateIsDiscarded(t *testing.T) {
+	state := startFaultyFake(t, map[int]Fault{0: {Kind: FaultDuplicate}})
+	state.mustCreate(t, "first")
+	state.mustCreate(t, "second")
+	state.tearDown(t)
+	if state.client.Stray() != 1 {
+		t.Errorf("Got %d stray responses; want 1", state.client.Stray())
+	}
+}
+
+func TestClient_BrokenStream(t *testing.T) {
+	testData := []struct {
+		name string
+
+		fault     Fault
+		wantError string
+	}{
+		{"Malformed", Fault{Kind: FaultMalformed}, "malformed response"},
+		{"Truncate", Fault{Kind: FaultTruncate}, "malformed response.*unexpected EOF"},
+		{"Close", Fault{Kind: FaultClose}, "responses stream closed"},
+	}
+	for _, d := range testData {
+		t.Run(d.name, func(t *testing.T) {
+			state := startFaultyFake(t, map[int]Fault{1: d.fault})
+
+			state.mustCreate(t, "first")
+
+			// The broken response must wake up the waiting request well before its
+			// timeout, and all future requests must fail right away.
+			start := time.Now()
+			if _, err := state.create("second", longTimeout); err == nil || !matches(d.wantError, err.Error()) {
+				t.Errorf("Got %v; want error matching %s", err, d.wantError)
+			}
+			if _, err := state.create("third", longTimeout); err == nil || !matches(d.wantError, err.Error()) {
+				t.Errorf("Got %v; want error matching %s", err, d.wantError)
+			}
+			if elapsed := time.Since(start); elapsed >= longTimeout {
+				t.Errorf("Requests took %v to fail; want them to fail without waiting for the timeout", elapsed)
+			}
+
+			if err := state.tearDown(t); !matches(d.wantError, err.Error()) {
+				t.Errorf("Got %v; want stream to be broken with %s", err, d.wantError)
+			}
+		})
+	}
+}
+
+func TestClient_FatalServerError(t *testing.T) {
+	state := startFaultyFake(t, nil)
+
+	state.mustCreate(t, "first")
+	if _, err := io.WriteString(state.peer.Input, "{\"bogus\": 1}\n"); err != nil {
+		t.Fatalf("Failed to send bogus request: %v", err)
+	}
+	if err := state.client.Wait(); err == nil || !strings.Contains(err.Error(), "stopped processing") {
+		t.Errorf("Got %v; want client to report the fatal error", err)
+	}
+	if _, err := state.create("second", longTimeout); err == nil {
+		t.Errorf("Request succeeded after fatal error; want it to fail")
+	}
+
+	state.tearDown(t)
+}
@@ -0,0 +1,149 @@
+// Copyright 2020 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not
+// use this file except in compliance with the License.  You may obtain a copy
+// of the License at:
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.  See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package protocol
+
+import (
+	"encoding/json"
+	"path"
+	"strconv"
+)
+
+// minimizedMapping is the encoding of a mapping using the minimized field names and omitting all
+// fields that have their default values.
+type minimizedMapping struct {
+	Path                 string `json:"p"`
+	PathPrefix           int    `json:"x,omitempty"`
+	UnderlyingPath       string `json:"u"`
+	UnderlyingPathPrefix int    `json:"y,omitempty"`
+	Writable             bool   `json:"w,omitempty"`
+}
+
+// minimizedCreateSandbox is the encoding of a create request using the minimized field names.
+type minimizedCreateSandbox struct {
+	ID       string             `json:"i"`
+	Mappings []minimizedMapping `json:"m,omitempty"`
+	Prefixes map[string]string  `json:"q,omitempty"`
+}
+
+// minimizedRequest is the encoding of a request using the minimized names.
+type minimizedRequest struct {
+	CreateSandbox  *minimizedCreateSandbox `json:"C,omitempty"`
+	DestroySandbox *string                 `json:"D,omitempty"`
+}
+
+// EncodeMinimized encodes a request using the minimized names accepted by sandboxfs, which yields
+// the smallest possible representation of the request without altering its contents.
+func EncodeMinimized(req Request) ([]byte, error) {
+	var minimized minimizedRequest
+	if req.CreateSandbox != nil {
+		create := &minimizedCreateSandbox{ID: req.CreateSandbox.ID, Prefixes: req.CreateSandbox.Prefixes}
+		for _, m := range req.CreateSandbox.Mappings {
+			create.Mappings = append(create.Mappings, minimizedMapping(m))
+		}
+		minimized.CreateSandbox = create
+	}
+	minimized.DestroySandbox = req.DestroySandbox
+	return json.Marshal(minimized)
+}
+
+// PrefixCompressor rewrites create requests to share common path prefixes.
+//
+// Prefixes are global to a reconfiguration stream, so a single compressor must be used for all
+// requests sent through the same stream and the requests must be sent in the order in which they
+// were compressed.
+type PrefixCompressor struct {
+	// ids maps the paths that have been assigned a prefix to their identifiers.
+	ids map[string]int
+}
+
+// NewPrefixCompressor instantiates a new compressor for a fresh stream.
+func NewPrefixCompressor() *PrefixCompressor {
+	return &PrefixCompressor{ids: make(map[string]int)}
+}
+
+// parent returns the parent directory of a path, with a trailing slash, and its basename.  Returns
+// an empty parent if the path cannot be split.
+func parent(p string) (string, string) {
+	if p == "/" || !path.IsAbs(p) {
+		return "", p
+	}
+	dir, base := path.Split(p)
+	if base == "" {
+		// Trailing slashes carry no meaning, so drop them to find the real parent.
+		dir, base = path.Split(path.Clean(p))
+	}
+	return dir, base
+}
+
+const (
+	// prefixUseCost is the approximate number of bytes that referring to a prefix adds to a
+	// minimized mapping.
+	prefixUseCost = len(`"x":1,`)
+
+	// prefixDefinitionCost is the approximate number of bytes that defining a prefix adds to a
+	// minimized request on top of the prefix's path.
+	prefixDefinitionCost = len(`"1":"",`)
+)
+
+// split computes the prefix and suffix to use for a path.  A prefix is only used when doing so
+// saves space, which requires the parent directory to be long enough and, if the prefix does not
+// exist yet, to be used enough times by the request, as determined by uses.  New prefixes are
+// registered in defined.
+func (c *PrefixCompressor) split(p string, uses map[string]int, defined map[string]string) (int, string) {
+	dir, base := parent(p)
+	if len(dir) <= prefixUseCost {
+		return 0, p
+	}
+
+	id, ok := c.ids[dir]
+	if !ok {
+		if uses[dir]*(len(dir)-prefixUseCost) <= len(dir)+prefixDefinitionCost {
+			return 0, p
+		}
+		id = len(c.ids) + 1
+		c.ids[dir] = id
+		defined[strconv.Itoa(id)] = dir
+	}
+	return id, base
+}
+
+// Compress returns a copy of a create request, whose mappings must not use prefixes, in which paths
+// refer to a prefix that holds their parent directory whenever doing so saves space.  Prefixes that
+// were not defined by earlier requests processed by this compressor are defined in the returned
+// request.
+func (c *PrefixCompressor) Compress(req *CreateSandboxRequest) *CreateSandboxRequest {
+	uses := make(map[string]int)
+	for _, m := range req.Mappings {
+		for _, p := range []string{m.Path, m.UnderlyingPath} {
+			if dir, _ := parent(p); dir != "" {
+				uses[dir]++
+			}
+		}
+	}
+
+	defined := make(map[string]string)
+	result := &CreateSandboxRequest{ID: req.ID, Mappings: make([]Mapping, 0, len(req.Mappings))}
+	for _, m := range req.Mappings {
+		var compressed Mapping
+		compressed.PathPrefix, compressed.Path = c.split(m.Path, uses, defined)
+		compressed.UnderlyingPathPrefix, compressed.UnderlyingPath = c.split(m.UnderlyingPath, uses, defined)
+		compressed.Writable = m.Writable
+		result.Mappings = append(result.Mappings, compressed)
+	}
+	if len(defined) > 0 {
+		result.Prefixes = defined
+	}
+	return result
+}
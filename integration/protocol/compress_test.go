@@ -0,0 +1,108 @@
+// Copyright 2020 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not
+// use this file except in compliance with the License.  You may obtain a copy
+// of the License at:
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.  See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package protocol
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestEncodeMinimized(t *testing.T) {
+	testData := []struct {
+		name string
+
+		req  Request
+		want string
+	}{
+		{
+			"Create",
+			Request{CreateSandbox: &CreateSandboxRequest{
+				ID: "sb",
+				Mappings: []Mapping{
+					{Path: "/", UnderlyingPath: "/a"},
+					{Path: "b", PathPrefix: 1, UnderlyingPath: "c", UnderlyingPathPrefix: 2, Writable: true},
+				},
+				Prefixes: map[string]string{"1": "/x", "2": "/y"},
+			}},
+			`{"C":{"i":"sb","m":[{"p":"/","u":"/a"},{"p":"b","x":1,"u":"c","y":2,"w":true}],"q":{"1":"/x","2":"/y"}}}`,
+		},
+		{"Destroy", MakeDestroySandboxRequest("sb"), `{"D":"sb"}`},
+	}
+	for _, d := range testData {
+		t.Run(d.name, func(t *testing.T) {
+			data, err := EncodeMinimized(d.req)
+			if err != nil {
+				t.Fatalf("Failed to encode request: %v", err)
+			}
+			if string(data) != d.want {
+				t.Errorf("Got %s; want %s", data, d.want)
+			}
+
+			var decoded Request
+			if err := json.Unmarshal(data, &decoded); err != nil {
+				t.Fatalf("Failed to decode minimized request: %v", err)
+			}
+			if !reflect.DeepEqual(decoded, d.req) {
+				t.Errorf("Got %+v after round trip; want %+v", decoded, d.req)
+			}
+		})
+	}
+}
+
+func TestPrefixCompressor_RoundTrip(t *testing.T) {
+	requests := []*CreateSandboxRequest{
+		{ID: "first", Mappings: []Mapping{
+			{Path: "/", UnderlyingPath: "/root"},
+			{Path: "/src/main.c", UnderlyingPath: "/home/user/workspace/src/main.c"},
+			{Path: "/src/util.c", UnderlyingPath: "/home/user/workspace/src/util.c", Writable: true},
+			{Path: "/src/lib.c", UnderlyingPath: "/home/user/workspace/src/lib.c"},
+		}},
+		{ID: "second", Mappings: []Mapping{
+			{Path: "/src/main.c", UnderlyingPath: "/home/user/workspace/src/main.c"},
+			{Path: "/out/a", UnderlyingPath: "/home/user/workspace/bazel-out/a"},
+			{Path: "/out/b", UnderlyingPath: "/home/user/workspace/bazel-out/b/", Writable: true},
+			{Path: "/single", UnderlyingPath: "/tmp/single/file"},
+		}},
+	}
+
+	compressor := NewPrefixCompressor()
+	prefixes := NewPrefixes()
+	var defined []int
+	for _, req := range requests {
+		compressed := compressor.Compress(req)
+		defined = append(defined, len(compressed.Prefixes))
+
+		got, err := prefixes.Resolve(compressed)
+		if err != nil {
+			t.Fatalf("Failed to resolve compressed request %s: %v", req.ID, err)
+		}
+		for i := range got {
+			want := req.Mappings[i]
+			if want.UnderlyingPath == "/home/user/workspace/bazel-out/b/" {
+				want.UnderlyingPath = "/home/user/workspace/bazel-out/b" // Trailing slashes are dropped.
+			}
+			if !reflect.DeepEqual(got[i], want) {
+				t.Errorf("Got mapping %+v in %s; want %+v", got[i], req.ID, want)
+			}
+		}
+	}
+
+	// Each request should only define the prefixes that are worth it and that previous requests
+	// did not define already.
+	if !reflect.DeepEqual(defined, []int{1, 1}) {
+		t.Errorf("Got %v new prefixes per request; want [1 1]", defined)
+	}
+}
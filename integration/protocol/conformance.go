@@ -0,0 +1,246 @@
+// Copyright 2020 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not
+// use this file except in compliance with the License.  You may obtain a copy
+// of the License at:
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.  See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package protocol
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+// Endpoint represents a peer that implements the server side of the reconfiguration protocol.
+type Endpoint struct {
+	// Input is the stream to which requests are written.
+	Input io.Writer
+
+	// Output is the stream from which responses are read.
+	Output io.Reader
+
+	// Root is a scratch directory in which the conformance suite places the files that its
+	// requests map.  Any %ROOT% string in a request is replaced by this path.
+	Root string
+
+	// Stat checks if the given path, relative to the top of the served file system, exists.
+	// The served file system is expected to expose Root at its top level, as a
+	// --mapping=rw:/:Root flag would do.  May be nil for implementations that do not expose a
+	// file system, in which case the suite only checks the responses to the requests.
+	Stat func(path string) error
+}
+
+// EndpointFactory instantiates a new endpoint for a single conformance case.  The returned
+// function is invoked once the case completes and must tear down the endpoint.
+type EndpointFactory func(t *testing.T) (*Endpoint, func())
+
+// conformanceStep represents a single request issued by a conformance case and the expectations
+// on its outcome.
+type conformanceStep struct {
+	// request is the raw request to send.
+	request string
+
+	// wantError is a regular expression that the error in the response must match, or empty if
+	// the request must succeed.
+	wantError string
+
+	// wantPaths lists paths, relative to the top of the file system, that must exist once the
+	// request has been processed.
+	wantPaths []string
+}
+
+// conformanceCase represents a sequence of requests that verifies a single protocol rule.
+type conformanceCase struct {
+	// name is the name of the test case.
+	name string
+
+	// rule is a description of the protocol rule verified by the case.  Reported on failure.
+	rule string
+
+	// steps is the sequence of requests to issue, in order.
+	steps []conformanceStep
+}
+
+// conformanceCases contains all the conformance cases in the suite.
+var conformanceCases = []conformanceCase{
+	{
+		"InvalidMapping",
+		"mapping paths must be absolute",
+		[]conformanceStep{
+			{`{"CreateSandbox":{"id":"sb","mappings":[{"path":"foo/../.","underlying_path":"%ROOT%/subdir"}]}}`, "path.*not absolute", []string{"file"}},
+		},
+	},
+	{
+		"MapRootLate",
+		"the root of a sandbox can only be mapped by the first mapping of a request",
+		[]conformanceStep{
+			{`{"CreateSandbox":{"id":"sb","mappings":[{"path":"/too-late","underlying_path":"%ROOT%/subdir"},{"path":"/","underlying_path":"%ROOT%/subdir"}]}}`, "Root can be mapped at most once", []string{"file"}},
+		},
+	},
+	{
+		"MapTwice",
+		"a path that is already mapped cannot be mapped again",
+		[]conformanceStep{
+			{`{"CreateSandbox":{"id":"sb","mappings":[{"path":"/foo","underlying_path":"%ROOT%/subdir"}]}}`, "", []string{"sb/foo"}},
+			{`{"CreateSandbox":{"id":"sb","mappings":[{"path":"/foo","underlying_path":"%ROOT%/file"}]}}`, "Already mapped", []string{"file", "sb/foo"}},
+		},
+	},
+	{
+		"MapSubrootLate",
+		"the root of a sandbox can only be mapped by the first mapping of a request",
+		[]conformanceStep{
+			{`{"CreateSandbox":{"id":"sb","mappings":[{"path":"/too-late","underlying_path":"%ROOT%/file"},{"path":"/","underlying_path":"%ROOT%/subdir"}]}}`, "Root can be mapped at most once", []string{"file"}},
+		},
+	},
+	{
+		"UnmapInvalidID",
+		"sandbox identifiers cannot be empty",
+		[]conformanceStep{
+			{`{"DestroySandbox":""}`, "Identifier cannot be empty", []string{"file"}},
+		},
+	},
+	{
+		"UnmapNotBasename",
+		"sandbox identifiers must be basenames",
+		[]conformanceStep{
+			{`{"DestroySandbox":"a/b"}`, "Identifier a/b is not a basename", []string{"file"}},
+		},
+	},
+	{
+		"BadPrefixes",
+		"prefixes must be defined before they are used",
+		[]conformanceStep{
+			{`{"CreateSandbox":{"id":"sb","mappings":[{"path":"foo","path_prefix":5,"underlying_path":"%ROOT%/file"},{"path":"/","underlying_path":"%ROOT%/subdir"}]}}`, "Prefix 5 does not exist", []string{"file"}},
+		},
+	},
+	{
+		"PrefixRedefinition",
+		"a prefix cannot be redefined with a different path",
+		[]conformanceStep{
+			{`{"CreateSandbox":{"id":"sb1","mappings":[{"path":"a","path_prefix":1,"underlying_path":"%ROOT%/x"}],"prefixes":{"1":"/first"}}}`, "", []string{"sb1/first/a"}},
+			{`{"CreateSandbox":{"id":"sb2","mappings":[{"path":"a","path_prefix":1,"underlying_path":"%ROOT%/y"}],"prefixes":{"1":"/second"}}}`, "Prefix 1 already had path /first but got new /second", []string{"file", "sb1/first/a"}},
+		},
+	},
+	{
+		"PrefixSuffixNotRelative",
+		"paths relative to a prefix must be relative",
+		[]conformanceStep{
+			{`{"CreateSandbox":{"id":"sb","mappings":[{"path":"/a","path_prefix":1,"underlying_path":"%ROOT%/x"}],"prefixes":{"1":"/first"}}}`, "Suffix /a must be relative", []string{"file"}},
+		},
+	},
+	{
+		"Prefixes",
+		"prefixes remain defined for subsequent requests",
+		[]conformanceStep{
+			{`{"CreateSandbox":{"id":"sb1","mappings":[{"path":"a","path_prefix":1,"underlying_path":"x","underlying_path_prefix":2,"writable":true}],"prefixes":{"1":"/foo/bar","2":"%ROOT%"}}}`, "", []string{"sb1/foo/bar/a"}},
+			{`{"CreateSandbox":{"id":"sb2","mappings":[{"path":"","path_prefix":3,"underlying_path":"y","underlying_path_prefix":2,"writable":true}],"prefixes":{"3":"/"}}}`, "", []string{"sb1/foo/bar/a", "sb2"}},
+		},
+	},
+	{
+		"Minimized",
+		"requests can use the minimized field names",
+		[]conformanceStep{
+			{`{"C":{"i":"empty","q":{"1":"%ROOT%"}}}`, "", []string{"empty"}},
+			{`{"C":{"i":"sb1","m":[{"p":"/a","u":"%ROOT%"}]}}`, "", []string{"sb1/a"}},
+			{`{"C":{"i":"sb2","m":[{"p":"a","x":2,"u":"dir","y":1,"w":true}],"q":{"2":"/x"}}}`, "", []string{"sb2/x/a"}},
+			{`{"D":"empty"}`, "", []string{"sb1/a", "sb2/x/a"}},
+		},
+	},
+}
+
+// setUpConformanceRoot populates the scratch directory of an endpoint with the files that the
+// conformance cases expect.
+func setUpConformanceRoot(root string) error {
+	for _, dir := range []string{"dir", "subdir", "x", "y"} {
+		if err := os.MkdirAll(filepath.Join(root, dir), 0755); err != nil {
+			return err
+		}
+	}
+	return ioutil.WriteFile(filepath.Join(root, "file"), []byte{}, 0644)
+}
+
+// runConformanceCase issues all requests of a conformance case against an endpoint.
+func runConformanceCase(t *testing.T, endpoint *Endpoint, c conformanceCase) {
+	t.Helper()
+
+	// Tag all failures with the rule under test so that they can be traced back to the
+	// protocol specification without having to read the test code.
+	broken := func(format string, arg ...interface{}) {
+		t.Helper()
+		t.Errorf("Rule %q broken: %s", c.rule, fmt.Sprintf(format, arg...))
+	}
+
+	decoder := json.NewDecoder(endpoint.Output)
+	for i, step := range c.steps {
+		var req Request
+		if err := json.Unmarshal([]byte(step.request), &req); err != nil {
+			panic(fmt.Sprintf("invalid request in conformance case %s: %v", c.name, err))
+		}
+		raw := strings.Replace(step.request, "%ROOT%", endpoint.Root, -1) + "\n"
+		if _, err := io.WriteString(endpoint.Input, raw); err != nil {
+			t.Fatalf("Failed to send request %d: %v", i, err)
+		}
+
+		var resp Response
+		if err := decoder.Decode(&resp); err != nil {
+			t.Fatalf("Failed to read response to request %d: %v", i, err)
+		}
+
+		if resp.ID == nil || *resp.ID != req.ID() {
+			broken("request %d: got response for id %v, want %s", i, resp.ID, req.ID())
+		}
+		if step.wantError == "" {
+			if resp.Error != nil {
+				broken("request %d: got error %s, want success", i, *resp.Error)
+			}
+		} else {
+			if resp.Error == nil {
+				broken("request %d: got success, want error matching %s", i, step.wantError)
+			} else if !regexp.MustCompile(step.wantError).MatchString(*resp.Error) {
+				broken("request %d: got error %s, want error matching %s", i, *resp.Error, step.wantError)
+			}
+		}
+
+		if endpoint.Stat != nil {
+			for _, path := range step.wantPaths {
+				if err := endpoint.Stat(path); err != nil {
+					broken("request %d: want %s to exist; got %v", i, path, err)
+				}
+			}
+		}
+	}
+}
+
+// RunConformance runs the reconfiguration protocol conformance suite against the endpoints
+// created by factory.  Each case in the suite runs as a separate subtest on a fresh endpoint.
+func RunConformance(t *testing.T, factory EndpointFactory) {
+	t.Helper()
+
+	for _, c := range conformanceCases {
+		c := c
+		t.Run(c.name, func(t *testing.T) {
+			endpoint, tearDown := factory(t)
+			defer tearDown()
+
+			if err := setUpConformanceRoot(endpoint.Root); err != nil {
+				t.Fatalf("Failed to populate %s: %v", endpoint.Root, err)
+			}
+			runConformanceCase(t, endpoint, c)
+		})
+	}
+}
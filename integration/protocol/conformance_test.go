@@ -0,0 +1,62 @@
+// Copyright 2020 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not
+// use this file except in compliance with the License.  You may obtain a copy
+// of the License at:
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.  See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package protocol
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+// startFake runs a new fake server in the background and returns an endpoint to talk to it.
+// The returned function stops the server and cleans up any files.
+func startFake(t *testing.T) (*Endpoint, func()) {
+	t.Helper()
+
+	root, err := ioutil.TempDir("", "test")
+	if err != nil {
+		t.Fatalf("Failed to create temporary directory: %v", err)
+	}
+
+	fake := NewFake(root)
+	inputReader, inputWriter := io.Pipe()
+	outputReader, outputWriter := io.Pipe()
+	done := make(chan error, 1)
+	go func() {
+		done <- fake.Serve(inputReader, outputWriter)
+		outputWriter.Close()
+	}()
+
+	endpoint := &Endpoint{
+		Input:  inputWriter,
+		Output: outputReader,
+		Root:   root,
+		Stat:   fake.Stat,
+	}
+	tearDown := func() {
+		inputWriter.Close()
+		if err := <-done; err != nil {
+			t.Errorf("Fake server failed: %v", err)
+		}
+		outputReader.Close()
+		os.RemoveAll(root)
+	}
+	return endpoint, tearDown
+}
+
+func TestFake_Conformance(t *testing.T) {
+	RunConformance(t, startFake)
+}
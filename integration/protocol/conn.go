@@ -0,0 +1,100 @@
+// Copyright 2020 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not
+// use this file except in compliance with the License.  You may obtain a copy
+// of the License at:
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.  See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package protocol
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+)
+
+// Conn represents an open channel to a sandboxfs instance.
+type Conn struct {
+	// Client sends requests through the channel and matches them with their responses.
+	*Client
+
+	// closers are the resources to release once the connection is no longer needed, in order.
+	closers []func() error
+}
+
+// Close releases all resources held by the connection.
+func (c *Conn) Close() error {
+	var first error
+	for _, closer := range c.closers {
+		if err := closer(); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
+
+// OpenFIFOs opens the reconfiguration FIFOs of a sandboxfs instance given in its --input and
+// --output flags.
+//
+// The input is opened before the output because that's the order in which sandboxfs opens them,
+// and opening a FIFO blocks until its other end is opened too.  Keep in mind that sandboxfs stops
+// accepting requests as soon as the input is closed.
+func OpenFIFOs(input string, output string) (*os.File, *os.File, error) {
+	inputFile, err := os.OpenFile(input, os.O_WRONLY, 0)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to open reconfiguration input: %v", err)
+	}
+	outputFile, err := os.Open(output)
+	if err != nil {
+		inputFile.Close()
+		return nil, nil, fmt.Errorf("failed to open reconfiguration output: %v", err)
+	}
+	return inputFile, outputFile, nil
+}
+
+// Connect opens a channel to sandboxfs, either via the socket of a multiplexing daemon if socket is
+// not empty, or via the input and output FIFOs otherwise.
+func Connect(socket string, input string, output string) (*Conn, error) {
+	if socket != "" {
+		conn, err := net.Dial("unix", socket)
+		if err != nil {
+			return nil, fmt.Errorf("failed to connect to %s: %v", socket, err)
+		}
+		return &Conn{
+			Client:  NewClient(conn, conn),
+			closers: []func() error{conn.Close},
+		}, nil
+	}
+
+	inputFile, outputFile, err := OpenFIFOs(input, output)
+	if err != nil {
+		return nil, err
+	}
+	return &Conn{
+		Client:  NewClient(inputFile, outputFile),
+		closers: []func() error{inputFile.Close, outputFile.Close},
+	}, nil
+}
+
+// ReadManifest loads a create request from a JSON file.  The manifest has the same format as the
+// body of a CreateSandbox request, minimized field names and prefixes included.
+func ReadManifest(path string) (*CreateSandboxRequest, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read manifest: %v", err)
+	}
+	var req CreateSandboxRequest
+	if err := json.Unmarshal(data, &req); err != nil {
+		return nil, fmt.Errorf("invalid manifest %s: %v", path, err)
+	}
+	return &req, nil
+}
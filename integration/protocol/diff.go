@@ -0,0 +1,201 @@
+// Copyright 2020 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not
+// use this file except in compliance with the License.  You may obtain a copy
+// of the License at:
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.  See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package protocol
+
+import (
+	"sort"
+)
+
+// MappingChange describes how the mapping of a path differs between two sandboxes.
+type MappingChange struct {
+	// Path is the normalized path of the mapping within the sandbox.
+	Path string `json:"path"`
+
+	// Old is the mapping of the path in the old sandbox, or nil if there was none.
+	Old *Mapping `json:"old,omitempty"`
+
+	// New is the mapping of the path in the new sandbox, or nil if there is none.
+	New *Mapping `json:"new,omitempty"`
+
+	// Added is true if the path is only mapped in the new sandbox.
+	Added bool `json:"added,omitempty"`
+
+	// Removed is true if the path is only mapped in the old sandbox.
+	Removed bool `json:"removed,omitempty"`
+
+	// Retargeted is true if the path is mapped in both sandboxes to different underlying paths.
+	Retargeted bool `json:"retargeted,omitempty"`
+
+	// ModeChanged is true if the path is mapped in both sandboxes but is writable in only one.
+	ModeChanged bool `json:"mode_changed,omitempty"`
+}
+
+// NodeState describes a node of the effective tree of a sandbox, ignoring its children.
+type NodeState struct {
+	// Kind indicates whether the node is a mapping or a scaffold directory.
+	Kind NodeKind `json:"kind"`
+
+	// UnderlyingPath is the path exposed by the node if it is a mapping, or empty otherwise.
+	UnderlyingPath string `json:"underlying_path,omitempty"`
+}
+
+// NodeChange describes how a node of the effective tree differs between two sandboxes.
+type NodeChange struct {
+	// Path is the absolute path of the node within the sandbox.
+	Path string `json:"path"`
+
+	// Old is the state of the node in the old sandbox, or nil if the node did not exist.
+	Old *NodeState `json:"old,omitempty"`
+
+	// New is the state of the node in the new sandbox, or nil if the node does not exist.
+	New *NodeState `json:"new,omitempty"`
+}
+
+// SandboxDiff holds the differences between two sandboxes.
+type SandboxDiff struct {
+	// Mappings contains the mappings that differ, sorted by path.
+	Mappings []MappingChange `json:"mappings"`
+
+	// Tree contains the nodes of the effective trees that differ, sorted by path.  This reveals
+	// changes caused by nesting, such as scaffold directories that appear or disappear, and
+	// mappings that do not take effect because they conflict with earlier ones.
+	Tree []NodeChange `json:"tree"`
+}
+
+// Empty returns true if the sandboxes are equivalent.
+func (d *SandboxDiff) Empty() bool {
+	return len(d.Mappings) == 0 && len(d.Tree) == 0
+}
+
+// indexMappings returns the mappings keyed by their normalized path.  If a path is mapped more than
+// once, only its first mapping is kept, as that's the one that takes effect.
+func indexMappings(mappings []Mapping) (map[string]Mapping, error) {
+	index := make(map[string]Mapping)
+	for _, m := range mappings {
+		path, err := normalizeMappingPath(m.Path)
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := index[path]; !ok {
+			m.Path = path
+			index[path] = m
+		}
+	}
+	return index, nil
+}
+
+// indexNodes returns the state of all nodes in a tree keyed by their path.
+func indexNodes(root *LayoutNode) map[string]NodeState {
+	index := make(map[string]NodeState)
+	root.Walk(func(node *LayoutNode) {
+		index[node.Path] = NodeState{Kind: node.Kind, UnderlyingPath: node.UnderlyingPath}
+	})
+	return index
+}
+
+// sortedUnion returns the sorted union of two sets of strings.
+func sortedUnion(keys1 []string, keys2 []string) []string {
+	seen := make(map[string]bool)
+	var all []string
+	for _, key := range append(keys1, keys2...) {
+		if !seen[key] {
+			seen[key] = true
+			all = append(all, key)
+		}
+	}
+	sort.Strings(all)
+	return all
+}
+
+// DiffSandboxes compares the mappings of two sandboxes, whose prefixes must have already been
+// resolved.
+func DiffSandboxes(oldMappings []Mapping, newMappings []Mapping) (*SandboxDiff, error) {
+	oldIndex, err := indexMappings(oldMappings)
+	if err != nil {
+		return nil, err
+	}
+	newIndex, err := indexMappings(newMappings)
+	if err != nil {
+		return nil, err
+	}
+	oldTree, err := BuildLayoutWithConflicts(oldMappings)
+	if err != nil {
+		return nil, err
+	}
+	newTree, err := BuildLayoutWithConflicts(newMappings)
+	if err != nil {
+		return nil, err
+	}
+
+	diff := &SandboxDiff{Mappings: []MappingChange{}, Tree: []NodeChange{}}
+
+	var oldPaths, newPaths []string
+	for path := range oldIndex {
+		oldPaths = append(oldPaths, path)
+	}
+	for path := range newIndex {
+		newPaths = append(newPaths, path)
+	}
+	for _, path := range sortedUnion(oldPaths, newPaths) {
+		oldMapping, inOld := oldIndex[path]
+		newMapping, inNew := newIndex[path]
+		change := MappingChange{Path: path}
+		if inOld {
+			change.Old = &oldMapping
+		}
+		if inNew {
+			change.New = &newMapping
+		}
+		switch {
+		case !inOld:
+			change.Added = true
+		case !inNew:
+			change.Removed = true
+		default:
+			change.Retargeted = oldMapping.UnderlyingPath != newMapping.UnderlyingPath
+			change.ModeChanged = oldMapping.Writable != newMapping.Writable
+			if !change.Retargeted && !change.ModeChanged {
+				continue
+			}
+		}
+		diff.Mappings = append(diff.Mappings, change)
+	}
+
+	oldNodes := indexNodes(oldTree)
+	newNodes := indexNodes(newTree)
+	oldPaths, newPaths = nil, nil
+	for path := range oldNodes {
+		oldPaths = append(oldPaths, path)
+	}
+	for path := range newNodes {
+		newPaths = append(newPaths, path)
+	}
+	for _, path := range sortedUnion(oldPaths, newPaths) {
+		oldNode, inOld := oldNodes[path]
+		newNode, inNew := newNodes[path]
+		if inOld && inNew && oldNode == newNode {
+			continue
+		}
+		change := NodeChange{Path: path}
+		if inOld {
+			change.Old = &oldNode
+		}
+		if inNew {
+			change.New = &newNode
+		}
+		diff.Tree = append(diff.Tree, change)
+	}
+	return diff, nil
+}
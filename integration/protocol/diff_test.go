@@ -0,0 +1,107 @@
+// Copyright 2020 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not
+// use this file except in compliance with the License.  You may obtain a copy
+// of the License at:
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.  See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package protocol
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+// describeDiff returns a compact textual representation of a diff for comparisons in tests.
+func describeDiff(diff *SandboxDiff) []string {
+	var lines []string
+	for _, c := range diff.Mappings {
+		lines = append(lines, fmt.Sprintf("mapping %s added=%v removed=%v retargeted=%v mode=%v", c.Path, c.Added, c.Removed, c.Retargeted, c.ModeChanged))
+	}
+	for _, c := range diff.Tree {
+		state := func(s *NodeState) string {
+			if s == nil {
+				return "none"
+			}
+			return fmt.Sprintf("%v:%s", s.Kind, s.UnderlyingPath)
+		}
+		lines = append(lines, fmt.Sprintf("node %s %s -> %s", c.Path, state(c.Old), state(c.New)))
+	}
+	return lines
+}
+
+func TestDiffSandboxes(t *testing.T) {
+	testData := []struct {
+		name string
+
+		old  []Mapping
+		new  []Mapping
+		want []string
+	}{
+		{
+			"Same",
+			[]Mapping{{Path: "/", UnderlyingPath: "/r"}, {Path: "/a/b", UnderlyingPath: "/ab"}},
+			[]Mapping{{Path: "/", UnderlyingPath: "/r"}, {Path: "/a//b/", UnderlyingPath: "/ab"}},
+			nil,
+		},
+		{
+			"AddedAndRemoved",
+			[]Mapping{{Path: "/a/b", UnderlyingPath: "/ab"}},
+			[]Mapping{{Path: "/c", UnderlyingPath: "/c"}},
+			[]string{
+				"mapping /a/b added=false removed=true retargeted=false mode=false",
+				"mapping /c added=true removed=false retargeted=false mode=false",
+				"node /a scaffold: -> none",
+				"node /a/b ro:/ab -> none",
+				"node /c none -> ro:/c",
+			},
+		},
+		{
+			"RetargetedAndFlipped",
+			[]Mapping{{Path: "/a", UnderlyingPath: "/x"}, {Path: "/b", UnderlyingPath: "/b"}, {Path: "/c", UnderlyingPath: "/c"}},
+			[]Mapping{{Path: "/a", UnderlyingPath: "/y"}, {Path: "/b", UnderlyingPath: "/b", Writable: true}, {Path: "/c", UnderlyingPath: "/z", Writable: true}},
+			[]string{
+				"mapping /a added=false removed=false retargeted=true mode=false",
+				"mapping /b added=false removed=false retargeted=false mode=true",
+				"mapping /c added=false removed=false retargeted=true mode=true",
+				"node /a ro:/x -> ro:/y",
+				"node /b ro:/b -> rw:/b",
+				"node /c ro:/c -> rw:/z",
+			},
+		},
+		{
+			"NestingOnlyVisibleInTree",
+			[]Mapping{{Path: "/a", UnderlyingPath: "/a"}, {Path: "/a/b", UnderlyingPath: "/ab"}},
+			[]Mapping{{Path: "/a/b", UnderlyingPath: "/ab"}, {Path: "/a", UnderlyingPath: "/a"}},
+			[]string{
+				"node /a ro:/a -> scaffold:",
+			},
+		},
+	}
+	for _, d := range testData {
+		t.Run(d.name, func(t *testing.T) {
+			diff, err := DiffSandboxes(d.old, d.new)
+			if err != nil {
+				t.Fatalf("Failed to diff sandboxes: %v", err)
+			}
+			if got := describeDiff(diff); !reflect.DeepEqual(got, d.want) {
+				t.Errorf("Got %v; want %v", got, d.want)
+			}
+			if diff.Empty() != (len(d.want) == 0) {
+				t.Errorf("Got Empty() %v; want %v", diff.Empty(), len(d.want) == 0)
+			}
+		})
+	}
+
+	if _, err := DiffSandboxes([]Mapping{{Path: "a", UnderlyingPath: "/a"}}, nil); err == nil {
+		t.Errorf("Got no error; want invalid mapping to fail")
+	}
+}
@@ -0,0 +1,19 @@
+// Copyright 2020 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not
+// use this file except in compliance with the License.  You may obtain a copy
+// of the License at:
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.  See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+// Package protocol provides support code to speak the sandboxfs reconfiguration protocol.
+//
+// The types in this package mirror the JSON messages documented in sandboxfs(1) and are shared by
+// the integration tests and by any tools that need to talk to a running sandboxfs instance.
+package protocol
@@ -0,0 +1,298 @@
+// Copyright 2020 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not
+// use this file except in compliance with the License.  You may obtain a copy
+// of the License at:
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.  See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package protocol
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// fakeMapping is a mapping within a sandbox of the fake, with all prefixes already resolved.
+type fakeMapping struct {
+	path           string
+	underlyingPath string
+	writable       bool
+}
+
+// String formats the mapping in the same way sandboxfs does in its error messages.
+func (m fakeMapping) String() string {
+	writability := "read-only"
+	if m.writable {
+		writability = "read/write"
+	}
+	return fmt.Sprintf("%s -> %s (%s)", m.path, m.underlyingPath, writability)
+}
+
+// Fake is an in-memory implementation of the server side of the reconfiguration protocol.
+//
+// The fake does not expose a real file system: it only tracks the sandboxes and mappings it has
+// been asked to create, and validates requests following the same rules as sandboxfs.  This makes
+// it useful to test protocol clients without having to mount a file system.
+type Fake struct {
+	// root is the directory exposed at the top of the file system, if not empty.
+	root string
+
+	// mu protects all fields below.
+	mu sync.Mutex
+
+	// prefixes contains all prefixes registered so far.
+	prefixes *Prefixes
+
+	// sandboxes contains the mappings of all live sandboxes, keyed by their identifier and then
+	// by their normalized path within the sandbox.
+	sandboxes map[string]map[string]fakeMapping
+}
+
+// NewFake instantiates a new fake server.  If root is not empty, the directory it points to is
+// exposed at the top of the file system as a --mapping=rw:/:root flag would do.
+func NewFake(root string) *Fake {
+	return &Fake{
+		root:      root,
+		prefixes:  NewPrefixes(),
+		sandboxes: make(map[string]map[string]fakeMapping),
+	}
+}
+
+// Serve processes requests from input and writes their responses to output.  Returns nil once
+// input reaches EOF, or the error that caused processing to stop abruptly.
+func (f *Fake) Serve(input io.Reader, output io.Writer) error {
+	decoder := json.NewDecoder(input)
+	encoder := json.NewEncoder(output)
+	for {
+		var req Request
+		if err := decoder.Decode(&req); err == io.EOF {
+			return nil
+		} else if err != nil {
+			message := err.Error()
+			if err := encoder.Encode(Response{Error: &message}); err != nil {
+				return err
+			}
+			return err
+		}
+
+		id := req.ID()
+		resp := Response{ID: &id}
+		if err := f.handle(req); err != nil {
+			message := err.Error()
+			resp.Error = &message
+		}
+		if err := encoder.Encode(resp); err != nil {
+			return err
+		}
+	}
+}
+
+// Sandboxes returns the sorted identifiers of all live sandboxes.
+func (f *Fake) Sandboxes() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	ids := make([]string, 0, len(f.sandboxes))
+	for id := range f.sandboxes {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// Stat checks if the given path, relative to the top of the file system, exists.
+func (f *Fake) Stat(path string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	components := strings.SplitN(filepath.Clean(path), "/", 2)
+	if sandbox, ok := f.sandboxes[components[0]]; ok {
+		inner := "/"
+		if len(components) > 1 {
+			inner += components[1]
+		}
+		return statInSandbox(sandbox, inner)
+	}
+
+	if f.root != "" {
+		_, err := os.Lstat(filepath.Join(f.root, path))
+		return err
+	}
+	return &os.PathError{Op: "stat", Path: path, Err: os.ErrNotExist}
+}
+
+// statInSandbox checks if the given absolute path exists within a sandbox.
+func statInSandbox(sandbox map[string]fakeMapping, path string) error {
+	for candidate := path; ; candidate = filepath.Dir(candidate) {
+		if mapping, ok := sandbox[candidate]; ok {
+			remainder := strings.TrimPrefix(path, candidate)
+			_, err := os.Lstat(mapping.underlyingPath + remainder)
+			return err
+		}
+		if candidate == "/" {
+			break
+		}
+	}
+
+	if path == "/" {
+		return nil // Unmapped root of the sandbox.
+	}
+	for mapped := range sandbox {
+		if strings.HasPrefix(mapped, path+"/") {
+			return nil // Scaffold directory.
+		}
+	}
+	return &os.PathError{Op: "stat", Path: path, Err: os.ErrNotExist}
+}
+
+// handle applies a single request.
+func (f *Fake) handle(req Request) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if req.DestroySandbox != nil {
+		id := *req.DestroySandbox
+		if err := validateID(id); err != nil {
+			return err
+		}
+		if _, ok := f.sandboxes[id]; !ok {
+			return serverErrorf("Unknown entry")
+		}
+		delete(f.sandboxes, id)
+		return nil
+	}
+
+	// Prefixes are registered before the request is validated in any other way, matching the
+	// behavior of sandboxfs, which does this while it reads the requests stream.
+	if err := f.prefixes.Register(req.CreateSandbox); err != nil {
+		return err
+	}
+	if err := validateID(req.CreateSandbox.ID); err != nil {
+		return err
+	}
+	resolved, err := f.prefixes.Resolve(req.CreateSandbox)
+	if err != nil {
+		return err
+	}
+
+	mappings := make([]fakeMapping, 0, len(resolved))
+	for _, m := range resolved {
+		path, err := normalizeMappingPath(m.Path)
+		if err != nil {
+			return err
+		}
+		if !filepath.IsAbs(m.UnderlyingPath) {
+			return serverErrorf("path %q is not absolute", m.UnderlyingPath)
+		}
+		mappings = append(mappings, fakeMapping{path, m.UnderlyingPath, m.Writable})
+	}
+
+	sandbox, exists := f.sandboxes[req.CreateSandbox.ID]
+	if len(mappings) > 0 && mappings[0].path == "/" {
+		if exists {
+			return serverErrorf("Cannot map '%s': Already mapped", mappings[0])
+		}
+		fileInfo, err := os.Lstat(mappings[0].underlyingPath)
+		if err != nil {
+			return serverErrorf("Cannot map '%s': Stat failed for %q: %v", mappings[0], mappings[0].underlyingPath, err)
+		}
+		if !fileInfo.IsDir() {
+			return serverErrorf("Cannot map '%s': %q is not a directory", mappings[0], mappings[0].underlyingPath)
+		}
+		sandbox = map[string]fakeMapping{"/": mappings[0]}
+		mappings = mappings[1:]
+	} else if !exists {
+		sandbox = make(map[string]fakeMapping)
+	}
+	f.sandboxes[req.CreateSandbox.ID] = sandbox
+
+	for _, mapping := range mappings {
+		if err := applyMapping(sandbox, mapping); err != nil {
+			return serverErrorf("Cannot map '%s': %v", mapping, err)
+		}
+	}
+	return nil
+}
+
+// validateID checks that a sandbox identifier is valid.
+func validateID(id string) error {
+	if id == "" {
+		return serverErrorf("Identifier cannot be empty")
+	} else if strings.Contains(id, "/") {
+		return serverErrorf("Identifier %s is not a basename", id)
+	}
+	return nil
+}
+
+// normalizeMappingPath checks that a path within a sandbox is absolute and does not contain any
+// dot-dot components, and returns the path without any dot components or repeated separators.
+func normalizeMappingPath(path string) (string, error) {
+	if !strings.HasPrefix(path, "/") {
+		return "", serverErrorf("path %q is not absolute", path)
+	}
+	var components []string
+	for _, component := range strings.Split(path, "/") {
+		switch component {
+		case "", ".":
+			continue
+		case "..":
+			return "", serverErrorf("path %q is not normalized", path)
+		default:
+			components = append(components, component)
+		}
+	}
+	return "/" + strings.Join(components, "/"), nil
+}
+
+// applyMapping adds a new non-root mapping to a sandbox.
+func applyMapping(sandbox map[string]fakeMapping, mapping fakeMapping) error {
+	if mapping.path == "/" {
+		return serverErrorf("Root can be mapped at most once")
+	}
+	if _, ok := sandbox[mapping.path]; ok {
+		return serverErrorf("Already mapped")
+	}
+	for mapped, other := range sandbox {
+		if mapped != "/" && strings.HasPrefix(mapped, mapping.path+"/") {
+			return serverErrorf("Already mapped") // The path exists as a scaffold directory.
+		}
+		if mapped == "/" || strings.HasPrefix(mapping.path, mapped+"/") {
+			fileInfo, err := os.Lstat(other.underlyingPath)
+			if err == nil && !fileInfo.IsDir() {
+				return serverErrorf("Already mapped")
+			}
+		}
+	}
+	if _, err := os.Lstat(mapping.underlyingPath); err != nil {
+		return serverErrorf("Stat failed for %q: %v", mapping.underlyingPath, err)
+	}
+	sandbox[mapping.path] = mapping
+	return nil
+}
+
+// serverError is an error whose message mimics, verbatim, one returned by sandboxfs.
+type serverError string
+
+// Error returns the error message.
+func (e serverError) Error() string {
+	return string(e)
+}
+
+// serverErrorf formats a new error whose message mimics one returned by sandboxfs.  We need this
+// instead of fmt.Errorf because sandboxfs's messages do not follow Go's conventions.
+func serverErrorf(format string, arg ...interface{}) error {
+	return serverError(fmt.Sprintf(format, arg...))
+}
@@ -0,0 +1,203 @@
+// Copyright 2020 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not
+// use this file except in compliance with the License.  You may obtain a copy
+// of the License at:
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.  See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package protocol
+
+import (
+	"bufio"
+	"io"
+	"io/ioutil"
+	"sync"
+	"time"
+)
+
+// FaultKind identifies the type of a fault to inject into a response.
+type FaultKind int
+
+const (
+	// FaultNone forwards the response untouched.
+	FaultNone FaultKind = iota
+
+	// FaultDelay forwards the response after the fault's delay elapses.  Responses that follow
+	// are not held back, so this may also reorder responses.
+	FaultDelay
+
+	// FaultReorder holds the response back and forwards it right after the next one.
+	FaultReorder
+
+	// FaultDrop discards the response.
+	FaultDrop
+
+	// FaultDuplicate forwards the response twice.
+	FaultDuplicate
+
+	// FaultMalformed replaces the response with invalid JSON.
+	FaultMalformed
+
+	// FaultTruncate forwards the first half of the response and then closes the output.
+	FaultTruncate
+
+	// FaultClose closes the output instead of forwarding the response.
+	FaultClose
+)
+
+// malformedResponse is the data sent in place of a response on a FaultMalformed fault.
+const malformedResponse = "{\"id\": this is not json}\n"
+
+// Fault describes a fault to inject into a response.
+type Fault struct {
+	// Kind is the type of the fault.
+	Kind FaultKind
+
+	// Delay is how long to hold the response for FaultDelay faults.  Ignored otherwise.
+	Delay time.Duration
+}
+
+// FaultPeer sits on the reconfiguration streams between a client and a server and injects faults
+// into the server's responses on a schedule.
+//
+// Requests are forwarded to the server verbatim.  Responses are assumed to be newline-terminated,
+// which is how sandboxfs emits them, and are numbered from zero in the order in which the server
+// emits them.  The schedule tells the peer which fault to inject for each response number, which
+// makes fault injection fully deterministic as long as the server responds in a deterministic
+// order.
+type FaultPeer struct {
+	// Input is the stream to which the client writes its requests.  Closing it closes the
+	// server's input once all requests have been forwarded.
+	Input io.WriteCloser
+
+	// Output is the stream from which the client reads the (possibly faulty) responses.
+	Output io.ReadCloser
+
+	// schedule maps response numbers to the faults to inject for them.
+	schedule map[int]Fault
+
+	// output is the writing side of Output.
+	output *io.PipeWriter
+
+	// writeMu serializes writes to output.
+	writeMu sync.Mutex
+
+	// delayed tracks the responses that are being held back by FaultDelay faults.
+	delayed sync.WaitGroup
+
+	// done is closed once both streams have been fully processed.
+	done chan struct{}
+}
+
+// NewFaultPeer starts a new peer that forwards requests to serverInput and reads responses from
+// serverOutput, injecting the faults given in schedule.
+func NewFaultPeer(serverInput io.WriteCloser, serverOutput io.Reader, schedule map[int]Fault) *FaultPeer {
+	inputReader, inputWriter := io.Pipe()
+	outputReader, outputWriter := io.Pipe()
+	p := &FaultPeer{
+		Input:    inputWriter,
+		Output:   outputReader,
+		schedule: schedule,
+		output:   outputWriter,
+		done:     make(chan struct{}),
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		io.Copy(serverInput, inputReader)
+		serverInput.Close()
+	}()
+	go func() {
+		defer wg.Done()
+		p.forwardResponses(serverOutput)
+	}()
+	go func() {
+		wg.Wait()
+		close(p.done)
+	}()
+	return p
+}
+
+// send forwards data to the client.  Errors are ignored because they can only happen once the
+// client has gone away or once the peer closed the output on purpose, and in both cases there is
+// nothing left to do with the data.
+func (p *FaultPeer) send(data []byte) {
+	p.writeMu.Lock()
+	defer p.writeMu.Unlock()
+	p.output.Write(data)
+}
+
+// forwardResponses reads all responses from serverOutput and forwards them to the client
+// following the schedule.
+func (p *FaultPeer) forwardResponses(serverOutput io.Reader) {
+	reader := bufio.NewReader(serverOutput)
+
+	// closeOutput closes the client's output early and drains the server's output so that the
+	// server does not stall on a full pipe.
+	closeOutput := func() {
+		p.output.Close()
+		io.Copy(ioutil.Discard, reader)
+	}
+
+	var held [][]byte
+	for i := 0; ; i++ {
+		response, err := reader.ReadBytes('\n')
+		if len(response) == 0 && err != nil {
+			break
+		}
+
+		fault := p.schedule[i]
+		switch fault.Kind {
+		case FaultNone:
+			p.send(response)
+		case FaultDelay:
+			p.delayed.Add(1)
+			go func(response []byte) {
+				defer p.delayed.Done()
+				time.Sleep(fault.Delay)
+				p.send(response)
+			}(response)
+		case FaultReorder:
+			held = append(held, response)
+			continue
+		case FaultDrop:
+		case FaultDuplicate:
+			p.send(response)
+			p.send(response)
+		case FaultMalformed:
+			p.send([]byte(malformedResponse))
+		case FaultTruncate:
+			p.send(response[:len(response)/2])
+			closeOutput()
+			return
+		case FaultClose:
+			closeOutput()
+			return
+		}
+
+		for _, response := range held {
+			p.send(response)
+		}
+		held = nil
+	}
+
+	for _, response := range held {
+		p.send(response)
+	}
+	p.delayed.Wait()
+	p.output.Close()
+}
+
+// Wait blocks until the peer has forwarded all requests and all responses.
+func (p *FaultPeer) Wait() {
+	<-p.done
+}
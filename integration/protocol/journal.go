@@ -0,0 +1,122 @@
+// Copyright 2020 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not
+// use this file except in compliance with the License.  You may obtain a copy
+// of the License at:
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.  See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package protocol
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+// JournalEntry represents a request that a sandboxfs instance applied successfully.
+//
+// Tools that create sandboxes record these entries in a journal file, one JSON object per line, so
+// that the layout of the sandboxes can be inspected later on.  The requests in a journal never
+// refer to prefixes so that each entry is self-contained.
+type JournalEntry struct {
+	// Time is the moment at which the request was acknowledged.
+	Time time.Time `json:"time"`
+
+	// Request is the applied request.
+	Request Request `json:"request"`
+}
+
+// AppendJournal appends an entry to the journal file at path, creating the file if necessary.
+func AppendJournal(path string, entry JournalEntry) error {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return fmt.Errorf("cannot encode journal entry: %v", err)
+	}
+
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open journal %s: %v", path, err)
+	}
+	defer file.Close()
+
+	// A single write of a whole line keeps concurrent writers from interleaving their entries.
+	if _, err := file.Write(append(data, '\n')); err != nil {
+		return fmt.Errorf("failed to write to journal %s: %v", path, err)
+	}
+	return nil
+}
+
+// ReadJournal reads all entries from the journal file at path.
+func ReadJournal(path string) ([]JournalEntry, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open journal %s: %v", path, err)
+	}
+	defer file.Close()
+
+	var entries []JournalEntry
+	decoder := json.NewDecoder(file)
+	for {
+		var entry JournalEntry
+		if err := decoder.Decode(&entry); err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("bad entry %d in journal %s: %v", len(entries)+1, path, err)
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+// ReplayJournal computes the sandboxes that are alive once all entries have been applied in order.
+// Returns the combined create requests for each live sandbox, keyed by identifier.
+func ReplayJournal(entries []JournalEntry) map[string]*CreateSandboxRequest {
+	return replayJournal(entries, false)
+}
+
+// ReplayJournalWithDestroyed is like ReplayJournal but also returns the sandboxes that were
+// destroyed, with the mappings they had right before their last destruction.  This allows
+// inspecting sandboxes after the fact, as their creators usually destroy them once done.
+func ReplayJournalWithDestroyed(entries []JournalEntry) map[string]*CreateSandboxRequest {
+	return replayJournal(entries, true)
+}
+
+// replayJournal implements ReplayJournal and ReplayJournalWithDestroyed.
+func replayJournal(entries []JournalEntry, keepDestroyed bool) map[string]*CreateSandboxRequest {
+	sandboxes := make(map[string]*CreateSandboxRequest)
+	destroyed := make(map[string]bool)
+	for _, entry := range entries {
+		if entry.Request.DestroySandbox != nil {
+			id := *entry.Request.DestroySandbox
+			if keepDestroyed {
+				destroyed[id] = true
+			} else {
+				delete(sandboxes, id)
+			}
+			continue
+		}
+
+		create := entry.Request.CreateSandbox
+		if previous, ok := sandboxes[create.ID]; ok && !destroyed[create.ID] {
+			// Creating an existing sandbox adds new mappings to it.
+			previous.Mappings = append(previous.Mappings, create.Mappings...)
+		} else {
+			// Recreating a destroyed sandbox starts over from scratch.
+			delete(destroyed, create.ID)
+			sandboxes[create.ID] = &CreateSandboxRequest{
+				ID:       create.ID,
+				Mappings: append([]Mapping{}, create.Mappings...),
+			}
+		}
+	}
+	return sandboxes
+}
@@ -0,0 +1,114 @@
+// Copyright 2020 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not
+// use this file except in compliance with the License.  You may obtain a copy
+// of the License at:
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.  See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package protocol
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestJournal_RoundTripAndReplay(t *testing.T) {
+	dir, err := ioutil.TempDir("", "test")
+	if err != nil {
+		t.Fatalf("Failed to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "journal")
+
+	a := Mapping{Path: "/a", UnderlyingPath: "/a"}
+	b := Mapping{Path: "/b", UnderlyingPath: "/b", Writable: true}
+	requests := []Request{
+		MakeCreateSandboxRequest("first", a),
+		MakeCreateSandboxRequest("second", a),
+		MakeCreateSandboxRequest("first", b),
+		MakeDestroySandboxRequest("second"),
+	}
+	for _, req := range requests {
+		if err := AppendJournal(path, JournalEntry{Time: time.Now(), Request: req}); err != nil {
+			t.Fatalf("Failed to append to journal: %v", err)
+		}
+	}
+
+	entries, err := ReadJournal(path)
+	if err != nil {
+		t.Fatalf("Failed to read journal: %v", err)
+	}
+	if len(entries) != len(requests) {
+		t.Fatalf("Got %d entries; want %d", len(entries), len(requests))
+	}
+
+	live := ReplayJournal(entries)
+	want := map[string]*CreateSandboxRequest{
+		"first": {ID: "first", Mappings: []Mapping{a, b}},
+	}
+	if !reflect.DeepEqual(live, want) {
+		t.Errorf("Got live sandboxes %v; want %v", live, want)
+	}
+}
+
+func TestReplayJournalWithDestroyed(t *testing.T) {
+	a := Mapping{Path: "/a", UnderlyingPath: "/a"}
+	b := Mapping{Path: "/b", UnderlyingPath: "/b", Writable: true}
+	c := Mapping{Path: "/c", UnderlyingPath: "/c"}
+	var entries []JournalEntry
+	for _, req := range []Request{
+		MakeCreateSandboxRequest("live", a),
+		MakeCreateSandboxRequest("gone", a),
+		MakeCreateSandboxRequest("gone", b),
+		MakeDestroySandboxRequest("gone"),
+		MakeCreateSandboxRequest("recreated", a),
+		MakeDestroySandboxRequest("recreated"),
+		MakeCreateSandboxRequest("recreated", c),
+		MakeDestroySandboxRequest("recreated"),
+	} {
+		entries = append(entries, JournalEntry{Request: req})
+	}
+
+	all := ReplayJournalWithDestroyed(entries)
+	want := map[string]*CreateSandboxRequest{
+		"live":      {ID: "live", Mappings: []Mapping{a}},
+		"gone":      {ID: "gone", Mappings: []Mapping{a, b}},
+		"recreated": {ID: "recreated", Mappings: []Mapping{c}},
+	}
+	if !reflect.DeepEqual(all, want) {
+		t.Errorf("Got sandboxes %v; want %v", all, want)
+	}
+
+	live := ReplayJournal(entries)
+	want = map[string]*CreateSandboxRequest{
+		"live": {ID: "live", Mappings: []Mapping{a}},
+	}
+	if !reflect.DeepEqual(live, want) {
+		t.Errorf("Got live sandboxes %v; want %v", live, want)
+	}
+}
+
+func TestReadJournal_BadEntry(t *testing.T) {
+	file, err := ioutil.TempFile("", "journal")
+	if err != nil {
+		t.Fatalf("Failed to create temporary file: %v", err)
+	}
+	defer os.Remove(file.Name())
+	file.WriteString("{\"time\": \"2020-01-01T00:00:00Z\", \"request\": {\"D\": \"x\"}}\n{\"request\": {}}\n")
+	file.Close()
+
+	if _, err := ReadJournal(file.Name()); err == nil || !matches("bad entry 2", err.Error()) {
+		t.Errorf("Got %v; want error about entry 2", err)
+	}
+}
@@ -0,0 +1,179 @@
+// Copyright 2020 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not
+// use this file except in compliance with the License.  You may obtain a copy
+// of the License at:
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.  See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package protocol
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// NodeKind identifies how a node in the layout of a sandbox came to be.
+type NodeKind int
+
+const (
+	// ScaffoldNode is a directory that sandboxfs synthesizes to hold the mappings beneath it.
+	ScaffoldNode NodeKind = iota
+
+	// ReadOnlyNode is the target of a read-only mapping.
+	ReadOnlyNode
+
+	// ReadWriteNode is the target of a read/write mapping.
+	ReadWriteNode
+)
+
+// String returns the short name of the node kind.
+func (k NodeKind) String() string {
+	switch k {
+	case ScaffoldNode:
+		return "scaffold"
+	case ReadOnlyNode:
+		return "ro"
+	case ReadWriteNode:
+		return "rw"
+	default:
+		panic(fmt.Sprintf("unknown node kind %d", k))
+	}
+}
+
+// MarshalText encodes the node kind as its short name.
+func (k NodeKind) MarshalText() ([]byte, error) {
+	return []byte(k.String()), nil
+}
+
+// LayoutNode represents a node in the directory tree of a sandbox as defined by its mappings.
+type LayoutNode struct {
+	// Name is the basename of the node, or "/" for the root.
+	Name string `json:"name"`
+
+	// Path is the absolute path of the node within the sandbox.
+	Path string `json:"path"`
+
+	// Kind indicates whether the node is a mapping or a scaffold directory.
+	Kind NodeKind `json:"kind"`
+
+	// UnderlyingPath is the path exposed by the node if it is a mapping, or empty otherwise.
+	UnderlyingPath string `json:"underlying_path,omitempty"`
+
+	// Shadows is the path hidden by this node within the underlying directory of the closest
+	// mapping that encloses it, or empty if the node is not beneath any mapping.
+	Shadows string `json:"shadows,omitempty"`
+
+	// Conflicts contains the mappings that targeted this node after it already existed, which
+	// sandboxfs rejects.  Only populated by BuildLayoutWithConflicts.
+	Conflicts []Mapping `json:"conflicts,omitempty"`
+
+	// Children contains the nodes beneath this one, sorted by name.
+	Children []*LayoutNode `json:"children,omitempty"`
+}
+
+// child returns the child of the node with the given name, or nil if it does not exist.
+func (n *LayoutNode) child(name string) *LayoutNode {
+	for _, child := range n.Children {
+		if child.Name == name {
+			return child
+		}
+	}
+	return nil
+}
+
+// Walk invokes fn on the node and all of its descendents in depth-first order.
+func (n *LayoutNode) Walk(fn func(*LayoutNode)) {
+	fn(n)
+	for _, child := range n.Children {
+		child.Walk(fn)
+	}
+}
+
+// BuildLayout constructs the tree of a sandbox by applying, in order, mappings whose prefixes have
+// already been resolved.  Fails following the same rules as sandboxfs would when the mappings are
+// invalid or conflict with each other.
+func BuildLayout(mappings []Mapping) (*LayoutNode, error) {
+	return buildLayout(mappings, false)
+}
+
+// BuildLayoutWithConflicts is like BuildLayout but, instead of failing when a mapping conflicts
+// with an earlier one, records the rejected mapping in the Conflicts field of the node it targeted
+// and continues.  Invalid mappings still cause a failure.
+func BuildLayoutWithConflicts(mappings []Mapping) (*LayoutNode, error) {
+	return buildLayout(mappings, true)
+}
+
+// buildLayout implements BuildLayout and BuildLayoutWithConflicts.
+func buildLayout(mappings []Mapping, keepConflicts bool) (*LayoutNode, error) {
+	root := &LayoutNode{Name: "/", Path: "/", Kind: ScaffoldNode}
+	for i, m := range mappings {
+		path, err := normalizeMappingPath(m.Path)
+		if err != nil {
+			return nil, err
+		}
+		if !filepath.IsAbs(m.UnderlyingPath) {
+			return nil, fmt.Errorf("path %q is not absolute", m.UnderlyingPath)
+		}
+
+		kind := ReadOnlyNode
+		if m.Writable {
+			kind = ReadWriteNode
+		}
+
+		if path == "/" {
+			if i != 0 {
+				if keepConflicts {
+					root.Conflicts = append(root.Conflicts, m)
+					continue
+				}
+				return nil, fmt.Errorf("cannot map %s: root can be mapped at most once", path)
+			}
+			root.Kind = kind
+			root.UnderlyingPath = m.UnderlyingPath
+			continue
+		}
+
+		node := root
+		components := strings.Split(path[1:], "/")
+		for j, name := range components {
+			child := node.child(name)
+			last := j == len(components)-1
+			if child != nil {
+				if last {
+					if keepConflicts {
+						child.Conflicts = append(child.Conflicts, m)
+						break
+					}
+					return nil, fmt.Errorf("cannot map %s: already mapped", path)
+				}
+				node = child
+				continue
+			}
+
+			child = &LayoutNode{Name: name, Path: "/" + strings.Join(components[:j+1], "/"), Kind: ScaffoldNode}
+			switch {
+			case node.Kind != ScaffoldNode:
+				child.Shadows = filepath.Join(node.UnderlyingPath, name)
+			case node.Shadows != "":
+				child.Shadows = filepath.Join(node.Shadows, name)
+			}
+			if last {
+				child.Kind = kind
+				child.UnderlyingPath = m.UnderlyingPath
+			}
+			node.Children = append(node.Children, child)
+			sort.Slice(node.Children, func(a, b int) bool { return node.Children[a].Name < node.Children[b].Name })
+			node = child
+		}
+	}
+	return root, nil
+}
@@ -0,0 +1,106 @@
+// Copyright 2020 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not
+// use this file except in compliance with the License.  You may obtain a copy
+// of the License at:
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.  See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package protocol
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+func TestBuildLayout_Ok(t *testing.T) {
+	mappings := []Mapping{
+		{Path: "/", UnderlyingPath: "/root"},
+		{Path: "/a/b", UnderlyingPath: "/ab"},
+		{Path: "/a/b/c", UnderlyingPath: "/abc", Writable: true},
+		{Path: "/", UnderlyingPath: "/ignored"},
+	}
+	_, err := BuildLayout(mappings)
+	if err == nil {
+		t.Fatalf("Got no error; want root remapping to fail")
+	}
+
+	root, err := BuildLayout(mappings[:3])
+	if err != nil {
+		t.Fatalf("Failed to build layout: %v", err)
+	}
+	var got []string
+	root.Walk(func(node *LayoutNode) {
+		got = append(got, fmt.Sprintf("%s %v %s", node.Path, node.Kind, node.UnderlyingPath))
+	})
+	want := []string{
+		"/ ro /root",
+		"/a scaffold ",
+		"/a/b ro /ab",
+		"/a/b/c rw /abc",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Got layout %v; want %v", got, want)
+	}
+}
+
+func TestBuildLayout_Errors(t *testing.T) {
+	testData := []struct {
+		name string
+
+		mappings  []Mapping
+		wantError string
+	}{
+		{"RelativePath", []Mapping{{Path: "a", UnderlyingPath: "/a"}}, "not absolute"},
+		{"RelativeUnderlyingPath", []Mapping{{Path: "/a", UnderlyingPath: "a"}}, "not absolute"},
+		{"RootLate", []Mapping{{Path: "/a", UnderlyingPath: "/a"}, {Path: "/", UnderlyingPath: "/"}}, "root can be mapped at most once"},
+		{"Twice", []Mapping{{Path: "/a", UnderlyingPath: "/a"}, {Path: "/a/", UnderlyingPath: "/b"}}, "already mapped"},
+		{"OverScaffold", []Mapping{{Path: "/a/b", UnderlyingPath: "/a"}, {Path: "/a", UnderlyingPath: "/b"}}, "already mapped"},
+	}
+	for _, d := range testData {
+		t.Run(d.name, func(t *testing.T) {
+			if _, err := BuildLayout(d.mappings); err == nil || !matches(d.wantError, err.Error()) {
+				t.Errorf("Got %v; want error matching %s", err, d.wantError)
+			}
+		})
+	}
+}
+
+func TestBuildLayoutWithConflicts(t *testing.T) {
+	mappings := []Mapping{
+		{Path: "/", UnderlyingPath: "/root"},
+		{Path: "/a/b", UnderlyingPath: "/ab"},
+		{Path: "/a/b/c", UnderlyingPath: "/abc", Writable: true},
+		{Path: "/", UnderlyingPath: "/other-root"},
+		{Path: "/a", UnderlyingPath: "/a"},
+		{Path: "/a/b/", UnderlyingPath: "/ab2", Writable: true},
+	}
+	root, err := BuildLayoutWithConflicts(mappings)
+	if err != nil {
+		t.Fatalf("Failed to build layout: %v", err)
+	}
+	var got []string
+	root.Walk(func(node *LayoutNode) {
+		got = append(got, fmt.Sprintf("%s %v shadows=%s conflicts=%d", node.Path, node.Kind, node.Shadows, len(node.Conflicts)))
+	})
+	want := []string{
+		"/ ro shadows= conflicts=1",
+		"/a scaffold shadows=/root/a conflicts=1",
+		"/a/b ro shadows=/root/a/b conflicts=1",
+		"/a/b/c rw shadows=/ab/c conflicts=0",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Got layout %v; want %v", got, want)
+	}
+
+	if _, err := BuildLayoutWithConflicts([]Mapping{{Path: "a", UnderlyingPath: "/a"}}); err == nil || !matches("not absolute", err.Error()) {
+		t.Errorf("Got %v; want invalid mapping to fail", err)
+	}
+}
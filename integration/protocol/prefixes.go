@@ -0,0 +1,103 @@
+// Copyright 2020 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not
+// use this file except in compliance with the License.  You may obtain a copy
+// of the License at:
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.  See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package protocol
+
+import (
+	"strconv"
+	"strings"
+)
+
+// Prefixes tracks the path prefixes defined by a stream of requests.
+//
+// Prefixes are global to a stream: once a request defines a prefix, all subsequent requests in
+// the same stream can use it without defining it again.
+type Prefixes struct {
+	// data maps prefix identifiers to their paths.  Prefix 0 is reserved and always empty so
+	// that paths can be given verbatim.
+	data map[int]string
+}
+
+// NewPrefixes instantiates an empty set of prefixes.
+func NewPrefixes() *Prefixes {
+	return &Prefixes{data: map[int]string{0: ""}}
+}
+
+// Register records all prefixes defined in a request and checks that all prefixes used by the
+// request exist.
+func (p *Prefixes) Register(req *CreateSandboxRequest) error {
+	for rawID, path := range req.Prefixes {
+		id, err := strconv.Atoi(rawID)
+		if err != nil || id < 0 {
+			return serverErrorf("Bad prefix number: %s", rawID)
+		}
+		if previous, ok := p.data[id]; ok {
+			if previous != path {
+				return serverErrorf("Prefix %d already had path %s but got new %s", id, previous, path)
+			}
+			continue
+		}
+		p.data[id] = path
+	}
+
+	for _, m := range req.Mappings {
+		for _, id := range []int{m.PathPrefix, m.UnderlyingPathPrefix} {
+			if _, ok := p.data[id]; !ok {
+				return serverErrorf("Prefix %d does not exist", id)
+			}
+		}
+	}
+	return nil
+}
+
+// BuildPath constructs a path given a prefix identifier, which must have been registered, and a
+// suffix.  The result is not normalized in any way.
+func (p *Prefixes) BuildPath(prefix int, suffix string) (string, error) {
+	if prefix != 0 && strings.HasPrefix(suffix, "/") {
+		return "", serverErrorf("Suffix %s must be relative", suffix)
+	}
+	base := p.data[prefix]
+	switch {
+	case suffix == "":
+		return base, nil
+	case base == "":
+		return suffix, nil
+	case strings.HasSuffix(base, "/"):
+		return base + suffix, nil
+	default:
+		return base + "/" + suffix, nil
+	}
+}
+
+// Resolve registers the prefixes of a request and returns the request's mappings with all of their
+// paths expanded so that they do not refer to any prefix.
+func (p *Prefixes) Resolve(req *CreateSandboxRequest) ([]Mapping, error) {
+	if err := p.Register(req); err != nil {
+		return nil, err
+	}
+
+	mappings := make([]Mapping, 0, len(req.Mappings))
+	for _, m := range req.Mappings {
+		path, err := p.BuildPath(m.PathPrefix, m.Path)
+		if err != nil {
+			return nil, err
+		}
+		underlyingPath, err := p.BuildPath(m.UnderlyingPathPrefix, m.UnderlyingPath)
+		if err != nil {
+			return nil, err
+		}
+		mappings = append(mappings, Mapping{Path: path, UnderlyingPath: underlyingPath, Writable: m.Writable})
+	}
+	return mappings, nil
+}
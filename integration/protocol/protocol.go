@@ -0,0 +1,274 @@
+// Copyright 2020 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not
+// use this file except in compliance with the License.  You may obtain a copy
+// of the License at:
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.  See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package protocol
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// Mapping represents a mapping entry in the reconfiguration protocol.
+type Mapping struct {
+	Path                 string `json:"path"`
+	PathPrefix           int    `json:"path_prefix"`
+	UnderlyingPath       string `json:"underlying_path"`
+	UnderlyingPathPrefix int    `json:"underlying_path_prefix"`
+	Writable             bool   `json:"writable"`
+}
+
+// ParseMapping parses a mapping given in the TYPE:PATH:UNDERLYING_PATH form accepted by the
+// --mapping flag of sandboxfs.
+func ParseMapping(value string) (Mapping, error) {
+	fields := strings.Split(value, ":")
+	if len(fields) != 3 {
+		return Mapping{}, fmt.Errorf("bad mapping %s: expected three colon-separated fields", value)
+	}
+
+	var writable bool
+	switch fields[0] {
+	case "ro":
+		writable = false
+	case "rw":
+		writable = true
+	default:
+		return Mapping{}, fmt.Errorf("bad mapping %s: type was %s but should be ro or rw", value, fields[0])
+	}
+
+	for _, path := range fields[1:] {
+		if !strings.HasPrefix(path, "/") {
+			return Mapping{}, fmt.Errorf("bad mapping %s: path %q is not absolute", value, path)
+		}
+	}
+	return Mapping{Path: fields[1], UnderlyingPath: fields[2], Writable: writable}, nil
+}
+
+// MappingsFlag is a repeatable flag.Value that collects --mapping values.
+type MappingsFlag []Mapping
+
+// String returns the textual representation of the flag's value.
+func (f *MappingsFlag) String() string {
+	values := make([]string, 0, len(*f))
+	for _, m := range *f {
+		kind := "ro"
+		if m.Writable {
+			kind = "rw"
+		}
+		values = append(values, fmt.Sprintf("%s:%s:%s", kind, m.Path, m.UnderlyingPath))
+	}
+	return strings.Join(values, ",")
+}
+
+// Set parses and records a new --mapping value.
+func (f *MappingsFlag) Set(value string) error {
+	m, err := ParseMapping(value)
+	if err != nil {
+		return err
+	}
+	*f = append(*f, m)
+	return nil
+}
+
+// mappingAliases maps the minimized field names of a mapping to their canonical names.
+var mappingAliases = map[string]string{
+	"p": "path",
+	"x": "path_prefix",
+	"u": "underlying_path",
+	"y": "underlying_path_prefix",
+	"w": "writable",
+}
+
+// UnmarshalJSON decodes a mapping that may use either the canonical or the minimized field names.
+func (m *Mapping) UnmarshalJSON(data []byte) error {
+	fields, err := decodeFields(data, mappingAliases)
+	if err != nil {
+		return err
+	}
+
+	var result Mapping
+	if err := decodeRequiredField(fields, "path", &result.Path); err != nil {
+		return err
+	}
+	if err := decodeOptionalField(fields, "path_prefix", &result.PathPrefix); err != nil {
+		return err
+	}
+	if err := decodeRequiredField(fields, "underlying_path", &result.UnderlyingPath); err != nil {
+		return err
+	}
+	if err := decodeOptionalField(fields, "underlying_path_prefix", &result.UnderlyingPathPrefix); err != nil {
+		return err
+	}
+	if err := decodeOptionalField(fields, "writable", &result.Writable); err != nil {
+		return err
+	}
+	*m = result
+	return nil
+}
+
+// CreateSandboxRequest represents a request to create a new sandbox.
+type CreateSandboxRequest struct {
+	ID       string            `json:"id"`
+	Mappings []Mapping         `json:"mappings,omitempty"`
+	Prefixes map[string]string `json:"prefixes,omitempty"`
+}
+
+// createSandboxAliases maps the minimized field names of a create request to their canonical names.
+var createSandboxAliases = map[string]string{
+	"i": "id",
+	"m": "mappings",
+	"q": "prefixes",
+}
+
+// UnmarshalJSON decodes a create request that may use either the canonical or the minimized field
+// names.
+func (r *CreateSandboxRequest) UnmarshalJSON(data []byte) error {
+	fields, err := decodeFields(data, createSandboxAliases)
+	if err != nil {
+		return err
+	}
+
+	var result CreateSandboxRequest
+	if err := decodeRequiredField(fields, "id", &result.ID); err != nil {
+		return err
+	}
+	if err := decodeOptionalField(fields, "mappings", &result.Mappings); err != nil {
+		return err
+	}
+	if err := decodeOptionalField(fields, "prefixes", &result.Prefixes); err != nil {
+		return err
+	}
+	*r = result
+	return nil
+}
+
+// Request represents a single reconfiguration request.  Exactly one of the fields must be set.
+type Request struct {
+	CreateSandbox  *CreateSandboxRequest `json:"CreateSandbox,omitempty"`
+	DestroySandbox *string               `json:"DestroySandbox,omitempty"`
+}
+
+// requestAliases maps the minimized request names to their canonical names.
+var requestAliases = map[string]string{
+	"C": "CreateSandbox",
+	"D": "DestroySandbox",
+}
+
+// UnmarshalJSON decodes a request that may use either the canonical or the minimized names.
+func (req *Request) UnmarshalJSON(data []byte) error {
+	fields, err := decodeFields(data, requestAliases)
+	if err != nil {
+		return err
+	}
+	if len(fields) != 1 {
+		return fmt.Errorf("expected a single CreateSandbox or DestroySandbox request; got %d fields", len(fields))
+	}
+
+	var result Request
+	for name, value := range fields {
+		switch name {
+		case "CreateSandbox":
+			if err := json.Unmarshal(value, &result.CreateSandbox); err != nil {
+				return err
+			}
+		case "DestroySandbox":
+			if err := json.Unmarshal(value, &result.DestroySandbox); err != nil {
+				return err
+			}
+		default:
+			return fmt.Errorf("unknown variant `%s`, expected `CreateSandbox` or `DestroySandbox`", name)
+		}
+	}
+	if result.CreateSandbox == nil && result.DestroySandbox == nil {
+		return fmt.Errorf("request cannot be null")
+	}
+	*req = result
+	return nil
+}
+
+// ID returns the sandbox identifier in a request message.
+func (req Request) ID() string {
+	if req.CreateSandbox != nil && req.DestroySandbox != nil {
+		panic("Bad request: contains both create and destroy requests")
+	} else if req.CreateSandbox != nil {
+		return req.CreateSandbox.ID
+	} else {
+		return *req.DestroySandbox
+	}
+}
+
+// Response represents the result of a reconfiguration request.
+type Response struct {
+	ID    *string `json:"id,omitempty"`
+	Error *string `json:"error,omitempty"`
+}
+
+// MakeCreateSandboxRequest is a convenience function to instantiate a single create request.
+func MakeCreateSandboxRequest(id string, mapping1 Mapping, mappingN ...Mapping) Request {
+	return Request{
+		CreateSandbox: &CreateSandboxRequest{
+			ID:       id,
+			Mappings: append([]Mapping{mapping1}, mappingN...),
+			Prefixes: make(map[string]string),
+		},
+	}
+}
+
+// MakeDestroySandboxRequest is a convenience function to instantiate a single destroy request.
+func MakeDestroySandboxRequest(id string) Request {
+	return Request{
+		DestroySandbox: &id,
+	}
+}
+
+// decodeFields decodes a JSON object into its raw fields, keyed by their canonical names as
+// resolved via the given aliases table.
+func decodeFields(data []byte, aliases map[string]string) (map[string]json.RawMessage, error) {
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, err
+	}
+
+	fields := make(map[string]json.RawMessage, len(raw))
+	for name, value := range raw {
+		if canonical, ok := aliases[name]; ok {
+			name = canonical
+		}
+		if _, ok := fields[name]; ok {
+			return nil, fmt.Errorf("duplicate field `%s`", name)
+		}
+		fields[name] = value
+	}
+	return fields, nil
+}
+
+// decodeRequiredField decodes the field called name into value and fails if it is missing.
+func decodeRequiredField(fields map[string]json.RawMessage, name string, value interface{}) error {
+	if _, ok := fields[name]; !ok {
+		return fmt.Errorf("missing field `%s`", name)
+	}
+	return decodeOptionalField(fields, name, value)
+}
+
+// decodeOptionalField decodes the field called name into value if present.
+func decodeOptionalField(fields map[string]json.RawMessage, name string, value interface{}) error {
+	raw, ok := fields[name]
+	if !ok {
+		return nil
+	}
+	if err := json.Unmarshal(raw, value); err != nil {
+		return fmt.Errorf("invalid field `%s`: %v", name, err)
+	}
+	return nil
+}
@@ -0,0 +1,95 @@
+// Copyright 2020 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not
+// use this file except in compliance with the License.  You may obtain a copy
+// of the License at:
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.  See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package protocol
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+// TraceEvent represents a request or a response captured from a reconfiguration stream.
+//
+// Traces are stored as one JSON-encoded event per line.  Exactly one of Request or Response is set
+// in each event.
+type TraceEvent struct {
+	// Time is the moment at which the message was seen.
+	Time time.Time `json:"time"`
+
+	// Stream identifies the stream in which the message was seen.  Prefixes are scoped to their
+	// stream, and so is the matching of responses to requests.
+	Stream int `json:"stream"`
+
+	// Request is the request as it was sent by the client, byte for byte.
+	Request json.RawMessage `json:"request,omitempty"`
+
+	// Response is the response to a request.
+	Response *Response `json:"response,omitempty"`
+}
+
+// TraceWriter records events to a trace.  It is safe to use from multiple goroutines.
+type TraceWriter struct {
+	// mu serializes writes to encoder so that events are not interleaved.
+	mu sync.Mutex
+
+	// encoder writes events to the trace.
+	encoder *json.Encoder
+}
+
+// NewTraceWriter instantiates a new trace writer that writes events to w.
+func NewTraceWriter(w io.Writer) *TraceWriter {
+	return &TraceWriter{encoder: json.NewEncoder(w)}
+}
+
+// RecordRequest records a request seen at the current time in the given stream.
+func (t *TraceWriter) RecordRequest(stream int, raw []byte) error {
+	return t.record(TraceEvent{Time: time.Now(), Stream: stream, Request: raw})
+}
+
+// RecordResponse records a response seen at the current time in the given stream.
+func (t *TraceWriter) RecordResponse(stream int, resp Response) error {
+	return t.record(TraceEvent{Time: time.Now(), Stream: stream, Response: &resp})
+}
+
+// record writes a single event to the trace.
+func (t *TraceWriter) record(event TraceEvent) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if err := t.encoder.Encode(event); err != nil {
+		return fmt.Errorf("failed to write trace event: %v", err)
+	}
+	return nil
+}
+
+// ReadTrace reads all events from a trace.
+func ReadTrace(r io.Reader) ([]TraceEvent, error) {
+	var events []TraceEvent
+	decoder := json.NewDecoder(r)
+	for {
+		var event TraceEvent
+		if err := decoder.Decode(&event); err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("bad event %d in trace: %v", len(events)+1, err)
+		}
+		if (event.Request == nil) == (event.Response == nil) {
+			return nil, fmt.Errorf("bad event %d in trace: must contain exactly one of request or response", len(events)+1)
+		}
+		events = append(events, event)
+	}
+	return events, nil
+}
@@ -30,8 +30,9 @@ import (
 )
 
 func TestReadOnly_DirectoryStructure(t *testing.T) {
-	state := utils.MountSetup(t, "--mapping=ro:/:%ROOT%", "--mapping=ro:/mappings/dir:%ROOT%/mappings/dir", "--mapping=ro:/mappings/scaffold/dir:%ROOT%/mappings/dir")
-	defer state.TearDown(t)
+	t.Parallel()
+
+	state := utils.MountSetup(t, utils.WithArgs("--mapping=ro:/:%ROOT%", "--mapping=ro:/mappings/dir:%ROOT%/mappings/dir", "--mapping=ro:/mappings/scaffold/dir:%ROOT%/mappings/dir"))
 
 	utils.MustMkdirAll(t, state.RootPath("dir1"), 0755)
 	utils.MustMkdirAll(t, state.RootPath("dir2"), 0500)
@@ -56,8 +57,9 @@ func TestReadOnly_DirectoryStructure(t *testing.T) {
 }
 
 func TestReadOnly_FileContents(t *testing.T) {
-	state := utils.MountSetup(t, "--mapping=ro:/:%ROOT%")
-	defer state.TearDown(t)
+	t.Parallel()
+
+	state := utils.MountSetup(t, utils.WithArgs("--mapping=ro:/:%ROOT%"))
 
 	utils.MustWriteFile(t, state.RootPath("file"), 0400, "foo")
 	utils.MustMkdirAll(t, state.RootPath("dir1/dir2"), 0755)
@@ -76,8 +78,9 @@ func TestReadOnly_FileContents(t *testing.T) {
 }
 
 func TestReadOnly_ReplaceUnderlyingFile(t *testing.T) {
-	state := utils.MountSetup(t, "--mapping=ro:/:%ROOT%")
-	defer state.TearDown(t)
+	t.Parallel()
+
+	state := utils.MountSetup(t, utils.WithArgs("--mapping=ro:/:%ROOT%"))
 
 	externalFile := state.RootPath("foo")
 	internalFile := state.MountPath("foo")
@@ -108,8 +111,9 @@ func TestReadOnly_ReplaceUnderlyingFile(t *testing.T) {
 }
 
 func TestReadOnly_MoveUnderlyingDirectory(t *testing.T) {
-	state := utils.MountSetup(t, "--mapping=ro:/:%ROOT%")
-	defer state.TearDown(t)
+	t.Parallel()
+
+	state := utils.MountSetup(t, utils.WithArgs("--mapping=ro:/:%ROOT%"))
 
 	utils.MustMkdirAll(t, state.RootPath("first/a"), 0755)
 	utils.MustMkdirAll(t, state.RootPath("first/b"), 0755)
@@ -139,8 +143,9 @@ func TestReadOnly_MoveUnderlyingDirectory(t *testing.T) {
 }
 
 func TestReadOnly_ReadLargeDir(t *testing.T) {
-	state := utils.MountSetup(t, "--mapping=ro:/:%ROOT%", "--mapping=ro:/dir:%ROOT%/dir", "--mapping=ro:/scaffold/abc:%ROOT%/dir")
-	defer state.TearDown(t)
+	t.Parallel()
+
+	state := utils.MountSetup(t, utils.WithArgs("--mapping=ro:/:%ROOT%", "--mapping=ro:/dir:%ROOT%/dir", "--mapping=ro:/scaffold/abc:%ROOT%/dir"))
 
 	utils.MustMkdirAll(t, state.RootPath("dir"), 0755)
 	wantNames := make(map[string]bool)
@@ -175,8 +180,9 @@ func TestReadOnly_ReadLargeDir(t *testing.T) {
 }
 
 func TestReadOnly_RepeatedReadDirsWhileDirIsOpen(t *testing.T) {
-	state := utils.MountSetup(t, "--mapping=ro:/:%ROOT%", "--mapping=ro:/dir:%ROOT%/dir", "--mapping=ro:/scaffold/abc:%ROOT%/dir")
-	defer state.TearDown(t)
+	t.Parallel()
+
+	state := utils.MountSetup(t, utils.WithArgs("--mapping=ro:/:%ROOT%", "--mapping=ro:/dir:%ROOT%/dir", "--mapping=ro:/scaffold/abc:%ROOT%/dir"))
 
 	utils.MustMkdirAll(t, state.RootPath("mapped-dir"), 0755)
 	utils.MustWriteFile(t, state.RootPath("mapped-file"), 0644, "")
@@ -218,8 +224,9 @@ func TestReadOnly_RepeatedReadDirsWhileDirIsOpen(t *testing.T) {
 }
 
 func TestReadOnly_Attributes(t *testing.T) {
-	state := utils.MountSetup(t, "--mapping=ro:/:%ROOT%")
-	defer state.TearDown(t)
+	t.Parallel()
+
+	state := utils.MountSetup(t, utils.WithArgs("--mapping=ro:/:%ROOT%"))
 
 	utils.MustMkdirAll(t, state.RootPath("dir"), 0755)
 	utils.MustWriteFile(t, state.RootPath("file"), 0644, "new content")
@@ -284,6 +291,8 @@ func TestReadOnly_Attributes(t *testing.T) {
 }
 
 func TestReadOnly_Access(t *testing.T) {
+	t.Parallel()
+
 	// mustMkdirAs creates a directory owned by the requested user and with the given mode, and
 	// fails the test immediately if these operations fail.
 	mustMkdirAs := func(user *utils.UnixUser, path string, mode os.FileMode) {
@@ -315,14 +324,13 @@ func TestReadOnly_Access(t *testing.T) {
 	}
 	t.Logf("Using unprivileged user: %v", user)
 
-	// We use MountSetupWithUser to mount the file system as root even if we are running as
-	// root because this function takes care of opening up all temporary directories to all
-	// readers, which we need for the tests below that run as the unprivileged user.
+	// We use WithUser to mount the file system as root even if we are running as root
+	// because this option takes care of opening up all temporary directories to all readers,
+	// which we need for the tests below that run as the unprivileged user.
 	//
 	// Note also that we must mount with "allow=other" so that our unprivileged executions
 	// can access the file system.
-	state := utils.MountSetupWithUser(t, root, "--allow=other", "--mapping=ro:/:%ROOT%", "--mapping=ro:/scaffold/dir/foo:%ROOT%/foo")
-	defer state.TearDown(t)
+	state := utils.MountSetup(t, utils.WithUser(root), utils.WithArgs("--allow=other", "--mapping=ro:/:%ROOT%", "--mapping=ro:/scaffold/dir/foo:%ROOT%/foo"))
 
 	utils.MustMkdirAll(t, state.RootPath("all"), 0777) // Place where "user" can create entries.
 
@@ -394,8 +402,9 @@ func TestReadOnly_Access(t *testing.T) {
 }
 
 func TestReadOnly_HardLinkCountsAreFixed(t *testing.T) {
-	state := utils.MountSetup(t, "--mapping=ro:/:%ROOT%", "--mapping=ro:/scaffold/dir:%ROOT%/dir")
-	defer state.TearDown(t)
+	t.Parallel()
+
+	state := utils.MountSetup(t, utils.WithArgs("--mapping=ro:/:%ROOT%", "--mapping=ro:/scaffold/dir:%ROOT%/dir"))
 
 	utils.MustMkdirAll(t, state.RootPath("dir"), 0755)
 	utils.MustWriteFile(t, state.RootPath("no-links"), 0644, "")
@@ -430,8 +439,9 @@ func TestReadOnly_HardLinkCountsAreFixed(t *testing.T) {
 }
 
 func TestReadOnly_ReadFromDirFails(t *testing.T) {
-	state := utils.MountSetup(t, "--mapping=ro:/:%ROOT%")
-	defer state.TearDown(t)
+	t.Parallel()
+
+	state := utils.MountSetup(t, utils.WithArgs("--mapping=ro:/:%ROOT%"))
 
 	utils.MustMkdirAll(t, state.RootPath("dir"), 0755)
 
@@ -449,8 +459,9 @@ func TestReadOnly_ReadFromDirFails(t *testing.T) {
 }
 
 func TestReadOnly_ReaddirFromFileFails(t *testing.T) {
-	state := utils.MountSetup(t, "--mapping=ro:/:%ROOT%")
-	defer state.TearDown(t)
+	t.Parallel()
+
+	state := utils.MountSetup(t, utils.WithArgs("--mapping=ro:/:%ROOT%"))
 
 	utils.MustWriteFile(t, state.RootPath("file"), 0644, "")
 
@@ -471,8 +482,9 @@ func TestReadOnly_ReaddirFromFileFails(t *testing.T) {
 }
 
 func TestReadOnly_Listxattrs(t *testing.T) {
-	state := utils.MountSetup(t, "--xattrs", "--mapping=ro:/:%ROOT%")
-	defer state.TearDown(t)
+	t.Parallel()
+
+	state := utils.MountSetup(t, utils.WithArgs("--xattrs", "--mapping=ro:/:%ROOT%"))
 
 	utils.MustMkdirAll(t, state.RootPath("dir"), 0755)
 	utils.MustWriteFile(t, state.RootPath("file"), 0644, "new content")
@@ -506,8 +518,9 @@ func TestReadOnly_Listxattrs(t *testing.T) {
 }
 
 func TestReadOnly_ListxattrsOnScaffoldDirectory(t *testing.T) {
-	state := utils.MountSetup(t, "--xattrs", "--mapping=ro:/:%ROOT%", "--mapping=ro:/scaffold/dir:%ROOT%")
-	defer state.TearDown(t)
+	t.Parallel()
+
+	state := utils.MountSetup(t, utils.WithArgs("--xattrs", "--mapping=ro:/:%ROOT%", "--mapping=ro:/scaffold/dir:%ROOT%"))
 
 	path := state.MountPath("scaffold")
 	buf := make([]byte, 32)
@@ -521,8 +534,9 @@ func TestReadOnly_ListxattrsOnScaffoldDirectory(t *testing.T) {
 }
 
 func TestReadOnly_ListxattrsDisabled(t *testing.T) {
-	state := utils.MountSetup(t, "--mapping=ro:/:%ROOT%")
-	defer state.TearDown(t)
+	t.Parallel()
+
+	state := utils.MountSetup(t, utils.WithArgs("--mapping=ro:/:%ROOT%"))
 
 	utils.MustMkdirAll(t, state.RootPath("dir"), 0755)
 
@@ -551,8 +565,9 @@ func TestReadOnly_ListxattrsDisabled(t *testing.T) {
 }
 
 func TestReadOnly_Getxattr(t *testing.T) {
-	state := utils.MountSetup(t, "--xattrs", "--mapping=ro:/:%ROOT%")
-	defer state.TearDown(t)
+	t.Parallel()
+
+	state := utils.MountSetup(t, utils.WithArgs("--xattrs", "--mapping=ro:/:%ROOT%"))
 
 	utils.MustMkdirAll(t, state.RootPath("dir"), 0755)
 	utils.MustWriteFile(t, state.RootPath("file"), 0644, "new content")
@@ -583,8 +598,9 @@ func TestReadOnly_Getxattr(t *testing.T) {
 }
 
 func TestReadOnly_GetxattrOnScaffoldDirectory(t *testing.T) {
-	state := utils.MountSetup(t, "--xattrs", "--mapping=ro:/:%ROOT%", "--mapping=ro:/scaffold/dir:%ROOT%")
-	defer state.TearDown(t)
+	t.Parallel()
+
+	state := utils.MountSetup(t, utils.WithArgs("--xattrs", "--mapping=ro:/:%ROOT%", "--mapping=ro:/scaffold/dir:%ROOT%"))
 
 	path := state.MountPath("scaffold")
 	buf := make([]byte, 32)
@@ -594,8 +610,9 @@ func TestReadOnly_GetxattrOnScaffoldDirectory(t *testing.T) {
 }
 
 func TestReadOnly_GetxattrMissingErrno(t *testing.T) {
-	state := utils.MountSetup(t, "--xattrs", "--mapping=ro:/:%ROOT%")
-	defer state.TearDown(t)
+	t.Parallel()
+
+	state := utils.MountSetup(t, utils.WithArgs("--xattrs", "--mapping=ro:/:%ROOT%"))
 
 	utils.MustMkdirAll(t, state.RootPath("dir"), 0755)
 	utils.MustWriteFile(t, state.RootPath("file"), 0644, "new content")
@@ -616,8 +633,9 @@ func TestReadOnly_GetxattrMissingErrno(t *testing.T) {
 }
 
 func TestReadOnly_GetxattrDisabled(t *testing.T) {
-	state := utils.MountSetup(t, "--mapping=ro:/:%ROOT%")
-	defer state.TearDown(t)
+	t.Parallel()
+
+	state := utils.MountSetup(t, utils.WithArgs("--mapping=ro:/:%ROOT%"))
 
 	utils.MustMkdirAll(t, state.RootPath("dir"), 0755)
 
@@ -29,6 +29,7 @@ import (
 
 	"golang.org/x/sys/unix"
 
+	"github.com/bazelbuild/sandboxfs/integration/logs"
 	"github.com/bazelbuild/sandboxfs/integration/utils"
 )
 
@@ -67,8 +68,7 @@ func createAsDifferentUserTest(t *testing.T, createAsUser func(string, *utils.Un
 	}
 	t.Logf("Using primary unprivileged user: %v", user)
 
-	state := utils.MountSetupWithUser(t, root, "--mapping=rw:/:%ROOT%", "--allow=other")
-	defer state.TearDown(t)
+	state := utils.MountSetup(t, utils.WithUser(root), utils.WithArgs("--mapping=rw:/:%ROOT%", "--allow=other"))
 
 	utils.MustMkdirAll(t, state.RootPath("dir"), 0777)
 	if err := os.Chown(state.RootPath("dir"), user.UID, user.GID); err != nil {
@@ -106,12 +106,15 @@ func createAsDifferentUserTest(t *testing.T, createAsUser func(string, *utils.Un
 }
 
 func TestReadWrite_MkdirAsDifferentUser(t *testing.T) {
+	t.Parallel()
+
 	createAsDifferentUserTest(t, utils.MkdirAsUser)
 }
 
 func TestReadWrite_CreateFile(t *testing.T) {
-	state := utils.MountSetup(t, "--mapping=rw:/:%ROOT%")
-	defer state.TearDown(t)
+	t.Parallel()
+
+	state := utils.MountSetup(t, utils.WithArgs("--mapping=rw:/:%ROOT%"))
 
 	utils.MustWriteFile(t, state.RootPath("file"), 0644, "original content")
 	utils.MustMkdirAll(t, state.RootPath("subdir"), 0755)
@@ -126,12 +129,15 @@ func TestReadWrite_CreateFile(t *testing.T) {
 }
 
 func TestReadWrite_CreateFileAsDifferentUser(t *testing.T) {
+	t.Parallel()
+
 	createAsDifferentUserTest(t, utils.CreateFileAsUser)
 }
 
 func TestReadWrite_DirectoryNlinkCountsStayFixed(t *testing.T) {
-	state := utils.MountSetup(t, "--mapping=rw:/:%ROOT%")
-	defer state.TearDown(t)
+	t.Parallel()
+
+	state := utils.MountSetup(t, utils.WithArgs("--mapping=rw:/:%ROOT%"))
 
 	checkNlink := func(path string, wantNlink int) {
 		t.Helper()
@@ -182,8 +188,9 @@ func TestReadWrite_DirectoryNlinkCountsStayFixed(t *testing.T) {
 }
 
 func TestReadWrite_Remove(t *testing.T) {
-	state := utils.MountSetup(t, "--mapping=rw:/:%ROOT%", "--mapping=rw:/mapped-dir:%ROOT%/mapped-dir", "--mapping=rw:/scaffold/dir:%ROOT%/scaffold-dir")
-	defer state.TearDown(t)
+	t.Parallel()
+
+	state := utils.MountSetup(t, utils.WithArgs("--mapping=rw:/:%ROOT%", "--mapping=rw:/mapped-dir:%ROOT%/mapped-dir", "--mapping=rw:/scaffold/dir:%ROOT%/scaffold-dir"))
 
 	utils.MustMkdirAll(t, state.RootPath("dir"), 0755)
 	utils.MustWriteFile(t, state.RootPath("file"), 0644, "")
@@ -237,8 +244,9 @@ func TestReadWrite_Remove(t *testing.T) {
 }
 
 func TestReadWRite_RemoveZeroesNlink(t *testing.T) {
-	state := utils.MountSetup(t, "--mapping=rw:/:%ROOT%")
-	defer state.TearDown(t)
+	t.Parallel()
+
+	state := utils.MountSetup(t, utils.WithArgs("--mapping=rw:/:%ROOT%"))
 
 	utils.MustMkdirAll(t, state.RootPath("dir"), 0755)
 	utils.MustWriteFile(t, state.RootPath("file"), 0644, "")
@@ -263,8 +271,9 @@ func TestReadWRite_RemoveZeroesNlink(t *testing.T) {
 }
 
 func TestReadWrite_RewriteFile(t *testing.T) {
-	state := utils.MountSetup(t, "--mapping=rw:/:%ROOT%")
-	defer state.TearDown(t)
+	t.Parallel()
+
+	state := utils.MountSetup(t, utils.WithArgs("--mapping=rw:/:%ROOT%"))
 
 	utils.MustWriteFile(t, state.RootPath("file"), 0644, "original content")
 	if err := utils.FileEquals(state.MountPath("file"), "original content"); err != nil {
@@ -278,8 +287,9 @@ func TestReadWrite_RewriteFile(t *testing.T) {
 }
 
 func TestReadWrite_RewriteFileWithShorterContent(t *testing.T) {
-	state := utils.MountSetup(t, "--mapping=rw:/:%ROOT%")
-	defer state.TearDown(t)
+	t.Parallel()
+
+	state := utils.MountSetup(t, utils.WithArgs("--mapping=rw:/:%ROOT%"))
 
 	utils.MustWriteFile(t, state.MountPath("file"), 0644, "very long contents")
 	utils.MustWriteFile(t, state.MountPath("file"), 0644, "short")
@@ -289,8 +299,9 @@ func TestReadWrite_RewriteFileWithShorterContent(t *testing.T) {
 }
 
 func TestReadWrite_WriteOnDeletedAndDuppedFd(t *testing.T) {
-	state := utils.MountSetup(t, "--mapping=rw:/:%ROOT%")
-	defer state.TearDown(t)
+	t.Parallel()
+
+	state := utils.MountSetup(t, utils.WithArgs("--mapping=rw:/:%ROOT%"))
 
 	fd, err := openAndDelete(state.MountPath("some-file"), syscall.O_RDWR|syscall.O_CREAT)
 	if err != nil {
@@ -342,8 +353,9 @@ func TestReadWrite_WriteOnDeletedAndDuppedFd(t *testing.T) {
 }
 
 func TestReadWrite_InodesArePreservedDuringReaddir(t *testing.T) {
-	state := utils.MountSetup(t, "--mapping=rw:/:%ROOT%")
-	defer state.TearDown(t)
+	t.Parallel()
+
+	state := utils.MountSetup(t, utils.WithArgs("--mapping=rw:/:%ROOT%"))
 
 	// inodeOf obtains the inode number of a file.
 	inodeOf := func(path string) uint64 {
@@ -382,8 +394,9 @@ func TestReadWrite_InodesArePreservedDuringReaddir(t *testing.T) {
 }
 
 func TestReadWrite_InodeReassignedAfterRecreation(t *testing.T) {
-	state := utils.MountSetup(t, "--mapping=rw:/:%ROOT%")
-	defer state.TearDown(t)
+	t.Parallel()
+
+	state := utils.MountSetup(t, utils.WithArgs("--mapping=rw:/:%ROOT%"))
 
 	testData := []struct {
 		name string
@@ -439,8 +452,9 @@ func TestReadWrite_InodeReassignedAfterRecreation(t *testing.T) {
 }
 
 func TestReadWrite_FstatOnDeletedNode(t *testing.T) {
-	state := utils.MountSetup(t, "--mapping=rw:/:%ROOT%")
-	defer state.TearDown(t)
+	t.Parallel()
+
+	state := utils.MountSetup(t, utils.WithArgs("--mapping=rw:/:%ROOT%"))
 
 	utils.MustMkdirAll(t, state.MountPath("dir"), 0755)
 	utils.MustWriteFile(t, state.MountPath("file"), 0644, "")
@@ -482,11 +496,14 @@ func TestReadWrite_FstatOnDeletedNode(t *testing.T) {
 			}
 		})
 	}
+
+	state.CheckNoLeaks(t)
 }
 
 func TestReadWrite_Truncate(t *testing.T) {
-	state := utils.MountSetup(t, "--mapping=rw:/:%ROOT%")
-	defer state.TearDown(t)
+	t.Parallel()
+
+	state := utils.MountSetup(t, utils.WithArgs("--mapping=rw:/:%ROOT%"))
 
 	utils.MustWriteFile(t, state.MountPath("file"), 0644, "very long contents")
 
@@ -501,8 +518,9 @@ func TestReadWrite_Truncate(t *testing.T) {
 }
 
 func TestReadWrite_FtruncateOnDeletedFile(t *testing.T) {
-	state := utils.MountSetup(t, "--mapping=rw:/:%ROOT%")
-	defer state.TearDown(t)
+	t.Parallel()
+
+	state := utils.MountSetup(t, utils.WithArgs("--mapping=rw:/:%ROOT%"))
 
 	originalContent := "very long contents"
 	utils.MustWriteFile(t, state.MountPath("file"), 0644, originalContent)
@@ -611,16 +629,17 @@ func doRenameTest(t *testing.T, oldOuterPath, newOuterPath, oldInnerPath, newInn
 }
 
 func TestReadWrite_NestedMappingsInheritDirectoryProperties(t *testing.T) {
+	t.Parallel()
+
 	rootSetup := func(root string) error {
 		if err := os.MkdirAll(filepath.Join(root, "already/exist"), 0755); err != nil {
 			return err
 		}
 		return os.MkdirAll(filepath.Join(root, "dir"), 0755)
 	}
-	state := utils.MountSetupWithRootSetup(t, rootSetup,
+	state := utils.MountSetup(t, utils.WithRootSetup(rootSetup), utils.WithArgs(
 		"--mapping=rw:/:%ROOT%",
-		"--mapping=ro:/already/exist/dir:%ROOT%/dir")
-	defer state.TearDown(t)
+		"--mapping=ro:/already/exist/dir:%ROOT%/dir"))
 
 	for _, path := range []string{"already/foo", "already/exist/foo"} {
 		if err := ioutil.WriteFile(state.MountPath(path), []byte(""), 0644); err != nil {
@@ -637,6 +656,8 @@ func TestReadWrite_NestedMappingsInheritDirectoryProperties(t *testing.T) {
 }
 
 func TestReadWrite_NestedMappingsClobberFiles(t *testing.T) {
+	t.Parallel()
+
 	rootSetup := func(root string) error {
 		if err := os.MkdirAll(filepath.Join(root, "dir"), 0755); err != nil {
 			return err
@@ -646,11 +667,10 @@ func TestReadWrite_NestedMappingsClobberFiles(t *testing.T) {
 		}
 		return os.Symlink("/non-existent", filepath.Join(root, "symlink"))
 	}
-	state := utils.MountSetupWithRootSetup(t, rootSetup,
+	state := utils.MountSetup(t, utils.WithRootSetup(rootSetup), utils.WithArgs(
 		"--mapping=rw:/:%ROOT%",
 		"--mapping=ro:/file/nested-dir:%ROOT%/dir",
-		"--mapping=ro:/symlink/nested-dir:%ROOT%/dir")
-	defer state.TearDown(t)
+		"--mapping=ro:/symlink/nested-dir:%ROOT%/dir"))
 
 	for _, component := range []string{"file", "symlink"} {
 		fileInfo, err := os.Lstat(state.MountPath(component, "nested-dir"))
@@ -668,8 +688,9 @@ func TestReadWrite_NestedMappingsClobberFiles(t *testing.T) {
 }
 
 func TestReadWrite_RenameFile(t *testing.T) {
-	state := utils.MountSetup(t, "--mapping=rw:/:%ROOT%")
-	defer state.TearDown(t)
+	t.Parallel()
+
+	state := utils.MountSetup(t, utils.WithArgs("--mapping=rw:/:%ROOT%"))
 
 	oldOuterPath := state.RootPath("old-name")
 	newOuterPath := state.RootPath("new-name")
@@ -679,8 +700,9 @@ func TestReadWrite_RenameFile(t *testing.T) {
 }
 
 func TestReadWrite_MoveFile(t *testing.T) {
-	state := utils.MountSetup(t, "--mapping=rw:/:%ROOT%")
-	defer state.TearDown(t)
+	t.Parallel()
+
+	state := utils.MountSetup(t, utils.WithArgs("--mapping=rw:/:%ROOT%"))
 
 	oldOuterPath := state.RootPath("dir1/dir2/old-name")
 	newOuterPath := state.RootPath("dir2/dir3/dir4/new-name")
@@ -690,8 +712,9 @@ func TestReadWrite_MoveFile(t *testing.T) {
 }
 
 func TestReadWrite_MoveRace(t *testing.T) {
-	state := utils.MountSetup(t, "--mapping=rw:/:%ROOT%")
-	defer state.TearDown(t)
+	t.Parallel()
+
+	state := utils.MountSetup(t, utils.WithArgs("--mapping=rw:/:%ROOT%"))
 
 	utils.MustMkdirAll(t, state.RootPath("dir1"), 0755)
 	utils.MustWriteFile(t, state.RootPath("dir1/file1"), 0644, "")
@@ -727,6 +750,8 @@ func TestReadWrite_MoveRace(t *testing.T) {
 }
 
 func TestReadWrite_MoveAsDifferentUser(t *testing.T) {
+	t.Parallel()
+
 	createAsDifferentUserTest(t, func(path string, user *utils.UnixUser) error {
 		if err := utils.CreateFileAsUser(path+".old", user); err != nil {
 			return err
@@ -736,8 +761,9 @@ func TestReadWrite_MoveAsDifferentUser(t *testing.T) {
 }
 
 func TestReadWrite_MoveDirectoryUpdatesContents(t *testing.T) {
-	state := utils.MountSetup(t, "--mapping=rw:/:%ROOT%")
-	defer state.TearDown(t)
+	t.Parallel()
+
+	state := utils.MountSetup(t, utils.WithArgs("--mapping=rw:/:%ROOT%"))
 
 	// Create the files directly in the mount path, as opposed to the root path (like other
 	// tests do), to ensure sandboxfs creates in-memory nodes for them.
@@ -759,8 +785,9 @@ func TestReadWrite_MoveDirectoryUpdatesContents(t *testing.T) {
 }
 
 func TestReadWrite_DeleteAfterMovedDirectory(t *testing.T) {
-	state := utils.MountSetup(t, "--mapping=rw:/:%ROOT%")
-	defer state.TearDown(t)
+	t.Parallel()
+
+	state := utils.MountSetup(t, utils.WithArgs("--mapping=rw:/:%ROOT%"))
 
 	// Create the files directly in the mount path, as opposed to the root path (like other
 	// tests do), to ensure sandboxfs creates in-memory nodes for them.
@@ -779,10 +806,11 @@ func TestReadWrite_DeleteAfterMovedDirectory(t *testing.T) {
 }
 
 func TestReadWrite_Mknod(t *testing.T) {
+	t.Parallel()
+
 	utils.RequireRoot(t, "Requires root privileges to create arbitrary nodes")
 
-	state := utils.MountSetup(t, "--mapping=rw:/:%ROOT%")
-	defer state.TearDown(t)
+	state := utils.MountSetup(t, utils.WithArgs("--mapping=rw:/:%ROOT%"))
 
 	// checkNode ensures that a given file is of the specified type and, if the type indicates
 	// that the file is a device, that the device number matches.  This check is done on both
@@ -849,6 +877,10 @@ func TestReadWrite_Mknod(t *testing.T) {
 	}
 	for _, d := range testData {
 		t.Run(d.name, func(t *testing.T) {
+			if utils.InUserNamespace() && d.statType&os.ModeDevice != 0 {
+				t.Skipf("Device nodes cannot be created within a user namespace")
+			}
+
 			path := state.MountPath(d.filename)
 
 			shouldHaveFailed := false
@@ -864,7 +896,10 @@ func TestReadWrite_Mknod(t *testing.T) {
 				}
 			}
 
-			err = checkNode(d.filename, (os.FileMode(d.perm)&os.ModePerm)|d.statType, uint64(d.dev))
+			// The kernel applies our umask to the requested permissions before they reach
+			// sandboxfs, which passes them verbatim to the underlying file system.
+			wantPerm := os.FileMode(d.perm) &^ utils.Umask() & os.ModePerm
+			err = checkNode(d.filename, wantPerm|d.statType, uint64(d.dev))
 			if findOS(d.wantOS) {
 				if err != nil {
 					t.Error(err)
@@ -882,12 +917,15 @@ func TestReadWrite_Mknod(t *testing.T) {
 	}
 }
 func TestReadWrite_MknodAsDifferentUser(t *testing.T) {
+	t.Parallel()
+
 	createAsDifferentUserTest(t, utils.MkfifoAsUser)
 }
 
 func TestReadWrite_Chmod(t *testing.T) {
-	state := utils.MountSetup(t, "--mapping=rw:/:%ROOT%")
-	defer state.TearDown(t)
+	t.Parallel()
+
+	state := utils.MountSetup(t, utils.WithArgs("--mapping=rw:/:%ROOT%"))
 
 	// checkPerm ensures that the given file has the given permissions on the underlying file
 	// system and within the mount point.
@@ -953,8 +991,9 @@ func TestReadWrite_Chmod(t *testing.T) {
 }
 
 func TestReadWrite_FchmodOnDeletedNode(t *testing.T) {
-	state := utils.MountSetup(t, "--mapping=rw:/:%ROOT%")
-	defer state.TearDown(t)
+	t.Parallel()
+
+	state := utils.MountSetup(t, utils.WithArgs("--mapping=rw:/:%ROOT%"))
 
 	utils.MustMkdirAll(t, state.MountPath("dir"), 0755)
 	utils.MustWriteFile(t, state.MountPath("file"), 0644, "")
@@ -989,13 +1028,42 @@ func TestReadWrite_FchmodOnDeletedNode(t *testing.T) {
 			}
 		})
 	}
+
+	state.CheckNoLeaks(t)
+}
+
+func TestReadWrite_OpenFileHoldsDescriptor(t *testing.T) {
+	t.Parallel()
+
+	// Silence the informational records, such as the one about mounting the file system, so
+	// that any record at all points to a problem with the descriptors.
+	state := utils.MountSetup(t, utils.WithLogFilter("warn"), utils.WithArgs("--mapping=rw:/:%ROOT%"))
+	state.ForbidLog(logs.Info)
+
+	utils.MustWriteFile(t, state.RootPath("file"), 0644, "contents")
+	before := state.Snapshot(t, "before open")
+
+	file, err := os.Open(state.MountPath("file"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	opened := state.Snapshot(t, "while open")
+	if len(opened.FDs) <= len(before.FDs) {
+		t.Errorf("Got %d fds while the file was open; want more than %d", len(opened.FDs), len(before.FDs))
+	}
+	if err := file.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	state.CheckNoLeaks(t)
 }
 
 func TestReadWrite_Chown(t *testing.T) {
+	t.Parallel()
+
 	utils.RequireRoot(t, "Requires root privileges to change test file ownership")
 
-	state := utils.MountSetup(t, "--mapping=rw:/:%ROOT%")
-	defer state.TearDown(t)
+	state := utils.MountSetup(t, utils.WithArgs("--mapping=rw:/:%ROOT%"))
 
 	// checkOwners ensures that the given file is owned by the given user and group on the
 	// underlying file system and within the mount point.
@@ -1057,10 +1125,11 @@ func TestReadWrite_Chown(t *testing.T) {
 }
 
 func TestReadWrite_FchownOnDeletedNode(t *testing.T) {
+	t.Parallel()
+
 	utils.RequireRoot(t, "Requires root privileges to change test file ownership")
 
-	state := utils.MountSetup(t, "--mapping=rw:/:%ROOT%")
-	defer state.TearDown(t)
+	state := utils.MountSetup(t, utils.WithArgs("--mapping=rw:/:%ROOT%"))
 
 	utils.MustMkdirAll(t, state.MountPath("dir"), 0755)
 	utils.MustWriteFile(t, state.MountPath("file"), 0644, "")
@@ -1095,11 +1164,14 @@ func TestReadWrite_FchownOnDeletedNode(t *testing.T) {
 			}
 		})
 	}
+
+	state.CheckNoLeaks(t)
 }
 
 func TestReadWrite_Chtimes(t *testing.T) {
-	state := utils.MountSetup(t, "--mapping=rw:/:%ROOT%")
-	defer state.TearDown(t)
+	t.Parallel()
+
+	state := utils.MountSetup(t, utils.WithArgs("--mapping=rw:/:%ROOT%"))
 
 	// checkTimes ensures that the given file has the desired timing information on the
 	// underlying file system and within the mount point.
@@ -1220,8 +1292,9 @@ func TestReadWrite_Chtimes(t *testing.T) {
 }
 
 func TestReadWrite_ChtimesResetsBirthtimeWithMtime(t *testing.T) {
-	state := utils.MountSetup(t, "--mapping=rw:/:%ROOT%")
-	defer state.TearDown(t)
+	t.Parallel()
+
+	state := utils.MountSetup(t, utils.WithArgs("--mapping=rw:/:%ROOT%"))
 
 	mustBtime := func(path string) time.Time {
 		t.Helper()
@@ -1264,8 +1337,9 @@ func TestReadWrite_ChtimesResetsBirthtimeWithMtime(t *testing.T) {
 }
 
 func TestReadWrite_FutimesOnDeletedNode(t *testing.T) {
-	state := utils.MountSetup(t, "--mapping=rw:/:%ROOT%")
-	defer state.TearDown(t)
+	t.Parallel()
+
+	state := utils.MountSetup(t, utils.WithArgs("--mapping=rw:/:%ROOT%"))
 
 	utils.MustMkdirAll(t, state.MountPath("dir"), 0755)
 	utils.MustWriteFile(t, state.MountPath("file"), 0644, "")
@@ -1307,11 +1381,14 @@ func TestReadWrite_FutimesOnDeletedNode(t *testing.T) {
 			}
 		})
 	}
+
+	state.CheckNoLeaks(t)
 }
 
 func TestReadWrite_HardLinksNotSupported(t *testing.T) {
-	state := utils.MountSetup(t, "--mapping=rw:/:%ROOT%", "--mapping=rw:/dir:%ROOT%/dir", "--mapping=rw:/scaffold/name3:%ROOT%/dir2")
-	defer state.TearDown(t)
+	t.Parallel()
+
+	state := utils.MountSetup(t, utils.WithArgs("--mapping=rw:/:%ROOT%", "--mapping=rw:/dir:%ROOT%/dir", "--mapping=rw:/scaffold/name3:%ROOT%/dir2"))
 
 	utils.MustWriteFile(t, state.RootPath("name1"), 0644, "")
 	utils.MustWriteFile(t, state.RootPath("dir/name2"), 0644, "")
@@ -1353,8 +1430,9 @@ func TestReadWrite_HardLinksNotSupported(t *testing.T) {
 }
 
 func TestReadWrite_SymlinkAndReadlink(t *testing.T) {
-	state := utils.MountSetup(t, "--mapping=rw:/:%ROOT%")
-	defer state.TearDown(t)
+	t.Parallel()
+
+	state := utils.MountSetup(t, utils.WithArgs("--mapping=rw:/:%ROOT%"))
 
 	path := state.MountPath("symlink")
 	target := "some/random/dangling/target"
@@ -1372,14 +1450,17 @@ func TestReadWrite_SymlinkAndReadlink(t *testing.T) {
 	}
 }
 func TestReadWrite_SymlinkAsDifferentUser(t *testing.T) {
+	t.Parallel()
+
 	createAsDifferentUserTest(t, func(path string, user *utils.UnixUser) error {
 		return utils.SymlinkAsUser("/non-existent/target", path, user)
 	})
 }
 
 func TestReadWrite_MmapAfterMovesWorks(t *testing.T) {
-	state := utils.MountSetup(t, "--mapping=rw:/:%ROOT%")
-	defer state.TearDown(t)
+	t.Parallel()
+
+	state := utils.MountSetup(t, utils.WithArgs("--mapping=rw:/:%ROOT%"))
 
 	content := "some contents"
 	utils.MustWriteFile(t, state.RootPath("name1"), 0644, content)
@@ -1431,8 +1512,7 @@ func TestReadWrite_MmapAfterMovesWorks(t *testing.T) {
 }
 
 func testXattrsOnDeletedFiles(t *testing.T, wantErr error, hook func(int) error) {
-	state := utils.MountSetup(t, "--xattrs", "--mapping=rw:/:%ROOT%")
-	defer state.TearDown(t)
+	state := utils.MountSetup(t, utils.WithArgs("--xattrs", "--mapping=rw:/:%ROOT%"))
 
 	utils.MustMkdirAll(t, state.RootPath("dir"), 0755)
 	utils.MustWriteFile(t, state.RootPath("file"), 0644, "content")
@@ -1450,6 +1530,8 @@ func testXattrsOnDeletedFiles(t *testing.T, wantErr error, hook func(int) error)
 }
 
 func TestReadWrite_Fgetxattr(t *testing.T) {
+	t.Parallel()
+
 	testXattrsOnDeletedFiles(t, utils.MissingXattrErr, func(fd int) error {
 		_, err := unix.Fgetxattr(fd, "user.foo", []byte{})
 		return err
@@ -1457,6 +1539,8 @@ func TestReadWrite_Fgetxattr(t *testing.T) {
 }
 
 func TestReadWrite_Flistxattr(t *testing.T) {
+	t.Parallel()
+
 	testXattrsOnDeletedFiles(t, nil, func(fd int) error {
 		_, err := unix.Flistxattr(fd, []byte{})
 		return err
@@ -1464,8 +1548,9 @@ func TestReadWrite_Flistxattr(t *testing.T) {
 }
 
 func TestReadWrite_Setxattr(t *testing.T) {
-	state := utils.MountSetup(t, "--xattrs", "--mapping=rw:/:%ROOT%")
-	defer state.TearDown(t)
+	t.Parallel()
+
+	state := utils.MountSetup(t, utils.WithArgs("--xattrs", "--mapping=rw:/:%ROOT%"))
 
 	utils.MustMkdirAll(t, state.RootPath("dir"), 0755)
 	utils.MustWriteFile(t, state.RootPath("file"), 0644, "new content")
@@ -1496,8 +1581,9 @@ func TestReadWrite_Setxattr(t *testing.T) {
 }
 
 func TestReadWrite_SetxattrOnScaffoldDirectory(t *testing.T) {
-	state := utils.MountSetup(t, "--xattrs", "--mapping=rw:/:%ROOT%", "--mapping=rw:/scaffold/dir:%ROOT%")
-	defer state.TearDown(t)
+	t.Parallel()
+
+	state := utils.MountSetup(t, utils.WithArgs("--xattrs", "--mapping=rw:/:%ROOT%", "--mapping=rw:/scaffold/dir:%ROOT%"))
 
 	path := state.MountPath("scaffold")
 	value := []byte("some-value")
@@ -1508,8 +1594,9 @@ func TestReadWrite_SetxattrOnScaffoldDirectory(t *testing.T) {
 }
 
 func TestReadWrite_SetxattrDisabled(t *testing.T) {
-	state := utils.MountSetup(t, "--mapping=ro:/:%ROOT%")
-	defer state.TearDown(t)
+	t.Parallel()
+
+	state := utils.MountSetup(t, utils.WithArgs("--mapping=ro:/:%ROOT%"))
 
 	utils.MustMkdirAll(t, state.RootPath("dir"), 0755)
 
@@ -1529,14 +1616,17 @@ func TestReadWrite_SetxattrDisabled(t *testing.T) {
 }
 
 func TestReadWrite_Fsetxattr(t *testing.T) {
+	t.Parallel()
+
 	testXattrsOnDeletedFiles(t, unix.EACCES, func(fd int) error {
 		return unix.Fsetxattr(fd, "user.foo", []byte{}, 0)
 	})
 }
 
 func TestReadWrite_Removexattr(t *testing.T) {
-	state := utils.MountSetup(t, "--xattrs", "--mapping=rw:/:%ROOT%")
-	defer state.TearDown(t)
+	t.Parallel()
+
+	state := utils.MountSetup(t, utils.WithArgs("--xattrs", "--mapping=rw:/:%ROOT%"))
 
 	utils.MustMkdirAll(t, state.RootPath("dir"), 0755)
 	utils.MustWriteFile(t, state.RootPath("file"), 0644, "new content")
@@ -1565,8 +1655,9 @@ func TestReadWrite_Removexattr(t *testing.T) {
 }
 
 func TestReadWrite_RemovexattrOnScaffoldDirectory(t *testing.T) {
-	state := utils.MountSetup(t, "--xattrs", "--mapping=rw:/:%ROOT%", "--mapping=rw:/scaffold/dir:%ROOT%")
-	defer state.TearDown(t)
+	t.Parallel()
+
+	state := utils.MountSetup(t, utils.WithArgs("--xattrs", "--mapping=rw:/:%ROOT%", "--mapping=rw:/scaffold/dir:%ROOT%"))
 
 	path := state.MountPath("scaffold")
 	wantErr := utils.WriteErrorForUnwritableNode()
@@ -1576,8 +1667,9 @@ func TestReadWrite_RemovexattrOnScaffoldDirectory(t *testing.T) {
 }
 
 func TestReadWrite_RemovexattrDisabled(t *testing.T) {
-	state := utils.MountSetup(t, "--mapping=ro:/:%ROOT%")
-	defer state.TearDown(t)
+	t.Parallel()
+
+	state := utils.MountSetup(t, utils.WithArgs("--mapping=ro:/:%ROOT%"))
 
 	utils.MustMkdirAll(t, state.RootPath("dir"), 0755)
 
@@ -1597,6 +1689,8 @@ func TestReadWrite_RemovexattrDisabled(t *testing.T) {
 }
 
 func TestReadWrite_Fremovexattr(t *testing.T) {
+	t.Parallel()
+
 	testXattrsOnDeletedFiles(t, unix.EACCES, func(fd int) error {
 		return unix.Fremovexattr(fd, "user.foo")
 	})
@@ -0,0 +1,215 @@
+// Copyright 2020 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not
+// use this file except in compliance with the License.  You may obtain a copy
+// of the License at:
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.  See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package integration
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/bazelbuild/sandboxfs/integration/protocol"
+	"github.com/bazelbuild/sandboxfs/integration/utils"
+)
+
+const (
+	// workersPerSandbox is the number of load workers in every long-lived sandbox.
+	workersPerSandbox = 4
+
+	// loadFileSlots is the number of distinct files that every load worker cycles through.
+	loadFileSlots = 8
+)
+
+// loadFileName returns the name of a file owned by a load worker.
+func loadFileName(worker int, slot int) string {
+	return fmt.Sprintf("w%d-%d", worker, slot)
+}
+
+// runSandboxLoad issues reads and writes within the directory of a long-lived sandbox on behalf of
+// one worker until stop is closed.  Every worker owns a set of files in the sandbox and tracks
+// their expected contents, which allows detecting errors, stale entries and entries or contents
+// that leaked from other sandboxes.
+func runSandboxLoad(dir string, sandbox string, worker int, stop <-chan struct{}) error {
+	live := make(map[string]string)
+	for n := 0; ; n++ {
+		select {
+		case <-stop:
+			return nil
+		default:
+		}
+
+		name := loadFileName(worker, n%loadFileSlots)
+		path := filepath.Join(dir, name)
+		contents := fmt.Sprintf("%s %d %d", sandbox, worker, n)
+		if err := ioutil.WriteFile(path, []byte(contents), 0644); err != nil {
+			return fmt.Errorf("write %s failed: %v", path, err)
+		}
+		live[name] = contents
+		if err := utils.FileEquals(path, contents); err != nil {
+			return err
+		}
+
+		if n%5 == 4 {
+			if err := os.Remove(path); err != nil {
+				return fmt.Errorf("unlink %s failed: %v", path, err)
+			}
+			delete(live, name)
+		}
+
+		next := loadFileName(worker, (n+1)%loadFileSlots)
+		if want, ok := live[next]; ok {
+			if err := utils.FileEquals(filepath.Join(dir, next), want); err != nil {
+				return err
+			}
+		} else if _, err := os.Lstat(filepath.Join(dir, next)); !os.IsNotExist(err) {
+			return fmt.Errorf("stat of deleted %s returned %v; want not found", filepath.Join(dir, next), err)
+		}
+
+		if n%7 == 0 {
+			entries, err := ioutil.ReadDir(dir)
+			if err != nil {
+				return fmt.Errorf("readdir %s failed: %v", dir, err)
+			}
+			own := make(map[string]bool)
+			prefix := fmt.Sprintf("w%d-", worker)
+			for _, entry := range entries {
+				if !strings.HasPrefix(entry.Name(), "w") {
+					return fmt.Errorf("readdir %s returned foreign entry %s", dir, entry.Name())
+				}
+				if strings.HasPrefix(entry.Name(), prefix) {
+					own[entry.Name()] = true
+				}
+			}
+			for name := range live {
+				if !own[name] {
+					return fmt.Errorf("readdir %s lost entry %s", dir, name)
+				}
+				delete(own, name)
+			}
+			for name := range own {
+				return fmt.Errorf("readdir %s returned stale entry %s", dir, name)
+			}
+		}
+	}
+}
+
+// churnSandboxes creates and destroys count short-lived sandboxes named after prefix.  Each of them
+// maps the short directory of the root, whose entries clash with those of the long-lived sandboxes,
+// and must expose its own contents and not those of the long-lived sandboxes.
+func churnSandboxes(state *utils.MountState, prefix string, count int) error {
+	for i := 0; i < count; i++ {
+		id := fmt.Sprintf("%s-%d", prefix, i)
+		create := protocol.MakeCreateSandboxRequest(id, protocol.Mapping{Path: "/", UnderlyingPath: "%ROOT%/short", Writable: false})
+		if err := reconfigure(state.Client, state.RootPath(), create); err != nil {
+			return fmt.Errorf("failed to create sandbox %s: %v", id, err)
+		}
+		if err := utils.FileEquals(state.MountPath(id, loadFileName(i%workersPerSandbox, i%loadFileSlots)), "foreign"); err != nil {
+			return err
+		}
+		if err := reconfigure(state.Client, state.RootPath(), protocol.MakeDestroySandboxRequest(id)); err != nil {
+			return fmt.Errorf("failed to destroy sandbox %s: %v", id, err)
+		}
+	}
+	return nil
+}
+
+func TestReconfiguration_IsolationUnderLoad(t *testing.T) {
+	t.Parallel()
+
+	const (
+		longLived       = 4
+		churners        = 8
+		sandboxesPerRun = 250
+	)
+
+	testData := []struct {
+		name string
+
+		reconfigThreads int
+	}{
+		{"OneThread", 1},
+		{"MultipleThreads", 4},
+	}
+	for _, d := range testData {
+		d := d
+		t.Run(d.name, func(t *testing.T) {
+			t.Parallel()
+
+			state := utils.MountSetup(t, utils.WithReconfigClient(), utils.WithArgs(fmt.Sprintf("--reconfig_threads=%d", d.reconfigThreads)))
+
+			// The short-lived sandboxes expose files with the same names as those written
+			// in the long-lived sandboxes so that any leak shows up as foreign contents.
+			utils.MustMkdirAll(t, state.RootPath("short"), 0755)
+			for worker := 0; worker < workersPerSandbox; worker++ {
+				for slot := 0; slot < loadFileSlots; slot++ {
+					utils.MustWriteFile(t, state.RootPath("short", loadFileName(worker, slot)), 0644, "foreign")
+				}
+			}
+
+			var longIDs []string
+			for i := 0; i < longLived; i++ {
+				id := fmt.Sprintf("long-%d", i)
+				utils.MustMkdirAll(t, state.RootPath(id), 0755)
+				create := protocol.MakeCreateSandboxRequest(id, protocol.Mapping{Path: "/", UnderlyingPath: "%ROOT%/" + id, Writable: true})
+				if err := reconfigure(state.Client, state.RootPath(), create); err != nil {
+					t.Fatal(err)
+				}
+				longIDs = append(longIDs, id)
+			}
+
+			stop := make(chan struct{})
+			var load sync.WaitGroup
+			for _, id := range longIDs {
+				for worker := 0; worker < workersPerSandbox; worker++ {
+					load.Add(1)
+					go func(id string, worker int) {
+						defer load.Done()
+						if err := runSandboxLoad(state.MountPath(id), id, worker, stop); err != nil {
+							t.Errorf("Load in long-lived sandbox failed: %v", err)
+						}
+					}(id, worker)
+				}
+			}
+
+			var churn sync.WaitGroup
+			for i := 0; i < churners; i++ {
+				churn.Add(1)
+				go func(i int) {
+					defer churn.Done()
+					if err := churnSandboxes(state, fmt.Sprintf("short-%d", i), sandboxesPerRun); err != nil {
+						t.Errorf("Churn of short-lived sandboxes failed: %v", err)
+					}
+				}(i)
+			}
+			churn.Wait()
+			close(stop)
+			load.Wait()
+
+			sort.Strings(longIDs)
+			if err := utils.DirEntryNamesEqual(state.MountPath(), longIDs); err != nil {
+				t.Errorf("Short-lived sandboxes left behind: %v", err)
+			}
+			for _, id := range longIDs {
+				if err := utils.DirEquals(state.RootPath(id), state.MountPath(id)); err != nil {
+					t.Error(err)
+				}
+			}
+		})
+	}
+}
@@ -27,110 +27,48 @@ import (
 	"sync"
 	"syscall"
 	"testing"
+	"time"
 
+	"github.com/bazelbuild/sandboxfs/integration/protocol"
 	"github.com/bazelbuild/sandboxfs/integration/utils"
 )
 
-// mapping represents a mapping entry in the reconfiguration protocol.
-type mapping struct {
-	Path                 string `json:"path"`
-	PathPrefix           int    `json:"path_prefix"`
-	UnderlyingPath       string `json:"underlying_path"`
-	UnderlyingPathPrefix int    `json:"underlying_path_prefix"`
-	Writable             bool   `json:"writable"`
-}
-
-// mapStep represents a map operation in the reconfiguration protocol.
-type createSandboxRequest struct {
-	ID       string            `json:"id"`
-	Mappings []mapping         `json:"mappings"`
-	Prefixes map[string]string `json:"prefixes"`
-}
-
-// request represents a single reconfiguration request.
-type request struct {
-	CreateSanbox   *createSandboxRequest `json:"CreateSandbox,omitempty"`
-	DestroySandbox *string               `json:"DestroySandbox,omitempty"`
-}
-
-// getID returns the sandbox identifier in a request message.
-func (req request) getID() string {
-	if req.CreateSanbox != nil && req.DestroySandbox != nil {
-		panic("Bad request: contains both create and destroy requests")
-	} else if req.CreateSanbox != nil {
-		return req.CreateSanbox.ID
-	} else {
-		return *req.DestroySandbox
-	}
-}
-
-// response represents the result of a reconfiguration request.
-type response struct {
-	ID    *string `json:"id,omitempty"`
-	Error *string `json:"error,omitempty"`
-}
-
-// makeCreateSandboxRequest is a convenience function to instantiate a single map step.
-func makeCreateSandboxRequest(id string, mapping1 mapping, mappingN ...mapping) request {
-	return request{
-		CreateSanbox: &createSandboxRequest{
-			ID:       id,
-			Mappings: append([]mapping{mapping1}, mappingN...),
-			Prefixes: make(map[string]string),
-		},
-	}
-}
-
-// makeDestroySandboxRequest is a convenience function to instantiate a single unmap step.
-func makeDestroySandboxRequest(id string) request {
-	return request{
-		DestroySandbox: &id,
-	}
-}
+// reconfigureTimeout is the maximum amount of time to wait for sandboxfs to respond to a
+// reconfiguration request.
+const reconfigureTimeout = 30 * time.Second
 
 // tryRawReconfigure pushes a new configuration to the sandboxfs process and waits for
 // acknowledgement. The reconfiguration request is provided as a string, which may be invalid (to
-// verify error cases). Returns the error message from the server, which might be nil.
-func tryRawReconfigure(input io.Writer, output io.Reader, root string, config string) (response, error) {
-	config = strings.Replace(string(config), "%ROOT%", root, -1) + "\n"
-	n, err := io.WriteString(input, config)
+// verify error cases), and id is the sandbox it refers to. Returns the error message from the
+// server, which might be nil.
+func tryRawReconfigure(client *protocol.Client, root string, id string, config string) (protocol.Response, error) {
+	config = strings.Replace(config, "%ROOT%", root, -1)
+	call, err := client.StartRaw(id, []byte(config))
 	if err != nil {
-		return response{}, fmt.Errorf("failed to send new configuration to sandboxfs: %v", err)
+		return protocol.Response{}, fmt.Errorf("failed to send new configuration to sandboxfs: %v", err)
 	}
-	if n != len(config) {
-		return response{}, fmt.Errorf("failed to send full configuration to sandboxfs: got %d bytes, want %d bytes", n, len(config))
-	}
-
-	decoder := json.NewDecoder(output)
-	resp := response{}
-	if err := decoder.Decode(&resp); err != nil {
-		return response{}, fmt.Errorf("failed to read from sandboxfs's output: %v", err)
-	}
-	return resp, nil
+	return call.Wait(utils.ScaledTimeout(reconfigureTimeout))
 }
 
 // tryReconfigure pushes a new configuration to the sandboxfs process and waits for
 // acknowledgement. The reconfiguration request is provided as a sequence of objects, which is
 // assumed to be valid (except for semantical errors). Returns the error message from the server,
 // which might be nil.
-func tryReconfigure(input io.Writer, output io.Reader, root string, config request) (response, error) {
+func tryReconfigure(client *protocol.Client, root string, config protocol.Request) (protocol.Response, error) {
 	configBytes, err := json.Marshal(config)
 	if err != nil {
 		panic(fmt.Sprintf("Bad configuration request in test: %v", err))
 	}
-	return tryRawReconfigure(input, output, root, string(configBytes))
+	return tryRawReconfigure(client, root, config.ID(), string(configBytes))
 }
 
 // reconfigure pushes a new configuration to the sandboxfs process and waits for acknowledgement.
-func reconfigure(input io.Writer, output io.Reader, root string, requests ...request) error {
+func reconfigure(client *protocol.Client, root string, requests ...protocol.Request) error {
 	for _, req := range requests {
-		resp, err := tryReconfigure(input, output, root, req)
+		resp, err := tryReconfigure(client, root, req)
 		if err != nil {
 			return err
 		}
-		if resp.ID == nil || *resp.ID != req.getID() {
-			return fmt.Errorf("sandboxfs replied with a bad id: got %v, want %s", resp.ID, req.getID())
-		}
 		if resp.Error != nil {
 			return fmt.Errorf("sandboxfs did not ack configuration: %s", *resp.Error)
 		}
@@ -173,10 +111,12 @@ func errorIfNotUnmapped(t *testing.T, dir string, name string) {
 }
 
 func TestReconfiguration_Streams(t *testing.T) {
-	reconfigureAndCheck := func(t *testing.T, state *utils.MountState, input io.Writer, output io.Reader) {
+	t.Parallel()
+
+	reconfigureAndCheck := func(t *testing.T, state *utils.MountState, client *protocol.Client) {
 		utils.MustMkdirAll(t, state.RootPath("a/b"), 0755)
-		config := makeCreateSandboxRequest("sb", mapping{Path: "/ro", UnderlyingPath: "%ROOT%/a", Writable: false})
-		if err := reconfigure(input, output, state.RootPath(), config); err != nil {
+		config := protocol.MakeCreateSandboxRequest("sb", protocol.Mapping{Path: "/ro", UnderlyingPath: "%ROOT%/a", Writable: false})
+		if err := reconfigure(client, state.RootPath(), config); err != nil {
 			t.Fatal(err)
 		}
 
@@ -188,12 +128,8 @@ func TestReconfiguration_Streams(t *testing.T) {
 	// TODO(jmmv): Consider dropping stdin/stdout support as defaults.  This is quite an
 	// artificial construct and makes our testing quite complex.
 	t.Run("Default", func(t *testing.T) {
-		stdoutReader, stdoutWriter := io.Pipe()
-		state := utils.MountSetupWithOutputs(t, stdoutWriter, os.Stderr)
-		defer stdoutReader.Close() // Just in case the test fails half-way through.
-		defer state.TearDown(t)
-		defer stdoutWriter.Close() // Just in case the test fails half-way through.
-		reconfigureAndCheck(t, state, state.Stdin, stdoutReader)
+		state := utils.MountSetup(t, utils.WithReconfigClient())
+		reconfigureAndCheck(t, state, state.Client)
 	})
 
 	t.Run("Explicit", func(t *testing.T) {
@@ -213,8 +149,7 @@ func TestReconfiguration_Streams(t *testing.T) {
 			t.Fatalf("Failed to create %s fifo: %v", outFifo, err)
 		}
 
-		state := utils.MountSetupWithOutputs(t, nil, os.Stderr, "--input="+inFifo, "--output="+outFifo)
-		defer state.TearDown(t)
+		state := utils.MountSetup(t, utils.WithStdout(nil), utils.WithArgs("--input="+inFifo, "--output="+outFifo))
 
 		input, err := os.OpenFile(inFifo, os.O_WRONLY, 0)
 		if err != nil {
@@ -228,26 +163,24 @@ func TestReconfiguration_Streams(t *testing.T) {
 		}
 		defer output.Close()
 
-		reconfigureAndCheck(t, state, input, output)
+		reconfigureAndCheck(t, state, protocol.NewClient(input, output))
 	})
 }
 
 func TestReconfiguration_Steps(t *testing.T) {
-	stdoutReader, stdoutWriter := io.Pipe()
-	state := utils.MountSetupWithOutputs(t, stdoutWriter, os.Stderr, "--mapping=ro:/:%ROOT%", "--mapping=rw:/initial:%ROOT%/initial")
-	defer stdoutReader.Close() // Just in case the test fails half-way through.
-	defer state.TearDown(t)
-	defer stdoutWriter.Close() // Just in case the test fails half-way through.
+	t.Parallel()
+
+	state := utils.MountSetup(t, utils.WithReconfigClient(), utils.WithArgs("--mapping=ro:/:%ROOT%", "--mapping=rw:/initial:%ROOT%/initial"))
 
 	utils.MustMkdirAll(t, state.RootPath("some/read-only-dir"), 0755)
 	utils.MustMkdirAll(t, state.RootPath("some/read-write-dir"), 0755)
-	config := makeCreateSandboxRequest(
+	config := protocol.MakeCreateSandboxRequest(
 		"sb",
-		mapping{Path: "/ro", UnderlyingPath: "%ROOT%/some/read-only-dir", Writable: false},
-		mapping{Path: "/ro/rw", UnderlyingPath: "%ROOT%/some/read-write-dir", Writable: true},
-		mapping{Path: "/nested/dup", UnderlyingPath: "%ROOT%/some/read-only-dir", Writable: false},
+		protocol.Mapping{Path: "/ro", UnderlyingPath: "%ROOT%/some/read-only-dir", Writable: false},
+		protocol.Mapping{Path: "/ro/rw", UnderlyingPath: "%ROOT%/some/read-write-dir", Writable: true},
+		protocol.Mapping{Path: "/nested/dup", UnderlyingPath: "%ROOT%/some/read-only-dir", Writable: false},
 	)
-	if err := reconfigure(state.Stdin, stdoutReader, state.RootPath(), config); err != nil {
+	if err := reconfigure(state.Client, state.RootPath(), config); err != nil {
 		t.Fatal(err)
 	}
 
@@ -270,8 +203,8 @@ func TestReconfiguration_Steps(t *testing.T) {
 		t.Errorf("Mkdir succeeded in read-only root mapping")
 	}
 
-	config = makeCreateSandboxRequest("sb2", mapping{Path: "/rw/dir", UnderlyingPath: "%ROOT%/some/read-write-dir", Writable: true})
-	if err := reconfigure(state.Stdin, stdoutReader, state.RootPath(), config); err != nil {
+	config = protocol.MakeCreateSandboxRequest("sb2", protocol.Mapping{Path: "/rw/dir", UnderlyingPath: "%ROOT%/some/read-write-dir", Writable: true})
+	if err := reconfigure(state.Client, state.RootPath(), config); err != nil {
 		t.Fatal(err)
 	}
 
@@ -285,8 +218,8 @@ func TestReconfiguration_Steps(t *testing.T) {
 		t.Errorf("Mkdir failed in read-write mapping: %v", err)
 	}
 
-	config = makeDestroySandboxRequest("sb")
-	if err := reconfigure(state.Stdin, stdoutReader, state.RootPath(), config); err != nil {
+	config = protocol.MakeDestroySandboxRequest("sb")
+	if err := reconfigure(state.Client, state.RootPath(), config); err != nil {
 		t.Fatal(err)
 	}
 
@@ -295,58 +228,61 @@ func TestReconfiguration_Steps(t *testing.T) {
 	if _, err := os.Lstat(state.MountPath("sb2")); err != nil {
 		t.Errorf("Non-deleted sandbox sb2 seems to be gone; got %v", err)
 	}
+
+	config = protocol.MakeDestroySandboxRequest("sb2")
+	if err := reconfigure(state.Client, state.RootPath(), config); err != nil {
+		t.Fatal(err)
+	}
+	state.CheckNoLeaks(t)
 }
 
 func TestReconfiguration_EmptySubroot(t *testing.T) {
-	stdoutReader, stdoutWriter := io.Pipe()
-	state := utils.MountSetupWithOutputs(t, stdoutWriter, os.Stderr, "--mapping=ro:/:%ROOT%")
-	defer stdoutReader.Close() // Just in case the test fails half-way through.
-	defer state.TearDown(t)
-	defer stdoutWriter.Close() // Just in case the test fails half-way through.
+	t.Parallel()
+
+	state := utils.MountSetup(t, utils.WithReconfigClient(), utils.WithArgs("--mapping=ro:/:%ROOT%"))
 
-	config := request{
-		CreateSanbox: &createSandboxRequest{
+	config := protocol.Request{
+		CreateSandbox: &protocol.CreateSandboxRequest{
 			ID:       "empty",
-			Mappings: []mapping{},
+			Mappings: []protocol.Mapping{},
 			Prefixes: make(map[string]string),
 		},
 	}
-	if err := reconfigure(state.Stdin, stdoutReader, state.RootPath(), config); err != nil {
+	if err := reconfigure(state.Client, state.RootPath(), config); err != nil {
 		t.Fatal(err)
 	}
 	if _, err := os.Lstat(state.MountPath("empty")); err != nil {
 		t.Errorf("Failed to stat empty root: %v", err)
 	}
 
-	config = makeDestroySandboxRequest("empty")
-	if err := reconfigure(state.Stdin, stdoutReader, state.RootPath(), config); err != nil {
+	config = protocol.MakeDestroySandboxRequest("empty")
+	if err := reconfigure(state.Client, state.RootPath(), config); err != nil {
 		t.Fatal(err)
 	}
 	errorIfNotUnmapped(t, state.MountPath(), "empty")
+	state.CheckNoLeaks(t)
 }
 
 func TestReconfiguration_Subroots(t *testing.T) {
-	stdoutReader, stdoutWriter := io.Pipe()
-	state := utils.MountSetupWithOutputs(t, stdoutWriter, os.Stderr)
-	defer stdoutReader.Close() // Just in case the test fails half-way through.
-	defer state.TearDown(t)
-	defer stdoutWriter.Close() // Just in case the test fails half-way through.
+	t.Parallel()
+
+	state := utils.MountSetup(t, utils.WithReconfigClient())
 
 	utils.MustMkdirAll(t, state.RootPath("sandbox1"), 0755)
 	utils.MustMkdirAll(t, state.RootPath("sandbox1subdir"), 0755)
 	utils.MustMkdirAll(t, state.RootPath("sandbox2"), 0755)
-	config := []request{
-		makeCreateSandboxRequest(
+	config := []protocol.Request{
+		protocol.MakeCreateSandboxRequest(
 			"sandbox1",
-			mapping{Path: "/", UnderlyingPath: "%ROOT%/sandbox1", Writable: true},
-			mapping{Path: "/subdir", UnderlyingPath: "%ROOT%/sandbox1subdir", Writable: true},
+			protocol.Mapping{Path: "/", UnderlyingPath: "%ROOT%/sandbox1", Writable: true},
+			protocol.Mapping{Path: "/subdir", UnderlyingPath: "%ROOT%/sandbox1subdir", Writable: true},
 		),
-		makeCreateSandboxRequest(
+		protocol.MakeCreateSandboxRequest(
 			"sandbox2",
-			mapping{Path: "/", UnderlyingPath: "%ROOT%/sandbox2", Writable: true},
+			protocol.Mapping{Path: "/", UnderlyingPath: "%ROOT%/sandbox2", Writable: true},
 		),
 	}
-	if err := reconfigure(state.Stdin, stdoutReader, state.RootPath(), config...); err != nil {
+	if err := reconfigure(state.Client, state.RootPath(), config...); err != nil {
 		t.Fatal(err)
 	}
 	for _, path := range []string{"sandbox1/a", "sandbox1/subdir/b", "sandbox2/c"} {
@@ -361,27 +297,26 @@ func TestReconfiguration_Subroots(t *testing.T) {
 	}
 
 	for _, subroot := range []string{"sandbox1", "sandbox2"} {
-		config := makeDestroySandboxRequest(subroot)
-		if err := reconfigure(state.Stdin, stdoutReader, state.RootPath(), config); err != nil {
+		config := protocol.MakeDestroySandboxRequest(subroot)
+		if err := reconfigure(state.Client, state.RootPath(), config); err != nil {
 			t.Fatal(err)
 		}
 		errorIfNotUnmapped(t, state.MountPath(), subroot)
 	}
+	state.CheckNoLeaks(t)
 }
 
 func TestReconfiguration_Prefixes(t *testing.T) {
-	stdoutReader, stdoutWriter := io.Pipe()
-	state := utils.MountSetupWithOutputs(t, stdoutWriter, os.Stderr)
-	defer stdoutReader.Close() // Just in case the test fails half-way through.
-	defer state.TearDown(t)
-	defer stdoutWriter.Close() // Just in case the test fails half-way through.
+	t.Parallel()
+
+	state := utils.MountSetup(t, utils.WithReconfigClient())
 
 	utils.MustMkdirAll(t, state.RootPath("x"), 0755)
 	utils.MustMkdirAll(t, state.RootPath("y"), 0755)
-	config1 := request{
-		CreateSanbox: &createSandboxRequest{
+	config1 := protocol.Request{
+		CreateSandbox: &protocol.CreateSandboxRequest{
 			ID: "sb1",
-			Mappings: []mapping{
+			Mappings: []protocol.Mapping{
 				{Path: "a", PathPrefix: 1, UnderlyingPath: "x", UnderlyingPathPrefix: 2, Writable: true},
 			},
 			Prefixes: map[string]string{
@@ -392,10 +327,10 @@ func TestReconfiguration_Prefixes(t *testing.T) {
 	}
 	// This second request is intended to define new prefixes and also use previously-defined
 	// prefixes.
-	config2 := request{
-		CreateSanbox: &createSandboxRequest{
+	config2 := protocol.Request{
+		CreateSandbox: &protocol.CreateSandboxRequest{
 			ID: "sb2",
-			Mappings: []mapping{
+			Mappings: []protocol.Mapping{
 				{Path: "", PathPrefix: 3, UnderlyingPath: "y", UnderlyingPathPrefix: 2, Writable: true},
 			},
 			Prefixes: map[string]string{
@@ -403,7 +338,7 @@ func TestReconfiguration_Prefixes(t *testing.T) {
 			},
 		},
 	}
-	if err := reconfigure(state.Stdin, stdoutReader, state.RootPath(), config1, config2); err != nil {
+	if err := reconfigure(state.Client, state.RootPath(), config1, config2); err != nil {
 		t.Fatal(err)
 	}
 	for _, path := range []string{"sb1/foo/bar/a/test", "sb2/test"} {
@@ -419,17 +354,15 @@ func TestReconfiguration_Prefixes(t *testing.T) {
 }
 
 func TestReconfiguration_Minimized(t *testing.T) {
-	stdoutReader, stdoutWriter := io.Pipe()
-	state := utils.MountSetupWithOutputs(t, stdoutWriter, os.Stderr)
-	defer stdoutReader.Close() // Just in case the test fails half-way through.
-	defer state.TearDown(t)
-	defer stdoutWriter.Close() // Just in case the test fails half-way through.
+	t.Parallel()
+
+	state := utils.MountSetup(t, utils.WithReconfigClient())
 
 	utils.MustMkdirAll(t, state.RootPath("dir"), 0755)
 
-	doOne := func(config string) {
+	doOne := func(id string, config string) {
 		t.Helper()
-		resp, err := tryRawReconfigure(state.Stdin, stdoutReader, state.RootPath(), config)
+		resp, err := tryRawReconfigure(state.Client, state.RootPath(), id, config)
 		if err != nil {
 			t.Fatal(err)
 		} else if resp.Error != nil {
@@ -437,17 +370,17 @@ func TestReconfiguration_Minimized(t *testing.T) {
 		}
 	}
 
-	doOne(`{"C":{"i":"empty","q":{"1":"%ROOT%"}}}`)
+	doOne("empty", `{"C":{"i":"empty","q":{"1":"%ROOT%"}}}`)
 	if _, err := os.Lstat(state.MountPath("empty")); err != nil {
 		t.Errorf("Failed to stat mount path %s: %v", "empty", err)
 	}
 
-	doOne(`{"C":{"i":"sb1","m":[{"p":"/a","u":"%ROOT%"}]}}`)
+	doOne("sb1", `{"C":{"i":"sb1","m":[{"p":"/a","u":"%ROOT%"}]}}`)
 	if _, err := os.Lstat(state.MountPath("sb1/a")); err != nil {
 		t.Errorf("Failed to stat mount path %s: %v", "empty", err)
 	}
 
-	doOne(`{"C":{"i":"sb2","m":[{"p":"a","x":2,"u":"dir","y":1,"w":true}],"q":{"2":"/x"}}}`)
+	doOne("sb2", `{"C":{"i":"sb2","m":[{"p":"a","x":2,"u":"dir","y":1,"w":true}],"q":{"2":"/x"}}}`)
 	if err := os.Mkdir(state.MountPath("sb2/x/a/test"), 0755); err != nil {
 		t.Errorf("Failed to create mount path %s: %v", "sb2/x/a/test", err)
 	}
@@ -458,111 +391,37 @@ func TestReconfiguration_Minimized(t *testing.T) {
 		t.Errorf("Failed to stat underlying path %s: %v", "dir/test", err)
 	}
 
-	doOne(`{"D":"empty"}`)
+	doOne("empty", `{"D":"empty"}`)
 	errorIfNotUnmapped(t, state.MountPath(), "empty")
 }
 
-func TestReconfiguration_RecoverableErrors(t *testing.T) {
-	// checkBadConfig applies the set of reconfiguration requests in configs and checks that the
-	// last one fails with the error provided in wantError.  All requests but the last one are
-	// expected to succeed, as they are intended to prepare the sandboxfs state before issuing
-	// the failing request.
-	checkBadConfig := func(t *testing.T, state *utils.MountState, stdoutReader io.Reader, configs []request, wantError string) {
-		t.Helper()
-
-		if len(configs) > 1 {
-			for _, req := range configs[:len(configs)-1] {
-				if err := reconfigure(state.Stdin, stdoutReader, state.RootPath(), req); err != nil {
-					t.Fatalf("prerequisite reconfiguration failed: %v", err)
-				}
-			}
-		}
+func TestReconfiguration_Conformance(t *testing.T) {
+	t.Parallel()
 
-		req := configs[len(configs)-1]
-		resp, err := tryReconfigure(state.Stdin, stdoutReader, state.RootPath(), req)
-		if err != nil {
-			t.Fatalf("want reconfiguration of / to fail; got success")
-		}
-		if resp.ID == nil || *resp.ID != req.getID() {
-			t.Errorf("sandboxfs replied with a bad id: got %v, want %s", resp.ID, req.getID())
-		}
-		if resp.Error == nil {
-			t.Errorf("want reconfiguration to respond with %s; got OK", wantError)
-		} else if !utils.MatchesRegexp(wantError, *resp.Error) {
-			t.Errorf("want reconfiguration to respond with %s; got %s", wantError, *resp.Error)
+	protocol.RunConformance(t, func(t *testing.T) (*protocol.Endpoint, func()) {
+		stdoutReader, stdoutWriter := io.Pipe()
+		state := utils.MountSetup(t, utils.WithStdout(stdoutWriter), utils.WithArgs("--mapping=rw:/:%ROOT%"))
+		endpoint := &protocol.Endpoint{
+			Input:  state.Stdin,
+			Output: stdoutReader,
+			Root:   state.RootPath(),
+			Stat: func(path string) error {
+				_, err := os.Lstat(state.MountPath(path))
+				return err
+			},
 		}
-		if _, err := os.Lstat(state.MountPath("file")); err != nil {
-			t.Errorf("want file to still exist after failed reconfiguration; got %v", err)
+		tearDown := func() {
+			stdoutWriter.Close()
+			state.TearDown(t)
+			stdoutReader.Close()
 		}
-	}
-
-	testData := []struct {
-		name string
-
-		config    []request
-		wantError string
-	}{
-		{
-			"InvalidMapping",
-			[]request{
-				makeCreateSandboxRequest("sb", mapping{Path: "foo/../.", UnderlyingPath: "%ROOT%/subdir", Writable: false}),
-			},
-			"path.*not absolute",
-		},
-		{
-			"MapRootLate",
-			[]request{
-				makeCreateSandboxRequest("sb", mapping{Path: "/too-late", UnderlyingPath: "%ROOT%/subdir", Writable: false}, mapping{Path: "/", UnderlyingPath: "%ROOT%/subdir", Writable: false}),
-			},
-			"Root can be mapped at most once",
-		},
-		{
-			"MapTwice",
-			[]request{
-				makeCreateSandboxRequest("sb", mapping{Path: "/foo", UnderlyingPath: "%ROOT%/subdir", Writable: false}),
-				makeCreateSandboxRequest("sb", mapping{Path: "/foo", UnderlyingPath: "%ROOT%/file", Writable: false}),
-			},
-			"Already mapped",
-		},
-		{
-			"MapSubrootLate",
-			[]request{
-				makeCreateSandboxRequest("sb", mapping{Path: "/too-late", UnderlyingPath: "%ROOT%/file", Writable: false}, mapping{Path: "/", UnderlyingPath: "%ROOT%/subdir", Writable: false}),
-			},
-			"Root can be mapped at most once",
-		},
-		{
-			"UnmapInvalidID",
-			[]request{
-				makeDestroySandboxRequest(""),
-			},
-			"Identifier cannot be empty",
-		},
-		{
-			"BadPrefixes",
-			[]request{
-				makeCreateSandboxRequest("sb", mapping{Path: "foo", PathPrefix: 5, UnderlyingPath: "%ROOT%/file", Writable: false}, mapping{Path: "/", UnderlyingPath: "%ROOT%/subdir", Writable: false}),
-			},
-			"Prefix 5 does not exist",
-		},
-	}
-	for _, d := range testData {
-		t.Run(d.name, func(t *testing.T) {
-			stdoutReader, stdoutWriter := io.Pipe()
-			state := utils.MountSetupWithOutputs(t, stdoutWriter, os.Stderr, "--mapping=rw:/:%ROOT%")
-			defer stdoutReader.Close() // Just in case the test fails half-way through.
-			defer state.TearDown(t)
-			defer stdoutWriter.Close() // Just in case the test fails half-way through.
-
-			utils.MustMkdirAll(t, state.RootPath("subdir"), 0755)
-			utils.MustWriteFile(t, state.RootPath("file"), 0644, "")
-
-			checkBadConfig(t, state, stdoutReader, d.config, d.wantError)
-		})
-	}
+		return endpoint, tearDown
+	})
 }
 
 func TestReconfiguration_RaceSystemComponents(t *testing.T) {
+	t.Parallel()
+
 	// This test verifies that a dynamic sandboxfs instance can be unmounted immediately after
 	// reconfiguration.
 	//
@@ -578,22 +437,17 @@ func TestReconfiguration_RaceSystemComponents(t *testing.T) {
 	// between us and the system easier to trigger.
 
 	oneShot := func() error {
-		stdoutReader, stdoutWriter := io.Pipe()
-		state := utils.MountSetupWithOutputs(t, stdoutWriter, os.Stderr, "--mapping=ro:/:%ROOT%")
-		defer stdoutReader.Close()
-		// state.TearDown and stdoutWriter.Close not deferred here because we want to
-		// explicitly control for any possible error they may report and abort the whole
-		// test early in that case.
+		state := utils.MountSetup(t, utils.WithReconfigClient(), utils.WithArgs("--mapping=ro:/:%ROOT%"))
+		// state.TearDown is called explicitly here because we want to control for any
+		// possible error it may report and abort the whole test early in that case.
 
 		utils.MustWriteFile(t, state.RootPath("first"), 0644, "First")
 
-		firstConfig := makeCreateSandboxRequest("first", mapping{Path: "/", UnderlyingPath: "%ROOT%/first", Writable: false})
-		if err := reconfigure(state.Stdin, stdoutReader, state.RootPath(), firstConfig); err != nil {
-			stdoutWriter.Close()
+		firstConfig := protocol.MakeCreateSandboxRequest("first", protocol.Mapping{Path: "/", UnderlyingPath: "%ROOT%/first", Writable: false})
+		if err := reconfigure(state.Client, state.RootPath(), firstConfig); err != nil {
 			state.TearDown(t)
 			return err
 		}
-		stdoutWriter.Close()
 		return state.TearDown(t)
 	}
 
@@ -607,6 +461,8 @@ func TestReconfiguration_RaceSystemComponents(t *testing.T) {
 // TODO(jmmv): These tests probably make little sense after reconfigurations protocol changed to
 // deal with sandboxes, not arbitrary paths.
 func TestReconfiguration_DirectoryListings(t *testing.T) {
+	t.Parallel()
+
 	testData := []struct {
 		name string
 
@@ -626,21 +482,17 @@ func TestReconfiguration_DirectoryListings(t *testing.T) {
 	}
 	for _, d := range testData {
 		t.Run(d.name, func(t *testing.T) {
-			stdoutReader, stdoutWriter := io.Pipe()
-			state := utils.MountSetupWithOutputs(t, stdoutWriter, os.Stderr)
-			defer stdoutReader.Close()
-			defer state.TearDown(t)
-			defer stdoutWriter.Close()
+			state := utils.MountSetup(t, utils.WithReconfigClient())
 
 			utils.MustMkdirAll(t, state.RootPath("dir1"), 0755)
 			utils.MustWriteFile(t, state.RootPath("dir1/first"), 0644, "First")
 			utils.MustMkdirAll(t, state.RootPath("dir2"), 0755)
 			utils.MustWriteFile(t, state.RootPath("dir2/second"), 0644, "Second")
 
-			firstConfig := []request{
-				makeCreateSandboxRequest("sb", mapping{Path: filepath.Join(d.dir, "first"), UnderlyingPath: state.RootPath(d.firstConfigTarget), Writable: false}),
+			firstConfig := []protocol.Request{
+				protocol.MakeCreateSandboxRequest("sb", protocol.Mapping{Path: filepath.Join(d.dir, "first"), UnderlyingPath: state.RootPath(d.firstConfigTarget), Writable: false}),
 			}
-			if err := reconfigure(state.Stdin, stdoutReader, state.RootPath(), firstConfig...); err != nil {
+			if err := reconfigure(state.Client, state.RootPath(), firstConfig...); err != nil {
 				t.Fatalf("First configuration failed: %v", err)
 			}
 			if err := utils.DirEntryNamesEqual(state.MountPath("sb", d.dir), []string{"first"}); err != nil {
@@ -658,11 +510,11 @@ func TestReconfiguration_DirectoryListings(t *testing.T) {
 				defer handle.Close()
 			}
 
-			secondConfig := []request{
-				makeDestroySandboxRequest("sb"),
-				makeCreateSandboxRequest("sb2", mapping{Path: filepath.Join(d.dir, "second"), UnderlyingPath: state.RootPath(d.secondConfigTarget), Writable: false}),
+			secondConfig := []protocol.Request{
+				protocol.MakeDestroySandboxRequest("sb"),
+				protocol.MakeCreateSandboxRequest("sb2", protocol.Mapping{Path: filepath.Join(d.dir, "second"), UnderlyingPath: state.RootPath(d.secondConfigTarget), Writable: false}),
 			}
-			if err := reconfigure(state.Stdin, stdoutReader, state.RootPath(), secondConfig...); err != nil {
+			if err := reconfigure(state.Client, state.RootPath(), secondConfig...); err != nil {
 				t.Fatalf("Second configuration failed: %v", err)
 			}
 			if err := utils.DirEntryNamesEqual(state.MountPath("sb2", d.dir), []string{"second"}); err != nil {
@@ -673,6 +525,8 @@ func TestReconfiguration_DirectoryListings(t *testing.T) {
 }
 
 func TestReconfiguration_FileSystemStillWorksAfterInputEOF(t *testing.T) {
+	t.Parallel()
+
 	// grepStderr reads from a pipe connected to stderr looking for the given pattern and writes
 
 	// to the found channel when the pattern is found.  Any contents read from the pipe are
@@ -702,22 +556,22 @@ func TestReconfiguration_FileSystemStillWorksAfterInputEOF(t *testing.T) {
 		}
 	}
 
+	// The pipe must outlive sandboxfs, which writes to it until it exits, so close it after the
+	// cleanup registered by MountSetup runs.
 	stderrReader, stderrWriter := io.Pipe()
-	defer stderrReader.Close()
-	defer stderrWriter.Close()
+	t.Cleanup(func() {
+		stderrWriter.Close()
+		stderrReader.Close()
+	})
 
-	stdoutReader, stdoutWriter := io.Pipe()
-	state := utils.MountSetupWithOutputs(t, stdoutWriter, stderrWriter)
-	defer stdoutReader.Close()
-	defer state.TearDown(t)
-	defer stdoutWriter.Close()
+	state := utils.MountSetup(t, utils.WithReconfigClient(), utils.WithStderr(stderrWriter))
 
 	gotEOF := make(chan bool)
 	go grepStderr(stderrReader, `Reached end of reconfiguration input`, gotEOF)
 
 	utils.MustMkdirAll(t, state.RootPath("dir"), 0755)
-	config := makeCreateSandboxRequest("sb", mapping{Path: "/dir", UnderlyingPath: "%ROOT%/dir", Writable: true})
-	if err := reconfigure(state.Stdin, stdoutReader, state.RootPath(), config); err != nil {
+	config := protocol.MakeCreateSandboxRequest("sb", protocol.Mapping{Path: "/dir", UnderlyingPath: "%ROOT%/dir", Writable: true})
+	if err := reconfigure(state.Client, state.RootPath(), config); err != nil {
 		t.Fatal(err)
 	}
 
@@ -738,6 +592,8 @@ func TestReconfiguration_FileSystemStillWorksAfterInputEOF(t *testing.T) {
 }
 
 func TestReconfiguration_StreamFileDoesNotExist(t *testing.T) {
+	t.Parallel()
+
 	tempDir, err := ioutil.TempDir("", "test")
 	if err != nil {
 		t.Fatalf("Failed to create temporary directory: %v", err)
@@ -789,10 +645,11 @@ func TestReconfiguration_StreamFileDoesNotExist(t *testing.T) {
 // we suspect sandboxfs processed all of them sequentially. Because of non-determinism, the caller
 // has to wrap this helper function and run it several times in a row to get a good answer.
 func requestsMatchResponsesWithNThreads(t *testing.T, threads int) bool {
+	// Responses are read directly from the output of sandboxfs because the reconfiguration client
+	// hides the order in which they arrive.
 	stdoutReader, stdoutWriter := io.Pipe()
-	state := utils.MountSetupWithOutputs(t, stdoutWriter, os.Stderr, fmt.Sprintf("--reconfig_threads=%d", threads))
-	defer stdoutReader.Close() // Just in case the test fails half-way through.
-	defer state.TearDown(t)
+	t.Cleanup(func() { stdoutReader.Close() })
+	state := utils.MountSetup(t, utils.WithStdout(stdoutWriter), utils.WithArgs(fmt.Sprintf("--reconfig_threads=%d", threads)))
 	defer stdoutWriter.Close() // Just in case the test fails half-way through.
 
 	utils.MustMkdirAll(t, state.RootPath("dir"), 0755)
@@ -803,7 +660,7 @@ func requestsMatchResponsesWithNThreads(t *testing.T, threads int) bool {
 	wg := sync.WaitGroup{}
 	for i := 0; i < 500; i++ {
 		id := fmt.Sprintf("sandbox-%d", i)
-		req := makeCreateSandboxRequest(id, mapping{Path: "/", UnderlyingPath: state.RootPath("dir"), Writable: false})
+		req := protocol.MakeCreateSandboxRequest(id, protocol.Mapping{Path: "/", UnderlyingPath: state.RootPath("dir"), Writable: false})
 		wg.Add(1)
 		go func() {
 			bytes, err := json.Marshal(req)
@@ -825,7 +682,7 @@ func requestsMatchResponsesWithNThreads(t *testing.T, threads int) bool {
 	decoder := json.NewDecoder(stdoutReader)
 	responses := []string{}
 	for i := 0; i < 500; i++ {
-		resp := response{}
+		resp := protocol.Response{}
 		if err := decoder.Decode(&resp); err != nil {
 			t.Errorf("Failed to decode %v", err)
 		}
@@ -841,6 +698,8 @@ func requestsMatchResponsesWithNThreads(t *testing.T, threads int) bool {
 }
 
 func TestReconfiguration_ParallelOneThread(t *testing.T) {
+	t.Parallel()
+
 	ok := true
 	for i := 0; i < 50; i++ {
 		if !requestsMatchResponsesWithNThreads(t, 1) {
@@ -854,6 +713,8 @@ func TestReconfiguration_ParallelOneThread(t *testing.T) {
 }
 
 func TestReconfiguration_ParallelMultipleThreads(t *testing.T) {
+	t.Parallel()
+
 	ok := false
 	for i := 0; i < 50; i++ {
 		if !requestsMatchResponsesWithNThreads(t, 4) {
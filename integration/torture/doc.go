@@ -0,0 +1,26 @@
+// Copyright 2020 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not
+// use this file except in compliance with the License.  You may obtain a copy
+// of the License at:
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.  See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+// Package torture implements concurrency stress tests for file systems.
+//
+// A run spawns many workers that apply overlapping renames, unlinks, creates, directory reads and
+// stats to a few shared directories.  The operations are designed so that the final contents of
+// the directories are known despite the races: a set of stable files is only ever moved across
+// directories under their unique names, and every worker creates, moves and deletes temporary files
+// that no other worker modifies.  This allows checking, at the end of the run, that no entries were
+// lost or duplicated and that the inode numbers of the stable files never changed.
+//
+// Runs also track their progress so that tests can detect hangs and capture diagnostic
+// information, such as the kernel stacks of the processes involved, before failing.
+package torture
@@ -0,0 +1,74 @@
+// Copyright 2020 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not
+// use this file except in compliance with the License.  You may obtain a copy
+// of the License at:
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.  See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package torture
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// KernelStacks returns the kernel stacks of all threads of a process as exposed by Linux in
+// <procRoot>/<pid>/task/*/stack, which usually requires root privileges to read.  Threads whose
+// stacks cannot be read show the reason instead so that the result is always useful for debugging.
+func KernelStacks(procRoot string, pid int) string {
+	taskDir := filepath.Join(procRoot, strconv.Itoa(pid), "task")
+	tasks, err := ioutil.ReadDir(taskDir)
+	if err != nil {
+		return fmt.Sprintf("cannot list threads of process %d: %v\n", pid, err)
+	}
+
+	var tids []int
+	for _, task := range tasks {
+		if tid, err := strconv.Atoi(task.Name()); err == nil {
+			tids = append(tids, tid)
+		}
+	}
+	sort.Ints(tids)
+
+	var b strings.Builder
+	for _, tid := range tids {
+		comm, err := ioutil.ReadFile(filepath.Join(taskDir, strconv.Itoa(tid), "comm"))
+		if err != nil {
+			comm = []byte("?")
+		}
+		fmt.Fprintf(&b, "thread %d (%s):\n", tid, strings.TrimSpace(string(comm)))
+		stack, err := ioutil.ReadFile(filepath.Join(taskDir, strconv.Itoa(tid), "stack"))
+		if err != nil {
+			fmt.Fprintf(&b, "    cannot read stack: %v\n", err)
+			continue
+		}
+		for _, line := range strings.Split(strings.TrimRight(string(stack), "\n"), "\n") {
+			fmt.Fprintf(&b, "    %s\n", line)
+		}
+	}
+	return b.String()
+}
+
+// GoroutineStacks returns the stacks of all goroutines of the current process.
+func GoroutineStacks() string {
+	buf := make([]byte, 64*1024)
+	for {
+		n := runtime.Stack(buf, true)
+		if n < len(buf) {
+			return string(buf[:n])
+		}
+		buf = make([]byte, 2*len(buf))
+	}
+}
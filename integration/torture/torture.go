@@ -0,0 +1,380 @@
+// Copyright 2020 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not
+// use this file except in compliance with the License.  You may obtain a copy
+// of the License at:
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.  See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package torture
+
+import (
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// Config describes the shape of a run.
+type Config struct {
+	// Dir is the directory in which to create the shared directories.
+	Dir string
+
+	// Dirs is the number of shared directories.
+	Dirs int
+
+	// Files is the number of stable files.
+	Files int
+
+	// Workers is the number of concurrent workers.
+	Workers int
+
+	// Duration is the time during which workers keep issuing operations.
+	Duration time.Duration
+
+	// Seed is the seed for the random choices of the workers.
+	Seed int64
+}
+
+// Hooks that tests replace to simulate stuck operations.
+var (
+	beforeOp = func() {}
+)
+
+// Run represents an ongoing torture run.
+type Run struct {
+	config Config
+
+	// inodes contains the inode number of every stable file, indexed by file number.
+	inodes []uint64
+
+	// progress counts the operations completed so far.
+	progress uint64
+
+	// done is closed once all workers have finished.
+	done chan struct{}
+
+	// workers contains the state of every worker, which is only valid once done is closed.
+	workers []*worker
+
+	// mu protects problems.
+	mu sync.Mutex
+
+	// problems contains the invariant violations detected while the run progressed.
+	problems []error
+}
+
+// worker represents the state of a single worker.
+type worker struct {
+	id  int
+	rng *rand.Rand
+
+	// next is the number of the next temporary file to create.
+	next int
+
+	// live maps the names of the temporary files that currently exist to their directories.
+	live map[string]string
+}
+
+// dirName returns the name of the given shared directory.
+func dirName(i int) string {
+	return fmt.Sprintf("d%d", i)
+}
+
+// stableName returns the name of the given stable file.
+func stableName(i int) string {
+	return fmt.Sprintf("f%d", i)
+}
+
+// tempName returns the name of the given temporary file of a worker.
+func tempName(worker int, i int) string {
+	return fmt.Sprintf("t%d-%d", worker, i)
+}
+
+// parseStableName returns the number of the stable file with the given name, or -1 if the name
+// does not belong to a stable file.
+func parseStableName(name string) int {
+	if !strings.HasPrefix(name, "f") {
+		return -1
+	}
+	i, err := strconv.Atoi(name[1:])
+	if err != nil || i < 0 {
+		return -1
+	}
+	return i
+}
+
+// validName returns true if name could have been created by a run.
+func validName(name string) bool {
+	if parseStableName(name) >= 0 {
+		return true
+	}
+	var w, i int
+	n, err := fmt.Sscanf(name, "t%d-%d", &w, &i)
+	return err == nil && n == 2 && tempName(w, i) == name
+}
+
+// inodeOf returns the inode number of the given path.
+func inodeOf(path string) (uint64, error) {
+	var st unix.Stat_t
+	if err := unix.Lstat(path, &st); err != nil {
+		return 0, err
+	}
+	return uint64(st.Ino), nil
+}
+
+// readDirNames returns the names of the entries in a directory.  Unlike ioutil.ReadDir, this does
+// not stat the entries, which other workers may have moved away in the meantime.
+func readDirNames(dir string) ([]string, error) {
+	f, err := os.Open(dir)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return f.Readdirnames(-1)
+}
+
+// Start creates the shared directories and the stable files, which are spread evenly across the
+// directories, and launches the workers.
+func Start(config Config) (*Run, error) {
+	r := &Run{
+		config: config,
+		inodes: make([]uint64, config.Files),
+		done:   make(chan struct{}),
+	}
+
+	for i := 0; i < config.Dirs; i++ {
+		if err := os.Mkdir(r.path(i, ""), 0755); err != nil {
+			return nil, err
+		}
+	}
+	for i := 0; i < config.Files; i++ {
+		path := r.path(i%config.Dirs, stableName(i))
+		if err := ioutil.WriteFile(path, []byte(stableName(i)), 0644); err != nil {
+			return nil, err
+		}
+		inode, err := inodeOf(path)
+		if err != nil {
+			return nil, err
+		}
+		r.inodes[i] = inode
+	}
+
+	rng := rand.New(rand.NewSource(config.Seed))
+	for i := 0; i < config.Workers; i++ {
+		r.workers = append(r.workers, &worker{id: i, rng: rand.New(rand.NewSource(rng.Int63())), live: make(map[string]string)})
+	}
+
+	deadline := time.Now().Add(config.Duration)
+	var wg sync.WaitGroup
+	wg.Add(len(r.workers))
+	for _, w := range r.workers {
+		go func(w *worker) {
+			defer wg.Done()
+			for time.Now().Before(deadline) {
+				beforeOp()
+				r.step(w)
+				atomic.AddUint64(&r.progress, 1)
+			}
+		}(w)
+	}
+	go func() {
+		wg.Wait()
+		close(r.done)
+	}()
+	return r, nil
+}
+
+// path returns the path to an entry within a shared directory, or to the directory itself if name
+// is empty.
+func (r *Run) path(dir int, name string) string {
+	return filepath.Join(r.config.Dir, dirName(dir), name)
+}
+
+// report records an invariant violation.
+func (r *Run) report(format string, arg ...interface{}) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.problems = append(r.problems, fmt.Errorf(format, arg...))
+}
+
+// expectNotExist reports err unless it indicates that the entry was concurrently moved away.
+func (r *Run) expectNotExist(op string, err error) {
+	if err != nil && !os.IsNotExist(err) {
+		r.report("%s failed: %v", op, err)
+	}
+}
+
+// step applies a random operation on behalf of a worker.
+func (r *Run) step(w *worker) {
+	dir := w.rng.Intn(r.config.Dirs)
+	switch w.rng.Intn(6) {
+	case 0: // Move a stable file to another directory.
+		file := stableName(w.rng.Intn(r.config.Files))
+		from, to := r.path(dir, file), r.path(w.rng.Intn(r.config.Dirs), file)
+		r.expectNotExist("rename "+from, os.Rename(from, to))
+
+	case 1: // Create a temporary file.
+		name := tempName(w.id, w.next)
+		w.next++
+		if err := ioutil.WriteFile(r.path(dir, name), []byte(name), 0644); err != nil {
+			r.report("create %s failed: %v", name, err)
+			return
+		}
+		w.live[name] = dirName(dir)
+
+	case 2: // Move one of our temporary files to another directory.
+		for name, from := range w.live {
+			if err := os.Rename(filepath.Join(r.config.Dir, from, name), r.path(dir, name)); err != nil {
+				r.report("rename %s failed: %v", name, err)
+				return
+			}
+			w.live[name] = dirName(dir)
+			break
+		}
+
+	case 3: // Delete one of our temporary files.
+		for name, from := range w.live {
+			if err := os.Remove(filepath.Join(r.config.Dir, from, name)); err != nil {
+				r.report("unlink %s failed: %v", name, err)
+				return
+			}
+			delete(w.live, name)
+			break
+		}
+
+	case 4: // Read a shared directory.
+		names, err := readDirNames(r.path(dir, ""))
+		if err != nil {
+			r.report("readdir %s failed: %v", dirName(dir), err)
+			return
+		}
+		for _, name := range names {
+			if !validName(name) {
+				r.report("readdir %s returned unknown entry %s", dirName(dir), name)
+			}
+		}
+
+	case 5: // Stat a stable file, which may or may not be in the chosen directory.
+		i := w.rng.Intn(r.config.Files)
+		inode, err := inodeOf(r.path(dir, stableName(i)))
+		if err != nil {
+			r.expectNotExist("stat "+stableName(i), err)
+			return
+		}
+		if inode != r.inodes[i] {
+			r.report("stat %s returned inode %d; want %d", stableName(i), inode, r.inodes[i])
+		}
+	}
+}
+
+// Dirs returns the names of the shared directories relative to the directory of the run.
+func (r *Run) Dirs() []string {
+	var dirs []string
+	for i := 0; i < r.config.Dirs; i++ {
+		dirs = append(dirs, dirName(i))
+	}
+	return dirs
+}
+
+// Progress returns the number of operations completed so far.
+func (r *Run) Progress() uint64 {
+	return atomic.LoadUint64(&r.progress)
+}
+
+// Wait blocks until the run completes and returns true, or returns false if no operation completes
+// for stallTimeout, in which case some of the workers are likely stuck.
+func (r *Run) Wait(stallTimeout time.Duration) bool {
+	ticker := time.NewTicker(stallTimeout / 10)
+	defer ticker.Stop()
+
+	last := r.Progress()
+	lastChange := time.Now()
+	for {
+		select {
+		case <-r.done:
+			return true
+		case now := <-ticker.C:
+			if progress := r.Progress(); progress != last {
+				last = progress
+				lastChange = now
+			} else if now.Sub(lastChange) >= stallTimeout {
+				return false
+			}
+		}
+	}
+}
+
+// Verify returns the problems detected while the run progressed and checks that the shared
+// directories contain exactly the expected entries.  Must only be called once Wait returns true.
+func (r *Run) Verify() []error {
+	r.mu.Lock()
+	problems := append([]error{}, r.problems...)
+	r.mu.Unlock()
+
+	want := make(map[string][]string)
+	for _, w := range r.workers {
+		for name, dir := range w.live {
+			want[name] = append(want[name], dir)
+		}
+	}
+	got := make(map[string][]string)
+	for i := 0; i < r.config.Dirs; i++ {
+		names, err := readDirNames(r.path(i, ""))
+		if err != nil {
+			problems = append(problems, err)
+			continue
+		}
+		for _, name := range names {
+			got[name] = append(got[name], dirName(i))
+		}
+	}
+
+	for i := 0; i < r.config.Files; i++ {
+		name := stableName(i)
+		dirs := got[name]
+		delete(got, name)
+		if len(dirs) != 1 {
+			problems = append(problems, fmt.Errorf("stable file %s found in %v; want exactly one directory", name, dirs))
+			continue
+		}
+		inode, err := inodeOf(filepath.Join(r.config.Dir, dirs[0], name))
+		if err != nil {
+			problems = append(problems, err)
+		} else if inode != r.inodes[i] {
+			problems = append(problems, fmt.Errorf("stable file %s has inode %d; want %d", name, inode, r.inodes[i]))
+		}
+	}
+
+	var names []string
+	for name := range got {
+		names = append(names, name)
+	}
+	for name := range want {
+		if _, ok := got[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if fmt.Sprint(got[name]) != fmt.Sprint(want[name]) {
+			problems = append(problems, fmt.Errorf("entry %s found in %v; want %v", name, got[name], want[name]))
+		}
+	}
+	return problems
+}
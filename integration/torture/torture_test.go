@@ -0,0 +1,164 @@
+// Copyright 2020 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not
+// use this file except in compliance with the License.  You may obtain a copy
+// of the License at:
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.  See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package torture
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// tempDir creates a temporary directory that is deleted when the test finishes.
+func tempDir(t *testing.T) string {
+	t.Helper()
+
+	dir, err := ioutil.TempDir("", "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	return dir
+}
+
+func TestRun_NoProblems(t *testing.T) {
+	dir := tempDir(t)
+	r, err := Start(Config{Dir: dir, Dirs: 3, Files: 10, Workers: 16, Duration: 200 * time.Millisecond, Seed: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !r.Wait(10 * time.Second) {
+		t.Fatalf("Run did not make progress")
+	}
+	if r.Progress() == 0 {
+		t.Errorf("Got no completed operations")
+	}
+	for _, err := range r.Verify() {
+		t.Error(err)
+	}
+}
+
+func TestRun_VerifyDetectsLostAndUnknownEntries(t *testing.T) {
+	dir := tempDir(t)
+	r, err := Start(Config{Dir: dir, Dirs: 2, Files: 4, Workers: 1, Duration: 0, Seed: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !r.Wait(10 * time.Second) {
+		t.Fatalf("Run did not make progress")
+	}
+
+	if err := os.Remove(filepath.Join(dir, "d1", "f1")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Rename(filepath.Join(dir, "d0", "f2"), filepath.Join(dir, "d0", "f2.moved")); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "d1", "f2"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	var problems []string
+	for _, err := range r.Verify() {
+		problems = append(problems, err.Error())
+	}
+	all := strings.Join(problems, "\n")
+	for _, want := range []string{"stable file f1 found in []", "stable file f2 has inode", "entry f2.moved found in [d0]; want []"} {
+		if !strings.Contains(all, want) {
+			t.Errorf("Got problems %q; want one containing %q", all, want)
+		}
+	}
+}
+
+func TestRun_WaitDetectsHang(t *testing.T) {
+	release := make(chan struct{})
+	beforeOp = func() { <-release }
+	defer func() { beforeOp = func() {} }()
+
+	dir := tempDir(t)
+	r, err := Start(Config{Dir: dir, Dirs: 1, Files: 1, Workers: 2, Duration: time.Second, Seed: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Wait(100 * time.Millisecond) {
+		t.Errorf("Wait succeeded; want hang detection")
+	}
+	close(release)
+	if !r.Wait(10 * time.Second) {
+		t.Errorf("Run did not finish once released")
+	}
+}
+
+func TestValidName(t *testing.T) {
+	testData := []struct {
+		name string
+		want bool
+	}{
+		{"f0", true},
+		{"f123", true},
+		{"t3-7", true},
+		{"f", false},
+		{"f-1", false},
+		{"t3", false},
+		{"t3-7x", false},
+		{"other", false},
+	}
+	for _, d := range testData {
+		if got := validName(d.name); got != d.want {
+			t.Errorf("Got %v for %s; want %v", got, d.name, d.want)
+		}
+	}
+}
+
+func TestKernelStacks(t *testing.T) {
+	procRoot := tempDir(t)
+	for tid, files := range map[string]map[string]string{
+		"12": {"comm": "worker\n", "stack": "[<0>] fuse_wait_answer+0x1/0x2\n[<0>] fuse_simple_request+0x3/0x4\n"},
+		"10": {"comm": "main\n"},
+	} {
+		taskDir := filepath.Join(procRoot, "10", "task", tid)
+		if err := os.MkdirAll(taskDir, 0755); err != nil {
+			t.Fatal(err)
+		}
+		for name, contents := range files {
+			if err := ioutil.WriteFile(filepath.Join(taskDir, name), []byte(contents), 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	got := KernelStacks(procRoot, 10)
+	want := `thread 10 (main):
+    cannot read stack: open ` + filepath.Join(procRoot, "10/task/10/stack") + `: no such file or directory
+thread 12 (worker):
+    [<0>] fuse_wait_answer+0x1/0x2
+    [<0>] fuse_simple_request+0x3/0x4
+`
+	if got != want {
+		t.Errorf("Got %q; want %q", got, want)
+	}
+
+	if got := KernelStacks(procRoot, 11); !strings.HasPrefix(got, "cannot list threads of process 11") {
+		t.Errorf("Got %q; want error for missing process", got)
+	}
+}
+
+func TestGoroutineStacks(t *testing.T) {
+	if got := GoroutineStacks(); !strings.Contains(got, "TestGoroutineStacks") {
+		t.Errorf("Got %q; want the stack of the current goroutine", got)
+	}
+}
@@ -0,0 +1,68 @@
+// Copyright 2020 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not
+// use this file except in compliance with the License.  You may obtain a copy
+// of the License at:
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.  See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package integration
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/bazelbuild/sandboxfs/integration/torture"
+	"github.com/bazelbuild/sandboxfs/integration/utils"
+)
+
+// tortureStallTimeout is the time after which a torture run that completes no operations is
+// considered to be hung.
+const tortureStallTimeout = 10 * time.Second
+
+func TestTorture_SharedDirectories(t *testing.T) {
+	t.Parallel()
+
+	state := utils.MountSetup(t, utils.WithArgs("--mapping=rw:/:%ROOT%"))
+
+	seed := time.Now().UnixNano()
+	t.Logf("Using seed %d", seed)
+	run, err := torture.Start(torture.Config{
+		Dir:      state.MountPath(),
+		Dirs:     4,
+		Files:    64,
+		Workers:  32,
+		Duration: utils.GetConfig().TortureDuration,
+		Seed:     seed,
+	})
+	if err != nil {
+		t.Fatalf("Failed to start torture run: %v", err)
+	}
+
+	stallTimeout := utils.ScaledTimeout(tortureStallTimeout)
+	if !run.Wait(stallTimeout) {
+		// The stuck workers stay blocked until TearDown kills sandboxfs, which makes their
+		// pending requests fail.
+		t.Logf("Kernel stacks of sandboxfs:\n%s", torture.KernelStacks("/proc", state.Cmd.Process.Pid))
+		t.Logf("Kernel stacks of the test:\n%s", torture.KernelStacks("/proc", os.Getpid()))
+		t.Logf("Goroutine stacks of the test:\n%s", torture.GoroutineStacks())
+		t.Fatalf("No progress in %v after %d operations; sandboxfs seems hung", stallTimeout, run.Progress())
+	}
+	t.Logf("Completed %d operations", run.Progress())
+
+	for _, err := range run.Verify() {
+		t.Error(err)
+	}
+	for _, dir := range run.Dirs() {
+		if err := utils.DirEquals(state.RootPath(dir), state.MountPath(dir)); err != nil {
+			t.Error(err)
+		}
+	}
+}
@@ -0,0 +1,230 @@
+// Copyright 2020 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not
+// use this file except in compliance with the License.  You may obtain a copy
+// of the License at:
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.  See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package integration
+
+import (
+	"os"
+	"testing"
+
+	"golang.org/x/sys/unix"
+
+	"github.com/bazelbuild/sandboxfs/integration/utils"
+)
+
+// The tests in this file verify that sandboxfs propagates the errors of the underlying file system
+// as they are.  They rely on Linux-specific features to make the underlying file system fail: small
+// tmpfs instances, read-only remounts and shut down ext4 images.
+
+// underlyingOp represents an operation on the mount point that must fail because of the underlying
+// file system.  The operations can assume that the mount point contains the entries created by
+// stageUnderlyingEntries before the underlying file system started failing.
+type underlyingOp struct {
+	name string
+	run  func(state *utils.MountState) error
+}
+
+// openForWriteOp opens an existing file for writing.
+var openForWriteOp = underlyingOp{"OpenForWrite", func(state *utils.MountState) error {
+	fd, err := unix.Open(state.MountPath("file"), unix.O_WRONLY, 0)
+	if err == nil {
+		unix.Close(fd)
+	}
+	return err
+}}
+
+// createOp creates a new file.
+var createOp = underlyingOp{"Create", func(state *utils.MountState) error {
+	fd, err := unix.Open(state.MountPath("new-file"), unix.O_WRONLY|unix.O_CREAT|unix.O_EXCL, 0644)
+	if err == nil {
+		unix.Close(fd)
+	}
+	return err
+}}
+
+// mkdirOp creates a new directory.
+var mkdirOp = underlyingOp{"Mkdir", func(state *utils.MountState) error {
+	return unix.Mkdir(state.MountPath("new-dir"), 0755)
+}}
+
+// renameOp renames an existing file.
+var renameOp = underlyingOp{"Rename", func(state *utils.MountState) error {
+	return unix.Rename(state.MountPath("file"), state.MountPath("dir/renamed"))
+}}
+
+// setxattrOp sets an extended attribute on an existing file.
+var setxattrOp = underlyingOp{"Setxattr", func(state *utils.MountState) error {
+	return unix.Setxattr(state.MountPath("file"), "user.foo", []byte("bar"), 0)
+}}
+
+// removeOp removes an existing file.
+var removeOp = underlyingOp{"Remove", func(state *utils.MountState) error {
+	return unix.Unlink(state.MountPath("file"))
+}}
+
+// stageUnderlyingEntries creates the entries that underlyingOps expect.
+//
+// The entries are created directly on the underlying file system because sandboxfs releases the
+// files it opens asynchronously, and any file that sandboxfs still has open for writing prevents
+// remounting the file system read-only.
+func stageUnderlyingEntries(t *testing.T, state *utils.MountState) {
+	t.Helper()
+
+	utils.MustWriteFile(t, state.RootPath("file"), 0644, "some content")
+	utils.MustMkdirAll(t, state.RootPath("dir"), 0755)
+}
+
+// writeUntilError writes data to the given file descriptor until a write fails or until more than
+// limit bytes have been written, and returns the error of the failed write, if any.
+func writeUntilError(fd int, limit int) error {
+	chunk := make([]byte, 4096)
+	for written := 0; written < limit; written += len(chunk) {
+		if _, err := unix.Write(fd, chunk); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func TestUnderlying_NoSpaceOnWrite(t *testing.T) {
+	t.Parallel()
+
+	utils.RequireRoot(t, "Requires root privileges to mount a tmpfs")
+
+	const size = 64 * 1024
+	state := utils.MountSetup(t, utils.WithTmpfsRoot(size, 0), utils.WithArgs("--mapping=rw:/:%ROOT%"))
+
+	fd, err := unix.Open(state.MountPath("file"), unix.O_WRONLY|unix.O_CREAT, 0644)
+	if err != nil {
+		t.Fatalf("Failed to create file: %v", err)
+	}
+	defer unix.Close(fd)
+
+	if err := writeUntilError(fd, 4*size); err != unix.ENOSPC {
+		t.Errorf("Got error %v when writing past the capacity of the file system; want %v", err, unix.ENOSPC)
+	}
+}
+
+func TestUnderlying_NoSpaceOnCreate(t *testing.T) {
+	t.Parallel()
+
+	utils.RequireRoot(t, "Requires root privileges to mount a tmpfs")
+
+	// The root directory consumes one inode, leaving room for only one more entry.
+	state := utils.MountSetup(t, utils.WithTmpfsRoot(64*1024, 2), utils.WithArgs("--mapping=rw:/:%ROOT%"))
+
+	// Consume the remaining inode directly on the underlying file system so that sandboxfs has
+	// no chance to see the entry before the file system is full.
+	if err := os.Mkdir(state.RootPath("filler"), 0755); err != nil {
+		t.Fatalf("Failed to consume the last inode: %v", err)
+	}
+
+	for _, op := range []underlyingOp{createOp, mkdirOp} {
+		t.Run(op.name, func(t *testing.T) {
+			if err := op.run(state); err != unix.ENOSPC {
+				t.Errorf("Got error %v; want %v", err, unix.ENOSPC)
+			}
+		})
+	}
+}
+
+func TestUnderlying_NoSpaceOnSetxattr(t *testing.T) {
+	t.Parallel()
+
+	utils.RequireRoot(t, "Requires root privileges to mount a file system image")
+	if utils.InUserNamespace() {
+		t.Skipf("Loop devices cannot be mounted within a user namespace")
+	}
+
+	state := utils.MountSetup(t, utils.WithImageRoot(8*1024*1024), utils.WithArgs("--xattrs", "--mapping=rw:/:%ROOT%"))
+	stageUnderlyingEntries(t, state)
+
+	// ext4 stores extended attributes in the inode or in a single block, so a value larger than
+	// a block does not fit.
+	value := make([]byte, 16*1024)
+	if err := unix.Setxattr(state.MountPath("file"), "user.big", value, 0); err != unix.ENOSPC {
+		t.Errorf("Got error %v; want %v", err, unix.ENOSPC)
+	}
+}
+
+func TestUnderlying_ReadOnly(t *testing.T) {
+	t.Parallel()
+
+	utils.RequireRoot(t, "Requires root privileges to mount a tmpfs")
+
+	for _, op := range []underlyingOp{openForWriteOp, createOp, mkdirOp, renameOp, setxattrOp, removeOp} {
+		op := op
+		t.Run(op.name, func(t *testing.T) {
+			t.Parallel()
+
+			state := utils.MountSetup(t, utils.WithTmpfsRoot(64*1024, 0), utils.WithArgs("--xattrs", "--mapping=rw:/:%ROOT%"))
+			stageUnderlyingEntries(t, state)
+			state.RemountRootReadOnly(t)
+
+			if err := op.run(state); err != unix.EROFS {
+				t.Errorf("Got error %v; want %v", err, unix.EROFS)
+			}
+		})
+	}
+}
+
+func TestUnderlying_IOError(t *testing.T) {
+	t.Parallel()
+
+	utils.RequireRoot(t, "Requires root privileges to mount a file system image")
+	if utils.InUserNamespace() {
+		t.Skipf("Loop devices cannot be mounted within a user namespace")
+	}
+
+	for _, op := range []underlyingOp{openForWriteOp, createOp, mkdirOp, renameOp, setxattrOp, removeOp} {
+		op := op
+		t.Run(op.name, func(t *testing.T) {
+			t.Parallel()
+
+			state := utils.MountSetup(t, utils.WithImageRoot(8*1024*1024), utils.WithArgs("--xattrs", "--mapping=rw:/:%ROOT%"))
+			stageUnderlyingEntries(t, state)
+			// Look up the entries before breaking the underlying file system so that the
+			// errors come from the operations under test and not from LOOKUP.
+			for _, name := range []string{"file", "dir"} {
+				if _, err := os.Lstat(state.MountPath(name)); err != nil {
+					t.Fatalf("Failed to stat %s: %v", name, err)
+				}
+			}
+			state.ShutdownRoot(t)
+
+			if err := op.run(state); err != unix.EIO {
+				t.Errorf("Got error %v; want %v", err, unix.EIO)
+			}
+		})
+	}
+
+	t.Run("WriteToOpenFile", func(t *testing.T) {
+		t.Parallel()
+
+		state := utils.MountSetup(t, utils.WithImageRoot(8*1024*1024), utils.WithArgs("--mapping=rw:/:%ROOT%"))
+		stageUnderlyingEntries(t, state)
+
+		fd, err := unix.Open(state.MountPath("file"), unix.O_WRONLY, 0)
+		if err != nil {
+			t.Fatalf("Failed to open file for writing: %v", err)
+		}
+		defer unix.Close(fd)
+
+		state.ShutdownRoot(t)
+
+		if err := writeUntilError(fd, 1024*1024); err != unix.EIO {
+			t.Errorf("Got error %v; want %v", err, unix.EIO)
+		}
+	})
+}
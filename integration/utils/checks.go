@@ -19,6 +19,7 @@ import (
 	"io/ioutil"
 	"os"
 	"os/exec"
+	"path/filepath"
 	"reflect"
 	"regexp"
 	"sort"
@@ -30,12 +31,33 @@ import (
 
 // MustMkdirAll wraps os.MkdirAll and immediately fails the test case on failure.
 // This is purely syntactic sugar to keep test setup short and concise.
+//
+// All directories created by this function get exactly the given permissions regardless of the
+// umask.
 func MustMkdirAll(t *testing.T, path string, perm os.FileMode) {
 	t.Helper()
 
+	// Find the directories that do not exist yet so that we only change the permissions of
+	// those we create.
+	var missing []string
+	for dir := filepath.Clean(path); ; dir = filepath.Dir(dir) {
+		if _, err := os.Lstat(dir); err == nil {
+			break
+		}
+		missing = append(missing, dir)
+		if dir == filepath.Dir(dir) {
+			break
+		}
+	}
+
 	if err := os.MkdirAll(path, perm); err != nil {
 		t.Fatalf("Failed to create directory %s: %v", path, err)
 	}
+	for i := len(missing) - 1; i >= 0; i-- {
+		if err := os.Chmod(missing[i], perm); err != nil {
+			t.Fatalf("Failed to set permissions of directory %s: %v", missing[i], err)
+		}
+	}
 }
 
 // MustSymlink wraps os.Symlink and immediately fails the test case on failure.
@@ -54,12 +76,22 @@ func MustSymlink(t *testing.T, target string, path string) {
 
 // MustWriteFile wraps ioutil.WriteFile and immediately fails the test case on failure.
 // This is purely syntactic sugar to keep test setup short and concise.
+//
+// If the file does not exist yet, it gets exactly the given permissions regardless of the umask.
 func MustWriteFile(t *testing.T, path string, perm os.FileMode, contents string) {
 	t.Helper()
 
+	_, err := os.Lstat(path)
+	created := os.IsNotExist(err)
+
 	if err := ioutil.WriteFile(path, []byte(contents), perm); err != nil {
 		t.Fatalf("Failed to create file %s: %v", path, err)
 	}
+	if created {
+		if err := os.Chmod(path, perm); err != nil {
+			t.Fatalf("Failed to set permissions of file %s: %v", path, err)
+		}
+	}
 }
 
 // RequireRoot checks if the test is running as root and skips the test with the given reason
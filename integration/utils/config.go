@@ -18,6 +18,7 @@ import (
 	"fmt"
 	"path/filepath"
 	"strings"
+	"time"
 )
 
 // Config represents the configuration for the integration tests as provided in the command line.
@@ -37,6 +38,17 @@ type Config struct {
 	// by those tests that require dropping privileges.  May be nil, in which case those tests
 	// are skipped.
 	UnprivilegedUser *UnixUser
+
+	// TimeScale is the factor by which to multiply all timeouts in the tests.  See
+	// ScaledTimeout for details.
+	TimeScale float64
+
+	// TortureDuration is the time during which the torture tests keep issuing operations.
+	TortureDuration time.Duration
+
+	// UpdateGolden is true if golden files should be rewritten with the actual outputs of the
+	// tests instead of being compared against them.
+	UpdateGolden bool
 }
 
 // globalConfig contains the singleton instance of the configuration.  This must be initialized at
@@ -44,36 +56,72 @@ type Config struct {
 // by any test.
 var globalConfig *Config
 
+// Flags contains the raw values of the command-line flags that configure the tests, before they
+// are validated and turned into a Config by SetConfigFromFlags.
+type Flags struct {
+	// Features is the whitespace-separated list of features enabled during the build.
+	Features string
+
+	// ReleaseBinary is true if the binary under test was built in release mode.
+	ReleaseBinary bool
+
+	// SandboxfsBinary is the path to the binary under test, which may be relative.
+	SandboxfsBinary string
+
+	// UnprivilegedUser is the name of the user to run unprivileged tests as, or empty if none.
+	UnprivilegedUser string
+
+	// TimeScale is the factor by which to multiply all timeouts in the tests.
+	TimeScale float64
+
+	// TortureDuration is the time during which the torture tests keep issuing operations.
+	TortureDuration time.Duration
+
+	// UpdateGolden is true if golden files should be rewritten instead of compared against.
+	UpdateGolden bool
+}
+
 // SetConfigFromFlags initializes the test configuration based on the raw values provided by the
 // user on the command line.  Returns an error if any of those values is incorrect.
-func SetConfigFromFlags(rawFeatures string, releaseBinary bool, rawSandboxfsBinary string, unprivilegedUserName string) error {
+func SetConfigFromFlags(flags *Flags) error {
 	if globalConfig != nil {
 		panic("SetConfigFromFlags can only be called once")
 	}
 
 	features := make(map[string]bool)
-	for _, feature := range strings.Split(rawFeatures, " ") {
+	for _, feature := range strings.Split(flags.Features, " ") {
 		features[feature] = true
 	}
 
-	sandboxfsBinary, err := filepath.Abs(rawSandboxfsBinary)
+	sandboxfsBinary, err := filepath.Abs(flags.SandboxfsBinary)
 	if err != nil {
-		return fmt.Errorf("cannot make %s absolute: %v", rawSandboxfsBinary, err)
+		return fmt.Errorf("cannot make %s absolute: %v", flags.SandboxfsBinary, err)
+	}
+
+	if flags.TimeScale <= 0 {
+		return fmt.Errorf("invalid time scale %v: must be positive", flags.TimeScale)
+	}
+
+	if flags.TortureDuration < 0 {
+		return fmt.Errorf("invalid torture duration %v: must not be negative", flags.TortureDuration)
 	}
 
 	var unprivilegedUser *UnixUser
-	if unprivilegedUserName != "" {
-		unprivilegedUser, err = LookupUser(unprivilegedUserName)
+	if flags.UnprivilegedUser != "" {
+		unprivilegedUser, err = LookupUser(flags.UnprivilegedUser)
 		if err != nil {
-			return fmt.Errorf("invalid unprivileged user setting %s: %v", unprivilegedUserName, err)
+			return fmt.Errorf("invalid unprivileged user setting %s: %v", flags.UnprivilegedUser, err)
 		}
 	}
 
 	globalConfig = &Config{
 		Features:         features,
-		ReleaseBinary:    releaseBinary,
+		ReleaseBinary:    flags.ReleaseBinary,
 		SandboxfsBinary:  sandboxfsBinary,
 		UnprivilegedUser: unprivilegedUser,
+		TimeScale:        flags.TimeScale,
+		TortureDuration:  flags.TortureDuration,
+		UpdateGolden:     flags.UpdateGolden,
 	}
 	return nil
 }
@@ -23,19 +23,15 @@ import (
 	"os/exec"
 	"path/filepath"
 	"strings"
+	"sync/atomic"
 	"syscall"
 	"testing"
 	"time"
 
-	"golang.org/x/sys/unix"
-)
-
-const (
-	// Maximum amount of time to wait for sandboxfs to come up and start serving.
-	startupDeadlineSeconds = 10
-
-	// Maximum amount of time to wait for sandboxfs to gracefully exit after an unmount.
-	shutdownDeadlineSeconds = 5
+	"github.com/bazelbuild/sandboxfs/integration/leaks"
+	"github.com/bazelbuild/sandboxfs/integration/logs"
+	"github.com/bazelbuild/sandboxfs/integration/mounts"
+	"github.com/bazelbuild/sandboxfs/integration/protocol"
 )
 
 // runState holds runtime information for an in-progress sandboxfs execution.
@@ -54,12 +50,25 @@ func setRustEnv(cmd *exec.Cmd) {
 	cmd.Env = append(cmd.Env, "RUST_LOG=info")
 }
 
+// sandboxfsCommand creates a command to run sandboxfs with the given arguments.
+//
+// sandboxfs runs with a zero umask so that the entries it creates in the underlying file system
+// get the permissions requested through the mount point, which the kernel has already masked
+// with the umask of the caller.  The umask is process-wide, so it cannot be changed by the tests,
+// which run in parallel.  Instead, sandboxfs is started via a shell that sets it and then replaces
+// itself with sandboxfs, which keeps the process identifier of the command valid for signals.
+func sandboxfsCommand(arg ...string) *exec.Cmd {
+	bin := GetConfig().SandboxfsBinary
+	shArgs := append([]string{"-c", `umask 0 && exec "$0" "$@"`, bin}, arg...)
+	return exec.Command("/bin/sh", shArgs...)
+}
+
 // run starts a background process to run sandboxfs and passes it the given arguments.
 func run(arg ...string) (*runState, error) {
 	bin := GetConfig().SandboxfsBinary
 
 	var state runState
-	state.cmd = exec.Command(bin, arg...)
+	state.cmd = sandboxfsCommand(arg...)
 	state.cmd.Stdout = &state.out
 	state.cmd.Stderr = &state.err
 	setRustEnv(state.cmd)
@@ -91,9 +100,7 @@ func wait(state *runState, wantExitStatus int) (string, string, error) {
 
 // RunAndWait invokes sandboxfs with the given arguments and waits for termination.
 //
-// TODO(jmmv): We should extend this function to also take what the expectations are on stdout and
-// stderr to remove a lot of boilerplate from the tests... but we should probably wait until Go's
-// 1.9 t.Helper() feature is available so that we can actually report failures/errors from here.
+// Tests that only need to check the outputs of sandboxfs should prefer RunAndExpect.
 func RunAndWait(wantExitStatus int, arg ...string) (string, string, error) {
 	state, err := run(arg...)
 	if err != nil {
@@ -102,27 +109,6 @@ func RunAndWait(wantExitStatus int, arg ...string) (string, string, error) {
 	return wait(state, wantExitStatus)
 }
 
-// retry runs the given action until either it succeeds or the given deadline expires.  If the
-// deadline expires, returns the last encountered error from the action.  Prints the given message
-// when an error is encountered.
-func retry(action func() error, message string, deadlineSeconds int) error {
-	var lastErr error
-	for tries := 0; tries < deadlineSeconds*10; tries++ {
-		lastErr = action()
-		if lastErr == nil {
-			return nil
-		}
-		if tries > 10 {
-			// Avoid cluttering the logs for the first few retries.  It's normal for
-			// the first attempt to not succeed, which results in warning messages
-			// printed for every test when there is nothing really wrong.
-			fmt.Fprintf(os.Stderr, "In retry attempt %d: %s: %v\n", tries, message, lastErr)
-		}
-		time.Sleep(100 * time.Millisecond)
-	}
-	return lastErr
-}
-
 // startBackground spawns sandboxfs with the given arguments and waits for the file system to be
 // ready for serving.  The cookie parameter specifies the relative path of a file within the mount
 // point that must exist in order to consider the file system to be up and running; the cookie may
@@ -135,8 +121,11 @@ func retry(action func() error, message string, deadlineSeconds int) error {
 // The credentials of the sandboxfs process are set to user if not nil.  Note that the caller must
 // be root if the given user is not nil.
 //
+// env contains extra environment variables for the sandboxfs process, which take precedence over
+// the defaults, and deadline is the maximum amount of time to wait for the cookie to appear.
+//
 // Returns a handle on the spawned sandboxfs process and a pipe to send data to its stdin.
-func startBackground(cookie string, stdout io.Writer, stderr io.Writer, user *UnixUser, args ...string) (*exec.Cmd, io.WriteCloser, error) {
+func startBackground(cookie string, stdout io.Writer, stderr io.Writer, user *UnixUser, env []string, deadline time.Duration, args ...string) (*exec.Cmd, io.WriteCloser, error) {
 	bin := GetConfig().SandboxfsBinary
 
 	// The sandboxfs command line syntax requires the mount point to appear at the end and we
@@ -144,7 +133,7 @@ func startBackground(cookie string, stdout io.Writer, stderr io.Writer, user *Un
 	// well, we have a bug and the test will crash/fail.
 	mountPoint := args[len(args)-1]
 
-	cmd := exec.Command(bin, args...)
+	cmd := sandboxfsCommand(args...)
 	stdin, err := cmd.StdinPipe()
 	if err != nil {
 		return nil, nil, fmt.Errorf("failed to create stdin pipe: %v", err)
@@ -152,7 +141,11 @@ func startBackground(cookie string, stdout io.Writer, stderr io.Writer, user *Un
 	cmd.Stdout = stdout
 	cmd.Stderr = stderr
 	SetCredential(cmd, user)
+	if user != nil && user.UID != os.Getuid() && InUserNamespace() {
+		grantMountCapability(cmd)
+	}
 	setRustEnv(cmd)
+	cmd.Env = append(cmd.Env, env...)
 	if err := cmd.Start(); err != nil {
 		return nil, nil, fmt.Errorf("failed to start %s with arguments %v: %v", bin, args, err)
 	}
@@ -160,7 +153,7 @@ func startBackground(cookie string, stdout io.Writer, stderr io.Writer, user *Un
 	if cookie != "" {
 		cookiePath := filepath.Join(mountPoint, cookie)
 		waitForCookie := func() error { return FileExistsAsUser(cookiePath, user) }
-		if err := retry(waitForCookie, "waiting for cookie to appear in mount point", startupDeadlineSeconds); err != nil {
+		if err := retry(waitForCookie, "waiting for cookie to appear in mount point", deadline); err != nil {
 			// Give up.  sandboxfs did't come up, so kill the process and clean up.
 			// There is not much we can do here if we encounter errors (e.g. we don't
 			// even know if the mount point was initialized, so the unmount call may or
@@ -168,8 +161,8 @@ func startBackground(cookie string, stdout io.Writer, stderr io.Writer, user *Un
 			stdin.Close()
 			cmd.Process.Kill()
 			cmd.Wait()
-			Unmount(mountPoint)
-			return nil, nil, fmt.Errorf("file system failed to come up: %s not found", cookiePath)
+			mounts.Unmount(mountPoint)
+			return nil, nil, fmt.Errorf("file system failed to come up: %v", err)
 		}
 	}
 
@@ -188,12 +181,24 @@ type MountState struct {
 	// with the sandboxfs process via stdin, so it's fine to just ignore this.
 	Stdin io.WriteCloser
 
+	// Client sends reconfiguration requests to sandboxfs via Stdin and reads their responses
+	// from its stdout.  Only set if the test was set up with WithReconfigClient.
+	Client *protocol.Client
+
 	// stdout contains the output of sandboxfs if the caller didn't capture it.
 	stdout *bytes.Buffer
 
 	// stderr contains the error output of sandboxfs if the caller didn't capture it.
 	stderr *bytes.Buffer
 
+	// logs parses the error output of sandboxfs into log records.
+	logs *logs.Capture
+
+	// clientReader and clientWriter are the ends of the pipe that connects the stdout of
+	// sandboxfs to Client, if any.
+	clientReader *io.PipeReader
+	clientWriter *io.PipeWriter
+
 	// tempDir points to the base directory where the test places any files it creates.
 	tempDir string
 
@@ -204,8 +209,26 @@ type MountState struct {
 	// mountPoint points to the directory where the sandboxfs instance is mounted.
 	mountPoint string
 
-	// oldMask keeps track of the process umask to restore when the test completes.
-	oldMask int
+	// shutdownDeadline is the maximum amount of time to wait for sandboxfs to exit on teardown.
+	shutdownDeadline time.Duration
+
+	// rootFS describes the file system mounted on root, or nil if root is a plain directory.
+	rootFS *rootFilesystem
+
+	// connection is the identifier of the FUSE connection that backs the mount point, or -1 if
+	// unknown.
+	connection int
+
+	// baseline contains the resources held by sandboxfs right after startup.  Nil if leak
+	// detection is not available, in which case baselineErr explains why.
+	baseline    *leaks.Snapshot
+	baselineErr error
+
+	// snapshots contains the history of resource snapshots taken during the test.
+	snapshots []*leaks.Snapshot
+
+	// tornDown is true once TearDown has run, which makes further calls do nothing.
+	tornDown bool
 }
 
 // MountPath joins all the given path components and constructs an absolute path within the test's
@@ -227,6 +250,25 @@ func (s *MountState) TempPath(arg ...string) string {
 	return filepath.Join(s.tempDir, filepath.Join(arg...))
 }
 
+// Logs returns the log records that sandboxfs has emitted so far.
+func (s *MountState) Logs() []logs.Record {
+	return s.logs.Records()
+}
+
+// ExpectLog registers that sandboxfs must emit a log record with the given level and a message
+// matching the given regexp before it exits.  The check happens during TearDown.
+func (s *MountState) ExpectLog(level logs.Level, pattern string) {
+	s.logs.Expect(level, pattern)
+}
+
+// ForbidLog registers that sandboxfs must not emit log records with the given level, or with a more
+// severe one, other than those registered via ExpectLog.  The check happens during TearDown.
+//
+// For example, ForbidLog(logs.Warn) makes the test fail on any unexpected WARN or ERROR record.
+func (s *MountState) ForbidLog(level logs.Level) {
+	s.logs.Forbid(level)
+}
+
 // createDirsRequiredByMappings inspects the flags that configure sandboxfs to extract the paths to
 // the targetes of the mappings, and creates those paths.
 func createDirsRequiredByMappings(root string, args ...string) error {
@@ -262,90 +304,174 @@ func hasRootMapping(args ...string) bool {
 	return false
 }
 
-// MountSetup initializes a test that runs sandboxfs in the background with default settings.
-//
-// This is essentially the same as mountSetupFull with stdout and stderr set to the caller's outputs
-// and with rootSetup and the user set to nil.  See the documentation for this other function for
-// further details.
-func MountSetup(t *testing.T, args ...string) *MountState {
-	t.Helper()
+// mountConfig holds the settings that tests can customize via MountOptions.
+type mountConfig struct {
+	// args contains the arguments to pass to sandboxfs, excluding the mount point.
+	args []string
+
+	// stdout and stderr are the destinations of the sandboxfs outputs.
+	stdout io.Writer
+	stderr io.Writer
+
+	// stdoutSet is true if the test chose the destination of stdout.
+	stdoutSet bool
+
+	// user holds the credentials with which to run sandboxfs, or nil to use the caller's.
+	user *UnixUser
 
-	return mountSetupFull(t, os.Stdout, os.Stderr, nil, nil, args...)
+	// rootSetup is a hook to prepare the root directory before sandboxfs is started.
+	rootSetup func(string) error
+
+	// env contains extra environment variables for sandboxfs.
+	env []string
+
+	// startupDeadline is the maximum amount of time to wait for sandboxfs to start serving,
+	// before scaling.
+	startupDeadline time.Duration
+
+	// shutdownDeadline is the maximum amount of time to wait for sandboxfs to exit on
+	// teardown, before scaling.
+	shutdownDeadline time.Duration
+
+	// reconfigClient is true if the test wants a client to send reconfiguration requests.
+	reconfigClient bool
+
+	// rootFS describes the file system to mount on the root directory, or nil to use the
+	// temporary directory as is.
+	rootFS *rootFilesystem
 }
 
-// MountSetupWithRootSetup initializes a test that runs sandboxfs in the background and provides
-// a mechanism to configure the root directory before sandboxfs is started.
+// MountOption customizes how MountSetup starts sandboxfs.
+type MountOption func(*mountConfig)
+
+// WithArgs appends the given arguments to the sandboxfs command line.
 //
-// This is essentially the same as mountSetupFull with stdout and stderr set to the caller's
-// outputs and with the user set to nil.  See the documentation for this other function for
-// further details.
-func MountSetupWithRootSetup(t *testing.T, rootSetup func(string) error, args ...string) *MountState {
-	t.Helper()
+// The arguments must *not* include the mount point: the mount point is derived from a temporary
+// directory created by MountSetup and is available via MountState.MountPath.  Similarly, the
+// arguments can use %ROOT% to reference the temporary directory in which tests can place files to
+// be exposed in the sandbox, available via MountState.RootPath.
+func WithArgs(args ...string) MountOption {
+	return func(c *mountConfig) {
+		c.args = append(c.args, args...)
+	}
+}
 
-	return mountSetupFull(t, os.Stdout, os.Stderr, nil, rootSetup, args...)
+// WithStdout redirects the stdout of sandboxfs to w, which can be nil to discard it.  By default,
+// the output is captured and dumped at the end of the test on failure.
+func WithStdout(w io.Writer) MountOption {
+	return func(c *mountConfig) {
+		c.stdout = w
+		c.stdoutSet = true
+	}
 }
 
-// MountSetupWithOutputs initializes a test that runs sandboxfs in the background with output
-// redirections.
-//
-// This is essentially the same as mountSetupFull with stdout and stderr set to the caller's
-// provided values and with rootSetup and the user set to nil.  See the documentation for this other
-// function for further details.
-func MountSetupWithOutputs(t *testing.T, stdout io.Writer, stderr io.Writer, args ...string) *MountState {
-	t.Helper()
+// WithStderr redirects the stderr of sandboxfs to w, which can be nil to discard it.  By default,
+// the output is captured and dumped at the end of the test on failure.
+func WithStderr(w io.Writer) MountOption {
+	return func(c *mountConfig) {
+		c.stderr = w
+	}
+}
 
-	return mountSetupFull(t, stdout, stderr, nil, nil, args...)
+// WithUser runs sandboxfs with the credentials of the given user.  The caller must be root.
+func WithUser(user *UnixUser) MountOption {
+	return func(c *mountConfig) {
+		c.user = user
+	}
 }
 
-// MountSetupWithUser initializes a test that runs sandboxfs in the background with different
-// credentials.
-//
-// This is essentially the same as mountSetupFull with stdout and stderr set to the caller's
-// outputs, with rootSetup set to nil, and with the user set to the given value.  See the
-// documentation for this other function for further details.
-func MountSetupWithUser(t *testing.T, user *UnixUser, args ...string) *MountState {
-	t.Helper()
+// WithRootSetup registers a hook that runs once the temporary root directory is created but before
+// sandboxfs is mounted.  This allows tests to stage files that mappings can later refer to via a
+// %ROOT%-prefixed path.
+func WithRootSetup(rootSetup func(string) error) MountOption {
+	return func(c *mountConfig) {
+		c.rootSetup = rootSetup
+	}
+}
+
+// WithEnv adds environment variables, in the NAME=value form, to the sandboxfs process.  These
+// take precedence over the defaults, such as RUST_LOG.
+func WithEnv(env ...string) MountOption {
+	return func(c *mountConfig) {
+		c.env = append(c.env, env...)
+	}
+}
 
-	return mountSetupFull(t, os.Stdout, os.Stderr, user, nil, args...)
+// WithLogFilter configures which log records sandboxfs emits.  The filter uses the syntax of the
+// RUST_LOG variable as understood by env_logger, such as "debug" or "sandboxfs=debug,fuse=warn", and
+// defaults to "info".
+func WithLogFilter(filter string) MountOption {
+	return func(c *mountConfig) {
+		c.env = append(c.env, "RUST_LOG="+filter)
+	}
 }
 
-// mountSetupFull initializes a test that runs sandboxfs in the background.
-//
-// args contains the list of arguments to pass to the sandboxfs *without* the mount point: the
-// mount point is derived from a temporary directory created here and returned in the mountPoint
-// field of the MountState structure.  Similarly, the arguments can use %ROOT% to reference the
-// temporary directory created here in which they can place files to be exposed in the sandbox.
-//
-// The stdout and stderr of the sandboxfs process are redirected to the objects given to the
-// function.  If these objects are os.Stdout or os.Stderr, respectively, the corresponding output
-// is captured and dumped at the end of the test on error.
-//
-// The sandboxfs process is started with the credentials of the calling user, unless the user field
-// is not nil, in which case those credentials are used.
+// WithStartupDeadline sets the maximum amount of time to wait for sandboxfs to start serving.  The
+// deadline is scaled by --time_scale, so it must be suitable for a fast and idle machine.
+func WithStartupDeadline(deadline time.Duration) MountOption {
+	return func(c *mountConfig) {
+		c.startupDeadline = deadline
+	}
+}
+
+// WithShutdownDeadline sets the maximum amount of time to wait for sandboxfs to unmount and exit
+// on teardown.  The deadline is scaled by --time_scale, so it must be suitable for a fast and idle
+// machine.
+func WithShutdownDeadline(deadline time.Duration) MountOption {
+	return func(c *mountConfig) {
+		c.shutdownDeadline = deadline
+	}
+}
+
+// WithDeadline sets both the startup and the shutdown deadlines to the same value.  This is a
+// shorthand for WithStartupDeadline and WithShutdownDeadline.
+func WithDeadline(deadline time.Duration) MountOption {
+	return func(c *mountConfig) {
+		c.startupDeadline = deadline
+		c.shutdownDeadline = deadline
+	}
+}
+
+// WithReconfigClient connects the stdin and stdout of sandboxfs to a reconfiguration client, which
+// is available via MountState.Client.  This cannot be combined with WithStdout.
+func WithReconfigClient() MountOption {
+	return func(c *mountConfig) {
+		c.reconfigClient = true
+	}
+}
+
+// MountSetup initializes a test that runs sandboxfs in the background, configured by the given
+// options.
 //
-// rootSetup is an optional hook that runs once the temporary root directory is created but before
-// sandboxfs is mounted.  This allows tests to stage files that mappings can later refer to via
-// a %ROOT%-prefixed path.
+// By default, sandboxfs runs with no arguments other than the mount point, with the credentials of
+// the calling user, and with its stdout and stderr captured and dumped at the end of the test on
+// failure.
+// Regardless of where stderr goes, it is also parsed into log records that tests can inspect with
+// MountState.Logs, and verify with MountState.ExpectLog and MountState.ForbidLog.
 //
 // This helper function receives a testing.T object because test setup for sandboxfs is complex and
 // we want to keep the test cases themselves as concise as possible.  Any failures within this
 // function are fatal.
 //
-// Callers must defer execution of MountState.TearDown() immediately on return to ensure the
-// background process and the mount point are cleaned up on test completion.
-func mountSetupFull(t *testing.T, stdout io.Writer, stderr io.Writer, user *UnixUser, rootSetup func(string) error, args ...string) *MountState {
+// The background process and the mount point are cleaned up automatically on test completion by
+// MountState.TearDown, which tests can also call explicitly to check for cleanup errors.
+func MountSetup(t *testing.T, opts ...MountOption) *MountState {
 	t.Helper()
 
-	success := false
+	c := mountConfig{
+		stdout:           os.Stdout,
+		stderr:           os.Stderr,
+		startupDeadline:  defaultStartupDeadline,
+		shutdownDeadline: defaultShutdownDeadline,
+	}
+	for _, opt := range opts {
+		opt(&c)
+	}
+	if c.reconfigClient && c.stdoutSet {
+		t.Fatalf("WithReconfigClient and WithStdout are mutually exclusive")
+	}
 
-	// Reset the test's umask to zero.  This allows tests to not care about how the umask
-	// affects files, which can introduce subtle bugs in the tests themselves.
-	oldMask := unix.Umask(0)
-	defer func() {
-		if !success {
-			unix.Umask(oldMask)
-		}
-	}()
+	success := false
 
 	tempDir, err := ioutil.TempDir("", "test")
 	if err != nil {
@@ -362,7 +488,18 @@ func mountSetupFull(t *testing.T, stdout io.Writer, stderr io.Writer, user *Unix
 	MustMkdirAll(t, root, 0755)
 	MustMkdirAll(t, mountPoint, 0755)
 
-	if user != nil {
+	if c.rootFS != nil {
+		if err := mountRootFilesystem(c.rootFS, tempDir, root); err != nil {
+			t.Fatalf("Failed to set up file system for %s: %v", root, err)
+		}
+		defer func() {
+			if !success {
+				unmountRootFilesystem(root)
+			}
+		}()
+	}
+
+	if c.user != nil {
 		// Ensure all users can navigate through the temporary directory, which are often created with
 		// strict permissions.
 		if err := os.Chmod(tempDir, 0755); err != nil {
@@ -370,13 +507,13 @@ func mountSetupFull(t *testing.T, stdout io.Writer, stderr io.Writer, user *Unix
 		}
 
 		// The mount point must be owned by the user that will mount the FUSE file system.
-		if err := os.Chown(mountPoint, user.UID, user.GID); err != nil {
+		if err := os.Chown(mountPoint, c.user.UID, c.user.GID); err != nil {
 			t.Fatalf("Failed to change ownership of %s", mountPoint)
 		}
 	}
 
-	realArgs := make([]string, 0, len(args)+1)
-	for _, arg := range args {
+	realArgs := make([]string, 0, len(c.args)+1)
+	for _, arg := range c.args {
 		realArgs = append(realArgs, strings.Replace(arg, "%ROOT%", root, -1))
 	}
 	realArgs = append(realArgs, mountPoint)
@@ -384,23 +521,37 @@ func mountSetupFull(t *testing.T, stdout io.Writer, stderr io.Writer, user *Unix
 	if err := createDirsRequiredByMappings(root, realArgs...); err != nil {
 		t.Fatalf("Failed to create directories required by mappings: %v", err)
 	}
-	if rootSetup != nil {
-		if err := rootSetup(root); err != nil {
+	if c.rootSetup != nil {
+		if err := c.rootSetup(root); err != nil {
 			t.Fatalf("Failed to run custom rootSetup hook on %s: %v", root, err)
 		}
 	}
 
+	stdout := c.stdout
 	var storedStdout *bytes.Buffer
-	if stdout == os.Stdout {
+	var clientReader *io.PipeReader
+	var clientWriter *io.PipeWriter
+	if c.reconfigClient {
+		clientReader, clientWriter = io.Pipe()
+		stdout = clientWriter
+		defer func() {
+			if !success {
+				clientWriter.Close()
+				clientReader.Close()
+			}
+		}()
+	} else if stdout == os.Stdout {
 		storedStdout = new(bytes.Buffer)
 		stdout = storedStdout
 	}
 
+	stderr := c.stderr
 	var storedStderr *bytes.Buffer
 	if stderr == os.Stderr {
 		storedStderr = new(bytes.Buffer)
 		stderr = storedStderr
 	}
+	capture := logs.NewCapture(stderr)
 
 	var cmd *exec.Cmd
 	var stdin io.WriteCloser
@@ -415,10 +566,13 @@ func mountSetupFull(t *testing.T, stdout io.Writer, stderr io.Writer, user *Unix
 		// when opening the input FIFO until there is a writer for it, which is not yet the
 		// case for our tests.  And, with the work I'm planning to do on reconfigurations, I
 		// may drop the possibility of changing the root mapping.
-		cmd, stdin, err = startBackground("", stdout, stderr, user, realArgs...)
+		cmd, stdin, err = startBackground("", stdout, capture, c.user, c.env, ScaledTimeout(c.startupDeadline), realArgs...)
+		if err != nil {
+			t.Fatalf("Failed to start sandboxfs: %v", err)
+		}
 	} else {
 		MustWriteFile(t, filepath.Join(root, ".cookie"), 0444, "")
-		cmd, stdin, err = startBackground(".cookie", stdout, stderr, user, realArgs...)
+		cmd, stdin, err = startBackground(".cookie", stdout, capture, c.user, c.env, ScaledTimeout(c.startupDeadline), realArgs...)
 		if err := os.Remove(filepath.Join(root, ".cookie")); err != nil {
 			t.Errorf("Failed to delete the startup cookie file: %v", err)
 			// Continue text execution.  Failing hard here is a difficult condition to
@@ -436,35 +590,56 @@ func mountSetupFull(t *testing.T, stdout io.Writer, stderr io.Writer, user *Unix
 	// cleanup routines from running, so any code below this line must not be able to fail.
 	success = true
 	state := &MountState{
-		Cmd:        cmd,
-		Stdin:      stdin,
-		stdout:     storedStdout,
-		stderr:     storedStderr,
-		tempDir:    tempDir,
-		root:       root,
-		mountPoint: mountPoint,
-		oldMask:    oldMask,
+		Cmd:          cmd,
+		Stdin:        stdin,
+		stdout:       storedStdout,
+		stderr:       storedStderr,
+		logs:         capture,
+		clientReader: clientReader,
+		clientWriter: clientWriter,
+		tempDir:      tempDir,
+		root:         root,
+		mountPoint:   mountPoint,
+
+		shutdownDeadline: ScaledTimeout(c.shutdownDeadline),
+		rootFS:           c.rootFS,
+	}
+	if c.reconfigClient {
+		state.Client = protocol.NewClient(stdin, clientReader)
+	}
+	if hasRootMapping(realArgs...) {
+		state.takeBaseline()
+	} else {
+		state.baselineErr = fmt.Errorf("cannot tell when sandboxfs is ready without a root mapping")
 	}
+	t.Cleanup(func() { state.TearDown(t) })
 	return state
 }
 
 // TearDown unmounts the sandboxfs instance and cleans up any test files.
 //
-// Similarly to MountSetup, TearDown takes a testing.T object.  The reason here is slightly
-// different though: because TearDown is scheduled to run with "defer", we require a mechanism to
-// report test failures if any cleanup action fails, so getting access to the testing.T object as an
-// argument is the simplest way of doing so.
+// MountSetup schedules TearDown to run when the test completes, so tests only need to call it
+// explicitly when they want to stop sandboxfs earlier or to check for errors.  Calls after the
+// first one do nothing.
+//
+// Similarly to MountSetup, TearDown takes a testing.T object so that it can report test failures if
+// any cleanup action fails.
 //
 // If tests wish to control the shutdown of the sandboxfs process, they can do so, but then they
 // must set s.Cmd to nil to tell TearDown to not clean up the process a second time.  The same
 // applies to s.Stdin.
 //
-// If tests wish to check if TearDown returned an error, they can do so by avoiding the recommended
-// use of "defer".  Note, though, that such tests will only receive the first error encountered by
-// this function, and that the function will run to completion even if there were failures.
+// If tests wish to check if TearDown returned an error, they can do so by calling it explicitly.
+// Note, though, that such tests will only receive the first error encountered by this function,
+// and that the function will run to completion even if there were failures.
 func (s *MountState) TearDown(t *testing.T) error {
 	t.Helper()
 
+	if s.tornDown {
+		return nil
+	}
+	s.tornDown = true
+
 	var firstErr error
 	setFirstErr := func(err error) {
 		if firstErr == nil {
@@ -472,8 +647,6 @@ func (s *MountState) TearDown(t *testing.T) error {
 		}
 	}
 
-	unix.Umask(s.oldMask)
-
 	if s.Stdin != nil {
 		if err := s.Stdin.Close(); err != nil {
 			t.Errorf("Failed to close sandboxfs's stdin pipe: %v", err)
@@ -484,6 +657,13 @@ func (s *MountState) TearDown(t *testing.T) error {
 	}
 
 	if s.Cmd != nil {
+		// Record the final state of sandboxfs in the history of snapshots dumped on failure.
+		if s.baseline != nil {
+			if snapshot, err := s.takeSnapshot("teardown"); err == nil {
+				s.snapshots = append(s.snapshots, snapshot)
+			}
+		}
+
 		// Calling Unmount on the mount point causes the running sandboxfs process to
 		// stop serving and to exit cleanly.  Note that Unmount is not an unmount(2)
 		// system call: this can be run as an unprivileged user, so we needn't check for
@@ -497,17 +677,22 @@ func (s *MountState) TearDown(t *testing.T) error {
 		// example, on macOS, the Finder may decide to obtain information about the mount
 		// point and, if it does that while we try to unmount it, we get an unexpected
 		// error.
-		unmount := func() error { return Unmount(s.mountPoint) }
-		if err := retry(unmount, "waiting for file system to be unmounted", shutdownDeadlineSeconds); err != nil {
+		unmount := func() error { return mounts.Unmount(s.mountPoint) }
+		if err := retry(unmount, "waiting for file system to be unmounted", s.shutdownDeadline); err != nil {
 			t.Errorf("Failed to unmount sandboxfs instance during teardown: %v", err)
 			setFirstErr(err)
 		}
 
-		timer := time.AfterFunc(shutdownDeadlineSeconds*time.Second, func() {
+		var killed int32
+		timer := time.AfterFunc(s.shutdownDeadline, func() {
+			atomic.StoreInt32(&killed, 1)
 			s.Cmd.Process.Kill()
 		})
 		err := s.Cmd.Wait()
 		timer.Stop()
+		if atomic.LoadInt32(&killed) != 0 {
+			err = fmt.Errorf("killed after waiting %v for it to exit: %v", s.shutdownDeadline, err)
+		}
 		if err != nil {
 			t.Errorf("sandboxfs did not exit successfully during teardown: %v", err)
 			setFirstErr(err)
@@ -516,6 +701,20 @@ func (s *MountState) TearDown(t *testing.T) error {
 		s.Cmd = nil
 	}
 
+	if s.clientWriter != nil {
+		// Now that sandboxfs is gone, let the client know that no more responses will come.
+		s.clientWriter.Close()
+		s.clientReader.Close()
+		s.clientWriter = nil
+	}
+
+	// The process is gone by now, so all of its logs are available.
+	s.logs.Flush()
+	for _, err := range s.logs.Verify() {
+		t.Errorf("Unexpected sandboxfs logs: %v", err)
+		setFirstErr(err)
+	}
+
 	if t.Failed() {
 		if s.stdout != nil {
 			fmt.Fprintf(os.Stderr, "sandboxfs stdout was:\n%s", s.stdout.String())
@@ -523,6 +722,14 @@ func (s *MountState) TearDown(t *testing.T) error {
 		if s.stderr != nil {
 			fmt.Fprintf(os.Stderr, "sandboxfs stderr was:\n%s", s.stderr.String())
 		}
+		s.dumpSnapshots()
+	}
+
+	if s.rootFS != nil {
+		if err := unmountRootFilesystem(s.root); err != nil {
+			t.Errorf("Failed to unmount the file system of the root directory during teardown: %v", err)
+			setFirstErr(err)
+		}
 	}
 
 	if err := os.RemoveAll(s.tempDir); err != nil {
@@ -0,0 +1,167 @@
+// Copyright 2020 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not
+// use this file except in compliance with the License.  You may obtain a copy
+// of the License at:
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.  See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package utils
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// Matcher checks the contents of an output stream of sandboxfs.
+type Matcher interface {
+	// match returns an error if got, which holds the contents of the stream named stream, does
+	// not satisfy the matcher.
+	match(t *testing.T, stream string, got string) error
+}
+
+// exactMatcher is a matcher that requires the output to be equal to a string.
+type exactMatcher struct {
+	want string
+}
+
+// Exactly returns a matcher that requires the output to be equal to want.
+func Exactly(want string) Matcher {
+	return &exactMatcher{want: want}
+}
+
+// match implements Matcher.
+func (m *exactMatcher) match(t *testing.T, stream string, got string) error {
+	if got != m.want {
+		return fmt.Errorf("got %s %q; want %q", stream, got, m.want)
+	}
+	return nil
+}
+
+// regexpMatcher is a matcher that requires the output to match a regular expression.
+type regexpMatcher struct {
+	pattern string
+}
+
+// Regexp returns a matcher that requires the output to match the given regular expression, which
+// must be valid.  As with MatchesRegexp, the match is not anchored.
+func Regexp(pattern string) Matcher {
+	return &regexpMatcher{pattern: pattern}
+}
+
+// match implements Matcher.
+func (m *regexpMatcher) match(t *testing.T, stream string, got string) error {
+	if !MatchesRegexp(m.pattern, got) {
+		return fmt.Errorf("got %s %q; want it to match %s", stream, got, m.pattern)
+	}
+	return nil
+}
+
+// goldenMatcher is a matcher that requires the output to be equal to the contents of a file.
+type goldenMatcher struct {
+	path     string
+	replacer *strings.Replacer
+}
+
+// Golden returns a matcher that requires the output to be equal to the contents of the golden file
+// at path, which is relative to the testdata directory of the calling test package.
+//
+// The substitutions are pairs of old and new strings that are replaced in the output before it is
+// compared, which allows golden files to be independent of the machine the tests run on.  For
+// example, passing fmt.Sprint(runtime.NumCPU()) and "NCPUS" makes the golden file refer to the
+// number of CPUs as NCPUS.
+//
+// When the tests run with --update, the matcher rewrites the golden file with the output instead
+// of comparing them.
+func Golden(path string, substitutions ...string) Matcher {
+	if len(substitutions)%2 != 0 {
+		// This function is intended to be used exclusively from tests, and as such we know
+		// that the given substitutions must come in pairs.  If they don't, we've got a bug
+		// in the code that must be fixed: there is no point in returning this as an error.
+		panic(fmt.Sprintf("substitutions for golden file %s must come in pairs", path))
+	}
+	return &goldenMatcher{
+		path:     filepath.Join("testdata", path),
+		replacer: strings.NewReplacer(substitutions...),
+	}
+}
+
+// match implements Matcher.
+func (m *goldenMatcher) match(t *testing.T, stream string, got string) error {
+	got = m.replacer.Replace(got)
+
+	if GetConfig().UpdateGolden {
+		if err := os.MkdirAll(filepath.Dir(m.path), 0755); err != nil {
+			return fmt.Errorf("failed to create directory for golden file %s: %v", m.path, err)
+		}
+		if err := ioutil.WriteFile(m.path, []byte(got), 0644); err != nil {
+			return fmt.Errorf("failed to update golden file %s: %v", m.path, err)
+		}
+		t.Logf("Updated golden file %s", m.path)
+		return nil
+	}
+
+	want, err := ioutil.ReadFile(m.path)
+	if err != nil {
+		return fmt.Errorf("failed to read golden file %s: %v; rerun with --update to create it", m.path, err)
+	}
+	if got != string(want) {
+		return fmt.Errorf("got %s %q; want contents of %s %q; rerun with --update if the change is expected", stream, got, m.path, want)
+	}
+	return nil
+}
+
+// Expect represents the expected results of running sandboxfs to completion.
+type Expect struct {
+	// Exit is the wanted exit status.
+	Exit int
+
+	// Stdout checks the contents of stdout.  If nil, stdout must be empty.
+	Stdout Matcher
+
+	// Stderr checks the contents of stderr.  If nil, stderr must be empty.
+	Stderr Matcher
+}
+
+// RunAndExpect invokes sandboxfs with the given arguments, waits for termination and checks that
+// the exit status and the contents of stdout and stderr satisfy the expectations.  Mismatches are
+// reported as test errors, and failures to run sandboxfs are fatal.
+//
+// Returns the textual contents of stdout and stderr in case the test wants to inspect them further.
+func RunAndExpect(t *testing.T, expect Expect, arg ...string) (string, string) {
+	t.Helper()
+
+	stdout, stderr, err := RunAndWait(expect.Exit, arg...)
+	if err != nil {
+		t.Fatalf("%v; stdout was %q and stderr was %q", err, stdout, stderr)
+	}
+
+	streams := []struct {
+		name    string
+		got     string
+		matcher Matcher
+	}{
+		{"stdout", stdout, expect.Stdout},
+		{"stderr", stderr, expect.Stderr},
+	}
+	for _, s := range streams {
+		matcher := s.matcher
+		if matcher == nil {
+			matcher = Exactly("")
+		}
+		if err := matcher.match(t, s.name, s.got); err != nil {
+			t.Error(err)
+		}
+	}
+	return stdout, stderr
+}
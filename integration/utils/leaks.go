@@ -0,0 +1,140 @@
+// Copyright 2020 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not
+// use this file except in compliance with the License.  You may obtain a copy
+// of the License at:
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.  See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package utils
+
+import (
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/bazelbuild/sandboxfs/integration/leaks"
+	"github.com/bazelbuild/sandboxfs/integration/mounts"
+)
+
+const (
+	// Location of the proc file system.
+	procRoot = "/proc"
+
+	// Location of the fusectl file system, which exposes the state of the FUSE connections.
+	fusectlRoot = "/sys/fs/fuse/connections"
+
+	// Maximum amount of time to wait for sandboxfs to release the resources of a test after the
+	// test has closed its files.  The kernel forwards some of the close operations to sandboxfs
+	// asynchronously, so resources are not released immediately.
+	quiescenceDeadline = 2 * time.Second
+)
+
+// fuseConnection returns the identifier of the FUSE connection that backs the mount point, or -1 if
+// it cannot be determined.
+func (s *MountState) fuseConnection() int {
+	found, err := mounts.ListMounts(procRoot, func(m *mounts.Mount) bool {
+		return m.MountPoint == s.mountPoint
+	})
+	if err != nil || len(found) != 1 {
+		return -1
+	}
+	return found[0].ConnectionID()
+}
+
+// takeSnapshot captures the resources held by the sandboxfs process.
+func (s *MountState) takeSnapshot(label string) (*leaks.Snapshot, error) {
+	if s.Cmd == nil {
+		return nil, fmt.Errorf("sandboxfs is not running")
+	}
+	return leaks.Take(label, procRoot, fusectlRoot, s.Cmd.Process.Pid, s.connection)
+}
+
+// takeBaseline captures the resources held by the sandboxfs process right after startup, which
+// serve as the reference for leak detection.  Leak detection is disabled if this fails, such as
+// on platforms other than Linux or when sandboxfs runs as a different user.
+func (s *MountState) takeBaseline() {
+	s.connection = s.fuseConnection()
+	s.baseline, s.baselineErr = s.takeSnapshot("setup")
+	if s.baselineErr == nil {
+		s.snapshots = append(s.snapshots, s.baseline)
+	}
+}
+
+// Snapshot captures the resources held by the sandboxfs process at a point of the test identified
+// by label.  The snapshot is also recorded in the history of the test, which is dumped on failure.
+// Skips the test if snapshots are not available.
+func (s *MountState) Snapshot(t *testing.T, label string) *leaks.Snapshot {
+	t.Helper()
+
+	if s.baselineErr != nil {
+		t.Skipf("Resource snapshots not available: %v", s.baselineErr)
+	}
+	snapshot, err := s.takeSnapshot(label)
+	if err != nil {
+		t.Fatalf("Failed to take snapshot %s: %v", label, err)
+	}
+	s.snapshots = append(s.snapshots, snapshot)
+	return snapshot
+}
+
+// CheckNoLeaks verifies that sandboxfs holds no more file descriptors or threads than it did when
+// it started, and that its FUSE connection has no pending requests.  This must be called at a
+// quiescent point of the test, once it has closed all of its files and destroyed its sandboxes.
+//
+// Because sandboxfs may release resources asynchronously, the check is retried for a short period
+// of time before reporting the leaks as test errors.  Does nothing if leak detection is not
+// available, in which case the reason is logged.
+func (s *MountState) CheckNoLeaks(t *testing.T) {
+	t.Helper()
+
+	if s.baselineErr != nil {
+		t.Logf("Leak detection not available: %v", s.baselineErr)
+		return
+	}
+
+	var snapshot *leaks.Snapshot
+	var problems []string
+	check := func() error {
+		var err error
+		snapshot, err = s.takeSnapshot("check")
+		if err != nil {
+			return err
+		}
+		problems = leaks.Diff(s.baseline, snapshot)
+		if len(problems) > 0 {
+			return fmt.Errorf("%d leaked resources", len(problems))
+		}
+		return nil
+	}
+	err := retry(check, "waiting for sandboxfs to release resources", ScaledTimeout(quiescenceDeadline))
+	if snapshot != nil {
+		s.snapshots = append(s.snapshots, snapshot)
+	}
+	if err != nil {
+		if len(problems) == 0 {
+			t.Errorf("Failed to check for leaks: %v", err)
+		}
+		for _, problem := range problems {
+			t.Errorf("sandboxfs leaked resources: %s", problem)
+		}
+	}
+}
+
+// dumpSnapshots prints the history of snapshots taken during the test.
+func (s *MountState) dumpSnapshots() {
+	if len(s.snapshots) == 0 {
+		return
+	}
+	fmt.Fprintf(os.Stderr, "sandboxfs resource snapshots were:\n")
+	for _, snapshot := range s.snapshots {
+		fmt.Fprintf(os.Stderr, "    %s\n", snapshot)
+	}
+}
@@ -0,0 +1,95 @@
+// Copyright 2020 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not
+// use this file except in compliance with the License.  You may obtain a copy
+// of the License at:
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.  See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package utils
+
+import (
+	"fmt"
+	"math/rand"
+	"os"
+	"time"
+)
+
+const (
+	// Default maximum amount of time to wait for sandboxfs to come up and start serving.
+	defaultStartupDeadline = 10 * time.Second
+
+	// Default maximum amount of time to wait for sandboxfs to gracefully exit after an unmount.
+	defaultShutdownDeadline = 5 * time.Second
+
+	// Delay between the first and second attempts of retry.  The delay doubles after every
+	// attempt up to maxRetryDelay.
+	initialRetryDelay = 10 * time.Millisecond
+
+	// Maximum delay between two attempts of retry.
+	maxRetryDelay = 1 * time.Second
+
+	// Period during which retry does not report failed attempts.  It's normal for the first few
+	// attempts to not succeed, so reporting them would print warnings for every test when there
+	// is nothing really wrong.
+	quietRetryPeriod = 1 * time.Second
+)
+
+// ScaledTimeout adjusts a timeout, which must be suitable for a fast and idle machine, to the
+// environment in which the tests run as configured by the --time_scale flag.
+//
+// All timeouts in the tests must go through this function so that the tests don't fail spuriously
+// when sandboxfs runs slower than usual, such as under sanitizers or on loaded CI machines.
+func ScaledTimeout(timeout time.Duration) time.Duration {
+	return time.Duration(float64(timeout) * GetConfig().TimeScale)
+}
+
+// jitter returns a random duration between half of the given delay and the full delay.  This
+// spreads the attempts of retries that run concurrently, which is the case for our parallel tests.
+func jitter(delay time.Duration) time.Duration {
+	half := delay / 2
+	return half + time.Duration(rand.Int63n(int64(delay-half)+1))
+}
+
+// retry runs the given action until either it succeeds or the given deadline expires.  The delay
+// between attempts grows exponentially, with some jitter, up to maxRetryDelay.  Prints the given
+// message when an attempt fails after the first few.
+//
+// If the deadline expires, returns an error that includes the last error encountered by the action,
+// how long we waited for and how many attempts we made.  The deadline is used as is, so callers
+// must scale it with ScaledTimeout as necessary.
+func retry(action func() error, message string, deadline time.Duration) error {
+	start := time.Now()
+	delay := initialRetryDelay
+	for attempt := 1; ; attempt++ {
+		err := action()
+		if err == nil {
+			return nil
+		}
+
+		elapsed := time.Since(start)
+		if elapsed >= deadline {
+			return fmt.Errorf("%s: gave up after %d attempts in %v (deadline %v): %v", message, attempt, elapsed.Round(time.Millisecond), deadline, err)
+		}
+		if elapsed > quietRetryPeriod {
+			fmt.Fprintf(os.Stderr, "In retry attempt %d after %v: %s: %v\n", attempt, elapsed.Round(time.Millisecond), message, err)
+		}
+
+		sleep := jitter(delay)
+		if remaining := deadline - elapsed; sleep > remaining {
+			sleep = remaining
+		}
+		time.Sleep(sleep)
+
+		delay *= 2
+		if delay > maxRetryDelay {
+			delay = maxRetryDelay
+		}
+	}
+}
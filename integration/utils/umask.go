@@ -0,0 +1,40 @@
+// Copyright 2020 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not
+// use this file except in compliance with the License.  You may obtain a copy
+// of the License at:
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.  See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package utils
+
+import (
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// processUmask is the umask of the test process.  It is captured during initialization, before any
+// test runs, because querying the umask requires changing it temporarily and doing so while tests
+// run in parallel would affect the files they create.
+var processUmask = func() os.FileMode {
+	mask := unix.Umask(0)
+	unix.Umask(mask)
+	return os.FileMode(mask)
+}()
+
+// Umask returns the umask of the test process, which the kernel applies to the permissions of the
+// entries that tests create, including those created within a mount point.
+//
+// Tests must never change the umask because it is process-wide and tests run in parallel.  Tests
+// that need exact permissions should set them explicitly after creating an entry, as the Must*
+// helpers do, or compute their expectations with this function.
+func Umask() os.FileMode {
+	return processUmask
+}
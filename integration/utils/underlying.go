@@ -0,0 +1,95 @@
+// Copyright 2020 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not
+// use this file except in compliance with the License.  You may obtain a copy
+// of the License at:
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.  See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package utils
+
+import (
+	"testing"
+)
+
+// rootFilesystemKind represents the type of a file system mounted on the root directory of a test.
+type rootFilesystemKind int
+
+// Valid kinds of root file systems.
+const (
+	// tmpfsRoot is a memory-backed file system with a limited capacity.
+	tmpfsRoot rootFilesystemKind = iota
+
+	// imageRoot is an ext4 file system stored in an image file and mounted via a loop device.
+	imageRoot
+)
+
+// rootFilesystem describes the file system to mount on the root directory of a test so that the
+// test can make the underlying file system fail.
+type rootFilesystem struct {
+	// kind is the type of the file system.
+	kind rootFilesystemKind
+
+	// size is the capacity of the file system in bytes.
+	size int64
+
+	// inodes is the maximum number of inodes in the file system, or 0 for the default.  Only
+	// applies to tmpfsRoot.
+	inodes int
+}
+
+// WithTmpfsRoot places the root directory of the test on a new tmpfs instance with a capacity of
+// size bytes and, if inodes is not zero, room for that many inodes.  Filling the file system makes
+// the underlying operations fail with ENOSPC.
+//
+// The caller must be root, so tests using this must call RequireRoot first.
+func WithTmpfsRoot(size int64, inodes int) MountOption {
+	return func(c *mountConfig) {
+		c.rootFS = &rootFilesystem{kind: tmpfsRoot, size: size, inodes: inodes}
+	}
+}
+
+// WithImageRoot places the root directory of the test on a new ext4 file system of size bytes,
+// stored in an image file and mounted via a loop device.  Unlike a tmpfs, this file system can be
+// forced into errors with MountState.ShutdownRoot.
+//
+// The caller must be root, so tests using this must call RequireRoot first.
+func WithImageRoot(size int64) MountOption {
+	return func(c *mountConfig) {
+		c.rootFS = &rootFilesystem{kind: imageRoot, size: size}
+	}
+}
+
+// RemountRootReadOnly makes the file system that holds the root directory of the test read-only,
+// which causes further write operations on the underlying files to fail with EROFS.  The test must
+// have been set up with WithTmpfsRoot or WithImageRoot, and must not have files open for writing.
+func (s *MountState) RemountRootReadOnly(t *testing.T) {
+	t.Helper()
+
+	if s.rootFS == nil {
+		t.Fatalf("RemountRootReadOnly requires WithTmpfsRoot or WithImageRoot")
+	}
+	if err := remountReadOnly(s.root); err != nil {
+		t.Fatalf("Failed to remount %s read-only: %v", s.root, err)
+	}
+}
+
+// ShutdownRoot forces the file system that holds the root directory of the test into a shut down
+// state, as if its storage had failed, which causes all further operations on the underlying files
+// to fail with EIO.  The test must have been set up with WithImageRoot.
+func (s *MountState) ShutdownRoot(t *testing.T) {
+	t.Helper()
+
+	if s.rootFS == nil || s.rootFS.kind != imageRoot {
+		t.Fatalf("ShutdownRoot requires WithImageRoot")
+	}
+	if err := shutdownFilesystem(s.root); err != nil {
+		t.Fatalf("Failed to shut down file system at %s: %v", s.root, err)
+	}
+}
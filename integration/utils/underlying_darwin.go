@@ -0,0 +1,43 @@
+// Copyright 2020 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not
+// use this file except in compliance with the License.  You may obtain a copy
+// of the License at:
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.  See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package utils
+
+import (
+	"fmt"
+)
+
+// mountRootFilesystem mounts the given file system on root.  This is not supported on this
+// platform.
+func mountRootFilesystem(fs *rootFilesystem, tempDir string, root string) error {
+	return fmt.Errorf("constrained root file systems are not supported on this platform")
+}
+
+// unmountRootFilesystem unmounts the file system mounted on root by mountRootFilesystem.  This is
+// not supported on this platform.
+func unmountRootFilesystem(root string) error {
+	return fmt.Errorf("constrained root file systems are not supported on this platform")
+}
+
+// remountReadOnly remounts the file system at path read-only.  This is not supported on this
+// platform.
+func remountReadOnly(path string) error {
+	return fmt.Errorf("read-only remounts are not supported on this platform")
+}
+
+// shutdownFilesystem shuts down the file system that contains path.  This is not supported on this
+// platform.
+func shutdownFilesystem(path string) error {
+	return fmt.Errorf("file system shutdowns are not supported on this platform")
+}
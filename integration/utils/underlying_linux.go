@@ -0,0 +1,102 @@
+// Copyright 2020 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not
+// use this file except in compliance with the License.  You may obtain a copy
+// of the License at:
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.  See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package utils
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+
+	"golang.org/x/sys/unix"
+)
+
+const (
+	// fsIocShutdown is the FS_IOC_SHUTDOWN ioctl, which is not defined by x/sys/unix.
+	fsIocShutdown = 0x8004587d
+
+	// fsGoingFlagsNoLogFlush tells FS_IOC_SHUTDOWN to not flush the journal, which simulates a
+	// sudden failure of the storage.
+	fsGoingFlagsNoLogFlush = 0x2
+)
+
+// mountRootFilesystem mounts the given file system on root.  tempDir is a scratch directory that
+// outlives the mount, which holds the image file if needed.
+func mountRootFilesystem(fs *rootFilesystem, tempDir string, root string) error {
+	switch fs.kind {
+	case tmpfsRoot:
+		options := fmt.Sprintf("size=%d,mode=0755", fs.size)
+		if fs.inodes != 0 {
+			options += fmt.Sprintf(",nr_inodes=%d", fs.inodes)
+		}
+		if err := unix.Mount("tmpfs", root, "tmpfs", 0, options); err != nil {
+			return fmt.Errorf("failed to mount tmpfs with options %s on %s: %v", options, root, err)
+		}
+		return nil
+
+	case imageRoot:
+		image := filepath.Join(tempDir, "root.img")
+		file, err := os.Create(image)
+		if err != nil {
+			return fmt.Errorf("failed to create image: %v", err)
+		}
+		err = file.Truncate(fs.size)
+		file.Close()
+		if err != nil {
+			return fmt.Errorf("failed to resize image to %d bytes: %v", fs.size, err)
+		}
+
+		if output, err := exec.Command("mkfs.ext4", "-q", "-F", image).CombinedOutput(); err != nil {
+			return fmt.Errorf("mkfs.ext4 %s failed: %v; output: %s", image, err, output)
+		}
+		// Let mount(8) set up the loop device because doing so by hand is racy.  The device
+		// is detached automatically when the file system is unmounted.
+		if output, err := exec.Command("mount", "-o", "loop", image, root).CombinedOutput(); err != nil {
+			return fmt.Errorf("mount -o loop %s %s failed: %v; output: %s", image, root, err, output)
+		}
+		// Keep the root directory empty, like it is in all other tests.
+		if err := os.Remove(filepath.Join(root, "lost+found")); err != nil {
+			unmountRootFilesystem(root)
+			return fmt.Errorf("failed to remove lost+found from %s: %v", root, err)
+		}
+		return nil
+
+	default:
+		panic(fmt.Sprintf("unknown root file system kind %d", fs.kind))
+	}
+}
+
+// unmountRootFilesystem unmounts the file system mounted on root by mountRootFilesystem.
+func unmountRootFilesystem(root string) error {
+	if err := unix.Unmount(root, 0); err != nil {
+		return fmt.Errorf("failed to unmount %s: %v", root, err)
+	}
+	return nil
+}
+
+// remountReadOnly remounts the file system at path read-only.
+func remountReadOnly(path string) error {
+	return unix.Mount("", path, "", unix.MS_REMOUNT|unix.MS_RDONLY, "")
+}
+
+// shutdownFilesystem shuts down the file system that contains path.
+func shutdownFilesystem(path string) error {
+	fd, err := unix.Open(path, unix.O_RDONLY|unix.O_DIRECTORY, 0)
+	if err != nil {
+		return err
+	}
+	defer unix.Close(fd)
+	return unix.IoctlSetPointerInt(fd, fsIocShutdown, fsGoingFlagsNoLogFlush)
+}
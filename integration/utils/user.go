@@ -106,6 +106,9 @@ func WriteErrorForUnwritableNode() error {
 
 // LookupUser looks up a user by username.
 func LookupUser(name string) (*UnixUser, error) {
+	if InUserNamespace() {
+		return lookupSyntheticUser(name)
+	}
 	generic, err := user.Lookup(name)
 	if err != nil {
 		return nil, fmt.Errorf("cannot find user %s: %v", name, err)
@@ -115,6 +118,9 @@ func LookupUser(name string) (*UnixUser, error) {
 
 // LookupUID looks up a user by UID.
 func LookupUID(uid int) (*UnixUser, error) {
+	if InUserNamespace() {
+		return lookupSyntheticUID(uid)
+	}
 	generic, err := user.LookupId(fmt.Sprintf("%d", uid))
 	if err != nil {
 		return nil, fmt.Errorf("cannot find user %d: %v", uid, err)
@@ -126,25 +132,21 @@ func LookupUID(uid int) (*UnixUser, error) {
 func LookupUserOtherThan(username ...string) (*UnixUser, error) {
 	// Testing a bunch of low-numbered UIDs should be sufficient because most Unix systems,
 	// if not all, have system accounts immediately after 0.
-	var other *UnixUser
+Candidates:
 	for i := 1; i < 100; i++ {
-		var err error
-		other, err = LookupUID(i)
+		other, err := LookupUID(i)
 		if err != nil {
 			continue
 		}
 
 		for _, name := range username {
 			if other.Username == name {
-				continue
+				continue Candidates
 			}
 		}
-		break
-	}
-	if other == nil {
-		return nil, fmt.Errorf("cannot find an unprivileged user other than %v", username)
+		return other, nil
 	}
-	return other, nil
+	return nil, fmt.Errorf("cannot find an unprivileged user other than %v", username)
 }
 
 // SetCredential updates the spawn attributes of the given command to execute such command under the
@@ -0,0 +1,92 @@
+// Copyright 2020 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not
+// use this file except in compliance with the License.  You may obtain a copy
+// of the License at:
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.  See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package utils
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// usernsSetupEnv is the name of the environment variable that tells the test program that it has
+// just been spawned in a new user namespace and that it must wait for its ID mappings to be set up.
+// The value is the number of mapped IDs.
+const usernsSetupEnv = "SANDBOXFS_USERNS_SETUP"
+
+// usernsUsersEnv is the name of the environment variable that tells the test program that it runs
+// within a fully set up user namespace.  The value is the number of mapped IDs.
+const usernsUsersEnv = "SANDBOXFS_USERNS_USERS"
+
+// syntheticUserPrefix is the prefix of the names given to the synthetic users that exist within
+// the user namespace.  The full name is the prefix followed by the numeric identifier.
+const syntheticUserPrefix = "nsuser"
+
+// syntheticUsers contains the number of users mapped into the user namespace in which the tests
+// run, or zero if the tests do not run in one.
+var syntheticUsers = func() int {
+	count, err := strconv.Atoi(os.Getenv(usernsUsersEnv))
+	if err != nil || count < 0 {
+		return 0
+	}
+	return count
+}()
+
+// InUserNamespace returns true if the tests run within the user namespace set up by
+// EnterUserNamespace.
+//
+// In this mode, the user database of the host is meaningless because the IDs it lists do not map
+// to the same accounts inside the namespace.  Instead, the namespace contains synthetic users that
+// are only known by the lookup functions in this package: UID 0 is root and every other mapped UID
+// N is a user named after SyntheticUsername(N) whose only group has the same numeric identifier.
+func InUserNamespace() bool {
+	return syntheticUsers > 0
+}
+
+// SyntheticUsername returns the name of the synthetic user with the given UID.
+func SyntheticUsername(uid int) string {
+	if uid == 0 {
+		return "root"
+	}
+	return fmt.Sprintf("%s%d", syntheticUserPrefix, uid)
+}
+
+// lookupSyntheticUID looks up a synthetic user by UID.
+func lookupSyntheticUID(uid int) (*UnixUser, error) {
+	if uid < 0 || uid >= syntheticUsers {
+		return nil, fmt.Errorf("cannot find user %d: only UIDs 0 to %d are mapped in the user namespace", uid, syntheticUsers-1)
+	}
+	return &UnixUser{
+		Username: SyntheticUsername(uid),
+		UID:      uid,
+		GID:      uid,
+		Groups:   []int{uid},
+	}, nil
+}
+
+// lookupSyntheticUser looks up a synthetic user by username.
+func lookupSyntheticUser(name string) (*UnixUser, error) {
+	if name == "root" {
+		return lookupSyntheticUID(0)
+	}
+	if !strings.HasPrefix(name, syntheticUserPrefix) {
+		return nil, fmt.Errorf("cannot find user %s: not a synthetic user in the user namespace", name)
+	}
+	uid, err := strconv.Atoi(strings.TrimPrefix(name, syntheticUserPrefix))
+	if err != nil || uid == 0 {
+		return nil, fmt.Errorf("cannot find user %s: not a synthetic user in the user namespace", name)
+	}
+	return lookupSyntheticUID(uid)
+}
@@ -16,17 +16,15 @@ package utils
 
 import (
 	"fmt"
-	"os"
 	"os/exec"
 )
 
-// Unmount unmounts the given file system.
-func Unmount(path string) error {
-	cmd := exec.Command("fusermount", "-u", path)
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-	if err := cmd.Run(); err != nil {
-		return fmt.Errorf("exec of fusermount -u %s failed: %v", path, err)
-	}
-	return nil
+// EnterUserNamespace re-executes the test program within a new user namespace.  This is not
+// supported on this platform.
+func EnterUserNamespace() error {
+	return fmt.Errorf("user namespaces are not supported on this platform")
+}
+
+// grantMountCapability does nothing on this platform because there are no user namespaces.
+func grantMountCapability(cmd *exec.Cmd) {
 }
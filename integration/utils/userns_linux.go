@@ -0,0 +1,217 @@
+// Copyright 2020 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not
+// use this file except in compliance with the License.  You may obtain a copy
+// of the License at:
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.  See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package utils
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"os/user"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// idRange represents a range of subordinate IDs delegated to a user.
+type idRange struct {
+	// start is the first ID in the range.
+	start int
+	// count is the number of IDs in the range.
+	count int
+}
+
+// readSubordinateRange returns the first range of subordinate IDs delegated to the given user in
+// the given file, which is either /etc/subuid or /etc/subgid.
+func readSubordinateRange(path string, owner *user.User) (idRange, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return idRange{}, fmt.Errorf("cannot read subordinate IDs: %v", err)
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		fields := strings.Split(strings.TrimSpace(scanner.Text()), ":")
+		if len(fields) != 3 || (fields[0] != owner.Username && fields[0] != owner.Uid) {
+			continue
+		}
+		start, err := strconv.Atoi(fields[1])
+		if err != nil {
+			return idRange{}, fmt.Errorf("invalid start %s in %s: %v", fields[1], path, err)
+		}
+		count, err := strconv.Atoi(fields[2])
+		if err != nil {
+			return idRange{}, fmt.Errorf("invalid count %s in %s: %v", fields[2], path, err)
+		}
+		return idRange{start: start, count: count}, nil
+	}
+	if err := scanner.Err(); err != nil {
+		return idRange{}, fmt.Errorf("cannot read subordinate IDs from %s: %v", path, err)
+	}
+	return idRange{}, fmt.Errorf("no subordinate IDs for user %s in %s", owner.Username, path)
+}
+
+// EnterUserNamespace re-executes the test program within a new user and mount namespace in which
+// the caller is root and in which a range of synthetic users exists.  This allows running the tests
+// that need root privileges, or that need to switch users, on machines where the caller does not
+// have root access.
+//
+// The namespace maps the caller's UID and GID to 0 and a range of subordinate IDs delegated to the
+// caller in /etc/subuid and /etc/subgid to the IDs that follow, which requires the setuid newuidmap
+// and newgidmap tools.  See InUserNamespace for details on how the synthetic users look like.
+// Mounts made by the tests are private to the namespace so they vanish when the tests finish.
+//
+// This function must be called early from TestMain and behaves differently depending on the stage
+// the test program is in.  When called on the host, it spawns the test program in the namespace,
+// waits for it and exits with its exit status, so it never returns unless there is an error.  When
+// called within the namespace, it finishes the namespace setup and returns nil, after which the test
+// program must continue running as usual.
+func EnterUserNamespace() error {
+	if InUserNamespace() {
+		if err := unix.Mount("", "/", "", unix.MS_REC|unix.MS_PRIVATE, ""); err != nil {
+			return fmt.Errorf("cannot make mounts private in the user namespace: %v", err)
+		}
+		return nil
+	}
+
+	if count := os.Getenv(usernsSetupEnv); count != "" {
+		return finishUserNamespaceSetup(count)
+	}
+
+	return runInUserNamespace()
+}
+
+// runInUserNamespace spawns the test program in a new user and mount namespace, maps the synthetic
+// users into it, and exits with the status of the test program once it finishes.
+func runInUserNamespace() error {
+	current, err := user.Current()
+	if err != nil {
+		return fmt.Errorf("cannot determine current user: %v", err)
+	}
+	uids, err := readSubordinateRange("/etc/subuid", current)
+	if err != nil {
+		return err
+	}
+	gids, err := readSubordinateRange("/etc/subgid", current)
+	if err != nil {
+		return err
+	}
+	count := uids.count
+	if gids.count < count {
+		count = gids.count
+	}
+
+	self, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("cannot locate test program: %v", err)
+	}
+
+	// The child waits until we close this pipe before proceeding because it cannot do anything
+	// useful until we have set up its ID mappings.
+	syncReader, syncWriter, err := os.Pipe()
+	if err != nil {
+		return fmt.Errorf("cannot create synchronization pipe: %v", err)
+	}
+	defer syncWriter.Close()
+
+	cmd := exec.Command(self, os.Args[1:]...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.ExtraFiles = []*os.File{syncReader}
+	cmd.Env = append(os.Environ(), fmt.Sprintf("%s=%d", usernsSetupEnv, count+1))
+	cmd.SysProcAttr = &syscall.SysProcAttr{
+		Cloneflags: syscall.CLONE_NEWUSER | syscall.CLONE_NEWNS,
+	}
+	err = cmd.Start()
+	syncReader.Close()
+	if err != nil {
+		return fmt.Errorf("cannot spawn test program in a new user namespace: %v", err)
+	}
+
+	pid := strconv.Itoa(cmd.Process.Pid)
+	mappings := []struct {
+		tool string
+		id   int
+		sub  idRange
+	}{
+		{"newuidmap", os.Getuid(), uids},
+		{"newgidmap", os.Getgid(), gids},
+	}
+	for _, m := range mappings {
+		args := []string{pid, "0", strconv.Itoa(m.id), "1", "1", strconv.Itoa(m.sub.start), strconv.Itoa(count)}
+		if output, err := exec.Command(m.tool, args...).CombinedOutput(); err != nil {
+			cmd.Process.Kill()
+			cmd.Wait()
+			return fmt.Errorf("%s %s failed: %v; output: %s", m.tool, strings.Join(args, " "), err, output)
+		}
+	}
+	syncWriter.Close()
+
+	if err := cmd.Wait(); err != nil {
+		if exitErr, ok := err.(*exec.ExitError); ok {
+			if status, ok := exitErr.Sys().(syscall.WaitStatus); ok && status.Exited() {
+				os.Exit(status.ExitStatus())
+			}
+		}
+		return fmt.Errorf("test program in user namespace failed: %v", err)
+	}
+	os.Exit(0)
+	return nil
+}
+
+// finishUserNamespaceSetup waits for the parent to map the synthetic users into the namespace and
+// then re-executes the test program to regain the capabilities that were lost when the namespace
+// was created with an unmapped UID.
+func finishUserNamespaceSetup(count string) error {
+	syncReader := os.NewFile(3, "userns-sync")
+	ioutil.ReadAll(syncReader)
+	syncReader.Close()
+
+	if os.Getuid() != 0 {
+		return fmt.Errorf("ID mappings were not set up for the user namespace")
+	}
+
+	self, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("cannot locate test program: %v", err)
+	}
+	var env []string
+	for _, variable := range os.Environ() {
+		if !strings.HasPrefix(variable, usernsSetupEnv+"=") {
+			env = append(env, variable)
+		}
+	}
+	env = append(env, fmt.Sprintf("%s=%s", usernsUsersEnv, count))
+	return syscall.Exec(self, os.Args, env)
+}
+
+// grantMountCapability updates the spawn attributes of the given command, which must run as a
+// synthetic user other than root within the user namespace, so that the command can mount FUSE
+// file systems.
+//
+// Outside of a user namespace, non-root users mount FUSE file systems through the setuid fusermount
+// helper.  Within the namespace, however, the setuid bit is ignored because the owner of fusermount
+// is not mapped, so we grant the command the capability to issue the mount system call directly.
+func grantMountCapability(cmd *exec.Cmd) {
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.AmbientCaps = append(cmd.SysProcAttr.AmbientCaps, unix.CAP_SYS_ADMIN)
+}